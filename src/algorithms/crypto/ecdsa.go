@@ -0,0 +1,97 @@
+package crypto
+
+import (
+	"errors"
+
+	secp256k1 "github.com/decred/dcrd/dcrec/secp256k1/v4"
+	secp256k1ecdsa "github.com/decred/dcrd/dcrec/secp256k1/v4/ecdsa"
+	util "github.com/filecoin-project/specs/util"
+	"golang.org/x/crypto/blake2b"
+)
+
+// ECDSA signatures over the secp256k1 curve sign the 256-bit BLAKE2b digest of a message. A signature
+// is 65 bytes: r and s, each 32 bytes big-endian, followed by the recovery ID (the parity of the y
+// coordinate of the point whose x coordinate is r, and whether that x coordinate overflowed the curve
+// order), from which the signer's public key is recovered. Only signatures whose s is at most half
+// the curve order are valid, so that a signature cannot be made into another valid one by negating s.
+// Public keys are 65 bytes, uncompressed: 0x04 followed by the x and y coordinates.
+
+var (
+	ErrSecp256k1SecretKeyInvalid = errors.New("secp256k1 secret key invalid")
+	ErrSecp256k1SignatureInvalid = errors.New("secp256k1 signature invalid")
+)
+
+const (
+	Secp256k1SignatureLength = 65
+	Secp256k1PublicKeyLength = 65
+)
+
+// The offset of the recovery ID in the header byte of a compact signature, for an uncompressed key.
+const _compactSigRecoveryOffset = 27
+
+// Recovers the public key which signed m, with Secp256k1Recover.
+func (self *ECDSA_I) Recover(m Message, sig SignatureBytes) ECDSA_Recover_FunRet {
+	pk, err := Secp256k1Recover(m, sig)
+	return &ECDSA_Recover_FunRet_I{PublicKey_: pk, Err_: err}
+}
+
+// Returns the public key of a 32-byte secret key.
+func Secp256k1PublicKey(sk SecretKey) (PublicKey, error) {
+	key, ok := _secp256k1SecretKey(util.Bytes(sk))
+	if !ok {
+		return nil, ErrSecp256k1SecretKeyInvalid
+	}
+	return PublicKey(key.PubKey().SerializeUncompressed()), nil
+}
+
+// Signs a message with a 32-byte secret key. The nonce is derived deterministically from the key and
+// the message digest (RFC 6979), and s is at most half the curve order.
+func Secp256k1Sign(sk SecretKey, m Message) (SignatureBytes, error) {
+	key, ok := _secp256k1SecretKey(util.Bytes(sk))
+	if !ok {
+		return nil, ErrSecp256k1SecretKeyInvalid
+	}
+	digest := blake2b.Sum256(m)
+	compact := secp256k1ecdsa.SignCompact(key, digest[:], false)
+
+	// A compact signature is the header byte followed by r and s.
+	ret := make(SignatureBytes, Secp256k1SignatureLength)
+	copy(ret[0:64], compact[1:65])
+	ret[64] = compact[0] - _compactSigRecoveryOffset
+	return ret, nil
+}
+
+// Recovers the public key whose secret key signed a message. A signature is valid for the recovered key
+// by construction, so it is valid for an address if the recovered key is that from which the address
+// is derived.
+func Secp256k1Recover(m Message, sig SignatureBytes) (PublicKey, error) {
+	if len(sig) != Secp256k1SignatureLength || sig[64] > 3 {
+		return nil, ErrSecp256k1SignatureInvalid
+	}
+	var s secp256k1.ModNScalar
+	if overflow := s.SetByteSlice(sig[32:64]); overflow || s.IsOverHalfOrder() {
+		return nil, ErrSecp256k1SignatureInvalid
+	}
+
+	compact := make([]byte, 0, Secp256k1SignatureLength)
+	compact = append(compact, sig[64]+_compactSigRecoveryOffset)
+	compact = append(compact, sig[0:64]...)
+	digest := blake2b.Sum256(m)
+	key, _, err := secp256k1ecdsa.RecoverCompact(compact, digest[:])
+	if err != nil {
+		return nil, ErrSecp256k1SignatureInvalid
+	}
+	return PublicKey(key.SerializeUncompressed()), nil
+}
+
+// Parses a 32-byte big-endian secret key, which must be in [1, n).
+func _secp256k1SecretKey(b util.Bytes) (*secp256k1.PrivateKey, bool) {
+	if len(b) != 32 {
+		return nil, false
+	}
+	var d secp256k1.ModNScalar
+	if overflow := d.SetByteSlice(b); overflow || d.IsZero() {
+		return nil, false
+	}
+	return secp256k1.NewPrivateKey(&d), true
+}
@@ -0,0 +1,130 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math/big"
+	"testing"
+)
+
+func _testSecretKey(b byte) SecretKey {
+	return SecretKey(bytes.Repeat([]byte{b}, 32))
+}
+
+func TestSecp256k1PublicKey(t *testing.T) {
+	// The public key of 1 is the generator.
+	one := make(SecretKey, 32)
+	one[31] = 1
+	pk, err := Secp256k1PublicKey(one)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "04" +
+		"79be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798" +
+		"483ada7726a3c4655da4fbfc0e1108a8fd17b448a68554199c47d08ffb10d4b8"
+	if got := hex.EncodeToString(pk); got != want {
+		t.Errorf("public key of 1 is %s, want %s", got, want)
+	}
+
+	for _, sk := range []SecretKey{make(SecretKey, 32), make(SecretKey, 31), SecretKey(bytes.Repeat([]byte{0xff}, 32))} {
+		if _, err := Secp256k1PublicKey(sk); err != ErrSecp256k1SecretKeyInvalid {
+			t.Errorf("public key of %x: error %v, want %v", sk, err, ErrSecp256k1SecretKeyInvalid)
+		}
+	}
+}
+
+func TestSecp256k1SignAndRecover(t *testing.T) {
+	m := Message("message")
+	for _, b := range []byte{1, 2, 0x7f} {
+		sk := _testSecretKey(b)
+		pk, err := Secp256k1PublicKey(sk)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig, err := Secp256k1Sign(sk, m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if halfOrder := _hexInt("7fffffffffffffffffffffffffffffff5d576e7357a4501ddfe92f46681b20a0"); new(big.Int).SetBytes(sig[32:64]).Cmp(halfOrder) > 0 {
+			t.Errorf("key %x: s of signature %x over half the curve order", sk, sig)
+		}
+		recovered, err := Secp256k1Recover(m, sig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(recovered, pk) {
+			t.Errorf("key %x: recovered %x, want %x", sk, recovered, pk)
+		}
+
+		// A signature recovers another key for a different message, or with the other recovery ID.
+		if other, err := Secp256k1Recover(Message("other message"), sig); err == nil && bytes.Equal(other, pk) {
+			t.Errorf("key %x: signature recovers the key for another message", sk)
+		}
+		flipped := append(SignatureBytes{}, sig...)
+		flipped[64] ^= 1
+		if other, err := Secp256k1Recover(m, flipped); err == nil && bytes.Equal(other, pk) {
+			t.Errorf("key %x: signature recovers the key with the other recovery ID", sk)
+		}
+	}
+}
+
+func TestSecp256k1RecoverMalformed(t *testing.T) {
+	sig, err := Secp256k1Sign(_testSecretKey(1), Message("message"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	order, _ := hex.DecodeString("fffffffffffffffffffffffffffffffebaaedce6af48a03bbfd25e8cd0364141")
+	malformed := func(f func(s SignatureBytes) SignatureBytes) SignatureBytes {
+		return f(append(SignatureBytes{}, sig...))
+	}
+
+	cases := map[string]SignatureBytes{
+		"short":       sig[:64],
+		"recovery ID": malformed(func(s SignatureBytes) SignatureBytes { s[64] = 4; return s }),
+		"zero r":      malformed(func(s SignatureBytes) SignatureBytes { copy(s[0:32], make([]byte, 32)); return s }),
+		"s of order":  malformed(func(s SignatureBytes) SignatureBytes { copy(s[32:64], order); return s }),
+		// The same signature with s negated, which recovers the same key with the other recovery ID.
+		"high s": malformed(func(s SignatureBytes) SignatureBytes {
+			n := new(big.Int).SetBytes(order)
+			high := n.Sub(n, new(big.Int).SetBytes(s[32:64])).Bytes()
+			copy(s[32:64], make([]byte, 32))
+			copy(s[64-len(high):64], high)
+			s[64] ^= 1
+			return s
+		}),
+	}
+	for name, s := range cases {
+		if _, err := Secp256k1Recover(Message("message"), s); err != ErrSecp256k1SignatureInvalid {
+			t.Errorf("%s: error %v, want %v", name, err, ErrSecp256k1SignatureInvalid)
+		}
+	}
+}
+
+func TestECDSARecover(t *testing.T) {
+	sk := _testSecretKey(1)
+	pk, err := Secp256k1PublicKey(sk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := Secp256k1Sign(sk, Message("message"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecdsa := &ECDSA_I{Signature_: &Signature_I{Type_: SigType_ECDSASigType, Sig_: sig}}
+
+	recovered := ecdsa.Recover(Message("message"), ecdsa.Signature().Sig())
+	if recovered.Err() != nil || !bytes.Equal(recovered.PublicKey(), pk) {
+		t.Errorf("recovered %x, %v, want %x", recovered.PublicKey(), recovered.Err(), pk)
+	}
+	if recovered := ecdsa.Recover(Message("message"), sig[:64]); recovered.Err() != ErrSecp256k1SignatureInvalid {
+		t.Errorf("short signature: error %v, want %v", recovered.Err(), ErrSecp256k1SignatureInvalid)
+	}
+}
+
+func _hexInt(s string) *big.Int {
+	ret, ok := new(big.Int).SetString(s, 16)
+	if !ok {
+		panic(s)
+	}
+	return ret
+}
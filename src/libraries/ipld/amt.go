@@ -0,0 +1,198 @@
+package ipld
+
+import (
+	"errors"
+
+	util "github.com/filecoin-project/specs/util"
+	cid "github.com/ipfs/go-cid"
+	cbornode "github.com/ipfs/go-ipld-cbor"
+)
+
+var (
+	ErrAMTNodeNotFound = errors.New("AMT node not found in store")
+	ErrAMTMalformed    = errors.New("Malformed AMT node")
+)
+
+// The branching factor of an AMT node.
+const AMTWidth = 8
+
+// An array-mapped trie (AMT) stores an array of IPLD data model values (e.g., links, as cid.Cid) as a
+// tree of DAG-CBOR blocks.
+// Its root is encoded as [height, count, node], and each of its nodes as [bitmap, links, values]: the
+// bitmap has bit j (of byte j/8) set if slot j of the node is occupied, and the node holds a link to a
+// child node (above height 0) or a value (at height 0) for each occupied slot, in order. Slot j of a node
+// at height h covers AMTWidth^h consecutive indices.
+
+// Stores values, at indices 0 to len(values)-1, in an AMT and returns the CID of its root.
+func PutAMT(store GraphStore, values []interface{}) cid.Cid {
+	height := uint64(0)
+	for span := uint64(AMTWidth); span < uint64(len(values)); span *= AMTWidth {
+		height++
+	}
+	root := []interface{}{height, uint64(len(values)), _putAMTNode(store, values, height)}
+	return store.Put(_dumpObject(root))
+}
+
+// Returns the node at height holding values (from the first index it covers), storing its children.
+func _putAMTNode(store GraphStore, values []interface{}, height uint64) []interface{} {
+	span := _amtSpan(height)
+	bitmap := byte(0)
+	links, nodeValues := []interface{}{}, []interface{}{}
+	for slot := uint64(0); slot < AMTWidth && slot*span < uint64(len(values)); slot++ {
+		bitmap |= 1 << slot
+		if height == 0 {
+			nodeValues = append(nodeValues, values[slot])
+			continue
+		}
+		end := (slot + 1) * span
+		if end > uint64(len(values)) {
+			end = uint64(len(values))
+		}
+		child := _putAMTNode(store, values[slot*span:end], height-1)
+		links = append(links, store.Put(_dumpObject(child)))
+	}
+	return []interface{}{[]byte{bitmap}, links, nodeValues}
+}
+
+// Loads the values of the AMT with the given root, in order of index. The AMT must hold a value at each
+// index from 0 to its count-1, as one stored by PutAMT does.
+func LoadAMT(store GraphStore, root cid.Cid) ([]interface{}, error) {
+	x, err := _loadAMTNode(store, root)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := AMTEntries(store, x)
+	if err != nil {
+		return nil, err
+	}
+	count, _ := _uint(x.([]interface{})[1])
+	if uint64(len(entries)) != count {
+		return nil, ErrAMTMalformed
+	}
+	ret := make([]interface{}, count)
+	for i := range ret {
+		v, found := entries[uint64(i)]
+		if !found {
+			return nil, ErrAMTMalformed
+		}
+		ret[i] = v
+	}
+	return ret, nil
+}
+
+// Returns whether a decoded DAG-CBOR value is an AMT root.
+func IsAMTRoot(x interface{}) bool {
+	root, ok := x.([]interface{})
+	if !ok || len(root) != 3 {
+		return false
+	}
+	_, heightOk := _uint(root[0])
+	_, countOk := _uint(root[1])
+	return heightOk && countOk && _isAMTNode(root[2])
+}
+
+func _isAMTNode(x interface{}) bool {
+	node, ok := x.([]interface{})
+	if !ok || len(node) != 3 {
+		return false
+	}
+	_, bitmapOk := node[0].([]byte)
+	_, linksOk := node[1].([]interface{})
+	_, valuesOk := node[2].([]interface{})
+	return bitmapOk && linksOk && valuesOk
+}
+
+// Returns the values of the AMT with the given (decoded) root by index, loading its nodes from store.
+func AMTEntries(store GraphStore, root interface{}) (map[uint64]interface{}, error) {
+	if !IsAMTRoot(root) {
+		return nil, ErrAMTMalformed
+	}
+	height, _ := _uint(root.([]interface{})[0])
+	ret := make(map[uint64]interface{})
+	if err := _amtNodeEntries(store, root.([]interface{})[2], height, 0, ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+func _amtNodeEntries(store GraphStore, x interface{}, height uint64, offset uint64, out map[uint64]interface{}) error {
+	if !_isAMTNode(x) {
+		return ErrAMTMalformed
+	}
+	node := x.([]interface{})
+	bitmap, links, values := node[0].([]byte), node[1].([]interface{}), node[2].([]interface{})
+
+	span := _amtSpan(height)
+	next := 0
+	for slot := uint64(0); slot < AMTWidth; slot++ {
+		if int(slot/8) >= len(bitmap) || bitmap[slot/8]&(1<<(slot%8)) == 0 {
+			continue
+		}
+		index := offset + slot*span
+		if height == 0 {
+			if next >= len(values) {
+				return ErrAMTMalformed
+			}
+			out[index] = values[next]
+		} else {
+			if next >= len(links) {
+				return ErrAMTMalformed
+			}
+			c, ok := links[next].(cid.Cid)
+			if !ok {
+				return ErrAMTMalformed
+			}
+			child, err := _loadAMTNode(store, c)
+			if err != nil {
+				return err
+			}
+			if err := _amtNodeEntries(store, child, height-1, index, out); err != nil {
+				return err
+			}
+		}
+		next++
+	}
+	return nil
+}
+
+// The number of indices covered by each slot of a node at height.
+func _amtSpan(height uint64) uint64 {
+	ret := uint64(1)
+	for h := uint64(0); h < height; h++ {
+		ret *= AMTWidth
+	}
+	return ret
+}
+
+func _loadAMTNode(store GraphStore, c cid.Cid) (interface{}, error) {
+	serialized, found := store.Get(c)
+	if !found {
+		return nil, ErrAMTNodeNotFound
+	}
+	var ret interface{}
+	if err := cbornode.DecodeInto(serialized, &ret); err != nil {
+		return nil, ErrAMTMalformed
+	}
+	return ret, nil
+}
+
+func _dumpObject(x interface{}) util.Bytes {
+	ret, err := cbornode.DumpObject(x)
+	if err != nil {
+		panic(err)
+	}
+	return ret
+}
+
+func _uint(x interface{}) (uint64, bool) {
+	switch x := x.(type) {
+	case uint64:
+		return x, true
+	case int64:
+		return uint64(x), x >= 0
+	case int:
+		return uint64(x), x >= 0
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,102 @@
+package ipld
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	cid "github.com/ipfs/go-cid"
+	cbornode "github.com/ipfs/go-ipld-cbor"
+)
+
+func _amtValues(n int) []interface{} {
+	ret := make([]interface{}, n)
+	for i := range ret {
+		ret[i] = fmt.Sprint("value ", i)
+	}
+	return ret
+}
+
+func TestAMTRoundTrip(t *testing.T) {
+	store := MemGraphStore_Make()
+	link := store.Put(_dumpObject("linked"))
+	// Heights 0 to 3, with full and partly filled nodes.
+	for _, n := range []int{0, 1, 8, 9, 64, 65, 600} {
+		values := _amtValues(n)
+		if n > 0 {
+			values[n-1] = link
+		}
+		root := PutAMT(store, values)
+		got, err := LoadAMT(store, root)
+		if err != nil {
+			t.Fatalf("%d values: %v", n, err)
+		}
+		if len(got) != n || (n > 0 && !reflect.DeepEqual(got, values)) {
+			t.Errorf("%d values: loaded %v, want %v", n, got, values)
+		}
+	}
+}
+
+func TestAMTHeight(t *testing.T) {
+	store := MemGraphStore_Make()
+	for _, c := range []struct {
+		n      int
+		height uint64
+	}{{0, 0}, {8, 0}, {9, 1}, {64, 1}, {65, 2}} {
+		var root interface{}
+		serialized, _ := store.Get(PutAMT(store, _amtValues(c.n)))
+		if err := cbornode.DecodeInto(serialized, &root); err != nil {
+			t.Fatal(err)
+		}
+		if !IsAMTRoot(root) {
+			t.Fatalf("%d values: root %v is not an AMT root", c.n, root)
+		}
+		if height, _ := _uint(root.([]interface{})[0]); height != c.height {
+			t.Errorf("%d values: height %d, want %d", c.n, height, c.height)
+		}
+	}
+}
+
+func TestLoadAMTErrors(t *testing.T) {
+	store := MemGraphStore_Make()
+	root := PutAMT(store, _amtValues(9))
+	serialized, _ := store.Get(root)
+	var decoded []interface{}
+	if err := cbornode.DecodeInto(serialized, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	firstChild := decoded[2].([]interface{})[1].([]interface{})[0].(cid.Cid)
+
+	miscounted := store.Put(_dumpObject([]interface{}{uint64(0), uint64(2), []interface{}{[]byte{1}, []interface{}{}, []interface{}{"a"}}}))
+	missingValue := store.Put(_dumpObject([]interface{}{uint64(0), uint64(2), []interface{}{[]byte{3}, []interface{}{}, []interface{}{"a"}}}))
+	sparse := store.Put(_dumpObject([]interface{}{uint64(0), uint64(1), []interface{}{[]byte{2}, []interface{}{}, []interface{}{"a"}}}))
+	badLink := store.Put(_dumpObject([]interface{}{uint64(1), uint64(1), []interface{}{[]byte{1}, []interface{}{"not a link"}, []interface{}{}}}))
+	notAMT := store.Put(_dumpObject(map[string]interface{}{"a": "b"}))
+
+	cases := []struct {
+		name string
+		root cid.Cid
+		err  error
+	}{
+		{"missing root", _missingCID("missing"), ErrAMTNodeNotFound},
+		{"count mismatch", miscounted, ErrAMTMalformed},
+		{"fewer values than bitmap slots", missingValue, ErrAMTMalformed},
+		{"value not at its index", sparse, ErrAMTMalformed},
+		{"child link not a CID", badLink, ErrAMTMalformed},
+		{"not an AMT", notAMT, ErrAMTMalformed},
+	}
+	for _, c := range cases {
+		if _, err := LoadAMT(store, c.root); err != c.err {
+			t.Errorf("%s: error %v, want %v", c.name, err, c.err)
+		}
+	}
+
+	store.Delete(firstChild)
+	if _, err := LoadAMT(store, root); err != ErrAMTNodeNotFound {
+		t.Errorf("missing child: error %v, want %v", err, ErrAMTNodeNotFound)
+	}
+}
+
+func _missingCID(name string) cid.Cid {
+	return MemGraphStore_Make().Put(_dumpObject(name))
+}
@@ -0,0 +1,142 @@
+package car
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	ipld "github.com/filecoin-project/specs/libraries/ipld"
+	util "github.com/filecoin-project/specs/util"
+	cid "github.com/ipfs/go-cid"
+	cbornode "github.com/ipfs/go-ipld-cbor"
+)
+
+// A CARv1 file is a header followed by a sequence of blocks. Each is prefixed by its length, as an
+// unsigned varint. The header is the DAG-CBOR encoding of a CarHeader, and each block is its CID
+// followed by its serialized value.
+type CarHeader struct {
+	Roots   []cid.Cid
+	Version uint64
+}
+
+func init() {
+	cbornode.RegisterCborType(CarHeader{})
+}
+
+// The maximum length of a header or block which is read.
+const MaxSectionSize = 32 << 20
+
+var (
+	ErrMalformed          = errors.New("Malformed CAR file")
+	ErrUnsupportedVersion = errors.New("Unsupported CAR file version")
+	ErrBlockCIDMismatch   = errors.New("CAR file block does not match its CID")
+)
+
+// Reader reads the blocks of a CAR file in order, without holding more than one in memory.
+type Reader struct {
+	Header CarHeader
+	_r     *bufio.Reader
+}
+
+func Reader_Make(r io.Reader) (*Reader, error) {
+	br := bufio.NewReader(r)
+	data, err := _readSection(br)
+	if err == io.EOF {
+		return nil, ErrMalformed
+	} else if err != nil {
+		return nil, err
+	}
+
+	var header CarHeader
+	if err := cbornode.DecodeInto(data, &header); err != nil {
+		return nil, ErrMalformed
+	}
+	if header.Version != 1 {
+		return nil, ErrUnsupportedVersion
+	}
+	return &Reader{Header: header, _r: br}, nil
+}
+
+// Returns the next block's CID and serialized value, or io.EOF after the last block.
+func (r *Reader) Next() (cid.Cid, util.Bytes, error) {
+	data, err := _readSection(r._r)
+	if err != nil {
+		return cid.Undef, nil, err
+	}
+	n, c, err := cid.CidFromBytes(data)
+	if err != nil {
+		return cid.Undef, nil, ErrMalformed
+	}
+	return c, util.Bytes(data[n:]), nil
+}
+
+// Reads the blocks of a CAR file into store, checking that each is put under its CID.
+// Returns the file's roots.
+func Load(r io.Reader, store ipld.GraphStore) ([]cid.Cid, error) {
+	cr, err := Reader_Make(r)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		c, value, err := cr.Next()
+		if err == io.EOF {
+			return cr.Header.Roots, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if !store.Put(value).Equals(c) {
+			return nil, ErrBlockCIDMismatch
+		}
+	}
+}
+
+// Writer writes the blocks of a CAR file as they are given, so that a file may be written without
+// holding its blocks in memory.
+type Writer struct {
+	_w io.Writer
+}
+
+// Writes the header of a CAR file with the given roots to w.
+func Writer_Make(w io.Writer, roots []cid.Cid) (*Writer, error) {
+	data, err := cbornode.DumpObject(&CarHeader{Roots: roots, Version: 1})
+	if err != nil {
+		return nil, err
+	}
+	if err := _writeSection(w, data); err != nil {
+		return nil, err
+	}
+	return &Writer{_w: w}, nil
+}
+
+func (w *Writer) Put(c cid.Cid, value util.Bytes) error {
+	return _writeSection(w._w, append(c.Bytes(), value...))
+}
+
+func _writeSection(w io.Writer, data util.Bytes) error {
+	prefix := make(util.Bytes, binary.MaxVarintLen64)
+	n := binary.PutUvarint(prefix, uint64(len(data)))
+	if _, err := w.Write(prefix[:n]); err != nil {
+		return err
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+// Returns io.EOF only if there is no data before the end of the file.
+func _readSection(r *bufio.Reader) (util.Bytes, error) {
+	length, err := binary.ReadUvarint(r)
+	if err == io.EOF {
+		return nil, io.EOF
+	} else if err != nil {
+		return nil, ErrMalformed
+	}
+	if length == 0 || length > MaxSectionSize {
+		return nil, ErrMalformed
+	}
+	data := make(util.Bytes, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, ErrMalformed
+	}
+	return data, nil
+}
@@ -0,0 +1,189 @@
+package car
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+
+	ipld "github.com/filecoin-project/specs/libraries/ipld"
+	util "github.com/filecoin-project/specs/util"
+	cid "github.com/ipfs/go-cid"
+	cbornode "github.com/ipfs/go-ipld-cbor"
+	mh "github.com/multiformats/go-multihash"
+)
+
+func _testCID(value util.Bytes) cid.Cid {
+	c, err := cid.NewPrefixV1(cid.DagCBOR, mh.SHA2_256).Sum(value)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+func _testBlock(t *testing.T, name string) (cid.Cid, util.Bytes) {
+	value, err := cbornode.DumpObject(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return _testCID(value), value
+}
+
+// Returns a CAR file holding the given blocks, in order, whose root is the first.
+func _testCAR(t *testing.T, names ...string) ([]cid.Cid, []byte) {
+	var buf bytes.Buffer
+	var cids []cid.Cid
+	var values []util.Bytes
+	for _, name := range names {
+		c, value := _testBlock(t, name)
+		cids = append(cids, c)
+		values = append(values, value)
+	}
+	w, err := Writer_Make(&buf, cids[:1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, c := range cids {
+		if err := w.Put(c, values[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return cids, buf.Bytes()
+}
+
+func _section(data []byte) []byte {
+	prefix := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(prefix, uint64(len(data)))
+	return append(prefix[:n], data...)
+}
+
+func _header(t *testing.T, h CarHeader) []byte {
+	data, err := cbornode.DumpObject(&h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return _section(data)
+}
+
+func TestWriteAndRead(t *testing.T) {
+	cids, data := _testCAR(t, "a", "b", "c")
+	r, err := Reader_Make(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Header.Roots) != 1 || r.Header.Roots[0] != cids[0] {
+		t.Errorf("roots %v, want %v", r.Header.Roots, cids[:1])
+	}
+	for _, want := range cids {
+		c, value, err := r.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c != want || _testCID(value) != want {
+			t.Errorf("block %v read, want %v", c, want)
+		}
+	}
+	if _, _, err := r.Next(); err != io.EOF {
+		t.Errorf("error %v after the last block, want io.EOF", err)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	cids, data := _testCAR(t, "a", "b")
+	store := ipld.MemGraphStore_Make()
+	roots, err := Load(bytes.NewReader(data), store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(roots) != 1 || roots[0] != cids[0] {
+		t.Errorf("roots %v, want %v", roots, cids[:1])
+	}
+	for _, c := range cids {
+		if _, found := store.Get(c); !found {
+			t.Errorf("block %v not loaded", c)
+		}
+	}
+
+	// A block whose value does not match its CID.
+	a, _ := _testBlock(t, "a")
+	_, b := _testBlock(t, "b")
+	var buf bytes.Buffer
+	w, err := Writer_Make(&buf, []cid.Cid{a})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Put(a, b); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(&buf, ipld.MemGraphStore_Make()); err != ErrBlockCIDMismatch {
+		t.Errorf("error %v loading a mismatched block, want %v", err, ErrBlockCIDMismatch)
+	}
+}
+
+func TestReadHeaderMalformed(t *testing.T) {
+	root, _ := _testBlock(t, "a")
+	cases := map[string]struct {
+		data []byte
+		err  error
+	}{
+		"empty":         {nil, ErrMalformed},
+		"zero length":   {_section(nil), ErrMalformed},
+		"truncated":     {_header(t, CarHeader{Roots: []cid.Cid{root}, Version: 1})[:10], ErrMalformed},
+		"not a header":  {_section([]byte{0xff}), ErrMalformed},
+		"version 2":     {_header(t, CarHeader{Roots: []cid.Cid{root}, Version: 2}), ErrUnsupportedVersion},
+		"unterminated":  {[]byte{0x80}, ErrMalformed},
+		"over max size": {_section(make([]byte, MaxSectionSize+1)), ErrMalformed},
+	}
+	for name, c := range cases {
+		if _, err := Reader_Make(bytes.NewReader(c.data)); err != c.err {
+			t.Errorf("%s: error %v, want %v", name, err, c.err)
+		}
+	}
+}
+
+func TestReadBlockMalformed(t *testing.T) {
+	_, data := _testCAR(t, "a")
+	headerOnly := func() []byte {
+		r, err := Reader_Make(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The header is followed by the one block.
+		_, value, err := r.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := _testCID(value)
+		return data[:len(data)-len(_section(append(c.Bytes(), value...)))]
+	}()
+
+	block := _section(append(_testCID(util.Bytes("x")).Bytes(), 'x'))
+	cases := map[string][]byte{
+		"truncated":     block[:len(block)-1],
+		"zero length":   _section(nil),
+		"no CID":        _section([]byte{0xff, 0xff}),
+		"over max size": _section(make([]byte, MaxSectionSize+1)),
+		"unterminated":  {0x80},
+	}
+	for name, section := range cases {
+		r, err := Reader_Make(bytes.NewReader(append(append([]byte{}, headerOnly...), section...)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := r.Next(); err != ErrMalformed {
+			t.Errorf("%s: error %v, want %v", name, err, ErrMalformed)
+		}
+	}
+
+	// A section of the maximum size is read.
+	prefix := _testCID(util.Bytes("x")).Bytes()
+	max := make([]byte, MaxSectionSize)
+	copy(max, prefix)
+	r, err := Reader_Make(bytes.NewReader(append(append([]byte{}, headerOnly...), _section(max)...)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, value, err := r.Next(); err != nil || len(value) != MaxSectionSize-len(prefix) {
+		t.Errorf("section of the maximum size: %d bytes read, error %v", len(value), err)
+	}
+}
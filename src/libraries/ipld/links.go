@@ -0,0 +1,21 @@
+package ipld
+
+import (
+	util "github.com/filecoin-project/specs/util"
+	cid "github.com/ipfs/go-cid"
+	cbornode "github.com/ipfs/go-ipld-cbor"
+	mh "github.com/multiformats/go-multihash"
+)
+
+// Returns the CIDs to which a serialized (DAG-CBOR) block links.
+func Links(value util.Bytes) ([]cid.Cid, error) {
+	n, err := cbornode.Decode(value, mh.SHA2_256, -1)
+	if err != nil {
+		return nil, err
+	}
+	var ret []cid.Cid
+	for _, l := range n.Links() {
+		ret = append(ret, l.Cid)
+	}
+	return ret, nil
+}
@@ -0,0 +1,36 @@
+package ipld
+
+import (
+	util "github.com/filecoin-project/specs/util"
+	cid "github.com/ipfs/go-cid"
+	mh "github.com/multiformats/go-multihash"
+)
+
+// A GraphStore holding values in memory, by their DAG-CBOR SHA2-256 CID (e.g., for tests and tools).
+type MemGraphStore struct {
+	GraphStore_I
+	_values map[cid.Cid]util.Bytes
+}
+
+func MemGraphStore_Make() *MemGraphStore {
+	return &MemGraphStore{_values: make(map[cid.Cid]util.Bytes)}
+}
+
+func (s *MemGraphStore) Get(c cid.Cid) (util.Bytes, bool) {
+	v, found := s._values[c]
+	return v, found
+}
+
+func (s *MemGraphStore) Put(value util.Bytes) cid.Cid {
+	c, err := cid.NewPrefixV1(cid.DagCBOR, mh.SHA2_256).Sum(value)
+	if err != nil {
+		panic(err)
+	}
+	s._values[c] = value
+	return c
+}
+
+// Removes a value from the store, if present.
+func (s *MemGraphStore) Delete(c cid.Cid) {
+	delete(s._values, c)
+}
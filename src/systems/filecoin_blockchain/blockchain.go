@@ -0,0 +1,13 @@
+package filecoin_blockchain
+
+import (
+	chain "github.com/filecoin-project/specs/systems/filecoin_blockchain/struct/chain"
+)
+
+func (bs *BlockchainSubsystem_I) AssembleTipsets() []chain.Tipset {
+	return bs.ForkChoice().AssembleTipsets()
+}
+
+func (bs *BlockchainSubsystem_I) ChooseTipset(tipsets []chain.Tipset) chain.Tipset {
+	return bs.ForkChoice().ChooseTipset(tipsets)
+}
@@ -0,0 +1,273 @@
+package chainsync
+
+import (
+	"sort"
+
+	block "github.com/filecoin-project/specs/systems/filecoin_blockchain/struct/block"
+	chain "github.com/filecoin-project/specs/systems/filecoin_blockchain/struct/chain"
+	util "github.com/filecoin-project/specs/util"
+	cid "github.com/ipfs/go-cid"
+)
+
+func PartialGraph_Make(fullGraph BlockGraph) PartialGraph {
+	return &PartialGraph_I{
+		fullGraph_:  fullGraph,
+		Blocks_:     make(map[cid.Cid]bool),
+		childrenIn_: make(map[cid.Cid]int),
+		parentsIn_:  make(map[cid.Cid]int),
+	}
+}
+
+// A block becomes a tail if none of its parents are in the partial graph, and a head if none of its
+// children are. It stops being either if its children or parents in the partial graph become
+// heads or tails in turn. Each update is in the number of the block's parents and children.
+func (pg *PartialGraph_I) Add(b block.BlockHeader) {
+	c := block.BlockHeaderCID(b)
+	if pg.Blocks_[c] {
+		return
+	}
+	h, found := pg.fullGraph().Header(c)
+	util.Assert(found)
+	pg.Blocks_[c] = true
+
+	for _, pc := range pg.fullGraph().ParentCIDs(c) {
+		if !pg.Blocks_[pc] {
+			continue
+		}
+		if pg.childrenIn_[pc] == 0 {
+			pg.Heads_ = _removeHeader(pg.Heads_, pc)
+		}
+		pg.childrenIn_[pc]++
+		pg.parentsIn_[c]++
+	}
+	for _, cc := range pg.fullGraph().ChildCIDs(c) {
+		if !pg.Blocks_[cc] {
+			continue
+		}
+		if pg.parentsIn_[cc] == 0 {
+			pg.Tails_ = _removeHeader(pg.Tails_, cc)
+		}
+		pg.parentsIn_[cc]++
+		pg.childrenIn_[c]++
+	}
+
+	if pg.parentsIn_[c] == 0 {
+		pg.Tails_ = append(pg.Tails_, h)
+	}
+	if pg.childrenIn_[c] == 0 {
+		pg.Heads_ = append(pg.Heads_, h)
+	}
+}
+
+func (pg *PartialGraph_I) Remove(b block.BlockHeader) {
+	c := block.BlockHeaderCID(b)
+	if !pg.Blocks_[c] {
+		return
+	}
+	delete(pg.Blocks_, c)
+	if pg.parentsIn_[c] == 0 {
+		pg.Tails_ = _removeHeader(pg.Tails_, c)
+	}
+	if pg.childrenIn_[c] == 0 {
+		pg.Heads_ = _removeHeader(pg.Heads_, c)
+	}
+	delete(pg.parentsIn_, c)
+	delete(pg.childrenIn_, c)
+
+	for _, pc := range pg.fullGraph().ParentCIDs(c) {
+		if !pg.Blocks_[pc] {
+			continue
+		}
+		pg.childrenIn_[pc]--
+		if pg.childrenIn_[pc] == 0 {
+			h, _ := pg.fullGraph().Header(pc)
+			pg.Heads_ = append(pg.Heads_, h)
+		}
+	}
+	for _, cc := range pg.fullGraph().ChildCIDs(c) {
+		if !pg.Blocks_[cc] {
+			continue
+		}
+		pg.parentsIn_[cc]--
+		if pg.parentsIn_[cc] == 0 {
+			h, _ := pg.fullGraph().Header(cc)
+			pg.Tails_ = append(pg.Tails_, h)
+		}
+	}
+}
+
+// Heads and Tails are each expected to be small (one per chain or fork in the partial graph), so are
+// kept as lists. These are copied on removal, so that callers may iterate over them while updating
+// the partial graph.
+func _removeHeader(headers []block.BlockHeader, c cid.Cid) []block.BlockHeader {
+	ret := make([]block.BlockHeader, 0, len(headers))
+	for _, h := range headers {
+		if block.BlockHeaderCID(h) != c {
+			ret = append(ret, h)
+		}
+	}
+	return ret
+}
+
+func BlockGraph_Make() BlockGraph {
+	return &BlockGraph_I{
+		headers_:  make(map[cid.Cid]block.BlockHeader),
+		blocks_:   make(map[cid.Cid]block.Block),
+		parents_:  make(map[cid.Cid][]cid.Cid),
+		children_: make(map[cid.Cid][]cid.Cid),
+	}
+}
+
+func (g *BlockGraph_I) AddHeader(h block.BlockHeader) cid.Cid {
+	c := block.BlockHeaderCID(h)
+	if _, found := g.headers_[c]; found {
+		return c
+	}
+	g.headers_[c] = h
+
+	var parents []cid.Cid
+	for _, parent := range h.Parents() {
+		pc := block.BlockHeaderCID(parent)
+		parents = append(parents, pc)
+		g.children_[pc] = append(g.children_[pc], c)
+	}
+	g.parents_[c] = parents
+	return c
+}
+
+// The block's header must be in the graph.
+func (g *BlockGraph_I) AddBlock(b block.Block) {
+	c := block.BlockHeaderCID(b.Header())
+	_, found := g.headers_[c]
+	util.Assert(found)
+	g.blocks_[c] = b
+}
+
+func (g *BlockGraph_I) Header(c cid.Cid) (block.BlockHeader, bool) {
+	h, found := g.headers_[c]
+	return h, found
+}
+
+func (g *BlockGraph_I) Block(c cid.Cid) (block.Block, bool) {
+	b, found := g.blocks_[c]
+	return b, found
+}
+
+func (g *BlockGraph_I) ParentCIDs(c cid.Cid) []cid.Cid {
+	return g.parents_[c]
+}
+
+func (g *BlockGraph_I) ChildCIDs(c cid.Cid) []cid.Cid {
+	return g.children_[c]
+}
+
+// Returns the parent tipset of a block in the graph, or nil if any of its parents is not in the graph.
+func (g *BlockGraph_I) Parents(b block.BlockHeader) chain.Tipset {
+	c := block.BlockHeaderCID(b)
+	h, found := g.Header(c)
+	util.Assert(found)
+	var parents []block.BlockHeader
+	for _, pc := range g.ParentCIDs(c) {
+		ph, found := g.Header(pc)
+		if !found {
+			return nil
+		}
+		parents = append(parents, ph)
+	}
+	if len(parents) == 0 {
+		return nil
+	}
+	return &chain.Tipset_I{
+		BlockCIDs_: h.Parents(),
+		Blocks_:    parents,
+		Weight_:    h.ParentWeight(),
+		Epoch_:     parents[0].Epoch(),
+	}
+}
+
+// Returns the children of a block: their full blocks if fetched, or otherwise blocks with only a header.
+func (g *BlockGraph_I) Children(b block.BlockHeader) []block.Block {
+	var ret []block.Block
+	for _, cc := range g.ChildCIDs(block.BlockHeaderCID(b)) {
+		ret = append(ret, g._block(cc))
+	}
+	return ret
+}
+
+// Walks back from b along the parent links, stopping at the blocks of ts. Fails on reaching a block
+// which is not in the graph, or is at or before the epoch of ts without being in it (so is on a fork).
+func (g *BlockGraph_I) AncestorsTo(b block.BlockHeader, ts chain.Tipset) ([]block.BlockHeader, bool) {
+	stop := make(map[cid.Cid]bool)
+	for _, tsb := range ts.BlockCIDs() {
+		stop[block.BlockHeaderCID(tsb)] = true
+	}
+	c := block.BlockHeaderCID(b)
+	if stop[c] {
+		return nil, true
+	}
+
+	var ret []block.BlockHeader
+	reached := false
+	visited := map[cid.Cid]bool{c: true}
+	pending := []cid.Cid{c}
+	for len(pending) > 0 {
+		c := pending[0]
+		pending = pending[1:]
+		h, found := g.Header(c)
+		if !found || h.Epoch() <= ts.Epoch() {
+			return nil, false
+		}
+		ret = append(ret, h)
+
+		for _, pc := range g.ParentCIDs(c) {
+			if stop[pc] {
+				reached = true
+			} else if !visited[pc] {
+				visited[pc] = true
+				pending = append(pending, pc)
+			}
+		}
+	}
+	if !reached {
+		return nil, false
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Epoch() != ret[j].Epoch() {
+			return ret[i].Epoch() > ret[j].Epoch()
+		}
+		return block.BlockHeaderCID(ret[i]).String() < block.BlockHeaderCID(ret[j]).String()
+	})
+	return ret, true
+}
+
+// The candidates are the children of any one block of ts, whose parents must be exactly the blocks of ts.
+func (g *BlockGraph_I) TipsetChildren(ts chain.Tipset) []block.Block {
+	if len(ts.BlockCIDs()) == 0 {
+		return nil
+	}
+	tsBlocks := make(map[cid.Cid]bool)
+	for _, tsb := range ts.BlockCIDs() {
+		tsBlocks[block.BlockHeaderCID(tsb)] = true
+	}
+
+	var ret []block.Block
+	for _, cc := range g.ChildCIDs(block.BlockHeaderCID(ts.BlockCIDs()[0])) {
+		parents := g.ParentCIDs(cc)
+		matches := len(parents) == len(tsBlocks)
+		for _, pc := range parents {
+			matches = matches && tsBlocks[pc]
+		}
+		if matches {
+			ret = append(ret, g._block(cc))
+		}
+	}
+	return ret
+}
+
+func (g *BlockGraph_I) _block(c cid.Cid) block.Block {
+	if b, found := g.blocks_[c]; found {
+		return b
+	}
+	return &block.Block_I{Header_: g.headers_[c]}
+}
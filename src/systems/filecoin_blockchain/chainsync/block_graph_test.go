@@ -0,0 +1,276 @@
+package chainsync
+
+import (
+	"sort"
+	"testing"
+
+	abi "github.com/filecoin-project/specs-actors/actors/abi"
+	block "github.com/filecoin-project/specs/systems/filecoin_blockchain/struct/block"
+	chain "github.com/filecoin-project/specs/systems/filecoin_blockchain/struct/chain"
+	cid "github.com/ipfs/go-cid"
+)
+
+// A block graph over genesis "g":
+//
+//	g <- a1 <- m <- n
+//	g <- a2 <- m
+//	a1 <- f <- fc
+//	g <- b
+//	a1 <- y <- x
+//
+// with m's parents the tipset {a1, a2}, f forking from a1 alone, and y (unlike x) never added.
+type _graphFixture struct {
+	graph   BlockGraph
+	headers map[string]block.BlockHeader
+}
+
+func _graphFixture_Make(t *testing.T) *_graphFixture {
+	ret := &_graphFixture{graph: BlockGraph_Make(), headers: make(map[string]block.BlockHeader)}
+	add := func(name string, epoch abi.ChainEpoch, miner uint64, parents ...string) {
+		var ps []block.BlockHeader
+		for _, p := range parents {
+			ps = append(ps, ret.headers[p])
+		}
+		ret.headers[name] = _header(ps, epoch, 0, _miner(t, miner))
+	}
+	add("g", 0, 1000)
+	add("a1", 1, 1001, "g")
+	add("a2", 1, 1002, "g")
+	add("b", 1, 1003, "g")
+	add("m", 2, 1001, "a1", "a2")
+	add("f", 2, 1001, "a1")
+	add("y", 2, 1002, "a1")
+	add("n", 3, 1001, "m")
+	add("fc", 3, 1001, "f")
+	add("x", 3, 1002, "y")
+	for name, h := range ret.headers {
+		if name != "y" {
+			ret.graph.AddHeader(h)
+		}
+	}
+	return ret
+}
+
+func (f *_graphFixture) get(names ...string) []block.BlockHeader {
+	var ret []block.BlockHeader
+	for _, name := range names {
+		ret = append(ret, f.headers[name])
+	}
+	return ret
+}
+
+func (f *_graphFixture) tipset(names ...string) chain.Tipset {
+	headers := f.get(names...)
+	ret := &chain.Tipset_I{BlockCIDs_: headers, Blocks_: headers}
+	if len(headers) > 0 {
+		ret.Epoch_ = headers[0].Epoch()
+	}
+	return ret
+}
+
+// Returns the fixture's names for headers, sorted.
+func (f *_graphFixture) names(headers []block.BlockHeader) []string {
+	byCID := make(map[cid.Cid]string)
+	for name, h := range f.headers {
+		byCID[_cid(h)] = name
+	}
+	var ret []string
+	for _, h := range headers {
+		ret = append(ret, byCID[_cid(h)])
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+func _namesEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestPartialGraphHeadsAndTails(t *testing.T) {
+	type op struct {
+		remove bool
+		name   string
+	}
+	add := func(names ...string) []op {
+		var ret []op
+		for _, name := range names {
+			ret = append(ret, op{false, name})
+		}
+		return ret
+	}
+	remove := func(names ...string) []op {
+		var ret []op
+		for _, name := range names {
+			ret = append(ret, op{true, name})
+		}
+		return ret
+	}
+	then := func(ops ...[]op) []op {
+		var ret []op
+		for _, o := range ops {
+			ret = append(ret, o...)
+		}
+		return ret
+	}
+
+	cases := []struct {
+		name  string
+		ops   []op
+		heads []string
+		tails []string
+	}{
+		{"single block", add("g"), []string{"g"}, []string{"g"}},
+		{"chain", add("g", "a1"), []string{"a1"}, []string{"g"}},
+		{"child first", add("a1", "g"), []string{"a1"}, []string{"g"}},
+		{"tipset", add("g", "a1", "a2", "m"), []string{"m"}, []string{"g"}},
+		{"disconnected", add("g", "m"), []string{"g", "m"}, []string{"g", "m"}},
+		{"gap filled", add("g", "m", "a1"), []string{"m"}, []string{"g"}},
+		{"one of two parents", add("a1", "a2", "m"), []string{"m"}, []string{"a1", "a2"}},
+		{"fork", add("g", "a1", "m", "f"), []string{"f", "m"}, []string{"g"}},
+		{"added twice", add("g", "a1", "g"), []string{"a1"}, []string{"g"}},
+		{"remove head", then(add("g", "a1", "a2", "m"), remove("m")), []string{"a1", "a2"}, []string{"g"}},
+		{"remove tail", then(add("g", "a1"), remove("g")), []string{"a1"}, []string{"a1"}},
+		{"remove middle", then(add("g", "a1", "m"), remove("a1")), []string{"g", "m"}, []string{"g", "m"}},
+		{"remove one of two parents", then(add("g", "a1", "a2", "m"), remove("a1")), []string{"m"}, []string{"g"}},
+		{"remove fork", then(add("g", "a1", "m", "f"), remove("f")), []string{"m"}, []string{"g"}},
+		{"remove absent", then(add("g"), remove("a1")), []string{"g"}, []string{"g"}},
+		{"remove all", then(add("g", "a1"), remove("a1", "g")), nil, nil},
+		{"remove and re-add", then(add("g", "a1", "m"), remove("a1"), add("a1")), []string{"m"}, []string{"g"}},
+	}
+	for _, tc := range cases {
+		f := _graphFixture_Make(t)
+		pg := PartialGraph_Make(f.graph)
+		members := make(map[string]bool)
+		for _, o := range tc.ops {
+			if o.remove {
+				pg.Remove(f.headers[o.name])
+				delete(members, o.name)
+			} else {
+				pg.Add(f.headers[o.name])
+				members[o.name] = true
+			}
+		}
+
+		if heads := f.names(pg.Heads()); !_namesEqual(heads, tc.heads) {
+			t.Errorf("%s: heads %v, want %v", tc.name, heads, tc.heads)
+		}
+		if tails := f.names(pg.Tails()); !_namesEqual(tails, tc.tails) {
+			t.Errorf("%s: tails %v, want %v", tc.name, tails, tc.tails)
+		}
+		if len(pg.Blocks()) != len(members) {
+			t.Errorf("%s: %d blocks, want %d", tc.name, len(pg.Blocks()), len(members))
+		}
+
+		// The counters hold, for each block in the partial graph, the number of its parents and children in it.
+		impl := pg.Impl()
+		for name := range members {
+			c := _cid(f.headers[name])
+			parents, children := 0, 0
+			for _, pc := range f.graph.ParentCIDs(c) {
+				if pg.Blocks()[pc] {
+					parents++
+				}
+			}
+			for _, cc := range f.graph.ChildCIDs(c) {
+				if pg.Blocks()[cc] {
+					children++
+				}
+			}
+			if impl.parentsIn_[c] != parents || impl.childrenIn_[c] != children {
+				t.Errorf("%s: %s has %d parents and %d children in the graph, counted %d and %d",
+					tc.name, name, parents, children, impl.parentsIn_[c], impl.childrenIn_[c])
+			}
+		}
+		for c := range impl.parentsIn_ {
+			if !pg.Blocks()[c] {
+				t.Errorf("%s: parents counted for block %v not in the graph", tc.name, c)
+			}
+		}
+		for c := range impl.childrenIn_ {
+			if !pg.Blocks()[c] {
+				t.Errorf("%s: children counted for block %v not in the graph", tc.name, c)
+			}
+		}
+	}
+}
+
+func TestAncestorsTo(t *testing.T) {
+	f := _graphFixture_Make(t)
+	cases := []struct {
+		name      string
+		from      string
+		to        []string
+		ancestors []string
+		ok        bool
+	}{
+		{"parent tipset", "m", []string{"a1", "a2"}, []string{"m"}, true},
+		{"two epochs", "n", []string{"a1", "a2"}, []string{"m", "n"}, true},
+		{"to genesis", "n", []string{"g"}, []string{"a1", "a2", "m", "n"}, true},
+		{"fork child of tipset block", "fc", []string{"a1"}, []string{"f", "fc"}, true},
+		{"block in tipset", "a1", []string{"a1", "a2"}, nil, true},
+		{"fork", "fc", []string{"m"}, nil, false},
+		{"fork below tipset", "n", []string{"b"}, nil, false},
+		{"tipset after block", "a1", []string{"n"}, nil, false},
+		{"missing block", "x", []string{"a1"}, nil, false},
+	}
+	for _, tc := range cases {
+		ancestors, ok := f.graph.AncestorsTo(f.headers[tc.from], f.tipset(tc.to...))
+		if ok != tc.ok {
+			t.Errorf("%s: ok %v, want %v", tc.name, ok, tc.ok)
+			continue
+		}
+		if names := f.names(ancestors); !_namesEqual(names, tc.ancestors) {
+			t.Errorf("%s: ancestors %v, want %v", tc.name, names, tc.ancestors)
+		}
+		for i := 1; i < len(ancestors); i++ {
+			if ancestors[i].Epoch() > ancestors[i-1].Epoch() {
+				t.Errorf("%s: ancestors not latest first", tc.name)
+			}
+		}
+	}
+}
+
+func TestTipsetChildren(t *testing.T) {
+	f := _graphFixture_Make(t)
+	m := &block.Block_I{Header_: f.headers["m"]}
+	f.graph.AddBlock(m)
+
+	cases := []struct {
+		name     string
+		tipset   []string
+		children []string
+	}{
+		{"tipset", []string{"a1", "a2"}, []string{"m"}},
+		{"tipset in other order", []string{"a2", "a1"}, []string{"m"}},
+		{"one block of tipset", []string{"a1"}, []string{"f"}},
+		{"several children", []string{"g"}, []string{"a1", "a2", "b"}},
+		{"child missing parent", []string{"y"}, []string{"x"}},
+		{"no children", []string{"n"}, nil},
+		{"empty tipset", nil, nil},
+	}
+	for _, tc := range cases {
+		children := f.graph.TipsetChildren(f.tipset(tc.tipset...))
+		var headers []block.BlockHeader
+		for _, b := range children {
+			headers = append(headers, b.Header())
+		}
+		if names := f.names(headers); !_namesEqual(names, tc.children) {
+			t.Errorf("%s: children %v, want %v", tc.name, names, tc.children)
+		}
+	}
+
+	// Children are full blocks if fetched, and otherwise blocks with only a header.
+	for _, b := range f.graph.TipsetChildren(f.tipset("a1", "a2")) {
+		if b != block.Block(m) {
+			t.Errorf("child %v is not the fetched block", _cid(b.Header()))
+		}
+	}
+}
@@ -1 +1,416 @@
 package chainsync
+
+import (
+	"sort"
+
+	ipld "github.com/filecoin-project/specs/libraries/ipld"
+	block "github.com/filecoin-project/specs/systems/filecoin_blockchain/struct/block"
+	chain "github.com/filecoin-project/specs/systems/filecoin_blockchain/struct/chain"
+	util "github.com/filecoin-project/specs/util"
+	cid "github.com/ipfs/go-cid"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+)
+
+// The maximum number of headers requested at once when fetching a block's missing ancestors.
+const FetchHeadersBatchSize = 50
+
+// The maximum number of TargetHeads.
+const MaxTargetHeads = 10
+
+// BlockFetcher fetches chain data from peers (e.g., using the BlockSync protocol).
+type BlockFetcher interface {
+	// Returns the peers from which chain data may be fetched.
+	Peers() []peer.ID
+
+	// Returns, from peer p, the header with CID c followed by up to count-1 of its ancestors,
+	// in order of decreasing epoch.
+	FetchHeaders(p peer.ID, c cid.Cid, count int) ([]block.BlockHeader, error)
+
+	// Returns, from peer p, the full block (header and messages) with CID c.
+	FetchBlock(p peer.ID, c cid.Cid) (block.Block, error)
+}
+
+// BlockValidator validates a full block, whose parents are valid, against its parent tipset
+// (e.g., StoragePowerConsensusSubsystem.ValidateBlock).
+type BlockValidator interface {
+	ValidateBlock(b block.Block) error
+}
+
+// BlockValidatorFunc adapts a function to a BlockValidator.
+type BlockValidatorFunc func(b block.Block) error
+
+func (f BlockValidatorFunc) ValidateBlock(b block.Block) error {
+	return f(b)
+}
+
+// Constructs a ChainSync which syncs forward from checkpoint, storing the chain data it fetches in
+// cacheChainStore.
+func ChainSync_Make(checkpoint chain.TrustedCheckpoint, cacheChainStore ipld.GraphStore, fetcher BlockFetcher, validator BlockValidator) ChainSync {
+	vg := ValidationGraph_Make(cacheChainStore)
+	h := block.BlockHeader(checkpoint)
+	vg.Graph().AddHeader(h)
+	vg.FinalityTipset().Add(h)
+	return &ChainSync_I{
+		LastTrustedCheckpoint_: checkpoint,
+		VGraph_:                vg,
+		Fetcher_:               fetcher,
+		Validator_:             validator,
+		sources_:               make(map[cid.Cid]peer.ID),
+		invalid_:               make(map[cid.Cid]bool),
+	}
+}
+
+func (cs *ChainSync_I) OnBlockHeader(h block.BlockHeader, from peer.ID) {
+	c := block.BlockHeaderCID(h)
+	if cs.invalid()[c] || cs._tracked(c) {
+		return
+	}
+	cs._addHeader(h, from)
+	cs.VGraph().UnconnectedG().Add(h)
+	cs._updateTargetHeads()
+}
+
+// Each round fetches missing headers, connects the blocks which now descend from FinalityTipset,
+// fetches their messages, and validates those whose parents are valid. Rounds are repeated until
+// none makes progress (i.e., all blocks are valid, or waiting for data no peer has provided).
+func (cs *ChainSync_I) Sync() {
+	for {
+		progress := cs._fetchHeaders()
+		progress = cs._connectBlocks() || progress
+		progress = cs._fetchBlocks() || progress
+		progress = cs._validateBlocks() || progress
+		cs._updateTargetHeads()
+		if !progress {
+			return
+		}
+	}
+}
+
+// Fetches the missing parents of the tails of UnconnectedG, from the peers which announced them.
+// Headers are not fetched beyond the epoch of LastTrustedCheckpoint: tails at or before that epoch which
+// are not in its tipset cannot descend from it, so are rejected.
+func (cs *ChainSync_I) _fetchHeaders() bool {
+	vg := cs.VGraph()
+	checkpoint := block.BlockHeader(cs.LastTrustedCheckpoint())
+	progress := false
+	for _, tail := range vg.UnconnectedG().Tails() {
+		c := block.BlockHeaderCID(tail)
+		if !vg.UnconnectedG().Blocks()[c] {
+			// Rejected as the descendant of an earlier tail.
+			continue
+		}
+
+		if tail.Epoch() <= checkpoint.Epoch() {
+			if _checkpointSibling(tail, checkpoint) {
+				// A checkpoint identifies its tipset by its parents (see Checkpoints), so blocks of the
+				// same epoch with the same parents may belong to it. Such blocks are connected, as their
+				// parents are those of the checkpoint, but fetched and validated like any other.
+				vg.UnconnectedG().Remove(tail)
+				vg.ConnectedG().Add(tail)
+			} else {
+				cs._reject(c)
+			}
+			progress = true
+			continue
+		}
+
+		for _, parent := range tail.Parents() {
+			pc := block.BlockHeaderCID(parent)
+			if cs.invalid()[pc] {
+				cs._reject(c)
+				progress = true
+				break
+			}
+			if _, found := vg.Graph().Header(pc); found {
+				continue
+			}
+			progress = cs._fetchAncestors(pc, cs.sources()[c]) || progress
+		}
+	}
+	return progress
+}
+
+// Fetches the header with CID c and a batch of its ancestors, from source or, failing that, another peer,
+// and adds them to UnconnectedG. Only headers linked to c by the Parents of the preceding headers are added,
+// and none beyond the epoch of LastTrustedCheckpoint.
+func (cs *ChainSync_I) _fetchAncestors(c cid.Cid, source peer.ID) bool {
+	vg := cs.VGraph()
+	checkpointEpoch := block.BlockHeader(cs.LastTrustedCheckpoint()).Epoch()
+	for _, p := range cs._peersFor(source) {
+		headers, err := cs.Fetcher().FetchHeaders(p, c, FetchHeadersBatchSize)
+		if err != nil || len(headers) == 0 || block.BlockHeaderCID(headers[0]) != c {
+			continue
+		}
+
+		wanted := map[cid.Cid]bool{c: true}
+		for _, h := range headers {
+			hc := block.BlockHeaderCID(h)
+			if !wanted[hc] || cs.invalid()[hc] || cs._tracked(hc) {
+				continue
+			}
+			cs._addHeader(h, p)
+			vg.UnconnectedG().Add(h)
+			if h.Epoch() <= checkpointEpoch {
+				continue
+			}
+			for _, parent := range h.Parents() {
+				wanted[block.BlockHeaderCID(parent)] = true
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// Moves to ConnectedG the blocks of UnconnectedG whose parents are all connected to FinalityTipset.
+// Such blocks have no parents in UnconnectedG, so are among its tails.
+func (cs *ChainSync_I) _connectBlocks() bool {
+	vg := cs.VGraph()
+	progress := false
+	for moved := true; moved; {
+		moved = false
+		for _, tail := range vg.UnconnectedG().Tails() {
+			if cs._parentsIn(tail, vg.FinalityTipset(), vg.ValidG(), vg.FetchedG(), vg.ConnectedG()) {
+				vg.UnconnectedG().Remove(tail)
+				vg.ConnectedG().Add(tail)
+				moved = true
+				progress = true
+			}
+		}
+	}
+	return progress
+}
+
+// Fetches the messages of the blocks of ConnectedG, heaviest first, moving them to FetchedG.
+// That the messages are those committed to by a block's header is checked on validation.
+func (cs *ChainSync_I) _fetchBlocks() bool {
+	vg := cs.VGraph()
+	progress := false
+	for _, h := range _sortedByWeight(_headers(vg.ConnectedG())) {
+		c := block.BlockHeaderCID(h)
+		for _, p := range cs._peersFor(cs.sources()[c]) {
+			b, err := cs.Fetcher().FetchBlock(p, c)
+			if err != nil || block.BlockHeaderCID(b.Header()) != c {
+				continue
+			}
+			vg.Graph().AddBlock(b)
+			vg.ConnectedG().Remove(h)
+			vg.FetchedG().Add(h)
+			progress = true
+			break
+		}
+	}
+	return progress
+}
+
+// Validates the blocks of FetchedG whose parents are valid, one at a time and heaviest first (so that the
+// children of a valid block are considered with the remaining blocks), moving valid blocks to ValidG and
+// rejecting invalid ones.
+func (cs *ChainSync_I) _validateBlocks() bool {
+	vg := cs.VGraph()
+	checkpoint := block.BlockHeader(cs.LastTrustedCheckpoint())
+	progress := false
+	for {
+		var ready []block.BlockHeader
+		for _, tail := range vg.FetchedG().Tails() {
+			if cs._parentsIn(tail, vg.FinalityTipset(), vg.ValidG()) || _checkpointSibling(tail, checkpoint) {
+				ready = append(ready, tail)
+			}
+		}
+		if len(ready) == 0 {
+			return progress
+		}
+
+		h := _sortedByWeight(ready)[0]
+		c := block.BlockHeaderCID(h)
+		b, found := vg.Graph().Block(c)
+		util.Assert(found)
+		if err := cs.Validator().ValidateBlock(b); err != nil {
+			cs._reject(c)
+		} else {
+			vg.FetchedG().Remove(h)
+			vg.ValidG().Add(h)
+		}
+		progress = true
+	}
+}
+
+// Sets TargetHeads to the heaviest of the blocks without children in the ValidationGraph, excluding those
+// lighter than the best valid head. Blocks are compared by ParentWeight, the weight of the chain they extend,
+// since their own weight is only known once they are validated.
+func (cs *ChainSync_I) _updateTargetHeads() {
+	vg := cs.VGraph()
+
+	var bestValidWeight block.ChainWeight
+	for _, h := range vg.ValidG().Heads() {
+		if h.ParentWeight() > bestValidWeight {
+			bestValidWeight = h.ParentWeight()
+		}
+	}
+
+	var heads []block.BlockHeader
+	for _, pg := range []PartialGraph{vg.ValidG(), vg.FetchedG(), vg.ConnectedG(), vg.UnconnectedG()} {
+		for _, h := range pg.Heads() {
+			if h.ParentWeight() >= bestValidWeight && !cs._hasTrackedChild(h) {
+				heads = append(heads, h)
+			}
+		}
+	}
+	heads = _sortedByWeight(heads)
+	if len(heads) > MaxTargetHeads {
+		heads = heads[:MaxTargetHeads]
+	}
+
+	vg.Impl().TargetHeads_ = heads
+	vg.Impl().BestTargetHead_ = nil
+	if len(heads) > 0 {
+		vg.Impl().BestTargetHead_ = heads[0]
+	}
+}
+
+// Marks a block and its descendants invalid, removing them from the ValidationGraph.
+func (cs *ChainSync_I) _reject(c cid.Cid) {
+	if cs.invalid()[c] {
+		return
+	}
+	cs.invalid()[c] = true
+
+	vg := cs.VGraph()
+	if h, found := vg.Graph().Header(c); found {
+		for _, pg := range []PartialGraph{vg.ValidG(), vg.FetchedG(), vg.ConnectedG(), vg.UnconnectedG()} {
+			if pg.Blocks()[c] {
+				pg.Remove(h)
+			}
+		}
+	}
+	for _, cc := range vg.Graph().ChildCIDs(c) {
+		cs._reject(cc)
+	}
+}
+
+func (cs *ChainSync_I) _addHeader(h block.BlockHeader, from peer.ID) {
+	vg := cs.VGraph()
+	c := vg.Graph().AddHeader(h)
+	vg.CacheChainStore().Put(util.Bytes(block.Serialize_BlockHeader(h)))
+	if _, found := cs.sources()[c]; !found {
+		cs.sources()[c] = from
+	}
+}
+
+// Returns whether a block is in one of the ValidationGraph's partial graphs.
+func (cs *ChainSync_I) _tracked(c cid.Cid) bool {
+	vg := cs.VGraph()
+	for _, pg := range []PartialGraph{vg.FinalityTipset(), vg.ValidG(), vg.FetchedG(), vg.ConnectedG(), vg.UnconnectedG()} {
+		if pg.Blocks()[c] {
+			return true
+		}
+	}
+	return false
+}
+
+func (cs *ChainSync_I) _hasTrackedChild(h block.BlockHeader) bool {
+	for _, cc := range cs.VGraph().Graph().ChildCIDs(block.BlockHeaderCID(h)) {
+		if cs._tracked(cc) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns whether all of a block's parents are in one of the given partial graphs.
+func (cs *ChainSync_I) _parentsIn(h block.BlockHeader, graphs ...PartialGraph) bool {
+	for _, pc := range cs.VGraph().Graph().ParentCIDs(block.BlockHeaderCID(h)) {
+		found := false
+		for _, pg := range graphs {
+			found = found || pg.Blocks()[pc]
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the peers from which to fetch the data of a block announced by source: source first, followed
+// by the others.
+func (cs *ChainSync_I) _peersFor(source peer.ID) []peer.ID {
+	ret := []peer.ID{}
+	if source != "" {
+		ret = append(ret, source)
+	}
+	for _, p := range cs.Fetcher().Peers() {
+		if p != source {
+			ret = append(ret, p)
+		}
+	}
+	return ret
+}
+
+// Returns whether h may belong to the tipset of checkpoint: whether it is another block of the same epoch,
+// with the same parents. Its parent state and the rest are checked when it is validated.
+func _checkpointSibling(h block.BlockHeader, checkpoint block.BlockHeader) bool {
+	if block.BlockHeaderCID(h) == block.BlockHeaderCID(checkpoint) || h.Epoch() != checkpoint.Epoch() {
+		return false
+	}
+	if len(h.Parents()) != len(checkpoint.Parents()) {
+		return false
+	}
+	for i, parent := range h.Parents() {
+		if block.BlockHeaderCID(parent) != block.BlockHeaderCID(checkpoint.Parents()[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the blocks of the checkpoint's tipset known to be valid: the checkpoint, and the siblings of it
+// which have been validated.
+func _checkpointTipset(cs ChainSync) []block.BlockHeader {
+	vg := cs.VGraph()
+	checkpoint := block.BlockHeader(cs.LastTrustedCheckpoint())
+	ret := _headers(vg.FinalityTipset())
+	for _, h := range _headers(vg.ValidG()) {
+		if _checkpointSibling(h, checkpoint) {
+			ret = append(ret, h)
+		}
+	}
+	return ret
+}
+
+func _headers(pg PartialGraph) []block.BlockHeader {
+	var ret []block.BlockHeader
+	for c := range pg.Blocks() {
+		h, found := pg.fullGraph().Header(c)
+		util.Assert(found)
+		ret = append(ret, h)
+	}
+	return ret
+}
+
+// Sorts headers by decreasing ParentWeight, then decreasing epoch, breaking ties by CID.
+func _sortedByWeight(headers []block.BlockHeader) []block.BlockHeader {
+	sort.Slice(headers, func(i, j int) bool {
+		a, b := headers[i], headers[j]
+		if a.ParentWeight() != b.ParentWeight() {
+			return a.ParentWeight() > b.ParentWeight()
+		}
+		if a.Epoch() != b.Epoch() {
+			return a.Epoch() > b.Epoch()
+		}
+		return block.BlockHeaderCID(a).String() < block.BlockHeaderCID(b).String()
+	})
+	return headers
+}
+
+func ValidationGraph_Make(cacheChainStore ipld.GraphStore) ValidationGraph {
+	g := BlockGraph_Make()
+	return &ValidationGraph_I{
+		CacheChainStore_: cacheChainStore,
+		Graph_:           g,
+		FinalityTipset_:  PartialGraph_Make(g),
+		ValidG_:          PartialGraph_Make(g),
+		FetchedG_:        PartialGraph_Make(g),
+		ConnectedG_:      PartialGraph_Make(g),
+		UnconnectedG_:    PartialGraph_Make(g),
+	}
+}
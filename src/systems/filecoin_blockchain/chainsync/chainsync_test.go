@@ -0,0 +1,322 @@
+package chainsync
+
+import (
+	"errors"
+	"testing"
+
+	addr "github.com/filecoin-project/go-address"
+	abi "github.com/filecoin-project/specs-actors/actors/abi"
+	ipld "github.com/filecoin-project/specs/libraries/ipld"
+	block "github.com/filecoin-project/specs/systems/filecoin_blockchain/struct/block"
+	chain "github.com/filecoin-project/specs/systems/filecoin_blockchain/struct/chain"
+	cid "github.com/ipfs/go-cid"
+)
+
+// An in-memory GraphStore for the chain data ChainSync caches. Values are addressed as DAG-CBOR, as
+// block.BlockHeaderCID addresses headers.
+var ErrTestInvalidBlock = errors.New("Test block invalid")
+
+// A BlockValidator which rejects the given blocks, and records the blocks it is asked to validate.
+type _testValidator struct {
+	invalid   map[cid.Cid]bool
+	validated []cid.Cid
+}
+
+func (v *_testValidator) ValidateBlock(b block.Block) error {
+	c := block.BlockHeaderCID(b.Header())
+	v.validated = append(v.validated, c)
+	if v.invalid[c] {
+		return ErrTestInvalidBlock
+	}
+	return nil
+}
+
+func _miner(t *testing.T, id uint64) addr.Address {
+	a, err := addr.NewIDAddress(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func _header(parents []block.BlockHeader, epoch abi.ChainEpoch, weight block.ChainWeight, miner addr.Address) block.BlockHeader {
+	return &block.BlockHeader_I{
+		Parents_:      parents,
+		ParentWeight_: weight,
+		Epoch_:        epoch,
+		Miner_:        miner,
+	}
+}
+
+// Returns a chain of n blocks mined by miner on parent, one per epoch, earliest first. Each block's
+// ParentWeight is that of its parent plus weightStep.
+func _chainOn(parent block.BlockHeader, n int, weightStep block.ChainWeight, miner addr.Address) []block.BlockHeader {
+	var ret []block.BlockHeader
+	for i := 0; i < n; i++ {
+		h := _header([]block.BlockHeader{parent}, parent.Epoch()+1, parent.ParentWeight()+weightStep, miner)
+		ret = append(ret, h)
+		parent = h
+	}
+	return ret
+}
+
+// Gives a peer the full blocks of headers.
+func _serve(p *SimPeer, headers ...block.BlockHeader) {
+	for _, h := range headers {
+		p.AddBlock(&block.Block_I{Header_: h})
+	}
+}
+
+func _cid(h block.BlockHeader) cid.Cid {
+	return block.BlockHeaderCID(h)
+}
+
+func _checkpoint(t *testing.T) block.BlockHeader {
+	return _header(nil, 10, 100, _miner(t, 1000))
+}
+
+func _syncFixture(checkpoint block.BlockHeader, network *SimNetwork, validator BlockValidator) ChainSync {
+	return ChainSync_Make(chain.TrustedCheckpoint(checkpoint), ipld.MemGraphStore_Make(), network, validator)
+}
+
+func _assertIn(t *testing.T, pg PartialGraph, name string, headers ...block.BlockHeader) {
+	t.Helper()
+	for _, h := range headers {
+		if !pg.Blocks()[_cid(h)] {
+			t.Errorf("block at epoch %d by %v not in %s", h.Epoch(), h.Miner(), name)
+		}
+	}
+}
+
+// Asserts that blocks are invalid and in none of the ValidationGraph's partial graphs.
+func _assertRejected(t *testing.T, cs ChainSync, headers ...block.BlockHeader) {
+	t.Helper()
+	for _, h := range headers {
+		if !cs.Impl().invalid()[_cid(h)] {
+			t.Errorf("block at epoch %d by %v not rejected", h.Epoch(), h.Miner())
+		}
+		if cs.Impl()._tracked(_cid(h)) {
+			t.Errorf("rejected block at epoch %d by %v still tracked", h.Epoch(), h.Miner())
+		}
+	}
+}
+
+func TestSyncForks(t *testing.T) {
+	checkpoint := _checkpoint(t)
+	lighter := _chainOn(checkpoint, 3, 1, _miner(t, 1001))
+	heavier := _chainOn(checkpoint, 2, 5, _miner(t, 1002))
+
+	network := SimNetwork_Make()
+	_serve(network.AddPeer("a"), lighter...)
+	_serve(network.AddPeer("b"), heavier...)
+	cs := _syncFixture(checkpoint, network, &_testValidator{})
+
+	if err := network.Announce(cs, "a", _cid(lighter[2])); err != nil {
+		t.Fatal(err)
+	}
+	if err := network.Announce(cs, "b", _cid(heavier[1])); err != nil {
+		t.Fatal(err)
+	}
+	cs.Sync()
+
+	vg := cs.VGraph()
+	_assertIn(t, vg.ValidG(), "ValidG", append(lighter, heavier...)...)
+	if len(vg.ValidG().Heads()) != 2 {
+		t.Errorf("ValidG has %d heads, want one per fork", len(vg.ValidG().Heads()))
+	}
+	if len(vg.ValidG().Tails()) != 2 {
+		t.Errorf("ValidG has %d tails, want one per fork", len(vg.ValidG().Tails()))
+	}
+
+	// The head of the lighter fork, though longer, is lighter than the best valid head, so is not a target.
+	if len(vg.TargetHeads()) != 1 {
+		t.Fatalf("%d target heads, want 1", len(vg.TargetHeads()))
+	}
+	if _cid(vg.BestTargetHead()) != _cid(heavier[1]) {
+		t.Errorf("best target head at epoch %d, want head of heavier fork", vg.BestTargetHead().Epoch())
+	}
+
+	// A block extending the lighter fork past the heavier one makes it the target.
+	extension := _chainOn(lighter[2], 1, 10, _miner(t, 1001))[0]
+	_serve(network.AddPeer("a"), extension)
+	if err := network.Announce(cs, "a", _cid(extension)); err != nil {
+		t.Fatal(err)
+	}
+	cs.Sync()
+	_assertIn(t, vg.ValidG(), "ValidG", extension)
+	if _cid(vg.BestTargetHead()) != _cid(extension) {
+		t.Errorf("best target head at epoch %d, want extension of lighter fork", vg.BestTargetHead().Epoch())
+	}
+}
+
+func TestSyncUnresponsivePeer(t *testing.T) {
+	checkpoint := _checkpoint(t)
+	headers := _chainOn(checkpoint, 4, 1, _miner(t, 1001))
+	head := headers[len(headers)-1]
+
+	network := SimNetwork_Make()
+	announcer := network.AddPeer("a")
+	_serve(announcer, headers...)
+	cs := _syncFixture(checkpoint, network, &_testValidator{})
+	if err := network.Announce(cs, "a", _cid(head)); err != nil {
+		t.Fatal(err)
+	}
+
+	// With the only peer holding the chain unresponsive, no progress is made.
+	announcer.SetUnresponsive(true)
+	if _, err := network.FetchBlock("a", _cid(head)); err != ErrPeerUnresponsive {
+		t.Fatalf("FetchBlock from unresponsive peer: %v, want %v", err, ErrPeerUnresponsive)
+	}
+	cs.Sync()
+	_assertIn(t, cs.VGraph().UnconnectedG(), "UnconnectedG", head)
+	if len(cs.VGraph().ValidG().Blocks()) != 0 {
+		t.Errorf("%d blocks validated without data", len(cs.VGraph().ValidG().Blocks()))
+	}
+
+	// Another peer holding the chain is used in its place.
+	_serve(network.AddPeer("b"), headers...)
+	cs.Sync()
+	_assertIn(t, cs.VGraph().ValidG(), "ValidG", headers...)
+	if _cid(cs.VGraph().BestTargetHead()) != _cid(head) {
+		t.Errorf("best target head at epoch %d, want %d", cs.VGraph().BestTargetHead().Epoch(), head.Epoch())
+	}
+}
+
+func TestSyncRejectsChainNotFromCheckpoint(t *testing.T) {
+	checkpoint := _checkpoint(t)
+	// A chain from a root before the checkpoint which does not pass through it.
+	root := _header(nil, 5, 50, _miner(t, 2000))
+	other := _chainOn(root, 8, 1, _miner(t, 2001))
+	// A block at the checkpoint's epoch on other parents.
+	sibling := _header([]block.BlockHeader{root}, checkpoint.Epoch(), 60, _miner(t, 2002))
+	onSibling := _chainOn(sibling, 2, 1, _miner(t, 2002))
+
+	network := SimNetwork_Make()
+	p := network.AddPeer("x")
+	_serve(p, root, sibling)
+	_serve(p, other...)
+	_serve(p, onSibling...)
+	validator := &_testValidator{}
+	cs := _syncFixture(checkpoint, network, validator)
+
+	if err := network.Announce(cs, "x", _cid(other[len(other)-1])); err != nil {
+		t.Fatal(err)
+	}
+	if err := network.Announce(cs, "x", _cid(onSibling[1])); err != nil {
+		t.Fatal(err)
+	}
+	cs.Sync()
+
+	// Headers are not fetched before the checkpoint's epoch.
+	if _, found := cs.VGraph().Graph().Header(_cid(root)); found {
+		t.Error("header before the checkpoint fetched")
+	}
+	_assertRejected(t, cs, other[len(other)-1])
+	_assertRejected(t, cs, sibling)
+	_assertRejected(t, cs, onSibling...)
+	if len(validator.validated) != 0 {
+		t.Errorf("%d blocks not descending from the checkpoint validated", len(validator.validated))
+	}
+	if len(cs.VGraph().UnconnectedG().Blocks()) != 0 {
+		t.Errorf("%d blocks left in UnconnectedG", len(cs.VGraph().UnconnectedG().Blocks()))
+	}
+	if cs.VGraph().BestTargetHead() != nil && cs.Impl().invalid()[_cid(cs.VGraph().BestTargetHead())] {
+		t.Error("rejected block is the best target head")
+	}
+
+	// Rejected blocks are ignored if announced again.
+	cs.OnBlockHeader(onSibling[1], "x")
+	if cs.Impl()._tracked(_cid(onSibling[1])) {
+		t.Error("rejected block tracked after being announced again")
+	}
+}
+
+func TestInvalidBlockRejectsDescendants(t *testing.T) {
+	checkpoint := _checkpoint(t)
+	valid := _chainOn(checkpoint, 1, 1, _miner(t, 1001))
+	invalid := _chainOn(valid[0], 1, 10, _miner(t, 1002))[0]
+	descendants := _chainOn(invalid, 2, 10, _miner(t, 1002))
+	fork := _chainOn(valid[0], 2, 1, _miner(t, 1003))
+
+	network := SimNetwork_Make()
+	p := network.AddPeer("a")
+	_serve(p, valid...)
+	_serve(p, invalid)
+	_serve(p, descendants...)
+	_serve(p, fork...)
+	validator := &_testValidator{invalid: map[cid.Cid]bool{_cid(invalid): true}}
+	cs := _syncFixture(checkpoint, network, validator)
+
+	if err := network.Announce(cs, "a", _cid(descendants[1])); err != nil {
+		t.Fatal(err)
+	}
+	if err := network.Announce(cs, "a", _cid(fork[1])); err != nil {
+		t.Fatal(err)
+	}
+	cs.Sync()
+
+	_assertIn(t, cs.VGraph().ValidG(), "ValidG", valid...)
+	_assertIn(t, cs.VGraph().ValidG(), "ValidG", fork...)
+	_assertRejected(t, cs, invalid)
+	_assertRejected(t, cs, descendants...)
+
+	// The descendants of the invalid block are rejected without validation.
+	for _, c := range validator.validated {
+		for _, d := range descendants {
+			if c == _cid(d) {
+				t.Errorf("descendant at epoch %d of an invalid block validated", d.Epoch())
+			}
+		}
+	}
+	// The heavier chain through the invalid block is not a target.
+	if _cid(cs.VGraph().BestTargetHead()) != _cid(fork[1]) {
+		t.Errorf("best target head at epoch %d, want head of valid fork", cs.VGraph().BestTargetHead().Epoch())
+	}
+
+	// A block later announced on the invalid chain is rejected as its descendant.
+	later := _chainOn(descendants[1], 1, 10, _miner(t, 1002))[0]
+	_serve(p, later)
+	if err := network.Announce(cs, "a", _cid(later)); err != nil {
+		t.Fatal(err)
+	}
+	cs.Sync()
+	_assertRejected(t, cs, later)
+}
+
+func TestSyncValidatesCheckpointSiblings(t *testing.T) {
+	checkpoint := _checkpoint(t)
+	// Blocks at the checkpoint's epoch on the same (no) parents, which may belong to its tipset.
+	sibling := _header(nil, checkpoint.Epoch(), checkpoint.ParentWeight(), _miner(t, 1001))
+	invalidSibling := _header(nil, checkpoint.Epoch(), checkpoint.ParentWeight(), _miner(t, 1002))
+	child := _header([]block.BlockHeader{checkpoint, sibling}, checkpoint.Epoch()+1, 101, _miner(t, 1003))
+	invalidChild := _header([]block.BlockHeader{checkpoint, invalidSibling}, checkpoint.Epoch()+1, 101, _miner(t, 1004))
+
+	network := SimNetwork_Make()
+	_serve(network.AddPeer("a"), sibling, invalidSibling, child, invalidChild)
+	validator := &_testValidator{invalid: map[cid.Cid]bool{_cid(invalidSibling): true}}
+	cs := _syncFixture(checkpoint, network, validator)
+
+	if err := network.Announce(cs, "a", _cid(child)); err != nil {
+		t.Fatal(err)
+	}
+	if err := network.Announce(cs, "a", _cid(invalidChild)); err != nil {
+		t.Fatal(err)
+	}
+	cs.Sync()
+
+	// Siblings are validated like any other block, rather than taken as final with the checkpoint.
+	if len(cs.VGraph().FinalityTipset().Blocks()) != 1 {
+		t.Errorf("%d blocks in FinalityTipset, want only the checkpoint", len(cs.VGraph().FinalityTipset().Blocks()))
+	}
+	_assertIn(t, cs.VGraph().ValidG(), "ValidG", sibling, child)
+	_assertRejected(t, cs, invalidSibling, invalidChild)
+
+	// The valid sibling is part of the checkpoint's tipset.
+	ancestors, err := CheckpointAncestry(cs, child)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ancestors) != 1 || _cid(ancestors[0]) != _cid(child) {
+		t.Errorf("%d ancestors of the child of the checkpoint's tipset, want only itself", len(ancestors))
+	}
+}
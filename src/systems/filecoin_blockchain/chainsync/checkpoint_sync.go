@@ -0,0 +1,219 @@
+package chainsync
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strings"
+
+	addr "github.com/filecoin-project/go-address"
+	ipld "github.com/filecoin-project/specs/libraries/ipld"
+	car "github.com/filecoin-project/specs/libraries/ipld/car"
+	block "github.com/filecoin-project/specs/systems/filecoin_blockchain/struct/block"
+	chain "github.com/filecoin-project/specs/systems/filecoin_blockchain/struct/chain"
+	node_base "github.com/filecoin-project/specs/systems/filecoin_nodes/node_base"
+	config "github.com/filecoin-project/specs/systems/filecoin_nodes/repository/config"
+	util "github.com/filecoin-project/specs/util"
+	cid "github.com/ipfs/go-cid"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+)
+
+// The configuration key under which a node's trusted checkpoint may be set, as "<block CID>:<state root CID>".
+const TrustedCheckpointConfigKey = config.ConfigKey("chainsync.trustedCheckpoint")
+
+// CheckpointSpec identifies a TrustedCheckpoint by the CID of the checkpoint block, and the root of
+// its parent state (the state on which the checkpoint's tipset is executed).
+type CheckpointSpec struct {
+	Block     cid.Cid
+	StateRoot cid.Cid
+}
+
+// The trusted checkpoints built into the node, for each network, used when none is configured.
+var DefaultTrustedCheckpoints = map[addr.Network]CheckpointSpec{
+	// Placeholder: none has yet been published.
+}
+
+var (
+	ErrNoTrustedCheckpoint = errors.New("No trusted checkpoint configured or built in for network")
+	ErrCheckpointMalformed = errors.New("Malformed trusted checkpoint")
+	ErrCheckpointMismatch  = errors.New("Snapshot does not match trusted checkpoint")
+	ErrSnapshotUnavailable = errors.New("Checkpoint snapshot not available from any peer")
+	ErrSnapshotIncomplete  = errors.New("Checkpoint snapshot does not hold the complete state tree")
+	ErrNotFromCheckpoint   = errors.New("Block does not descend from trusted checkpoint")
+)
+
+func ParseCheckpointSpec(s string) (CheckpointSpec, error) {
+	parts := strings.Split(strings.TrimSpace(s), ":")
+	if len(parts) != 2 {
+		return CheckpointSpec{}, ErrCheckpointMalformed
+	}
+	blockCID, err := cid.Decode(parts[0])
+	if err != nil {
+		return CheckpointSpec{}, ErrCheckpointMalformed
+	}
+	stateRoot, err := cid.Decode(parts[1])
+	if err != nil {
+		return CheckpointSpec{}, ErrCheckpointMalformed
+	}
+	return CheckpointSpec{Block: blockCID, StateRoot: stateRoot}, nil
+}
+
+func (spec CheckpointSpec) String() string {
+	return spec.Block.String() + ":" + spec.StateRoot.String()
+}
+
+// Returns the trusted checkpoint set in cfg or, if none is, the checkpoint built in for the node's network.
+func TrustedCheckpointFromConfig(cfg config.Config) (CheckpointSpec, error) {
+	if ret := cfg.Get(TrustedCheckpointConfigKey); ret.Is_c() {
+		return ParseCheckpointSpec(string(ret.As_c()))
+	}
+	if spec, found := DefaultTrustedCheckpoints[node_base.NETWORK]; found {
+		return spec, nil
+	}
+	return CheckpointSpec{}, ErrNoTrustedCheckpoint
+}
+
+// SnapshotSource provides the header and parent state tree of a trusted checkpoint, so that they need
+// not be computed by fetching and replaying the chain before it.
+type SnapshotSource interface {
+	// Loads the checkpoint's header into chainStore and its parent state tree into stateStore,
+	// returning the header.
+	LoadSnapshot(spec CheckpointSpec, chainStore ipld.GraphStore, stateStore ipld.GraphStore) (block.BlockHeader, error)
+}
+
+// StateFetcher fetches state trees from peers (e.g., with a Graphsync request selecting all blocks
+// reachable from the root).
+type StateFetcher interface {
+	FetchState(p peer.ID, root cid.Cid, store ipld.GraphStore) error
+}
+
+// PeerSnapshotSource loads a snapshot from the first peer able to provide both the checkpoint's header
+// and its parent state tree.
+type PeerSnapshotSource struct {
+	Blocks BlockFetcher
+	State  StateFetcher
+}
+
+func (s PeerSnapshotSource) LoadSnapshot(spec CheckpointSpec, chainStore ipld.GraphStore, stateStore ipld.GraphStore) (block.BlockHeader, error) {
+	for _, p := range s.Blocks.Peers() {
+		headers, err := s.Blocks.FetchHeaders(p, spec.Block, 1)
+		if err != nil || len(headers) == 0 || block.BlockHeaderCID(headers[0]) != spec.Block {
+			continue
+		}
+		if err := s.State.FetchState(p, spec.StateRoot, stateStore); err != nil {
+			continue
+		}
+		chainStore.Put(util.Bytes(block.Serialize_BlockHeader(headers[0])))
+		return headers[0], nil
+	}
+	return nil, ErrSnapshotUnavailable
+}
+
+// FileSnapshotSource loads a snapshot from a CAR file whose roots are the checkpoint block and its parent
+// state root, and which holds the checkpoint's header and the blocks of its parent state tree.
+type FileSnapshotSource struct {
+	Path string
+}
+
+func (s FileSnapshotSource) LoadSnapshot(spec CheckpointSpec, chainStore ipld.GraphStore, stateStore ipld.GraphStore) (block.BlockHeader, error) {
+	f, err := os.Open(s.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r, err := car.Reader_Make(f)
+	if err != nil {
+		return nil, err
+	}
+	roots := r.Header.Roots
+	if len(roots) != 2 || roots[0] != spec.Block || roots[1] != spec.StateRoot {
+		return nil, ErrCheckpointMismatch
+	}
+
+	var header block.BlockHeader
+	for {
+		c, value, err := r.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		store := stateStore
+		if c == spec.Block {
+			store = chainStore
+			if header, err = block.Deserialize_BlockHeader(util.Serialization(value)); err != nil {
+				return nil, err
+			}
+		}
+		if !store.Put(value).Equals(c) {
+			return nil, car.ErrBlockCIDMismatch
+		}
+	}
+	if header == nil {
+		return nil, ErrCheckpointMismatch
+	}
+	return header, nil
+}
+
+// Boots ChainSync from a trusted checkpoint, without fetching or replaying the chain before it.
+// The checkpoint's header and parent state tree are loaded from source, and checked against spec by
+// hashing alone. ChainSync then syncs forward from the checkpoint: it only validates blocks whose header
+// chains lead back to it.
+func ChainSync_MakeFromCheckpoint(spec CheckpointSpec, source SnapshotSource, chainStore ipld.GraphStore, stateStore ipld.GraphStore, fetcher BlockFetcher, validator BlockValidator) (ChainSync, error) {
+	h, err := source.LoadSnapshot(spec, chainStore, stateStore)
+	if err != nil {
+		return nil, err
+	}
+	if block.BlockHeaderCID(h) != spec.Block || h.ParentState().RootCID() != spec.StateRoot {
+		return nil, ErrCheckpointMismatch
+	}
+	if !_stateComplete(stateStore, spec.StateRoot) {
+		return nil, ErrSnapshotIncomplete
+	}
+	return ChainSync_Make(chain.TrustedCheckpoint(h), chainStore, fetcher, validator), nil
+}
+
+// Returns the headers from head back to (excluding) the tipset of LastTrustedCheckpoint, in order of
+// decreasing epoch, checking that head descends from the checkpoint through blocks ChainSync has fetched.
+func CheckpointAncestry(cs ChainSync, head block.BlockHeader) ([]block.BlockHeader, error) {
+	vg := cs.VGraph()
+	final := _checkpointTipset(cs)
+	ts := &chain.Tipset_I{
+		BlockCIDs_: final,
+		Blocks_:    final,
+		Epoch_:     block.BlockHeader(cs.LastTrustedCheckpoint()).Epoch(),
+	}
+	ancestors, ok := vg.Graph().AncestorsTo(head, ts)
+	if !ok {
+		return nil, ErrNotFromCheckpoint
+	}
+	return ancestors, nil
+}
+
+// Returns whether store holds every block reachable from a state tree's root: its actor map, and each
+// actor's head state and the blocks to which it links.
+func _stateComplete(store ipld.GraphStore, root cid.Cid) bool {
+	visited := map[cid.Cid]bool{root: true}
+	pending := []cid.Cid{root}
+	for len(pending) > 0 {
+		c := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		value, found := store.Get(c)
+		if !found {
+			return false
+		}
+		links, err := ipld.Links(value)
+		if err != nil {
+			return false
+		}
+		for _, l := range links {
+			if !visited[l] {
+				visited[l] = true
+				pending = append(pending, l)
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,41 @@
+package chainsync
+
+import (
+	"testing"
+
+	ipld "github.com/filecoin-project/specs/libraries/ipld"
+	cid "github.com/ipfs/go-cid"
+	cbornode "github.com/ipfs/go-ipld-cbor"
+	mh "github.com/multiformats/go-multihash"
+)
+
+// Puts a DAG-CBOR node linking to the given CIDs.
+func _putNode(t *testing.T, store *ipld.MemGraphStore, name string, links ...cid.Cid) cid.Cid {
+	n, err := cbornode.WrapObject(map[string]interface{}{"name": name, "links": links}, mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store.Put(n.RawData())
+}
+
+func TestStateComplete(t *testing.T) {
+	store := ipld.MemGraphStore_Make()
+	leaf := _putNode(t, store, "leaf")
+	// Two actors sharing a head state.
+	head := _putNode(t, store, "head", leaf)
+	actors := _putNode(t, store, "actors", head, head)
+	root := _putNode(t, store, "root", actors)
+	if !_stateComplete(store, root) {
+		t.Error("complete state tree reported incomplete")
+	}
+
+	store.Delete(leaf)
+	if _stateComplete(store, root) {
+		t.Error("state tree missing a leaf reported complete")
+	}
+
+	missingRoot := _putNode(t, ipld.MemGraphStore_Make(), "other root")
+	if _stateComplete(store, missingRoot) {
+		t.Error("missing state tree reported complete")
+	}
+}
@@ -0,0 +1,156 @@
+package chainsync
+
+import (
+	"errors"
+	"sort"
+	"sync"
+
+	block "github.com/filecoin-project/specs/systems/filecoin_blockchain/struct/block"
+	cid "github.com/ipfs/go-cid"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+)
+
+var (
+	ErrPeerNotFound     = errors.New("Peer not found")
+	ErrPeerUnresponsive = errors.New("Peer did not respond")
+	ErrBlockNotFound    = errors.New("Block not found at peer")
+)
+
+// SimNetwork is an in-memory stand-in for the peers from which ChainSync fetches chain data.
+// Each peer serves the blocks it has been given, so peers may hold different forks, or only part
+// of a chain, and may be made unresponsive. Requests are served synchronously, so a SimNetwork may
+// be used to drive ChainSync deterministically and without a network.
+type SimNetwork struct {
+	_lock  sync.Mutex
+	_peers map[peer.ID]*SimPeer
+}
+
+// A peer of a SimNetwork.
+type SimPeer struct {
+	_lock         sync.Mutex
+	_blocks       map[cid.Cid]block.Block
+	_unresponsive bool
+}
+
+var _ BlockFetcher = &SimNetwork{}
+
+func SimNetwork_Make() *SimNetwork {
+	return &SimNetwork{_peers: make(map[peer.ID]*SimPeer)}
+}
+
+// Adds a peer holding no blocks, or returns the peer if already added.
+func (n *SimNetwork) AddPeer(id peer.ID) *SimPeer {
+	n._lock.Lock()
+	defer n._lock.Unlock()
+	if p, found := n._peers[id]; found {
+		return p
+	}
+	p := &SimPeer{_blocks: make(map[cid.Cid]block.Block)}
+	n._peers[id] = p
+	return p
+}
+
+// Returns the network's peers, in order.
+func (n *SimNetwork) Peers() []peer.ID {
+	n._lock.Lock()
+	defer n._lock.Unlock()
+	var ret []peer.ID
+	for id := range n._peers {
+		ret = append(ret, id)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i] < ret[j] })
+	return ret
+}
+
+// Returns the header with CID c, followed by the latest of its ancestors held by the peer, in order of
+// decreasing epoch.
+func (n *SimNetwork) FetchHeaders(p peer.ID, c cid.Cid, count int) ([]block.BlockHeader, error) {
+	sp, err := n._peer(p)
+	if err != nil {
+		return nil, err
+	}
+	sp._lock.Lock()
+	defer sp._lock.Unlock()
+	if _, found := sp._blocks[c]; !found {
+		return nil, ErrBlockNotFound
+	}
+
+	// Visits ancestors latest first, so that those returned are the closest to c.
+	visited := map[cid.Cid]bool{c: true}
+	pending := []cid.Cid{c}
+	var ret []block.BlockHeader
+	for len(pending) > 0 && len(ret) < count {
+		sort.Slice(pending, func(i, j int) bool {
+			a, b := sp._blocks[pending[i]].Header(), sp._blocks[pending[j]].Header()
+			if a.Epoch() != b.Epoch() {
+				return a.Epoch() > b.Epoch()
+			}
+			return pending[i].String() < pending[j].String()
+		})
+		h := sp._blocks[pending[0]].Header()
+		pending = pending[1:]
+		ret = append(ret, h)
+
+		for _, parent := range h.Parents() {
+			pc := block.BlockHeaderCID(parent)
+			if _, found := sp._blocks[pc]; found && !visited[pc] {
+				visited[pc] = true
+				pending = append(pending, pc)
+			}
+		}
+	}
+	return ret, nil
+}
+
+func (n *SimNetwork) FetchBlock(p peer.ID, c cid.Cid) (block.Block, error) {
+	sp, err := n._peer(p)
+	if err != nil {
+		return nil, err
+	}
+	sp._lock.Lock()
+	defer sp._lock.Unlock()
+	b, found := sp._blocks[c]
+	if !found {
+		return nil, ErrBlockNotFound
+	}
+	return b, nil
+}
+
+// Announces the header of a block held by a peer to a syncer, as though received on BlockPubsub.
+func (n *SimNetwork) Announce(cs ChainSync, from peer.ID, c cid.Cid) error {
+	b, err := n.FetchBlock(from, c)
+	if err != nil {
+		return err
+	}
+	cs.OnBlockHeader(b.Header(), from)
+	return nil
+}
+
+func (n *SimNetwork) _peer(p peer.ID) (*SimPeer, error) {
+	n._lock.Lock()
+	sp, found := n._peers[p]
+	n._lock.Unlock()
+	if !found {
+		return nil, ErrPeerNotFound
+	}
+	sp._lock.Lock()
+	unresponsive := sp._unresponsive
+	sp._lock.Unlock()
+	if unresponsive {
+		return nil, ErrPeerUnresponsive
+	}
+	return sp, nil
+}
+
+func (p *SimPeer) AddBlock(b block.Block) {
+	p._lock.Lock()
+	defer p._lock.Unlock()
+	p._blocks[block.BlockHeaderCID(b.Header())] = b
+}
+
+// Sets whether the peer fails all requests.
+func (p *SimPeer) SetUnresponsive(unresponsive bool) {
+	p._lock.Lock()
+	defer p._lock.Unlock()
+	p._unresponsive = unresponsive
+}
@@ -0,0 +1,239 @@
+package filecoin_blockchain
+
+import (
+	"bytes"
+	"sort"
+
+	addr "github.com/filecoin-project/go-address"
+	abi "github.com/filecoin-project/specs-actors/actors/abi"
+	ipld "github.com/filecoin-project/specs/libraries/ipld"
+	block "github.com/filecoin-project/specs/systems/filecoin_blockchain/struct/block"
+	chain "github.com/filecoin-project/specs/systems/filecoin_blockchain/struct/chain"
+	st "github.com/filecoin-project/specs/systems/filecoin_vm/state_tree"
+	util "github.com/filecoin-project/specs/util"
+	cid "github.com/ipfs/go-cid"
+)
+
+// TipsetWeigher computes the weight of the chain ending in a tipset, failing if it exceeds the range
+// of ChainWeight. The StoragePowerConsensusSubsystem is one, with Expected Consensus' weight function.
+type TipsetWeigher interface {
+	ComputeChainWeight(tipset chain.Tipset) (block.ChainWeight, error)
+}
+
+// TipsetExecutor provides the state resulting from executing a tipset's messages on its parent state.
+type TipsetExecutor interface {
+	TipsetState(tipset chain.Tipset) st.StateTree
+}
+
+// HeadChangeListener is notified of each change of the chain head, with the blocks of the tipsets
+// removed from the chain (latest first) and of those added to it (earliest first), and the state of
+// the new head. The MessagePoolSubsystem and the interpreter's EventDispatcher are listeners.
+type HeadChangeListener interface {
+	HeadChange(reverted []block.Block, applied []block.Block, headState st.StateTree)
+}
+
+func ForkChoice_Make(head chain.Tipset, chainStore ipld.GraphStore, weigher TipsetWeigher, executor TipsetExecutor, listeners []HeadChangeListener) ForkChoice {
+	return &ForkChoice_I{
+		ChainStore_: chainStore,
+		Weigher_:    weigher,
+		Executor_:   executor,
+		Listeners_:  listeners,
+		Head_:       head,
+		blocks_:     make(map[cid.Cid]block.Block),
+	}
+}
+
+// Adds a block which has been received and validated, to be assembled into tipsets.
+func (fc *ForkChoice_I) AddBlock(b block.Block) {
+	fc.blocks_[block.BlockHeaderCID(b.Header())] = b
+}
+
+// Groups the blocks received by epoch and parent set, then by parent state, parent weight and parent
+// message and implicit receipts (which blocks validly mined on the same parents share). Each group forms the
+// heaviest tipset of its blocks. Where a miner has more than one block in a group (a double-fork
+// mining fault), only the first in canonical order is included.
+//
+// The tipsets are returned in order of epoch, then of their blocks' CIDs.
+func (fc *ForkChoice_I) AssembleTipsets() []chain.Tipset {
+	groups := make(map[_tipsetKey][]block.BlockHeader)
+	for _, b := range fc.blocks_ {
+		h := b.Header()
+		k := _tipsetKey{
+			epoch:        h.Epoch(),
+			parents:      chain.TipsetKey(h.Parents()),
+			parentWeight: h.ParentWeight(),
+			parentState:  h.ParentState().RootCID(),
+			receipts:     h.ParentMessageReceipts(),
+			implicit:     h.ParentImplicitReceipts(),
+		}
+		groups[k] = append(groups[k], h)
+	}
+
+	var ret []chain.Tipset
+	for _, headers := range groups {
+		chain.SortTipsetBlocks(headers)
+		ts, err := chain.Tipset_Make(_firstPerMiner(headers))
+		util.Assert(err == nil)
+		ret = append(ret, ts)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Epoch() != ret[j].Epoch() {
+			return ret[i].Epoch() < ret[j].Epoch()
+		}
+		return chain.TipsetKey(ret[i].Blocks()) < chain.TipsetKey(ret[j].Blocks())
+	})
+	return ret
+}
+
+// Returns the heaviest of tipsets by ComputeChainWeight, or nil if there are none. Between tipsets of
+// equal weight, the one with the smaller MinTicket is chosen; if those are the same, the next smallest
+// tickets are compared, and so on (see Expected Consensus). Tipsets whose weight cannot be computed are
+// not chosen.
+func (fc *ForkChoice_I) ChooseTipset(tipsets []chain.Tipset) chain.Tipset {
+	var best chain.Tipset
+	var bestWeight block.ChainWeight
+	for _, ts := range tipsets {
+		w, err := fc.Weigher().ComputeChainWeight(ts)
+		if err != nil {
+			continue
+		}
+		if best == nil || w > bestWeight || (w == bestWeight && _ticketsLess(ts, best)) {
+			best, bestWeight = ts, w
+		}
+	}
+	return best
+}
+
+// Chooses the heaviest of the head and the tipsets assembled from the blocks received, and makes it
+// the head. If the head changes, the listeners are notified of the tipsets reverted and applied: those
+// from the old and the new head back to their latest common ancestor.
+func (fc *ForkChoice_I) UpdateHead() chain.Tipset {
+	oldHead := fc.Head()
+	newHead := fc.ChooseTipset(append(fc.AssembleTipsets(), oldHead))
+	if newHead == nil || chain.TipsetKey(newHead.Blocks()) == chain.TipsetKey(oldHead.Blocks()) {
+		return oldHead
+	}
+	reverted, applied := fc._path(oldHead, newHead)
+	fc.Head_ = newHead
+
+	var revertedBlocks, appliedBlocks []block.Block
+	for _, ts := range reverted {
+		for _, h := range ts.Blocks() {
+			revertedBlocks = append(revertedBlocks, fc._block(h))
+		}
+	}
+	for i := len(applied) - 1; i >= 0; i-- {
+		for _, h := range applied[i].Blocks() {
+			appliedBlocks = append(appliedBlocks, fc._block(h))
+		}
+	}
+	headState := fc.Executor().TipsetState(newHead)
+	for _, l := range fc.Listeners() {
+		l.HeadChange(revertedBlocks, appliedBlocks, headState)
+	}
+	return newHead
+}
+
+// Drops the blocks at epochs before the given epoch (e.g., those before finality, past which the chain
+// is not reorganized).
+func (fc *ForkChoice_I) Prune(before abi.ChainEpoch) {
+	for c, b := range fc.blocks_ {
+		if b.Header().Epoch() < before {
+			delete(fc.blocks_, c)
+		}
+	}
+}
+
+type _tipsetKey struct {
+	epoch        abi.ChainEpoch
+	parents      string
+	parentWeight block.ChainWeight
+	parentState  cid.Cid
+	receipts     cid.Cid
+	implicit     cid.Cid
+}
+
+// Returns the tipsets from oldHead and from newHead back to (excluding) their latest common ancestor,
+// each latest first.
+func (fc *ForkChoice_I) _path(oldHead chain.Tipset, newHead chain.Tipset) (reverted []chain.Tipset, applied []chain.Tipset) {
+	for chain.TipsetKey(oldHead.Blocks()) != chain.TipsetKey(newHead.Blocks()) {
+		if oldHead.Epoch() >= newHead.Epoch() {
+			reverted = append(reverted, oldHead)
+			oldHead = fc._parent(oldHead)
+		} else {
+			applied = append(applied, newHead)
+			newHead = fc._parent(newHead)
+		}
+	}
+	return reverted, applied
+}
+
+// The parent tipset is formed by the blocks received, if they are held, and is otherwise that linked from
+// the tipset or, for tipsets linking none (such as those formed here, or of headers loaded on their own),
+// formed by the parent headers loaded from the ChainStore by their CIDs.
+func (fc *ForkChoice_I) _parent(ts chain.Tipset) chain.Tipset {
+	var parents []block.BlockHeader
+	for _, parent := range ts.Blocks()[0].Parents() {
+		c := block.BlockHeaderCID(parent)
+		if b, found := fc.blocks_[c]; found {
+			parents = append(parents, b.Header())
+			continue
+		}
+		if ret := ts.Parents(); ret != nil {
+			return ret
+		}
+		h, err := block.LoadBlockHeader(fc.ChainStore(), c)
+		util.Assert(err == nil)
+		parents = append(parents, h)
+	}
+	ret, err := chain.Tipset_Make(parents)
+	util.Assert(err == nil)
+	return ret
+}
+
+// Returns the full block of a header, as received or, for blocks not received (or since pruned), as
+// loaded from the ChainStore through its TxMeta.
+func (fc *ForkChoice_I) _block(h block.BlockHeader) block.Block {
+	if b, found := fc.blocks_[block.BlockHeaderCID(h)]; found {
+		return b
+	}
+	ret, err := block.LoadBlock(fc.ChainStore(), h)
+	util.Assert(err == nil)
+	return ret
+}
+
+// headers must be in canonical order.
+func _firstPerMiner(headers []block.BlockHeader) []block.BlockHeader {
+	var ret []block.BlockHeader
+	seen := make(map[addr.Address]bool)
+	for _, h := range headers {
+		if !seen[h.Miner()] {
+			seen[h.Miner()] = true
+			ret = append(ret, h)
+		}
+	}
+	return ret
+}
+
+func _ticketsLess(a chain.Tipset, b chain.Tipset) bool {
+	ta, tb := _sortedTickets(a), _sortedTickets(b)
+	for i := 0; i < len(ta) && i < len(tb); i++ {
+		if c := bytes.Compare(ta[i], tb[i]); c != 0 {
+			return c < 0
+		}
+	}
+	// The tipsets' tickets only collide (or are the same blocks) with negligible probability, but the
+	// choice is kept deterministic by comparing their blocks.
+	return chain.TipsetKey(a.Blocks()) < chain.TipsetKey(b.Blocks())
+}
+
+func _sortedTickets(ts chain.Tipset) []util.Bytes {
+	var ret []util.Bytes
+	for _, h := range ts.Blocks() {
+		ret = append(ret, h.Ticket().Output())
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return bytes.Compare(ret[i], ret[j]) < 0
+	})
+	return ret
+}
@@ -0,0 +1,336 @@
+package filecoin_blockchain
+
+import (
+	"errors"
+	"testing"
+
+	addr "github.com/filecoin-project/go-address"
+	abi "github.com/filecoin-project/specs-actors/actors/abi"
+	ipld "github.com/filecoin-project/specs/libraries/ipld"
+	block "github.com/filecoin-project/specs/systems/filecoin_blockchain/struct/block"
+	chain "github.com/filecoin-project/specs/systems/filecoin_blockchain/struct/chain"
+	msg "github.com/filecoin-project/specs/systems/filecoin_vm/message"
+	st "github.com/filecoin-project/specs/systems/filecoin_vm/state_tree"
+	util "github.com/filecoin-project/specs/util"
+)
+
+var ErrTestWeightUnknown = errors.New("Test tipset weight unknown")
+
+// Weighs the tipsets given weights, by their blocks, failing for others.
+type _testWeigher struct {
+	weights map[string]block.ChainWeight
+}
+
+func (w *_testWeigher) ComputeChainWeight(ts chain.Tipset) (block.ChainWeight, error) {
+	ret, found := w.weights[chain.TipsetKey(ts.Blocks())]
+	if !found {
+		return 0, ErrTestWeightUnknown
+	}
+	return ret, nil
+}
+
+func _testWeigher_Make(weights map[chain.Tipset]block.ChainWeight) *_testWeigher {
+	ret := &_testWeigher{weights: make(map[string]block.ChainWeight)}
+	for ts, w := range weights {
+		ret.weights[chain.TipsetKey(ts.Blocks())] = w
+	}
+	return ret
+}
+
+type _testExecutor struct{}
+
+func (_testExecutor) TipsetState(ts chain.Tipset) st.StateTree {
+	return &st.StateTree_I{}
+}
+
+type _headChange struct {
+	reverted []block.Block
+	applied  []block.Block
+}
+
+type _recordingListener struct {
+	changes []_headChange
+}
+
+func (l *_recordingListener) HeadChange(reverted []block.Block, applied []block.Block, headState st.StateTree) {
+	l.changes = append(l.changes, _headChange{reverted, applied})
+}
+
+func _fcMiner(t *testing.T, id uint64) addr.Address {
+	a, err := addr.NewIDAddress(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+// Returns a header of miner with a one-byte ticket, including one message.
+func _fcHeader(t *testing.T, parents []block.BlockHeader, epoch abi.ChainEpoch, parentWeight block.ChainWeight, miner uint64, ticket byte) block.BlockHeader {
+	m := &msg.UnsignedMessage_I{From_: _fcMiner(t, miner), GasLimit_: msg.GasAmount_FromInt(1)}
+	return &block.BlockHeader_I{
+		Parents_:      parents,
+		ParentWeight_: parentWeight,
+		ParentState_:  &st.StateTree_I{},
+		Epoch_:        epoch,
+		Ticket_:       &block.Ticket_I{Output_: util.Bytes{ticket}},
+		Miner_:        _fcMiner(t, miner),
+		Messages_:     &block.TxMeta_I{BLSMessages_: []msg.UnsignedMessage{m}},
+	}
+}
+
+func _fcBlock(h block.BlockHeader) block.Block {
+	return &block.Block_I{Header_: h, BLSMessages_: h.Messages().BLSMessages()}
+}
+
+func _fcTipset(t *testing.T, parent chain.Tipset, headers ...block.BlockHeader) chain.Tipset {
+	ts, err := chain.Tipset_Make(headers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts.(*chain.Tipset_I).Parents_ = parent
+	return ts
+}
+
+func _assertBlocks(t *testing.T, name string, got []block.BlockHeader, want ...block.BlockHeader) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Errorf("%s: %d blocks, want %d", name, len(got), len(want))
+		return
+	}
+	for i, h := range got {
+		if block.BlockHeaderCID(h) != block.BlockHeaderCID(want[i]) {
+			t.Errorf("%s: block %d by %v at epoch %d, want by %v at epoch %d", name, i, h.Miner(), h.Epoch(), want[i].Miner(), want[i].Epoch())
+		}
+	}
+}
+
+func TestAssembleTipsets(t *testing.T) {
+	g := _fcHeader(t, nil, 0, 0, 100, 0)
+	parents := []block.BlockHeader{g}
+	a := _fcHeader(t, parents, 1, 10, 101, 3)
+	b := _fcHeader(t, parents, 1, 10, 102, 1)
+	// A second block of a's miner (a double-fork mining fault), after a in canonical order.
+	a2 := _fcHeader(t, parents, 1, 10, 101, 5)
+	// A block on the same parents claiming another parent weight.
+	c := _fcHeader(t, parents, 1, 11, 103, 2)
+	d := _fcHeader(t, []block.BlockHeader{b, a}, 2, 20, 101, 4)
+
+	fc := ForkChoice_Make(nil, nil, _testWeigher_Make(nil), _testExecutor{}, nil)
+	for _, h := range []block.BlockHeader{d, c, a2, b, a} {
+		fc.AddBlock(_fcBlock(h))
+	}
+	tipsets := fc.AssembleTipsets()
+
+	if len(tipsets) != 3 {
+		t.Fatalf("%d tipsets assembled, want 3", len(tipsets))
+	}
+	var ab, alone chain.Tipset
+	for _, ts := range tipsets[:2] {
+		if ts.Epoch() != 1 {
+			t.Fatalf("tipset at epoch %d before that at epoch 2", ts.Epoch())
+		}
+		if len(ts.Blocks()) == 1 {
+			alone = ts
+		} else {
+			ab = ts
+		}
+	}
+	if ab == nil || alone == nil {
+		t.Fatal("blocks with another parent weight grouped with the others")
+	}
+	// In canonical (ticket) order, without the miner's second block.
+	_assertBlocks(t, "tipset of a and b", ab.Blocks(), b, a)
+	_assertBlocks(t, "tipset of c", alone.Blocks(), c)
+	_assertBlocks(t, "tipset at epoch 2", tipsets[2].Blocks(), d)
+	if tipsets[2].Epoch() != 2 {
+		t.Errorf("last tipset at epoch %d, want 2", tipsets[2].Epoch())
+	}
+
+	// Pruned blocks are no longer assembled.
+	fc.Prune(2)
+	if tipsets := fc.AssembleTipsets(); len(tipsets) != 1 {
+		t.Errorf("%d tipsets assembled after pruning, want 1", len(tipsets))
+	}
+}
+
+func TestChooseTipset(t *testing.T) {
+	g := _fcHeader(t, nil, 0, 0, 100, 0)
+	parents := []block.BlockHeader{g}
+	light := _fcTipset(t, nil, _fcHeader(t, parents, 1, 0, 101, 5))
+	heavy := _fcTipset(t, nil, _fcHeader(t, parents, 1, 0, 102, 9))
+	// As heavy, with a smaller MinTicket.
+	lowTicket := _fcTipset(t, nil, _fcHeader(t, parents, 1, 0, 103, 3), _fcHeader(t, parents, 1, 0, 104, 8))
+	// As lowTicket, with a smaller second ticket.
+	lowSecondTicket := _fcTipset(t, nil, _fcHeader(t, parents, 1, 0, 105, 3), _fcHeader(t, parents, 1, 0, 106, 7))
+	unweighable := _fcTipset(t, nil, _fcHeader(t, parents, 1, 0, 107, 1))
+
+	fc := ForkChoice_Make(nil, nil, _testWeigher_Make(map[chain.Tipset]block.ChainWeight{
+		light:           10,
+		heavy:           12,
+		lowTicket:       12,
+		lowSecondTicket: 12,
+	}), _testExecutor{}, nil)
+
+	cases := []struct {
+		name    string
+		tipsets []chain.Tipset
+		chosen  chain.Tipset
+	}{
+		{"heavier", []chain.Tipset{light, heavy}, heavy},
+		{"heavier, listed first", []chain.Tipset{heavy, light}, heavy},
+		{"smaller MinTicket", []chain.Tipset{heavy, lowTicket}, lowTicket},
+		{"smaller second ticket", []chain.Tipset{lowTicket, lowSecondTicket}, lowSecondTicket},
+		{"smaller second ticket, listed first", []chain.Tipset{lowSecondTicket, lowTicket}, lowSecondTicket},
+		{"weight unknown", []chain.Tipset{unweighable, light}, light},
+		{"only weight unknown", []chain.Tipset{unweighable}, nil},
+		{"none", nil, nil},
+	}
+	for _, c := range cases {
+		chosen := fc.ChooseTipset(c.tipsets)
+		switch {
+		case c.chosen == nil && chosen != nil:
+			t.Errorf("%s: tipset chosen, want none", c.name)
+		case c.chosen != nil && (chosen == nil || chain.TipsetKey(chosen.Blocks()) != chain.TipsetKey(c.chosen.Blocks())):
+			t.Errorf("%s: wrong tipset chosen", c.name)
+		}
+	}
+}
+
+// Two forks from genesis: x1 <- x2, and y1 <- y2 <- y3. The blocks of g and the y fork have been
+// received; those of the x fork are only linked from their tipsets.
+type _forkFixture struct {
+	g, x1, x2, y1, y2, y3 chain.Tipset
+}
+
+func _forkFixture_Make(t *testing.T) _forkFixture {
+	var f _forkFixture
+	g := _fcHeader(t, nil, 0, 0, 100, 0)
+	f.g = _fcTipset(t, nil, g)
+	x1 := _fcHeader(t, []block.BlockHeader{g}, 1, 1, 101, 1)
+	f.x1 = _fcTipset(t, f.g, x1)
+	f.x2 = _fcTipset(t, f.x1, _fcHeader(t, []block.BlockHeader{x1}, 2, 2, 101, 2))
+	y1 := _fcHeader(t, []block.BlockHeader{g}, 1, 1, 102, 3)
+	f.y1 = _fcTipset(t, nil, y1)
+	y2 := _fcHeader(t, []block.BlockHeader{y1}, 2, 2, 102, 4)
+	f.y2 = _fcTipset(t, nil, y2)
+	f.y3 = _fcTipset(t, nil, _fcHeader(t, []block.BlockHeader{y2}, 3, 3, 102, 5))
+	return f
+}
+
+func (f _forkFixture) forkChoice(listeners ...HeadChangeListener) ForkChoice {
+	return f.forkChoiceAt(f.x2, nil, listeners...)
+}
+
+// Returns a ForkChoice with the given head (that of the x fork), which has received the blocks of g and the y fork.
+func (f _forkFixture) forkChoiceAt(head chain.Tipset, chainStore ipld.GraphStore, listeners ...HeadChangeListener) ForkChoice {
+	weigher := _testWeigher_Make(map[chain.Tipset]block.ChainWeight{
+		f.g: 0, f.x1: 1, f.x2: 2, f.y1: 1, f.y2: 2, f.y3: 3,
+	})
+	fc := ForkChoice_Make(head, chainStore, weigher, _testExecutor{}, listeners)
+	for _, ts := range []chain.Tipset{f.g, f.y1, f.y2, f.y3} {
+		fc.AddBlock(_fcBlock(ts.Blocks()[0]))
+	}
+	return fc
+}
+
+func _assertTipsets(t *testing.T, name string, got []chain.Tipset, want ...chain.Tipset) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Errorf("%s: %d tipsets, want %d", name, len(got), len(want))
+		return
+	}
+	for i, ts := range got {
+		if chain.TipsetKey(ts.Blocks()) != chain.TipsetKey(want[i].Blocks()) {
+			t.Errorf("%s: tipset %d at epoch %d, want epoch %d", name, i, ts.Epoch(), want[i].Epoch())
+		}
+	}
+}
+
+func TestPath(t *testing.T) {
+	f := _forkFixture_Make(t)
+	fc := f.forkChoice().Impl()
+
+	cases := []struct {
+		name              string
+		oldHead, newHead  chain.Tipset
+		reverted, applied []chain.Tipset
+	}{
+		{"to the other fork", f.x2, f.y3, []chain.Tipset{f.x2, f.x1}, []chain.Tipset{f.y3, f.y2, f.y1}},
+		{"back again", f.y3, f.x2, []chain.Tipset{f.y3, f.y2, f.y1}, []chain.Tipset{f.x2, f.x1}},
+		{"extending", f.y1, f.y3, nil, []chain.Tipset{f.y3, f.y2}},
+		{"to an ancestor", f.x2, f.g, []chain.Tipset{f.x2, f.x1}, nil},
+		{"unchanged", f.y2, f.y2, nil, nil},
+	}
+	for _, c := range cases {
+		reverted, applied := fc._path(c.oldHead, c.newHead)
+		_assertTipsets(t, c.name+", reverted", reverted, c.reverted...)
+		_assertTipsets(t, c.name+", applied", applied, c.applied...)
+	}
+}
+
+// Returns the headers of blocks, checking that each was loaded with its message.
+func _headersWithMessage(t *testing.T, bs []block.Block) []block.BlockHeader {
+	t.Helper()
+	var ret []block.BlockHeader
+	for _, b := range bs {
+		ret = append(ret, b.Header())
+		if len(b.BLSMessages()) != 1 {
+			t.Errorf("block at epoch %d with %d messages, want 1", b.Header().Epoch(), len(b.BLSMessages()))
+		}
+	}
+	return ret
+}
+
+func TestUpdateHead(t *testing.T) {
+	f := _forkFixture_Make(t)
+	l := &_recordingListener{}
+	fc := f.forkChoice(l)
+
+	if head := fc.UpdateHead(); chain.TipsetKey(head.Blocks()) != chain.TipsetKey(f.y3.Blocks()) {
+		t.Fatalf("head at epoch %d, want the head of the heavier fork", head.Epoch())
+	}
+	if len(l.changes) != 1 {
+		t.Fatalf("%d head changes, want 1", len(l.changes))
+	}
+	// Reverted latest first; applied earliest first. Blocks not received are loaded with their messages.
+	_assertBlocks(t, "reverted", _headersWithMessage(t, l.changes[0].reverted), f.x2.Blocks()[0], f.x1.Blocks()[0])
+	_assertBlocks(t, "applied", _headersWithMessage(t, l.changes[0].applied), f.y1.Blocks()[0], f.y2.Blocks()[0], f.y3.Blocks()[0])
+
+	// The head is kept, without notification, while no heavier tipset is assembled.
+	fc.UpdateHead()
+	if len(l.changes) != 1 {
+		t.Errorf("%d head changes without a heavier tipset, want 1", len(l.changes))
+	}
+}
+
+// The head's blocks, and those of its fork, were never received (as after a restart): the head is formed
+// from headers loaded from the ChainStore, linking neither its parent tipset nor their messages.
+func TestUpdateHeadFromChainStore(t *testing.T) {
+	f := _forkFixture_Make(t)
+	store := ipld.MemGraphStore_Make()
+	for _, ts := range []chain.Tipset{f.x1, f.x2} {
+		h := ts.Blocks()[0]
+		store.Put(util.Bytes(block.Serialize_BlockHeader(h)))
+		store.Put(util.Bytes(block.Serialize_TxMeta(h.Messages())))
+	}
+	loaded, err := block.LoadBlockHeader(store, block.BlockHeaderCID(f.x2.Blocks()[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	head := _fcTipset(t, nil, loaded)
+
+	l := &_recordingListener{}
+	fc := f.forkChoiceAt(head, store, l)
+	reverted, applied := fc.Impl()._path(head, f.y3)
+	_assertTipsets(t, "reverted", reverted, f.x2, f.x1)
+	_assertTipsets(t, "applied", applied, f.y3, f.y2, f.y1)
+
+	if head := fc.UpdateHead(); chain.TipsetKey(head.Blocks()) != chain.TipsetKey(f.y3.Blocks()) {
+		t.Fatalf("head at epoch %d, want the head of the heavier fork", head.Epoch())
+	}
+	if len(l.changes) != 1 {
+		t.Fatalf("%d head changes, want 1", len(l.changes))
+	}
+	_assertBlocks(t, "reverted", _headersWithMessage(t, l.changes[0].reverted), f.x2.Blocks()[0], f.x1.Blocks()[0])
+	_assertBlocks(t, "applied", _headersWithMessage(t, l.changes[0].applied), f.y1.Blocks()[0], f.y2.Blocks()[0], f.y3.Blocks()[0])
+}
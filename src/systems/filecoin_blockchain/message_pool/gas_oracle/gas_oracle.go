@@ -0,0 +1,152 @@
+package gas_oracle
+
+import (
+	"math"
+	"sort"
+
+	abi "github.com/filecoin-project/specs-actors/actors/abi"
+	ipld "github.com/filecoin-project/specs/libraries/ipld"
+	message_pool "github.com/filecoin-project/specs/systems/filecoin_blockchain/message_pool"
+	block "github.com/filecoin-project/specs/systems/filecoin_blockchain/struct/block"
+	chain "github.com/filecoin-project/specs/systems/filecoin_blockchain/struct/chain"
+	msg "github.com/filecoin-project/specs/systems/filecoin_vm/message"
+	util "github.com/filecoin-project/specs/util"
+)
+
+type GasPriceOracleParams struct {
+	// Number of most recent tipsets whose included messages are considered.
+	LookbackTipsets int
+	// Probability, in percent, with which a message at the suggested gas price should be included
+	// within the target number of epochs.
+	ConfidencePercent float64
+	// Expected number of blocks in a tipset.
+	ExpectedBlocksPerEpoch int
+	// Percentage of a tipset's gas capacity (the block gas limit, for each of its blocks) above which its
+	// messages are taken to have competed for inclusion.
+	FullTipsetPercent float64
+}
+
+var DefaultGasPriceOracleParams = GasPriceOracleParams{
+	LookbackTipsets:        20,
+	ConfidencePercent:      90,
+	ExpectedBlocksPerEpoch: 5,
+	FullTipsetPercent:      90,
+}
+
+// GasPriceOracle suggests gas prices for messages, from the gas prices of the messages included in
+// recent tipsets and of those pending in the message pool.
+type GasPriceOracle struct {
+	_chainStore ipld.GraphStore
+	_pool       message_pool.MessagePoolSubsystem
+	_params     GasPriceOracleParams
+}
+
+func GasPriceOracle_Make(chainStore ipld.GraphStore, pool message_pool.MessagePoolSubsystem, params GasPriceOracleParams) *GasPriceOracle {
+	util.Assert(params.LookbackTipsets > 0 && params.ExpectedBlocksPerEpoch > 0)
+	util.Assert(params.ConfidencePercent > 0 && params.ConfidencePercent < 100)
+	return &GasPriceOracle{
+		_chainStore: chainStore,
+		_pool:       pool,
+		_params:     params,
+	}
+}
+
+// Suggests a gas price for a message to be included within targetEpochs after the chain head.
+// This is the higher of:
+//
+// - the price estimated from recent tipsets: the lowest price at which a message would have been
+// included within targetEpochs, with the configured confidence, given the recent tipsets' clearing prices;
+// - the price needed to outbid the pending messages which, at the expected rate of block production,
+// cannot all be included within targetEpochs.
+func (o *GasPriceOracle) SuggestGasPrice(c chain.Chain, targetEpochs int) abi.TokenAmount {
+	util.Assert(targetEpochs > 0)
+	chainPrice := o._chainEstimate(o._clearingPrices(c), targetEpochs)
+	poolPrice := o._poolEstimate(targetEpochs)
+	if poolPrice > chainPrice {
+		return poolPrice
+	}
+	return chainPrice
+}
+
+// Returns the clearing price of each of the last LookbackTipsets tipsets up to the chain head:
+// the lowest gas price of the messages it included, or zero if it was not full (so that any
+// message could have been included). The tipsets are walked back through their parents, skipping
+// null rounds.
+func (o *GasPriceOracle) _clearingPrices(c chain.Chain) []abi.TokenAmount {
+	var ret []abi.TokenAmount
+	ts := c.HeadTipset()
+	for i := 0; i < o._params.LookbackTipsets; i++ {
+		ret = append(ret, o._clearingPrice(ts))
+		if ts.Epoch() <= 0 {
+			break
+		}
+		ts = ts.Parents()
+	}
+	return ret
+}
+
+func (o *GasPriceOracle) _clearingPrice(ts chain.Tipset) abi.TokenAmount {
+	gasLimit := msg.GasAmount_Zero()
+	var minPrice abi.TokenAmount
+	found := false
+	for _, header := range ts.Blocks() {
+		for _, m := range _blockMessages(o._chainStore, header) {
+			gasLimit = gasLimit.Add(m.GasLimit())
+			if !found || m.GasPrice() < minPrice {
+				minPrice = m.GasPrice()
+				found = true
+			}
+		}
+	}
+
+	// Compares gasLimit / capacity with FullTipsetPercent / 100.
+	capacity := msg.GasAmount_FromInt(block.BlockGasLimit).Scale(len(ts.Blocks()))
+	if !found || gasLimit.Scale(100).LessThan(capacity.Scale(int(o._params.FullTipsetPercent))) {
+		return abi.TokenAmount(0)
+	}
+	return minPrice
+}
+
+// A message at gas price p is included in a tipset with probability F(p), the fraction of clearing prices
+// no greater than p, so is included within k epochs with probability 1 - (1 - F(p))^k.
+// The estimate is the lowest price for which this is at least the configured confidence.
+func (o *GasPriceOracle) _chainEstimate(clearingPrices []abi.TokenAmount, targetEpochs int) abi.TokenAmount {
+	sort.Slice(clearingPrices, func(i, j int) bool { return clearingPrices[i] < clearingPrices[j] })
+	confidence := o._params.ConfidencePercent / 100
+	rank := 100 * (1 - math.Pow(1-confidence, 1/float64(targetEpochs)))
+	return message_pool.GasPricePercentile(clearingPrices, rank)
+}
+
+// Returns the price which outbids the pending messages that cannot be included within targetEpochs,
+// or zero if all can be.
+func (o *GasPriceOracle) _poolEstimate(targetEpochs int) abi.TokenAmount {
+	capacity := msg.GasAmount_FromInt(block.BlockGasLimit).Scale(targetEpochs * o._params.ExpectedBlocksPerEpoch)
+	if !capacity.LessThan(o._pool.Stats().TotalGasLimit()) {
+		return abi.TokenAmount(0)
+	}
+
+	pending := o._pool.FindMessage(&message_pool.MessageQuery_I{})
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].Message().GasPrice() > pending[j].Message().GasPrice()
+	})
+	gasLimit := msg.GasAmount_Zero()
+	for _, m := range pending {
+		gasLimit = gasLimit.Add(m.Message().GasLimit())
+		if capacity.LessThan(gasLimit) {
+			return m.Message().GasPrice() + 1
+		}
+	}
+	return abi.TokenAmount(0)
+}
+
+// Returns the messages included in a block, loaded from the chain store. The blocks of the chain
+// up to the head, with the messages they link to, are always present in the chain store.
+func _blockMessages(chainStore ipld.GraphStore, header block.BlockHeader) []msg.UnsignedMessage {
+	blk, err := block.LoadBlock(chainStore, header)
+	util.Assert(err == nil)
+	ret := append([]msg.UnsignedMessage{}, blk.BLSMessages()...)
+	for _, sm := range blk.SECPMessages() {
+		ret = append(ret, sm.Message())
+	}
+	return ret
+}
@@ -0,0 +1,108 @@
+package gas_oracle
+
+import (
+	"reflect"
+	"testing"
+
+	abi "github.com/filecoin-project/specs-actors/actors/abi"
+	filcrypto "github.com/filecoin-project/specs/algorithms/crypto"
+	message_pool "github.com/filecoin-project/specs/systems/filecoin_blockchain/message_pool"
+	block "github.com/filecoin-project/specs/systems/filecoin_blockchain/struct/block"
+	chain "github.com/filecoin-project/specs/systems/filecoin_blockchain/struct/chain"
+	msg "github.com/filecoin-project/specs/systems/filecoin_vm/message"
+)
+
+// An empty message pool.
+type _emptyPool struct {
+	message_pool.MessagePoolSubsystem
+}
+
+func (_emptyPool) Stats() message_pool.MessagePoolStats {
+	return &message_pool.MessagePoolStats_I{TotalGasLimit_: msg.GasAmount_Zero()}
+}
+
+func (_emptyPool) FindMessage(q message_pool.MessageQuery) []msg.SignedMessage {
+	return nil
+}
+
+func _message(gasPrice abi.TokenAmount) msg.UnsignedMessage {
+	return &msg.UnsignedMessage_I{GasPrice_: gasPrice, GasLimit_: msg.GasAmount_FromInt(100)}
+}
+
+// Returns a tipset of one block on parent which includes a SECP message at gasPrice and a BLS message
+// at a higher price, or no messages if gasPrice is zero.
+func _tipset(parent chain.Tipset, epoch abi.ChainEpoch, gasPrice abi.TokenAmount) chain.Tipset {
+	txMeta := &block.TxMeta_I{}
+	if gasPrice > 0 {
+		txMeta.BLSMessages_ = []msg.UnsignedMessage{_message(gasPrice + 5)}
+		txMeta.SECPMessages_ = []msg.SignedMessage{msg.SignedMessage_Make(_message(gasPrice), &filcrypto.Signature_I{})}
+	}
+	h := &block.BlockHeader_I{Epoch_: epoch, Messages_: txMeta}
+	return &chain.Tipset_I{Blocks_: []block.BlockHeader{h}, Epoch_: epoch, Parents_: parent}
+}
+
+// Returns a chain from genesis (with no messages) to epoch 10, with a null round at epoch 5. The tipset at
+// each epoch clears at a gas price equal to its epoch. Only the head is reachable other than through the
+// tipsets' parents.
+func _testChain() chain.Chain {
+	ts := _tipset(nil, 0, 0)
+	for epoch := abi.ChainEpoch(1); epoch <= 10; epoch++ {
+		if epoch == 5 {
+			continue
+		}
+		ts = _tipset(ts, epoch, abi.TokenAmount(epoch))
+	}
+	return &chain.Chain_I{HeadTipset_: ts}
+}
+
+func TestClearingPrices(t *testing.T) {
+	cases := []struct {
+		lookback int
+		prices   []abi.TokenAmount
+	}{
+		{3, []abi.TokenAmount{10, 9, 8}},
+		// The null round is skipped.
+		{6, []abi.TokenAmount{10, 9, 8, 7, 6, 4}},
+		// The walk stops at genesis.
+		{20, []abi.TokenAmount{10, 9, 8, 7, 6, 4, 3, 2, 1, 0}},
+	}
+	for _, c := range cases {
+		params := DefaultGasPriceOracleParams
+		params.LookbackTipsets = c.lookback
+		o := GasPriceOracle_Make(nil, _emptyPool{}, params)
+		if prices := o._clearingPrices(_testChain()); !reflect.DeepEqual(prices, c.prices) {
+			t.Errorf("lookback %d: clearing prices %v, want %v", c.lookback, prices, c.prices)
+		}
+	}
+}
+
+func TestChainEstimate(t *testing.T) {
+	o := GasPriceOracle_Make(nil, _emptyPool{}, DefaultGasPriceOracleParams)
+	prices := func() []abi.TokenAmount { return []abi.TokenAmount{1, 2, 3, 4, 5, 6, 7, 8, 9, 10} }
+	cases := []struct {
+		targetEpochs int
+		price        abi.TokenAmount
+	}{
+		// At the 90th percentile, for inclusion in the next tipset with 90% confidence.
+		{1, 9},
+		// At the 68th percentile, as 1 - (1 - 0.68)^2 >= 0.9.
+		{2, 7},
+		// The lowest price suffices over enough epochs.
+		{100, 1},
+	}
+	for _, c := range cases {
+		if price := o._chainEstimate(prices(), c.targetEpochs); price != c.price {
+			t.Errorf("%d epochs: estimate %d, want %d", c.targetEpochs, price, c.price)
+		}
+	}
+}
+
+func TestSuggestGasPrice(t *testing.T) {
+	params := DefaultGasPriceOracleParams
+	params.LookbackTipsets = 10
+	o := GasPriceOracle_Make(nil, _emptyPool{}, params)
+	// Clearing prices 0, 1, 2, 3, 4, 6, 7, 8, 9, 10; the empty pool estimates zero.
+	if price := o.SuggestGasPrice(_testChain(), 1); price != 9 {
+		t.Errorf("suggested price %d, want 9", price)
+	}
+}
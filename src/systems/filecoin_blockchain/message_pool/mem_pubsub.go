@@ -0,0 +1,102 @@
+package message_pool
+
+import (
+	"sync"
+
+	util "github.com/filecoin-project/specs/util"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+)
+
+// MemPubSub is an in-memory stand-in for the message pubsub topic, on which every node which joins
+// is directly connected to every other. It delivers messages synchronously, so may be used to exercise
+// message propagation, validation and peer scoring deterministically and without a network.
+type MemPubSub struct {
+	_lock   sync.Mutex
+	_topics []*MemTopic
+}
+
+// A node's handle on a MemPubSub topic.
+type MemTopic struct {
+	_pubsub    *MemPubSub
+	_id        peer.ID
+	_validator MessageValidatorFunc
+	// Messages this node has published or received, which it will not process again.
+	_seen map[util.BytesKey]bool
+	// Messages received from peers and accepted, in order of receipt.
+	_accepted []util.Bytes
+}
+
+var _ MessageTopic = &MemTopic{}
+
+func MemPubSub_Make() *MemPubSub {
+	return &MemPubSub{}
+}
+
+func (ps *MemPubSub) Join(id peer.ID) *MemTopic {
+	ps._lock.Lock()
+	defer ps._lock.Unlock()
+	ret := &MemTopic{
+		_pubsub: ps,
+		_id:     id,
+		_seen:   make(map[util.BytesKey]bool),
+	}
+	ps._topics = append(ps._topics, ret)
+	return ret
+}
+
+func (t *MemTopic) RegisterValidator(v MessageValidatorFunc) {
+	t._pubsub._lock.Lock()
+	defer t._pubsub._lock.Unlock()
+	t._validator = v
+}
+
+// Delivers data to every other node, each of which validates it and, if accepted, relays it
+// to those which have not yet received it.
+func (t *MemTopic) Publish(data util.Bytes) error {
+	t._pubsub._propagate(t, data)
+	return nil
+}
+
+// Returns the messages received from peers and accepted, in order of receipt.
+func (t *MemTopic) Accepted() []util.Bytes {
+	t._pubsub._lock.Lock()
+	defer t._pubsub._lock.Unlock()
+	return append([]util.Bytes{}, t._accepted...)
+}
+
+func (ps *MemPubSub) _propagate(origin *MemTopic, data util.Bytes) {
+	key := util.BytesKey(data)
+	ps._lock.Lock()
+	origin._seen[key] = true
+	ps._lock.Unlock()
+
+	relayers := []*MemTopic{origin}
+	for len(relayers) > 0 {
+		relayer := relayers[0]
+		relayers = relayers[1:]
+
+		ps._lock.Lock()
+		var recipients []*MemTopic
+		for _, t := range ps._topics {
+			if !t._seen[key] {
+				t._seen[key] = true
+				recipients = append(recipients, t)
+			}
+		}
+		ps._lock.Unlock()
+
+		// Validators run without the lock held, since they may publish in turn.
+		for _, t := range recipients {
+			result := ValidationAccept
+			if t._validator != nil {
+				result = t._validator(relayer._id, data)
+			}
+			if result == ValidationAccept {
+				ps._lock.Lock()
+				t._accepted = append(t._accepted, data)
+				ps._lock.Unlock()
+				relayers = append(relayers, t)
+			}
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package message_pool
+
+import (
+	"sync"
+
+	addr "github.com/filecoin-project/go-address"
+	filcrypto "github.com/filecoin-project/specs/algorithms/crypto"
+	clock "github.com/filecoin-project/specs/systems/filecoin_nodes/clock"
+	msg "github.com/filecoin-project/specs/systems/filecoin_vm/message"
+	st "github.com/filecoin-project/specs/systems/filecoin_vm/state_tree"
+	util "github.com/filecoin-project/specs/util"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+)
+
+// The outcome of validating a message received on the message topic.
+type ValidationResult int
+
+const (
+	// The message is valid: it is delivered to the pool and relayed to other peers.
+	ValidationAccept ValidationResult = iota
+	// The message is not (or no longer) useful, but its relayer is not at fault: it is dropped.
+	ValidationIgnore
+	// The message is invalid regardless of chain state: it is dropped, and its relayer penalized.
+	ValidationReject
+)
+
+// Validates a message received from a peer, before it is delivered or relayed.
+type MessageValidatorFunc func(from peer.ID, data util.Bytes) ValidationResult
+
+type GossipParams struct {
+	// Maximum amount by which a message's CallSeqNum may exceed its sender's CallSeqNum.
+	MaxCallSeqNumGap int
+	// Score changes for a peer relaying a valid or an invalid message.
+	AcceptReward  int
+	RejectPenalty int
+	// Bounds on a peer's score. Messages from peers whose score has fallen to the minimum
+	// are ignored without validation.
+	MaxScore int
+	MinScore int
+	// A peer's score moves ScoreDecay towards zero for each ScoreDecayInterval (in seconds) elapsed,
+	// so that a graylisted peer recovers, and past rewards lapse, over time.
+	ScoreDecay         int
+	ScoreDecayInterval clock.UnixTime
+}
+
+var DefaultGossipParams = GossipParams{
+	MaxCallSeqNumGap:   100,
+	AcceptReward:       1,
+	RejectPenalty:      10,
+	MaxScore:           100,
+	MinScore:           -100,
+	ScoreDecay:         1,
+	ScoreDecayInterval: 60,
+}
+
+// MessageValidator validates messages received on the message topic, in stages of increasing cost:
+//
+// - syntax: the message deserializes, is within the maximum size, and has valid fields;
+// - signature: the message is signed by the key of its sender's robust address in the head state;
+// - state: the sender exists in the head state, has a balance covering the message's value and gas limit,
+// and its CallSeqNum is neither already used nor too far ahead;
+// - pool: the message is added to the message pool (so is neither a duplicate nor an underpriced replacement).
+//
+// Messages failing the syntax or signature checks are rejected, and their relayer penalized.
+// Those failing later checks, which depend on the chain head, are only ignored.
+type MessageValidator struct {
+	_pool     MessageStore
+	_resolver AddressResolver
+	_params   GossipParams
+	_clock    clock.UTCClock
+
+	_lock sync.Mutex
+	// Scores of peers whose score is non-zero.
+	_scores map[peer.ID]*_peerScore
+}
+
+type _peerScore struct {
+	score int
+	// The time from which the score next decays.
+	decayedAt clock.UnixTime
+}
+
+func MessageValidator_Make(pool MessageStore, resolver AddressResolver, params GossipParams, clk clock.UTCClock) *MessageValidator {
+	Assert(params.MinScore < 0 && params.MaxScore > 0)
+	Assert(params.ScoreDecay > 0 && params.ScoreDecayInterval > 0)
+	return &MessageValidator{
+		_pool:     pool,
+		_resolver: resolver,
+		_params:   params,
+		_clock:    clk,
+		_scores:   make(map[peer.ID]*_peerScore),
+	}
+}
+
+func (v *MessageValidator) Validate(from peer.ID, data util.Bytes) ValidationResult {
+	if v.Graylisted(from) {
+		return ValidationIgnore
+	}
+	ret := v._validate(data)
+	v._score(from, ret)
+	return ret
+}
+
+// Returns a peer's score, which starts at zero.
+func (v *MessageValidator) Score(p peer.ID) int {
+	v._lock.Lock()
+	defer v._lock.Unlock()
+	return v._decayedScore(p)
+}
+
+// Returns whether a peer has recently relayed so many invalid messages that its messages are ignored.
+func (v *MessageValidator) Graylisted(p peer.ID) bool {
+	return v.Score(p) <= v._params.MinScore
+}
+
+func (v *MessageValidator) _score(p peer.ID, result ValidationResult) {
+	v._lock.Lock()
+	defer v._lock.Unlock()
+	score := v._decayedScore(p)
+	switch result {
+	case ValidationAccept:
+		score = util.IntMin(score+v._params.AcceptReward, v._params.MaxScore)
+	case ValidationReject:
+		score = util.IntMax(score-v._params.RejectPenalty, v._params.MinScore)
+	default:
+		return
+	}
+	if score == 0 {
+		delete(v._scores, p)
+	} else if s, found := v._scores[p]; found {
+		s.score = score
+	} else {
+		v._scores[p] = &_peerScore{score: score, decayedAt: v._clock.NowUTCUnix()}
+	}
+}
+
+// Applies the decay since a peer's score last decayed, and returns the score. Must be called with the
+// lock held.
+func (v *MessageValidator) _decayedScore(p peer.ID) int {
+	s, found := v._scores[p]
+	if !found {
+		return 0
+	}
+	intervals := (v._clock.NowUTCUnix() - s.decayedAt) / v._params.ScoreDecayInterval
+	if intervals <= 0 {
+		return s.score
+	}
+	s.decayedAt += intervals * v._params.ScoreDecayInterval
+
+	decay := int(intervals) * v._params.ScoreDecay
+	if s.score > 0 {
+		s.score = util.IntMax(s.score-decay, 0)
+	} else {
+		s.score = util.IntMin(s.score+decay, 0)
+	}
+	if s.score == 0 {
+		delete(v._scores, p)
+	}
+	return s.score
+}
+
+func (v *MessageValidator) _validate(data util.Bytes) ValidationResult {
+	m, ok := _checkMessageSyntax(data)
+	if !ok {
+		return ValidationReject
+	}
+
+	headState := v._pool.HeadState()
+	sender, ok := v._resolver.ResolveAddress(headState, m.Message().From())
+	if !ok {
+		return ValidationIgnore
+	}
+	robust, ok := v._resolver.RobustAddress(headState, sender)
+	if !ok {
+		return ValidationIgnore
+	}
+	if !_signatureValid(m, robust) {
+		return ValidationReject
+	}
+
+	if !v._stateValid(headState, sender, m.Message()) {
+		return ValidationIgnore
+	}
+
+	if err := v._pool.Add(m); err != nil {
+		return ValidationIgnore
+	}
+	return ValidationAccept
+}
+
+func (v *MessageValidator) _stateValid(headState st.StateTree, sender addr.Address, m msg.UnsignedMessage) bool {
+	fromActor, ok := headState.GetActor(sender)
+	if !ok {
+		return false
+	}
+	if m.CallSeqNum() < fromActor.CallSeqNum() || int(m.CallSeqNum()-fromActor.CallSeqNum()) > v._params.MaxCallSeqNumGap {
+		return false
+	}
+	return _balanceCovers(fromActor.Balance(), m)
+}
+
+// Checks the properties of a serialized message which do not depend on chain state.
+func _checkMessageSyntax(data util.Bytes) (msg.SignedMessage, bool) {
+	if len(data) > msg.MessageMaxSize {
+		return nil, false
+	}
+	m, err := msg.Deserialize_SignedMessage(util.Serialization(data))
+	if err != nil {
+		return nil, false
+	}
+	message := m.Message()
+	if message.Version() != 0 || message.Value() < 0 || message.GasPrice() < 0 || !msg.GasAmount_Zero().LessThan(message.GasLimit()) {
+		return nil, false
+	}
+	if m.Signature() == nil {
+		return nil, false
+	}
+	switch message.From().Protocol() {
+	case addr.BLS:
+		return m, m.Signature().Type() == filcrypto.SigType_BLSSigType
+	case addr.SECP256K1:
+		return m, m.Signature().Type() == filcrypto.SigType_ECDSASigType
+	case addr.ID:
+		// The signature type is checked against the sender's robust address.
+		return m, true
+	default:
+		return nil, false
+	}
+}
+
+// Returns whether a message is signed by the key of its sender's robust address.
+func _signatureValid(m msg.SignedMessage, robust addr.Address) bool {
+	switch robust.Protocol() {
+	case addr.BLS:
+		if m.Signature().Type() != filcrypto.SigType_BLSSigType {
+			return false
+		}
+		_, err := msg.Verify(m, filcrypto.PublicKey(robust.Payload()))
+		return err == nil
+	case addr.SECP256K1:
+		if m.Signature().Type() != filcrypto.SigType_ECDSASigType {
+			return false
+		}
+		// The address is a hash of the public key, which is recovered from the signature,
+		// and must hash to the address.
+		unsigned := filcrypto.Message(msg.Serialize_UnsignedMessage(m.Message()))
+		ecdsa := &filcrypto.ECDSA_I{Signature_: m.Signature()}
+		recovered := ecdsa.Recover(unsigned, m.Signature().Sig())
+		if recovered.Err() != nil {
+			return false
+		}
+		signer, err := addr.NewSecp256k1Address(recovered.PublicKey())
+		return err == nil && signer == robust
+	default:
+		return false
+	}
+}
@@ -0,0 +1,304 @@
+package message_pool
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	addr "github.com/filecoin-project/go-address"
+	abi "github.com/filecoin-project/specs-actors/actors/abi"
+	builtin "github.com/filecoin-project/specs-actors/actors/builtin"
+	filcrypto "github.com/filecoin-project/specs/algorithms/crypto"
+	clock "github.com/filecoin-project/specs/systems/filecoin_nodes/clock"
+	actstate "github.com/filecoin-project/specs/systems/filecoin_vm/actor"
+	msg "github.com/filecoin-project/specs/systems/filecoin_vm/message"
+	st "github.com/filecoin-project/specs/systems/filecoin_vm/state_tree"
+	util "github.com/filecoin-project/specs/util"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+)
+
+type _testClock struct {
+	clock.UTCClock_I
+	now clock.UnixTime
+}
+
+func (c *_testClock) NowUTCUnix() clock.UnixTime {
+	return c.now
+}
+
+// Returns distinct messages which fail the syntax check, being over the maximum size.
+func _oversizedMessage(i int) util.Bytes {
+	ret := make(util.Bytes, msg.MessageMaxSize+1)
+	ret[0], ret[1] = byte(i), byte(i>>8)
+	return ret
+}
+
+// Joins a publishing node and a validating node to a MemPubSub. The validator rejects the messages
+// published in these tests before consulting its pool or resolver.
+func _gossipFixture(clk clock.UTCClock) (publisher *MemTopic, receiver *MemTopic, v *MessageValidator) {
+	ps := MemPubSub_Make()
+	publisher = ps.Join(peer.ID("publisher"))
+	receiver = ps.Join(peer.ID("receiver"))
+	v = MessageValidator_Make(nil, nil, DefaultGossipParams, clk)
+	receiver.RegisterValidator(v.Validate)
+	return publisher, receiver, v
+}
+
+func TestInvalidMessagesGraylistRelayer(t *testing.T) {
+	clk := &_testClock{now: 1000}
+	publisher, receiver, v := _gossipFixture(clk)
+	params := DefaultGossipParams
+
+	toGraylist := -params.MinScore / params.RejectPenalty
+	for i := 0; i < toGraylist; i++ {
+		if v.Graylisted(peer.ID("publisher")) {
+			t.Fatalf("publisher graylisted after %d invalid messages, want %d", i, toGraylist)
+		}
+		if err := publisher.Publish(_oversizedMessage(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if score := v.Score(peer.ID("publisher")); score != params.MinScore {
+		t.Errorf("publisher score %d, want %d", score, params.MinScore)
+	}
+	if !v.Graylisted(peer.ID("publisher")) {
+		t.Error("publisher not graylisted")
+	}
+	if len(receiver.Accepted()) != 0 {
+		t.Errorf("%d invalid messages accepted", len(receiver.Accepted()))
+	}
+
+	// Further messages are ignored without validation, and do not lower the score further.
+	if result := v.Validate(peer.ID("publisher"), _oversizedMessage(toGraylist)); result != ValidationIgnore {
+		t.Errorf("message from graylisted peer: result %d, want %d", result, ValidationIgnore)
+	}
+	if score := v.Score(peer.ID("publisher")); score != params.MinScore {
+		t.Errorf("publisher score %d after graylisting, want %d", score, params.MinScore)
+	}
+	if score := v.Score(peer.ID("other")); score != 0 {
+		t.Errorf("other peer's score %d, want 0", score)
+	}
+}
+
+func TestGraylistedPeerRecovers(t *testing.T) {
+	clk := &_testClock{now: 1000}
+	publisher, _, v := _gossipFixture(clk)
+	params := DefaultGossipParams
+
+	for i := 0; !v.Graylisted(peer.ID("publisher")); i++ {
+		if err := publisher.Publish(_oversizedMessage(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Less than an interval elapsed: no decay.
+	clk.now += params.ScoreDecayInterval - 1
+	if !v.Graylisted(peer.ID("publisher")) {
+		t.Error("publisher recovered before a decay interval elapsed")
+	}
+
+	// One interval elapsed since the score was last set.
+	clk.now++
+	if v.Graylisted(peer.ID("publisher")) {
+		t.Error("publisher still graylisted after a decay interval")
+	}
+	if score, want := v.Score(peer.ID("publisher")), params.MinScore+params.ScoreDecay; score != want {
+		t.Errorf("publisher score %d, want %d", score, want)
+	}
+
+	// A further invalid message graylists it again.
+	if err := publisher.Publish(_oversizedMessage(1000)); err != nil {
+		t.Fatal(err)
+	}
+	if !v.Graylisted(peer.ID("publisher")) {
+		t.Error("publisher not graylisted again after an invalid message")
+	}
+
+	// The score decays no further than zero.
+	intervals := -params.MinScore/params.ScoreDecay + 10
+	clk.now += clock.UnixTime(intervals) * params.ScoreDecayInterval
+	if score := v.Score(peer.ID("publisher")); score != 0 {
+		t.Errorf("publisher score %d after full decay, want 0", score)
+	}
+}
+
+func TestRewardsDecay(t *testing.T) {
+	clk := &_testClock{now: 1000}
+	_, _, v := _gossipFixture(clk)
+	params := DefaultGossipParams
+	p := peer.ID("publisher")
+
+	for i := 0; i < 5; i++ {
+		v._score(p, ValidationAccept)
+	}
+	if score, want := v.Score(p), 5*params.AcceptReward; score != want {
+		t.Fatalf("score %d, want %d", score, want)
+	}
+
+	clk.now += 2 * params.ScoreDecayInterval
+	if score, want := v.Score(p), 5*params.AcceptReward-2*params.ScoreDecay; score != want {
+		t.Errorf("score %d after two intervals, want %d", score, want)
+	}
+
+	clk.now += 100 * params.ScoreDecayInterval
+	if score := v.Score(p); score != 0 {
+		t.Errorf("score %d after full decay, want 0", score)
+	}
+
+	// A reject after full decay applies from zero.
+	v._score(p, ValidationReject)
+	if score, want := v.Score(p), -params.RejectPenalty; score != want {
+		t.Errorf("score %d, want %d", score, want)
+	}
+}
+
+// A sender with a secp256k1 key, whose actor is at ID 100 with a balance of 1000 and CallSeqNum 5.
+type _gossipSender struct {
+	secretKey filcrypto.SecretKey
+	robust    addr.Address
+	id        addr.Address
+}
+
+func _gossipSender_Make(t *testing.T, seed byte) _gossipSender {
+	sk := filcrypto.SecretKey(bytes.Repeat([]byte{seed}, 32))
+	pk, err := filcrypto.Secp256k1PublicKey(sk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	robust, err := addr.NewSecp256k1Address(pk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return _gossipSender{secretKey: sk, robust: robust, id: _testSender(t, 100)}
+}
+
+func (s _gossipSender) headState() st.StateTree {
+	return &st.StateTree_I{
+		ActorStates_: map[addr.Address]actstate.ActorState{
+			s.id: &actstate.ActorState_I{CodeID_: builtin.AccountActorCodeID, Balance_: abi.TokenAmount(1000), CallSeqNum_: 5},
+		},
+	}
+}
+
+// Returns a message from the sender's robust address, signed with signer's key.
+func (s _gossipSender) message(t *testing.T, signer _gossipSender, callSeqNum actstate.CallSeqNum, value abi.TokenAmount) util.Bytes {
+	unsigned := &msg.UnsignedMessage_I{
+		To_:         s.id,
+		From_:       s.robust,
+		Method_:     builtin.MethodSend,
+		CallSeqNum_: callSeqNum,
+		Value_:      value,
+		GasPrice_:   abi.TokenAmount(1),
+		GasLimit_:   msg.GasAmount_FromInt(100),
+	}
+	sig, err := filcrypto.Secp256k1Sign(signer.secretKey, filcrypto.Message(msg.Serialize_UnsignedMessage(unsigned)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := msg.SignedMessage_Make(unsigned, &filcrypto.Signature_I{Type_: filcrypto.SigType_ECDSASigType, Sig_: sig})
+	return util.Bytes(msg.Serialize_SignedMessage(signed))
+}
+
+// Returns a message from the sender's robust address, signed with its key and then malleated: with the
+// signature's s negated, and the recovery ID flipped to match, so that it still recovers the key.
+func (s _gossipSender) malleatedMessage(t *testing.T, callSeqNum actstate.CallSeqNum, value abi.TokenAmount) util.Bytes {
+	signed, err := msg.Deserialize_SignedMessage(util.Serialization(s.message(t, s, callSeqNum, value)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := append(filcrypto.SignatureBytes{}, signed.Signature().Sig()...)
+	order, _ := new(big.Int).SetString("fffffffffffffffffffffffffffffffebaaedce6af48a03bbfd25e8cd0364141", 16)
+	negated := order.Sub(order, new(big.Int).SetBytes(sig[32:64])).Bytes()
+	copy(sig[32:64], make([]byte, 32))
+	copy(sig[64-len(negated):64], negated)
+	sig[64] ^= 1
+	malleated := msg.SignedMessage_Make(signed.Message(), &filcrypto.Signature_I{Type_: filcrypto.SigType_ECDSASigType, Sig_: sig})
+	return util.Bytes(msg.Serialize_SignedMessage(malleated))
+}
+
+// Maps the sender's robust address to its ID address, and no other.
+type _senderResolver struct {
+	sender _gossipSender
+}
+
+func (r _senderResolver) ResolveAddress(tree st.StateTree, a addr.Address) (addr.Address, bool) {
+	if a == r.sender.robust || a == r.sender.id {
+		return r.sender.id, true
+	}
+	return a, false
+}
+
+func (r _senderResolver) RobustAddress(tree st.StateTree, id addr.Address) (addr.Address, bool) {
+	if id == r.sender.id {
+		return r.sender.robust, true
+	}
+	return addr.Undef, false
+}
+
+// A pool checking messages against a fixed head state, whose Add fails with addErr, if set.
+type _gossipPool struct {
+	MessageStore
+	head   st.StateTree
+	addErr error
+	added  int
+}
+
+func (p *_gossipPool) HeadState() st.StateTree {
+	return p.head
+}
+
+func (p *_gossipPool) Add(m msg.SignedMessage) error {
+	if p.addErr != nil {
+		return p.addErr
+	}
+	p.added++
+	return nil
+}
+
+// Each message passes the stages before that which it exercises; the result determines whether the
+// receiver relays it, and the change of the publisher's score.
+func TestValidationStages(t *testing.T) {
+	params := DefaultGossipParams
+	sender := _gossipSender_Make(t, 1)
+	other := _gossipSender_Make(t, 2)
+	cases := []struct {
+		name    string
+		data    util.Bytes
+		addErr  error
+		result  ValidationResult
+		scoreBy int
+	}{
+		{"valid", sender.message(t, sender, 5, 10), nil, ValidationAccept, params.AcceptReward},
+		{"signed by another key", sender.message(t, other, 5, 10), nil, ValidationReject, -params.RejectPenalty},
+		{"malleated signature", sender.malleatedMessage(t, 5, 10), nil, ValidationReject, -params.RejectPenalty},
+		{"sender not in InitActor map", other.message(t, other, 5, 10), nil, ValidationIgnore, 0},
+		{"CallSeqNum already used", sender.message(t, sender, 4, 10), nil, ValidationIgnore, 0},
+		{"CallSeqNum at maximum gap", sender.message(t, sender, actstate.CallSeqNum(5+params.MaxCallSeqNumGap), 10), nil, ValidationAccept, params.AcceptReward},
+		{"CallSeqNum beyond maximum gap", sender.message(t, sender, actstate.CallSeqNum(6+params.MaxCallSeqNumGap), 10), nil, ValidationIgnore, 0},
+		{"balance below cost", sender.message(t, sender, 5, 901), nil, ValidationIgnore, 0},
+		{"rejected by pool", sender.message(t, sender, 5, 10), ErrReplacementUnderpriced, ValidationIgnore, 0},
+	}
+	for _, c := range cases {
+		pool := &_gossipPool{head: sender.headState(), addErr: c.addErr}
+		ps := MemPubSub_Make()
+		publisher := ps.Join(peer.ID("publisher"))
+		receiver := ps.Join(peer.ID("receiver"))
+		v := MessageValidator_Make(pool, _senderResolver{sender}, params, &_testClock{now: 1000})
+		receiver.RegisterValidator(v.Validate)
+
+		if err := publisher.Publish(c.data); err != nil {
+			t.Fatal(err)
+		}
+		accepted := len(receiver.Accepted()) == 1
+		if accepted != (c.result == ValidationAccept) || (pool.added == 1) != accepted {
+			t.Errorf("%s: accepted %v and added to pool %d times, want result %d", c.name, accepted, pool.added, c.result)
+		}
+		if score := v.Score(peer.ID("publisher")); score != c.scoreBy {
+			t.Errorf("%s: publisher score %d, want %d", c.name, score, c.scoreBy)
+		}
+		if result := v._validate(c.data); result != c.result {
+			t.Errorf("%s: result %d, want %d", c.name, result, c.result)
+		}
+	}
+}
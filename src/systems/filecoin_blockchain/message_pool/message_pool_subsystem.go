@@ -0,0 +1,77 @@
+package message_pool
+
+import (
+	"math"
+	"sort"
+
+	abi "github.com/filecoin-project/specs-actors/actors/abi"
+	block "github.com/filecoin-project/specs/systems/filecoin_blockchain/struct/block"
+	clock "github.com/filecoin-project/specs/systems/filecoin_nodes/clock"
+	repo "github.com/filecoin-project/specs/systems/filecoin_nodes/repository"
+	msg "github.com/filecoin-project/specs/systems/filecoin_vm/message"
+	st "github.com/filecoin-project/specs/systems/filecoin_vm/state_tree"
+	util "github.com/filecoin-project/specs/util"
+)
+
+// Constructs a message pool whose locally submitted messages are persisted in datastore, and which
+// validates and adds the messages received on topic. The scores of the peers relaying them decay over time,
+// as measured by clk.
+// Those persisted by a previous run must be restored with Syncer().LoadLocalMessages(), once headState is
+// that of the node's chain head. The local messages are republished every LocalMessageRepublishInterval
+// until stop is called.
+func MessagePoolSubsystem_Make(params MessagePoolParams, resolver AddressResolver, headState st.StateTree, datastore repo.Datastore, topic MessageTopic, clk clock.UTCClock) (
+	mp MessagePoolSubsystem, stop func()) {
+
+	store := PendingMessageStore_Make(params, resolver, headState)
+	topic.RegisterValidator(MessageValidator_Make(store, resolver, DefaultGossipParams, clk).Validate)
+	syncer := MessageSyncer_Make(store, LocalMessageStore_Make(datastore), topic)
+	mp = &MessagePoolSubsystem_I{
+		Store_:  store,
+		Syncer_: syncer,
+	}
+	return mp, StartLocalMessageRepublisher(syncer, LocalMessageRepublishInterval)
+}
+
+func (mp *MessagePoolSubsystem_I) Add(m msg.SignedMessage) error {
+	return mp.Store().Add(m)
+}
+
+func (mp *MessagePoolSubsystem_I) HeadChange(reverted []block.Block, applied []block.Block, headState st.StateTree) {
+	mp.Store().HeadChange(reverted, applied, headState)
+}
+
+// The percentile ranks at which MessagePoolStats reports the gas prices of pending messages.
+var GasPricePercentileRanks = []float64{10, 25, 50, 75, 90}
+
+func (mp *MessagePoolSubsystem_I) Stats() MessagePoolStats {
+	var gasPrices []abi.TokenAmount
+	totalGasLimit := msg.GasAmount_Zero()
+	for _, sender := range mp.Store().Senders() {
+		for _, m := range mp.Store().PendingFrom(sender) {
+			gasPrices = append(gasPrices, m.Message().GasPrice())
+			totalGasLimit = totalGasLimit.Add(m.Message().GasLimit())
+		}
+	}
+	sort.Slice(gasPrices, func(i, j int) bool { return gasPrices[i] < gasPrices[j] })
+
+	var percentiles []abi.TokenAmount
+	if len(gasPrices) > 0 {
+		for _, rank := range GasPricePercentileRanks {
+			percentiles = append(percentiles, GasPricePercentile(gasPrices, rank))
+		}
+	}
+
+	return &MessagePoolStats_I{
+		Size_:                util.UInt(len(gasPrices)),
+		TotalGasLimit_:       totalGasLimit,
+		GasPricePercentiles_: percentiles,
+	}
+}
+
+// Returns the gas price at percentile rank (between 0 and 100) of a non-empty list of gas prices in
+// increasing order, using the nearest-rank method.
+func GasPricePercentile(sortedGasPrices []abi.TokenAmount, rank float64) abi.TokenAmount {
+	Assert(len(sortedGasPrices) > 0 && rank >= 0 && rank <= 100)
+	i := int(math.Ceil(rank/100*float64(len(sortedGasPrices)))) - 1
+	return sortedGasPrices[util.IntMax(i, 0)]
+}
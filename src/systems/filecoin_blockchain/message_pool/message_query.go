@@ -0,0 +1,141 @@
+package message_pool
+
+import (
+	"bytes"
+	"sort"
+
+	addr "github.com/filecoin-project/go-address"
+	msg "github.com/filecoin-project/specs/systems/filecoin_vm/message"
+)
+
+func (mp *MessagePoolSubsystem_I) FindMessage(q MessageQuery) []msg.SignedMessage {
+	return mp.Store().Find(q)
+}
+
+// Returns the pending messages matching q, ordered by sender and CallSeqNum.
+//
+// Candidates are taken from the smallest of the indexes selected by q's sender, receiver and method
+// constraints (or all pending messages, if q has none of these), and then checked against every
+// constraint.
+func (s *PendingMessageStore) Find(q MessageQuery) []msg.SignedMessage {
+	s._lock.Lock()
+	defer s._lock.Unlock()
+
+	candidates := s._candidates(q)
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.from != b.from {
+			return a.from.String() < b.from.String()
+		}
+		return a.callSeqNum < b.callSeqNum
+	})
+
+	var ret []msg.SignedMessage
+	for _, key := range candidates {
+		m := s._pending[key.from][key.callSeqNum]
+		if s._queryMatches(q, key, m.Message()) {
+			ret = append(ret, m)
+		}
+	}
+	return ret
+}
+
+// Returns the keys of the smallest of the indexes selected by q's sender, receiver and method
+// constraints, or of all pending messages if q has none of these.
+func (s *PendingMessageStore) _candidates(q MessageQuery) []_messageKey {
+	var candidates []_messageKey
+	indexed := false
+	useIndex := func(keys []_messageKey) {
+		if !indexed || len(keys) < len(candidates) {
+			candidates = keys
+			indexed = true
+		}
+	}
+
+	if q.From() != nil && q.From().Is_Some() {
+		var keys []_messageKey
+		if sender, ok := s._resolver.ResolveAddress(s._headState, q.From().As_Some()); ok {
+			for n := range s._pending[sender] {
+				keys = append(keys, _messageKey{from: sender, callSeqNum: n})
+			}
+		}
+		useIndex(keys)
+	}
+	if q.To() != nil && q.To().Is_Some() {
+		var keys []_messageKey
+		for _, receiver := range s._receiverKeys(q.To().As_Some()) {
+			for key := range s._byReceiver[receiver] {
+				keys = append(keys, key)
+			}
+		}
+		useIndex(keys)
+	}
+	if q.Method() != nil && q.Method().Is_Some() {
+		var keys []_messageKey
+		for key := range s._byMethod[q.Method().As_Some()] {
+			keys = append(keys, key)
+		}
+		useIndex(keys)
+	}
+	if !indexed {
+		for sender, senderMsgs := range s._pending {
+			for n := range senderMsgs {
+				candidates = append(candidates, _messageKey{from: sender, callSeqNum: n})
+			}
+		}
+	}
+	return candidates
+}
+
+// Returns the keys under which messages to a receiver may be indexed: the address itself, and
+// the ID address to which it resolves in the head state.
+func (s *PendingMessageStore) _receiverKeys(to addr.Address) []addr.Address {
+	ret := []addr.Address{to}
+	if id, ok := s._resolver.ResolveAddress(s._headState, to); ok && id != to {
+		ret = append(ret, id)
+	}
+	return ret
+}
+
+func (s *PendingMessageStore) _queryMatches(q MessageQuery, key _messageKey, m msg.UnsignedMessage) bool {
+	if q.From() != nil && q.From().Is_Some() {
+		if sender, ok := s._resolver.ResolveAddress(s._headState, q.From().As_Some()); !ok || sender != key.from {
+			return false
+		}
+	}
+	if q.To() != nil && q.To().Is_Some() {
+		found := false
+		for _, receiver := range s._receiverKeys(q.To().As_Some()) {
+			found = found || receiver == s._receivers[key] || receiver == m.To()
+		}
+		if !found {
+			return false
+		}
+	}
+	if q.Method() != nil && q.Method().Is_Some() && q.Method().As_Some() != m.Method() {
+		return false
+	}
+	if q.Params() != nil && q.Params().Is_Some() && !bytes.Equal(q.Params().As_Some(), m.Params()) {
+		return false
+	}
+
+	if q.ValueMin() != nil && q.ValueMin().Is_Some() && m.Value() < q.ValueMin().As_Some() {
+		return false
+	}
+	if q.ValueMax() != nil && q.ValueMax().Is_Some() && m.Value() > q.ValueMax().As_Some() {
+		return false
+	}
+	if q.GasPriceMin() != nil && q.GasPriceMin().Is_Some() && m.GasPrice() < q.GasPriceMin().As_Some() {
+		return false
+	}
+	if q.GasPriceMax() != nil && q.GasPriceMax().Is_Some() && m.GasPrice() > q.GasPriceMax().As_Some() {
+		return false
+	}
+	if q.GasLimitMin() != nil && q.GasLimitMin().Is_Some() && m.GasLimit().LessThan(q.GasLimitMin().As_Some()) {
+		return false
+	}
+	if q.GasLimitMax() != nil && q.GasLimitMax().Is_Some() && q.GasLimitMax().As_Some().LessThan(m.GasLimit()) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,348 @@
+package message_pool
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	addr "github.com/filecoin-project/go-address"
+	abi "github.com/filecoin-project/specs-actors/actors/abi"
+	builtin "github.com/filecoin-project/specs-actors/actors/builtin"
+	filcrypto "github.com/filecoin-project/specs/algorithms/crypto"
+	block "github.com/filecoin-project/specs/systems/filecoin_blockchain/struct/block"
+	actstate "github.com/filecoin-project/specs/systems/filecoin_vm/actor"
+	msg "github.com/filecoin-project/specs/systems/filecoin_vm/message"
+	st "github.com/filecoin-project/specs/systems/filecoin_vm/state_tree"
+)
+
+// Resolves ID addresses to themselves, and robust addresses through a map.
+type _queryResolver map[addr.Address]addr.Address
+
+func (r _queryResolver) ResolveAddress(tree st.StateTree, a addr.Address) (addr.Address, bool) {
+	if a.Protocol() == addr.ID {
+		return a, true
+	}
+	id, found := r[a]
+	if !found {
+		return a, false
+	}
+	return id, true
+}
+
+func (r _queryResolver) RobustAddress(tree st.StateTree, id addr.Address) (addr.Address, bool) {
+	for robust, mapped := range r {
+		if mapped == id {
+			return robust, true
+		}
+	}
+	return addr.Undef, false
+}
+
+// Senders at IDs 100 (with a robust address) and 101, a receiver at ID 200 (with a robust address),
+// and a robust receiver address which does not resolve.
+type _queryFixture struct {
+	robustSender, sender, otherSender    addr.Address
+	robustReceiver, receiver, unresolved addr.Address
+	// The messages added to the store, in the order of the indices used by the tests.
+	messages []msg.SignedMessage
+	store    *PendingMessageStore
+}
+
+func _robustAddr(t *testing.T, name string) addr.Address {
+	ret, err := addr.NewActorAddress([]byte(name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ret
+}
+
+func _queryFixture_Make(t *testing.T) *_queryFixture {
+	f := &_queryFixture{
+		robustSender:   _robustAddr(t, "sender"),
+		sender:         _testSender(t, 100),
+		otherSender:    _testSender(t, 101),
+		robustReceiver: _robustAddr(t, "receiver"),
+		receiver:       _testSender(t, 200),
+		unresolved:     _robustAddr(t, "unresolved"),
+	}
+	resolver := _queryResolver{f.robustSender: f.sender, f.robustReceiver: f.receiver}
+	f.store = PendingMessageStore_Make(DefaultMessagePoolParams, resolver, f.headState(0))
+
+	f.messages = []msg.SignedMessage{
+		_queryMessage(f.robustSender, 0, f.robustReceiver, builtin.MethodSend, nil, 10, 1, 100),
+		_queryMessage(f.sender, 1, f.receiver, 2, abi.MethodParams{1}, 20, 2, 200),
+		_queryMessage(f.sender, 2, f.otherSender, 2, abi.MethodParams{2}, 30, 3, 300),
+		_queryMessage(f.otherSender, 0, f.unresolved, builtin.MethodSend, nil, 40, 4, 400),
+		_queryMessage(f.otherSender, 1, f.receiver, 3, nil, 50, 5, 500),
+	}
+	for _, m := range f.messages {
+		if err := f.store.Add(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return f
+}
+
+// Returns a head state in which both senders have used CallSeqNums below callSeqNum.
+func (f *_queryFixture) headState(callSeqNum actstate.CallSeqNum) st.StateTree {
+	actors := make(map[addr.Address]actstate.ActorState)
+	for _, a := range []addr.Address{f.sender, f.otherSender, f.receiver} {
+		actors[a] = &actstate.ActorState_I{CodeID_: builtin.AccountActorCodeID, Balance_: abi.TokenAmount(1000000), CallSeqNum_: callSeqNum}
+	}
+	return &st.StateTree_I{ActorStates_: actors}
+}
+
+func (f *_queryFixture) key(i int) _messageKey {
+	key, ok := f.store._key(f.messages[i].Message())
+	Assert(ok)
+	return key
+}
+
+func _queryMessage(from addr.Address, callSeqNum actstate.CallSeqNum, to addr.Address, method abi.MethodNum, params abi.MethodParams, value, gasPrice abi.TokenAmount, gasLimit int) msg.SignedMessage {
+	return msg.SignedMessage_Make(
+		&msg.UnsignedMessage_I{
+			To_:         to,
+			From_:       from,
+			Method_:     method,
+			Params_:     params,
+			CallSeqNum_: callSeqNum,
+			Value_:      value,
+			GasPrice_:   gasPrice,
+			GasLimit_:   msg.GasAmount_FromInt(gasLimit),
+		},
+		&filcrypto.Signature_I{Type_: filcrypto.SigType_ECDSASigType},
+	)
+}
+
+// Asserts that the receiver and method indexes hold exactly the pending messages, under the receivers
+// recorded for them and their methods, and hold no empty sets.
+func _assertIndexes(t *testing.T, s *PendingMessageStore) {
+	t.Helper()
+	byReceiver, byMethod := 0, 0
+	for receiver, keys := range s._byReceiver {
+		if len(keys) == 0 {
+			t.Errorf("empty receiver index for %v", receiver)
+		}
+		byReceiver += len(keys)
+	}
+	for method, keys := range s._byMethod {
+		if len(keys) == 0 {
+			t.Errorf("empty method index for %v", method)
+		}
+		byMethod += len(keys)
+	}
+	if byReceiver != s._size || byMethod != s._size || len(s._receivers) != s._size {
+		t.Errorf("%d receiver index entries, %d method index entries and %d receivers for %d pending messages", byReceiver, byMethod, len(s._receivers), s._size)
+	}
+
+	for sender, senderMsgs := range s._pending {
+		for n, m := range senderMsgs {
+			key := _messageKey{from: sender, callSeqNum: n}
+			receiver, found := s._receivers[key]
+			if !found {
+				t.Errorf("no receiver recorded for %v", key)
+			}
+			if _, found := s._byReceiver[receiver][key]; !found {
+				t.Errorf("%v not indexed under receiver %v", key, receiver)
+			}
+			if _, found := s._byMethod[m.Message().Method()][key]; !found {
+				t.Errorf("%v not indexed under method %v", key, m.Message().Method())
+			}
+		}
+	}
+}
+
+// Asserts that the messages found are those of the fixture with the given indices, in order.
+func (f *_queryFixture) assertFound(t *testing.T, name string, found []msg.SignedMessage, want ...int) {
+	t.Helper()
+	var got []int
+	for _, m := range found {
+		i := 0
+		for i < len(f.messages) && f.messages[i] != m {
+			i++
+		}
+		got = append(got, i)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("%s: found messages %v, want %v", name, got, want)
+	}
+}
+
+func TestFindMessages(t *testing.T) {
+	f := _queryFixture_Make(t)
+	_assertIndexes(t, f.store)
+
+	cases := []struct {
+		name string
+		q    *MessageQuery_I
+		want []int
+	}{
+		{"no constraints", &MessageQuery_I{}, []int{0, 1, 2, 3, 4}},
+
+		{"robust sender", &MessageQuery_I{From_: MessageQuery_From_Make_Some(f.robustSender)}, []int{0, 1, 2}},
+		{"ID sender", &MessageQuery_I{From_: MessageQuery_From_Make_Some(f.sender)}, []int{0, 1, 2}},
+		{"other sender", &MessageQuery_I{From_: MessageQuery_From_Make_Some(f.otherSender)}, []int{3, 4}},
+		{"unresolved sender", &MessageQuery_I{From_: MessageQuery_From_Make_Some(f.unresolved)}, nil},
+
+		// Messages to a receiver are found by either of its addresses, whichever they were sent to.
+		{"robust receiver", &MessageQuery_I{To_: MessageQuery_To_Make_Some(f.robustReceiver)}, []int{0, 1, 4}},
+		{"ID receiver", &MessageQuery_I{To_: MessageQuery_To_Make_Some(f.receiver)}, []int{0, 1, 4}},
+		{"unresolved receiver", &MessageQuery_I{To_: MessageQuery_To_Make_Some(f.unresolved)}, []int{3}},
+		{"sender as receiver", &MessageQuery_I{To_: MessageQuery_To_Make_Some(f.otherSender)}, []int{2}},
+		{"no messages to receiver", &MessageQuery_I{To_: MessageQuery_To_Make_Some(f.sender)}, nil},
+
+		{"method", &MessageQuery_I{Method_: MessageQuery_Method_Make_Some(2)}, []int{1, 2}},
+		{"send method", &MessageQuery_I{Method_: MessageQuery_Method_Make_Some(builtin.MethodSend)}, []int{0, 3}},
+		{"params", &MessageQuery_I{Params_: MessageQuery_Params_Make_Some(abi.MethodParams{1})}, []int{1}},
+
+		{"min value", &MessageQuery_I{ValueMin_: MessageQuery_ValueMin_Make_Some(30)}, []int{2, 3, 4}},
+		{"max value", &MessageQuery_I{ValueMax_: MessageQuery_ValueMax_Make_Some(20)}, []int{0, 1}},
+		{"value range", &MessageQuery_I{ValueMin_: MessageQuery_ValueMin_Make_Some(20), ValueMax_: MessageQuery_ValueMax_Make_Some(40)}, []int{1, 2, 3}},
+		{"min gas price", &MessageQuery_I{GasPriceMin_: MessageQuery_GasPriceMin_Make_Some(4)}, []int{3, 4}},
+		{"max gas price", &MessageQuery_I{GasPriceMax_: MessageQuery_GasPriceMax_Make_Some(1)}, []int{0}},
+		{"min gas limit", &MessageQuery_I{GasLimitMin_: MessageQuery_GasLimitMin_Make_Some(msg.GasAmount_FromInt(300))}, []int{2, 3, 4}},
+		{"max gas limit", &MessageQuery_I{GasLimitMax_: MessageQuery_GasLimitMax_Make_Some(msg.GasAmount_FromInt(200))}, []int{0, 1}},
+
+		{"sender and method", &MessageQuery_I{From_: MessageQuery_From_Make_Some(f.robustSender), Method_: MessageQuery_Method_Make_Some(2)}, []int{1, 2}},
+		{"receiver and sender", &MessageQuery_I{To_: MessageQuery_To_Make_Some(f.robustReceiver), From_: MessageQuery_From_Make_Some(f.otherSender)}, []int{4}},
+		{"sender, method and value", &MessageQuery_I{
+			From_:     MessageQuery_From_Make_Some(f.sender),
+			Method_:   MessageQuery_Method_Make_Some(2),
+			ValueMin_: MessageQuery_ValueMin_Make_Some(30),
+		}, []int{2}},
+		{"disjoint constraints", &MessageQuery_I{From_: MessageQuery_From_Make_Some(f.sender), Method_: MessageQuery_Method_Make_Some(3)}, nil},
+	}
+	for _, c := range cases {
+		f.assertFound(t, c.name, f.store.Find(c.q), c.want...)
+	}
+}
+
+func TestFindUsesSmallestIndex(t *testing.T) {
+	f := _queryFixture_Make(t)
+	keys := func(indices ...int) []_messageKey {
+		ret := make([]_messageKey, 0, len(indices))
+		for _, i := range indices {
+			ret = append(ret, f.key(i))
+		}
+		return ret
+	}
+
+	cases := []struct {
+		name string
+		q    *MessageQuery_I
+		want []_messageKey
+	}{
+		{"no indexed constraint", &MessageQuery_I{ValueMin_: MessageQuery_ValueMin_Make_Some(30)}, keys(0, 1, 2, 3, 4)},
+		{"sender smallest", &MessageQuery_I{
+			From_: MessageQuery_From_Make_Some(f.otherSender),
+			To_:   MessageQuery_To_Make_Some(f.receiver),
+		}, keys(3, 4)},
+		{"receiver smallest", &MessageQuery_I{
+			From_: MessageQuery_From_Make_Some(f.sender),
+			To_:   MessageQuery_To_Make_Some(f.unresolved),
+		}, keys(3)},
+		{"method smallest", &MessageQuery_I{
+			From_:   MessageQuery_From_Make_Some(f.sender),
+			To_:     MessageQuery_To_Make_Some(f.robustReceiver),
+			Method_: MessageQuery_Method_Make_Some(3),
+		}, keys(4)},
+		{"empty index", &MessageQuery_I{
+			From_:   MessageQuery_From_Make_Some(f.sender),
+			Method_: MessageQuery_Method_Make_Some(4),
+		}, keys()},
+	}
+	for _, c := range cases {
+		got := f.store._candidates(c.q)
+		sort.Slice(got, func(i, j int) bool {
+			if got[i].from != got[j].from {
+				return got[i].from.String() < got[j].from.String()
+			}
+			return got[i].callSeqNum < got[j].callSeqNum
+		})
+		if len(got) != len(c.want) || (len(got) > 0 && !reflect.DeepEqual(got, c.want)) {
+			t.Errorf("%s: candidates %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestIndexesFollowRemoval(t *testing.T) {
+	f := _queryFixture_Make(t)
+	byReceiver := &MessageQuery_I{To_: MessageQuery_To_Make_Some(f.receiver)}
+	byMethod := &MessageQuery_I{Method_: MessageQuery_Method_Make_Some(3)}
+
+	// Removing the only message to the unresolved receiver, and the only one with method 3, drops
+	// their index entries.
+	if !f.store.Remove(f.otherSender, 0) || !f.store.Remove(f.otherSender, 1) {
+		t.Fatal("messages not removed")
+	}
+	_assertIndexes(t, f.store)
+	if _, found := f.store._byReceiver[f.unresolved]; found {
+		t.Error("receiver index kept for a receiver with no pending messages")
+	}
+	if _, found := f.store._byMethod[3]; found {
+		t.Error("method index kept for a method with no pending messages")
+	}
+	f.assertFound(t, "receiver after removal", f.store.Find(byReceiver), 0, 1)
+	f.assertFound(t, "method after removal", f.store.Find(byMethod))
+
+	// Removing by the robust sender address.
+	if !f.store.Remove(f.robustSender, 0) {
+		t.Fatal("message not removed")
+	}
+	_assertIndexes(t, f.store)
+	f.assertFound(t, "receiver after robust removal", f.store.Find(byReceiver), 1)
+}
+
+func TestIndexesFollowReplaceByFee(t *testing.T) {
+	f := _queryFixture_Make(t)
+
+	// Replace message 1 (to the receiver, method 2) by one to the unresolved receiver with method 3.
+	replacement := _queryMessage(f.sender, 1, f.unresolved, 3, nil, 20, 10, 200)
+	if err := f.store.Add(replacement); err != nil {
+		t.Fatal(err)
+	}
+	_assertIndexes(t, f.store)
+	f.messages = append(f.messages, replacement)
+
+	f.assertFound(t, "old receiver", f.store.Find(&MessageQuery_I{To_: MessageQuery_To_Make_Some(f.receiver)}), 0, 4)
+	f.assertFound(t, "new receiver", f.store.Find(&MessageQuery_I{To_: MessageQuery_To_Make_Some(f.unresolved)}), 5, 3)
+	f.assertFound(t, "old method", f.store.Find(&MessageQuery_I{Method_: MessageQuery_Method_Make_Some(2)}), 2)
+	f.assertFound(t, "new method", f.store.Find(&MessageQuery_I{Method_: MessageQuery_Method_Make_Some(3)}), 5, 4)
+
+	// An underpriced replacement leaves the indexes unchanged.
+	if err := f.store.Add(_queryMessage(f.sender, 1, f.receiver, 2, nil, 20, 11, 200)); err != ErrReplacementUnderpriced {
+		t.Fatalf("underpriced replacement: error %v, want %v", err, ErrReplacementUnderpriced)
+	}
+	_assertIndexes(t, f.store)
+	f.assertFound(t, "method after underpriced replacement", f.store.Find(&MessageQuery_I{Method_: MessageQuery_Method_Make_Some(3)}), 5, 4)
+}
+
+func TestIndexesFollowReorg(t *testing.T) {
+	f := _queryFixture_Make(t)
+	byReceiver := &MessageQuery_I{To_: MessageQuery_To_Make_Some(f.robustReceiver)}
+	oldHead := f.headState(0)
+
+	// A block including messages 0 and 3 is applied.
+	included := &block.Block_I{SECPMessages_: []msg.SignedMessage{f.messages[0], f.messages[3]}}
+	f.store.HeadChange(nil, []block.Block{included}, f.headState(1))
+	_assertIndexes(t, f.store)
+	if f.store.Size() != 3 {
+		t.Errorf("%d messages pending after applying a block, want 3", f.store.Size())
+	}
+	f.assertFound(t, "receiver after apply", f.store.Find(byReceiver), 1, 4)
+	f.assertFound(t, "unresolved receiver after apply", f.store.Find(&MessageQuery_I{To_: MessageQuery_To_Make_Some(f.unresolved)}))
+	f.assertFound(t, "send method after apply", f.store.Find(&MessageQuery_I{Method_: MessageQuery_Method_Make_Some(builtin.MethodSend)}))
+
+	// Reverting it re-adds and re-indexes its messages.
+	f.store.HeadChange([]block.Block{included}, nil, oldHead)
+	_assertIndexes(t, f.store)
+	f.assertFound(t, "receiver after revert", f.store.Find(byReceiver), 0, 1, 4)
+	f.assertFound(t, "send method after revert", f.store.Find(&MessageQuery_I{Method_: MessageQuery_Method_Make_Some(builtin.MethodSend)}), 0, 3)
+
+	// A head state in which the senders have used every pending CallSeqNum prunes all messages.
+	f.store.HeadChange(nil, nil, f.headState(10))
+	_assertIndexes(t, f.store)
+	if len(f.store._byReceiver) != 0 || len(f.store._byMethod) != 0 || len(f.store._receivers) != 0 {
+		t.Errorf("indexes not empty after pruning: %v, %v, %v", f.store._byReceiver, f.store._byMethod, f.store._receivers)
+	}
+}
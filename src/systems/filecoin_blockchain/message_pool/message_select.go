@@ -0,0 +1,277 @@
+package message_pool
+
+import (
+	"container/heap"
+	"math/big"
+
+	addr "github.com/filecoin-project/go-address"
+	abi "github.com/filecoin-project/specs-actors/actors/abi"
+	filcrypto "github.com/filecoin-project/specs/algorithms/crypto"
+	block "github.com/filecoin-project/specs/systems/filecoin_blockchain/struct/block"
+	actstate "github.com/filecoin-project/specs/systems/filecoin_vm/actor"
+	msg "github.com/filecoin-project/specs/systems/filecoin_vm/message"
+)
+
+// The pending messages from a sender, in CallSeqNum order, with the sender's state at the chain head.
+type SenderMessages struct {
+	Sender     addr.Address
+	CallSeqNum actstate.CallSeqNum
+	Balance    abi.TokenAmount
+	Messages   []msg.SignedMessage
+}
+
+type MessageSelectionParams struct {
+	// Maximum sum of the gas limits of the selected messages.
+	GasLimit msg.GasAmount
+	// Maximum sum of the on-chain sizes of the selected messages.
+	MaxSize int
+}
+
+// Messages selected for a block, partitioned by signature type. Each sender's messages appear in
+// CallSeqNum order in one of the partitions.
+type MessageSelection struct {
+	BLSMessages  []msg.SignedMessage
+	SECPMessages []msg.SignedMessage
+	// Sums of the gas limits and on-chain sizes of the selected messages.
+	GasLimit msg.GasAmount
+	Size     int
+}
+
+// Returns the messages, in block order, which are most profitable for miner to include in a block
+// on the chain head.
+func (mp *MessagePoolSubsystem_I) GetMostProfitableMessages(miner addr.Address) []msg.SignedMessage {
+	params := MessageSelectionParams{
+		GasLimit: msg.GasAmount_FromInt(block.BlockGasLimit),
+		MaxSize:  block.BlockMaxSize,
+	}
+	selection := SelectMessages(_senderMessages(mp.Store()), params)
+	return append(selection.BLSMessages, selection.SECPMessages...)
+}
+
+func _senderMessages(store MessageStore) []SenderMessages {
+	headState := store.HeadState()
+	var ret []SenderMessages
+	for _, sender := range store.Senders() {
+		fromActor, ok := headState.GetActor(sender)
+		if !ok {
+			continue
+		}
+		ret = append(ret, SenderMessages{
+			Sender:     sender,
+			CallSeqNum: fromActor.CallSeqNum(),
+			Balance:    fromActor.Balance(),
+			Messages:   store.PendingFrom(sender),
+		})
+	}
+	return ret
+}
+
+// SelectMessages selects the messages which pay the most gas fees within the gas limit and
+// size of a block.
+//
+// A sender's messages can only be included in CallSeqNum order, starting from the sender's
+// CallSeqNum, so each sender's messages form a chain in which each message depends on the previous ones.
+// A chain ends at a gap in CallSeqNums, or at the first message which, together with those before it,
+// costs more than the sender's balance.
+//
+// Each chain is divided into chunks whose effective gas premium (the total fee at their messages' gas prices,
+// per unit of gas limit) is non-increasing along the chain; a chunk of a message with a low gas price followed
+// by messages with higher gas prices has their combined premium.
+// Chunks are then included greedily, in order of effective gas premium, from the start of each chain.
+// A chunk which does not fit in the remaining space is trimmed to the longest prefix which does, and the
+// rest of its chain discarded.
+//
+// The selection depends only on the pending messages and the senders' balances and CallSeqNums,
+// so may be run on synthetic pools (e.g., for benchmarks).
+func SelectMessages(senders []SenderMessages, params MessageSelectionParams) MessageSelection {
+	ret := MessageSelection{
+		GasLimit: msg.GasAmount_Zero(),
+	}
+
+	chains := make(_chunkHeap, 0, len(senders))
+	for _, s := range senders {
+		chunks := _chunkChain(_messageChain(s, params))
+		if len(chunks) > 0 {
+			chains = append(chains, &_chainCursor{sender: s.Sender, chunks: chunks})
+		}
+	}
+	heap.Init(&chains)
+
+	for chains.Len() > 0 {
+		cursor := chains[0]
+		chunk := cursor.chunks[0]
+
+		if !_chunkFits(chunk, ret, params) {
+			// Later chunks depend on this one, so the chain ends at the longest prefix which fits.
+			prefix := _fittingPrefix(chunk, ret, params)
+			if len(prefix) == 0 {
+				heap.Pop(&chains)
+				continue
+			}
+			// The prefix may have a lower premium than chunk, so is reconsidered against other chains.
+			cursor.chunks = _chunkChain(prefix)
+			heap.Fix(&chains, 0)
+			continue
+		}
+
+		for _, link := range chunk.links {
+			if link.message.Signature().Type() == filcrypto.SigType_BLSSigType {
+				ret.BLSMessages = append(ret.BLSMessages, link.message)
+			} else {
+				ret.SECPMessages = append(ret.SECPMessages, link.message)
+			}
+		}
+		ret.GasLimit = ret.GasLimit.Add(chunk.gasLimit)
+		ret.Size += chunk.size
+
+		cursor.chunks = cursor.chunks[1:]
+		if len(cursor.chunks) == 0 {
+			heap.Pop(&chains)
+		} else {
+			heap.Fix(&chains, 0)
+		}
+	}
+
+	return ret
+}
+
+// Gas fees are big integers, as their sums over chunks may exceed the range of a TokenAmount.
+type _chainLink struct {
+	message  msg.SignedMessage
+	gasLimit msg.GasAmount
+	gasFee   *big.Int
+	// On-chain size: BLS messages are included without their signatures.
+	size int
+}
+
+type _chunk struct {
+	links    []_chainLink
+	gasLimit msg.GasAmount
+	gasFee   *big.Int
+	size     int
+}
+
+// Returns whether the effective gas premium of a is higher than that of b.
+// Compares a.gasFee / a.gasLimit with b.gasFee / b.gasLimit by cross-multiplication, without division.
+// The products of gas limits and fees may exceed 64 bits, so are computed as big integers.
+func (a *_chunk) _premiumExceeds(b *_chunk) bool {
+	aWeighted := new(big.Int).Mul(a.gasFee, msg.GasAmount_AsBigInt(b.gasLimit))
+	bWeighted := new(big.Int).Mul(b.gasFee, msg.GasAmount_AsBigInt(a.gasLimit))
+	return aWeighted.Cmp(bWeighted) > 0
+}
+
+// Returns the messages from a sender which may be included in order, from the sender's CallSeqNum.
+// The total cost of the messages is summed as a big integer, as it may exceed the range of a TokenAmount.
+func _messageChain(s SenderMessages, params MessageSelectionParams) []_chainLink {
+	var ret []_chainLink
+	next := s.CallSeqNum
+	balance := big.NewInt(int64(s.Balance))
+	cost := new(big.Int)
+	for _, m := range s.Messages {
+		message := m.Message()
+		if message.CallSeqNum() < next {
+			continue
+		}
+		if message.CallSeqNum() > next || params.GasLimit.LessThan(message.GasLimit()) {
+			break
+		}
+		gasFee := _gasFeeBig(message.GasLimit(), message.GasPrice())
+		cost.Add(cost, gasFee)
+		cost.Add(cost, big.NewInt(int64(message.Value())))
+		if cost.Cmp(balance) > 0 {
+			break
+		}
+		ret = append(ret, _chainLink{
+			message:  m,
+			gasLimit: message.GasLimit(),
+			gasFee:   gasFee,
+			size:     _onChainSize(m),
+		})
+		next++
+	}
+	return ret
+}
+
+// Divides a chain into chunks with non-increasing effective gas premiums, merging each chunk
+// with those before it while its premium exceeds theirs.
+func _chunkChain(links []_chainLink) []*_chunk {
+	var ret []*_chunk
+	for _, link := range links {
+		c := &_chunk{
+			links:    []_chainLink{link},
+			gasLimit: link.gasLimit,
+			gasFee:   link.gasFee,
+			size:     link.size,
+		}
+		for len(ret) > 0 && c._premiumExceeds(ret[len(ret)-1]) {
+			prev := ret[len(ret)-1]
+			ret = ret[:len(ret)-1]
+			c = &_chunk{
+				links:    append(append([]_chainLink{}, prev.links...), c.links...),
+				gasLimit: prev.gasLimit.Add(c.gasLimit),
+				gasFee:   new(big.Int).Add(prev.gasFee, c.gasFee),
+				size:     prev.size + c.size,
+			}
+		}
+		ret = append(ret, c)
+	}
+	return ret
+}
+
+func _chunkFits(c *_chunk, selection MessageSelection, params MessageSelectionParams) bool {
+	return !params.GasLimit.LessThan(selection.GasLimit.Add(c.gasLimit)) && selection.Size+c.size <= params.MaxSize
+}
+
+func _fittingPrefix(c *_chunk, selection MessageSelection, params MessageSelectionParams) []_chainLink {
+	gasLimit := selection.GasLimit
+	size := selection.Size
+	for i, link := range c.links {
+		gasLimit = gasLimit.Add(link.gasLimit)
+		size += link.size
+		if params.GasLimit.LessThan(gasLimit) || size > params.MaxSize {
+			return c.links[:i]
+		}
+	}
+	return c.links
+}
+
+func _onChainSize(m msg.SignedMessage) int {
+	if m.Signature().Type() == filcrypto.SigType_BLSSigType {
+		return len(msg.Serialize_UnsignedMessage(m.Message()))
+	}
+	return len(msg.Serialize_SignedMessage(m))
+}
+
+// The remaining chunks of a sender's chain.
+type _chainCursor struct {
+	sender addr.Address
+	chunks []*_chunk
+}
+
+// A max-heap of chains, by the effective gas premium of their first remaining chunk.
+// Ties are broken by sender address, so that selection is deterministic.
+type _chunkHeap []*_chainCursor
+
+func (h _chunkHeap) Len() int { return len(h) }
+
+func (h _chunkHeap) Less(i, j int) bool {
+	a, b := h[i].chunks[0], h[j].chunks[0]
+	if a._premiumExceeds(b) {
+		return true
+	}
+	if b._premiumExceeds(a) {
+		return false
+	}
+	return h[i].sender.String() < h[j].sender.String()
+}
+
+func (h _chunkHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *_chunkHeap) Push(x interface{}) { *h = append(*h, x.(*_chainCursor)) }
+
+func (h *_chunkHeap) Pop() interface{} {
+	old := *h
+	ret := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return ret
+}
@@ -0,0 +1,99 @@
+package message_pool
+
+import (
+	"math/big"
+	"math/rand"
+	"testing"
+
+	addr "github.com/filecoin-project/go-address"
+	abi "github.com/filecoin-project/specs-actors/actors/abi"
+	filcrypto "github.com/filecoin-project/specs/algorithms/crypto"
+	block "github.com/filecoin-project/specs/systems/filecoin_blockchain/struct/block"
+	actstate "github.com/filecoin-project/specs/systems/filecoin_vm/actor"
+	msg "github.com/filecoin-project/specs/systems/filecoin_vm/message"
+)
+
+func TestPremiumExceedsLargeValues(t *testing.T) {
+	// Products of these fees and gas limits exceed 64 bits.
+	gasLimit := msg.GasAmount_FromInt(1e10)
+	high := &_chunk{gasLimit: gasLimit, gasFee: big.NewInt(4e18)}
+	low := &_chunk{gasLimit: gasLimit, gasFee: big.NewInt(3e18)}
+
+	if !high._premiumExceeds(low) {
+		t.Error("higher premium does not exceed lower premium")
+	}
+	if low._premiumExceeds(high) {
+		t.Error("lower premium exceeds higher premium")
+	}
+	if high._premiumExceeds(high) {
+		t.Error("premium exceeds itself")
+	}
+}
+
+func TestChunkChainSumsLargeFees(t *testing.T) {
+	// A message with a low premium, followed by two whose fees sum beyond 64 bits.
+	links := []_chainLink{
+		{gasLimit: msg.GasAmount_FromInt(1e10), gasFee: big.NewInt(1)},
+		{gasLimit: msg.GasAmount_FromInt(1), gasFee: big.NewInt(5e18)},
+		{gasLimit: msg.GasAmount_FromInt(1), gasFee: big.NewInt(5e18)},
+	}
+
+	chunks := _chunkChain(links)
+	if len(chunks) != 1 {
+		t.Fatalf("chain divided into %d chunks, want 1", len(chunks))
+	}
+	if want, _ := new(big.Int).SetString("10000000000000000001", 10); chunks[0].gasFee.Cmp(want) != 0 {
+		t.Errorf("chunk fee is %v, want %v", chunks[0].gasFee, want)
+	}
+}
+
+// Returns a synthetic pool of messages from senders, each with perSender consecutive messages
+// at pseudo-random gas prices, half of them BLS-signed.
+func _syntheticPool(senders int, perSender int) []SenderMessages {
+	r := rand.New(rand.NewSource(1))
+	ret := make([]SenderMessages, 0, senders)
+	for i := 0; i < senders; i++ {
+		from, err := addr.NewIDAddress(uint64(1000 + i))
+		if err != nil {
+			panic(err)
+		}
+		sigType := filcrypto.SigType_ECDSASigType
+		if i%2 == 0 {
+			sigType = filcrypto.SigType_BLSSigType
+		}
+
+		s := SenderMessages{
+			Sender:     from,
+			CallSeqNum: 0,
+			Balance:    abi.TokenAmount(1e18),
+		}
+		for n := 0; n < perSender; n++ {
+			s.Messages = append(s.Messages, msg.SignedMessage_Make(
+				&msg.UnsignedMessage_I{
+					To_:         from,
+					From_:       from,
+					CallSeqNum_: actstate.CallSeqNum(n),
+					Value_:      abi.TokenAmount(0),
+					GasPrice_:   abi.TokenAmount(1 + r.Intn(1000)),
+					GasLimit_:   msg.GasAmount_FromInt(1000 + r.Intn(100000)),
+				},
+				&filcrypto.Signature_I{Type_: sigType},
+			))
+		}
+		ret = append(ret, s)
+	}
+	return ret
+}
+
+func BenchmarkSelectMessages(b *testing.B) {
+	senders := _syntheticPool(10000, 10) // 100k messages
+	params := MessageSelectionParams{
+		GasLimit: msg.GasAmount_FromInt(1e10),
+		MaxSize:  block.BlockMaxSize,
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		SelectMessages(senders, params)
+	}
+}
@@ -0,0 +1,522 @@
+package message_pool
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"sort"
+	"sync"
+
+	addr "github.com/filecoin-project/go-address"
+	abi "github.com/filecoin-project/specs-actors/actors/abi"
+	block "github.com/filecoin-project/specs/systems/filecoin_blockchain/struct/block"
+	actstate "github.com/filecoin-project/specs/systems/filecoin_vm/actor"
+	msg "github.com/filecoin-project/specs/systems/filecoin_vm/message"
+	st "github.com/filecoin-project/specs/systems/filecoin_vm/state_tree"
+	util "github.com/filecoin-project/specs/util"
+)
+
+var Assert = util.Assert
+var IMPL_FINISH = util.IMPL_FINISH
+
+var (
+	ErrMessageInvalid         = errors.New("Message is syntactically invalid")
+	ErrSenderNotFound         = errors.New("Message sender not found in head state")
+	ErrCallSeqNumTooLow       = errors.New("Message CallSeqNum already used by sender")
+	ErrInsufficientBalance    = errors.New("Sender balance does not cover message value and gas limit")
+	ErrReplacementUnderpriced = errors.New("Replacement message gas price below minimum premium")
+	ErrSenderPoolFull         = errors.New("Sender has too many pending messages")
+	ErrPoolFull               = errors.New("Message pool full and message gas price too low to evict another")
+)
+
+type MessagePoolParams struct {
+	// Maximum number of pending messages from a single sender.
+	MaxPendingPerSender int
+	// Maximum number of pending messages.
+	MaxPending int
+	// Minimum increase in gas price, in percent, for a message to replace a pending message
+	// with the same sender and CallSeqNum.
+	ReplaceByFeePremiumPercent int
+	// Number of BLS messages included in applied blocks, whose signatures are retained
+	// so that the messages can be re-added if those blocks are reverted.
+	BLSSignatureCacheSize int
+}
+
+var DefaultMessagePoolParams = MessagePoolParams{
+	MaxPendingPerSender:        1000,
+	MaxPending:                 50000,
+	ReplaceByFeePremiumPercent: 25,
+	BLSSignatureCacheSize:      10000,
+}
+
+// MessageStore holds pending messages, by sender and CallSeqNum, for a chain head.
+type MessageStore interface {
+	// Adds a message, after checking it against the head state.
+	Add(m msg.SignedMessage) error
+
+	// Returns the pending message from a sender with a CallSeqNum, if any.
+	Get(from addr.Address, callSeqNum actstate.CallSeqNum) (msg.SignedMessage, bool)
+
+	// Removes the pending message from a sender with a CallSeqNum, returning whether there was one.
+	Remove(from addr.Address, callSeqNum actstate.CallSeqNum) bool
+
+	// Returns the pending messages from a sender, in CallSeqNum order.
+	PendingFrom(from addr.Address) []msg.SignedMessage
+
+	// Returns the CallSeqNum for a sender's next message, or false if the sender does not exist.
+	NextCallSeqNum(from addr.Address) (actstate.CallSeqNum, bool)
+
+	// Returns the senders with pending messages.
+	Senders() []addr.Address
+
+	// Returns the number of pending messages.
+	Size() int
+
+	// Returns the pending messages matching all of the constraints specified in q.
+	Find(q MessageQuery) []msg.SignedMessage
+
+	// Updates the pending messages for a change of chain head, given the blocks of the tipsets
+	// removed from the chain and of those added to it, and the state of the new head.
+	HeadChange(reverted []block.Block, applied []block.Block, headState st.StateTree)
+
+	// Returns the state against which messages are checked.
+	HeadState() st.StateTree
+}
+
+// AddressResolver maps actor addresses to ID addresses through the InitActor state of a state tree.
+// It is the subset of the VM runtime's AddressResolver which the message pool uses (and which the
+// runtime's CachingAddressResolver implements), declared here so that the message pool does not
+// depend on the runtime implementation.
+type AddressResolver interface {
+	// Returns the ID address to which a is mapped, or a itself (and false) if it is not mapped.
+	// ID addresses resolve to themselves.
+	ResolveAddress(tree st.StateTree, a addr.Address) (addr.Address, bool)
+
+	// Returns a robust (public key or actor) address which resolves to the ID address id, if any.
+	RobustAddress(tree st.StateTree, id addr.Address) (addr.Address, bool)
+}
+
+// PendingMessageStore is an in-memory MessageStore.
+//
+// Senders are identified by the ID address to which the message's From address resolves in the
+// head state, so that messages sent from an actor's ID address and from its robust address are
+// ordered together.
+type PendingMessageStore struct {
+	_params   MessagePoolParams
+	_resolver AddressResolver
+
+	_lock      sync.Mutex
+	_headState st.StateTree
+	// Pending messages, keyed by sender and CallSeqNum.
+	_pending map[addr.Address]map[actstate.CallSeqNum]msg.SignedMessage
+	_size    int
+	// Secondary indexes of pending messages, by receiver and method.
+	// Receivers are indexed by the address to which they resolved in the head state when the
+	// message was added, which is recorded in _receivers.
+	_byReceiver map[addr.Address]map[_messageKey]struct{}
+	_byMethod   map[abi.MethodNum]map[_messageKey]struct{}
+	_receivers  map[_messageKey]addr.Address
+	// Signed forms of BLS messages included in applied blocks, whose signatures are not
+	// retained on chain, in order of inclusion.
+	_blsSignatures     map[_messageKey]msg.SignedMessage
+	_blsSignatureOrder []_messageKey
+}
+
+type _messageKey struct {
+	from       addr.Address
+	callSeqNum actstate.CallSeqNum
+}
+
+var _ MessageStore = &PendingMessageStore{}
+
+func PendingMessageStore_Make(params MessagePoolParams, resolver AddressResolver, headState st.StateTree) *PendingMessageStore {
+	Assert(params.MaxPendingPerSender > 0 && params.MaxPending > 0)
+	Assert(params.ReplaceByFeePremiumPercent >= 0)
+	return &PendingMessageStore{
+		_params:        params,
+		_resolver:      resolver,
+		_headState:     headState,
+		_pending:       make(map[addr.Address]map[actstate.CallSeqNum]msg.SignedMessage),
+		_byReceiver:    make(map[addr.Address]map[_messageKey]struct{}),
+		_byMethod:      make(map[abi.MethodNum]map[_messageKey]struct{}),
+		_receivers:     make(map[_messageKey]addr.Address),
+		_blsSignatures: make(map[_messageKey]msg.SignedMessage),
+	}
+}
+
+func (s *PendingMessageStore) HeadState() st.StateTree {
+	s._lock.Lock()
+	defer s._lock.Unlock()
+	return s._headState
+}
+
+func (s *PendingMessageStore) Size() int {
+	s._lock.Lock()
+	defer s._lock.Unlock()
+	return s._size
+}
+
+// Adds a message if it is valid against the head state:
+// its sender must exist, must not have used its CallSeqNum, and must have a balance covering
+// its value and gas limit.
+//
+// A message with the same sender and CallSeqNum as a pending message replaces it, if its gas price
+// exceeds that of the pending message by at least the replace-by-fee premium.
+// If the pool is full, the message evicts the last pending message of the sender whose last message
+// has the lowest gas price, if that is lower than the message's own.
+func (s *PendingMessageStore) Add(m msg.SignedMessage) error {
+	s._lock.Lock()
+	defer s._lock.Unlock()
+	return s._add(m)
+}
+
+func (s *PendingMessageStore) Get(from addr.Address, callSeqNum actstate.CallSeqNum) (msg.SignedMessage, bool) {
+	s._lock.Lock()
+	defer s._lock.Unlock()
+	sender, ok := s._resolver.ResolveAddress(s._headState, from)
+	if !ok {
+		return nil, false
+	}
+	ret, found := s._pending[sender][callSeqNum]
+	return ret, found
+}
+
+func (s *PendingMessageStore) Remove(from addr.Address, callSeqNum actstate.CallSeqNum) bool {
+	s._lock.Lock()
+	defer s._lock.Unlock()
+	sender, ok := s._resolver.ResolveAddress(s._headState, from)
+	if !ok {
+		return false
+	}
+	_, found := s._remove(sender, callSeqNum)
+	return found
+}
+
+func (s *PendingMessageStore) PendingFrom(from addr.Address) []msg.SignedMessage {
+	s._lock.Lock()
+	defer s._lock.Unlock()
+	sender, ok := s._resolver.ResolveAddress(s._headState, from)
+	if !ok {
+		return nil
+	}
+	return s._pendingFrom(sender)
+}
+
+// The next CallSeqNum is the first, from that of the sender's actor in the head state, which no pending
+// message uses, so that a message with it may be included once those before it are.
+func (s *PendingMessageStore) NextCallSeqNum(from addr.Address) (actstate.CallSeqNum, bool) {
+	s._lock.Lock()
+	defer s._lock.Unlock()
+	sender, ok := s._resolver.ResolveAddress(s._headState, from)
+	if !ok {
+		return 0, false
+	}
+	fromActor, ok := s._headState.GetActor(sender)
+	if !ok {
+		return 0, false
+	}
+	ret := fromActor.CallSeqNum()
+	for {
+		if _, found := s._pending[sender][ret]; !found {
+			return ret, true
+		}
+		ret++
+	}
+}
+
+func (s *PendingMessageStore) Senders() []addr.Address {
+	s._lock.Lock()
+	defer s._lock.Unlock()
+	ret := make([]addr.Address, 0, len(s._pending))
+	for sender := range s._pending {
+		ret = append(ret, sender)
+	}
+	return ret
+}
+
+// Messages included in applied blocks are removed. Messages included in reverted blocks, but not
+// in applied ones, are re-added if they are valid against the new head state.
+// Pending messages made invalid by the new head state, because their sender no longer exists or
+// has since used their CallSeqNum, are removed.
+func (s *PendingMessageStore) HeadChange(reverted []block.Block, applied []block.Block, headState st.StateTree) {
+	s._lock.Lock()
+	defer s._lock.Unlock()
+
+	// Resolve the reverted messages' senders in the old head state, which includes them.
+	revertedMsgs := make(map[_messageKey]msg.SignedMessage)
+	var revertedOrder []_messageKey
+	for _, blk := range reverted {
+		for _, m := range s._signedMessages(blk) {
+			key, ok := s._key(m.Message())
+			if !ok {
+				continue
+			}
+			if _, found := revertedMsgs[key]; !found {
+				revertedOrder = append(revertedOrder, key)
+			}
+			revertedMsgs[key] = m
+		}
+	}
+
+	s._headState = headState
+
+	for _, blk := range applied {
+		for _, m := range blk.BLSMessages() {
+			key, ok := s._key(m)
+			if !ok {
+				continue
+			}
+			delete(revertedMsgs, key)
+			if pending, found := s._remove(key.from, key.callSeqNum); found && _messagesEqual(pending.Message(), m) {
+				s._retainBLSSignature(key, pending)
+			}
+		}
+		for _, sm := range blk.SECPMessages() {
+			key, ok := s._key(sm.Message())
+			if !ok {
+				continue
+			}
+			delete(revertedMsgs, key)
+			s._remove(key.from, key.callSeqNum)
+		}
+	}
+
+	s._prune()
+
+	for _, key := range revertedOrder {
+		if m, found := revertedMsgs[key]; found {
+			// Messages which are no longer valid are dropped.
+			_ = s._add(m)
+		}
+	}
+}
+
+func (s *PendingMessageStore) _add(m msg.SignedMessage) error {
+	message := m.Message()
+	if message.Value() < 0 || message.GasPrice() < 0 || !msg.GasAmount_Zero().LessThan(message.GasLimit()) {
+		return ErrMessageInvalid
+	}
+
+	sender, ok := s._resolver.ResolveAddress(s._headState, message.From())
+	if !ok {
+		return ErrSenderNotFound
+	}
+	fromActor, ok := s._headState.GetActor(sender)
+	if !ok {
+		return ErrSenderNotFound
+	}
+	if message.CallSeqNum() < fromActor.CallSeqNum() {
+		return ErrCallSeqNumTooLow
+	}
+	if !_balanceCovers(fromActor.Balance(), message) {
+		return ErrInsufficientBalance
+	}
+
+	senderMsgs := s._pending[sender]
+	if existing, found := senderMsgs[message.CallSeqNum()]; found {
+		if message.GasPrice() < _minReplacementGasPrice(existing.Message().GasPrice(), s._params.ReplaceByFeePremiumPercent) {
+			return ErrReplacementUnderpriced
+		}
+		key := _messageKey{from: sender, callSeqNum: message.CallSeqNum()}
+		s._unindex(key, existing)
+		senderMsgs[message.CallSeqNum()] = m
+		s._index(key, m)
+		return nil
+	}
+
+	if len(senderMsgs) >= s._params.MaxPendingPerSender {
+		return ErrSenderPoolFull
+	}
+	if s._size >= s._params.MaxPending && !s._evictCheaperThan(message.GasPrice(), sender) {
+		return ErrPoolFull
+	}
+
+	if senderMsgs == nil {
+		senderMsgs = make(map[actstate.CallSeqNum]msg.SignedMessage)
+		s._pending[sender] = senderMsgs
+	}
+	senderMsgs[message.CallSeqNum()] = m
+	s._index(_messageKey{from: sender, callSeqNum: message.CallSeqNum()}, m)
+	s._size++
+	return nil
+}
+
+func (s *PendingMessageStore) _remove(sender addr.Address, callSeqNum actstate.CallSeqNum) (msg.SignedMessage, bool) {
+	senderMsgs := s._pending[sender]
+	ret, found := senderMsgs[callSeqNum]
+	if !found {
+		return nil, false
+	}
+	delete(senderMsgs, callSeqNum)
+	if len(senderMsgs) == 0 {
+		delete(s._pending, sender)
+	}
+	s._unindex(_messageKey{from: sender, callSeqNum: callSeqNum}, ret)
+	s._size--
+	return ret, true
+}
+
+func (s *PendingMessageStore) _index(key _messageKey, m msg.SignedMessage) {
+	receiver, _ := s._resolver.ResolveAddress(s._headState, m.Message().To())
+	s._receivers[key] = receiver
+	if s._byReceiver[receiver] == nil {
+		s._byReceiver[receiver] = make(map[_messageKey]struct{})
+	}
+	s._byReceiver[receiver][key] = struct{}{}
+
+	method := m.Message().Method()
+	if s._byMethod[method] == nil {
+		s._byMethod[method] = make(map[_messageKey]struct{})
+	}
+	s._byMethod[method][key] = struct{}{}
+}
+
+func (s *PendingMessageStore) _unindex(key _messageKey, m msg.SignedMessage) {
+	receiver := s._receivers[key]
+	delete(s._receivers, key)
+	delete(s._byReceiver[receiver], key)
+	if len(s._byReceiver[receiver]) == 0 {
+		delete(s._byReceiver, receiver)
+	}
+
+	method := m.Message().Method()
+	delete(s._byMethod[method], key)
+	if len(s._byMethod[method]) == 0 {
+		delete(s._byMethod, method)
+	}
+}
+
+func (s *PendingMessageStore) _pendingFrom(sender addr.Address) []msg.SignedMessage {
+	senderMsgs := s._pending[sender]
+	seqNums := make([]actstate.CallSeqNum, 0, len(senderMsgs))
+	for n := range senderMsgs {
+		seqNums = append(seqNums, n)
+	}
+	sort.Slice(seqNums, func(i, j int) bool { return seqNums[i] < seqNums[j] })
+
+	ret := make([]msg.SignedMessage, 0, len(seqNums))
+	for _, n := range seqNums {
+		ret = append(ret, senderMsgs[n])
+	}
+	return ret
+}
+
+// Evicts the last pending message (by CallSeqNum) of the sender, other than exclude, whose last
+// message has the lowest gas price, if that is lower than gasPrice.
+// Only a sender's last message is evicted, so that the remaining messages may still be included
+// in order.
+func (s *PendingMessageStore) _evictCheaperThan(gasPrice abi.TokenAmount, exclude addr.Address) bool {
+	var evictKey _messageKey
+	var evictPrice abi.TokenAmount
+	found := false
+	for sender, senderMsgs := range s._pending {
+		if sender == exclude {
+			continue
+		}
+		last := actstate.CallSeqNum(-1)
+		for n := range senderMsgs {
+			if n > last {
+				last = n
+			}
+		}
+		price := senderMsgs[last].Message().GasPrice()
+		if price < gasPrice && (!found || price < evictPrice) {
+			evictKey = _messageKey{from: sender, callSeqNum: last}
+			evictPrice = price
+			found = true
+		}
+	}
+	if !found {
+		return false
+	}
+	s._remove(evictKey.from, evictKey.callSeqNum)
+	return true
+}
+
+// Removes the pending messages whose sender no longer exists in the head state, or whose
+// CallSeqNum the sender has already used.
+func (s *PendingMessageStore) _prune() {
+	for sender, senderMsgs := range s._pending {
+		fromActor, ok := s._headState.GetActor(sender)
+		for n := range senderMsgs {
+			if !ok || n < fromActor.CallSeqNum() {
+				s._remove(sender, n)
+			}
+		}
+	}
+}
+
+// Returns the signed messages of a block, for re-adding to the pool.
+// BLS messages are signed with signatures retained from when they were pending, if any;
+// those without a retained signature are omitted.
+func (s *PendingMessageStore) _signedMessages(blk block.Block) []msg.SignedMessage {
+	var ret []msg.SignedMessage
+	for _, m := range blk.BLSMessages() {
+		key, ok := s._key(m)
+		if !ok {
+			continue
+		}
+		if sm, found := s._blsSignatures[key]; found && _messagesEqual(sm.Message(), m) {
+			ret = append(ret, sm)
+		}
+	}
+	return append(ret, blk.SECPMessages()...)
+}
+
+func (s *PendingMessageStore) _retainBLSSignature(key _messageKey, m msg.SignedMessage) {
+	if _, found := s._blsSignatures[key]; !found {
+		s._blsSignatureOrder = append(s._blsSignatureOrder, key)
+	}
+	s._blsSignatures[key] = m
+	for len(s._blsSignatureOrder) > s._params.BLSSignatureCacheSize {
+		delete(s._blsSignatures, s._blsSignatureOrder[0])
+		s._blsSignatureOrder = s._blsSignatureOrder[1:]
+	}
+}
+
+func (s *PendingMessageStore) _key(m msg.UnsignedMessage) (_messageKey, bool) {
+	sender, ok := s._resolver.ResolveAddress(s._headState, m.From())
+	if !ok {
+		return _messageKey{}, false
+	}
+	return _messageKey{from: sender, callSeqNum: m.CallSeqNum()}, true
+}
+
+// Returns the lowest gas price with which a message may replace a pending message with gas price
+// oldPrice: at least premiumPercent higher, and in any case higher.
+func _minReplacementGasPrice(oldPrice abi.TokenAmount, premiumPercent int) abi.TokenAmount {
+	premium := oldPrice * abi.TokenAmount(premiumPercent) / 100
+	if premium < 1 {
+		premium = 1
+	}
+	return oldPrice + premium
+}
+
+// Returns the maximum cost of a message to its sender: its value, plus its gas limit at its gas price.
+// Returns false if the cost is beyond the range of a TokenAmount, so exceeds any balance.
+func _messageMaxCost(m msg.UnsignedMessage) (abi.TokenAmount, bool) {
+	fee := _gasFeeBig(m.GasLimit(), m.GasPrice())
+	return _tokenAmount(fee.Add(fee, big.NewInt(int64(m.Value()))))
+}
+
+// Returns the fee for gas at a price.
+func _gasFeeBig(gas msg.GasAmount, price abi.TokenAmount) *big.Int {
+	return new(big.Int).Mul(msg.GasAmount_AsBigInt(gas), big.NewInt(int64(price)))
+}
+
+func _tokenAmount(x *big.Int) (abi.TokenAmount, bool) {
+	if !x.IsInt64() {
+		return 0, false
+	}
+	return abi.TokenAmount(x.Int64()), true
+}
+
+// Returns whether a balance covers the maximum cost of a message.
+func _balanceCovers(balance abi.TokenAmount, m msg.UnsignedMessage) bool {
+	cost, ok := _messageMaxCost(m)
+	return ok && balance >= cost
+}
+
+func _messagesEqual(a msg.UnsignedMessage, b msg.UnsignedMessage) bool {
+	return bytes.Equal(msg.Serialize_UnsignedMessage(a), msg.Serialize_UnsignedMessage(b))
+}
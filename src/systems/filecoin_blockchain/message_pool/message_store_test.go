@@ -0,0 +1,39 @@
+package message_pool
+
+import (
+	"testing"
+
+	actstate "github.com/filecoin-project/specs/systems/filecoin_vm/actor"
+)
+
+func TestNextCallSeqNum(t *testing.T) {
+	sender := _gossipSender_Make(t, 1)
+	s := PendingMessageStore_Make(DefaultMessagePoolParams, _senderResolver{sender}, sender.headState())
+
+	next := func() actstate.CallSeqNum {
+		t.Helper()
+		ret, ok := s.NextCallSeqNum(sender.robust)
+		if !ok {
+			t.Fatal("sender not found")
+		}
+		return ret
+	}
+	// That of the sender's actor, with no pending messages.
+	if n := next(); n != 5 {
+		t.Errorf("next CallSeqNum %d, want 5", n)
+	}
+
+	for _, n := range []actstate.CallSeqNum{5, 6, 8} {
+		if err := s.Add(_localMessage(sender.id, n, 1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The first gap, rather than the number of pending messages past the actor's.
+	if n := next(); n != 7 {
+		t.Errorf("next CallSeqNum %d, want 7", n)
+	}
+
+	if _, ok := s.NextCallSeqNum(_testSender(t, 101)); ok {
+		t.Error("next CallSeqNum of an unknown sender")
+	}
+}
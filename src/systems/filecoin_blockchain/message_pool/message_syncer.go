@@ -0,0 +1,177 @@
+package message_pool
+
+import (
+	"fmt"
+	"time"
+
+	addr "github.com/filecoin-project/go-address"
+	repo "github.com/filecoin-project/specs/systems/filecoin_nodes/repository"
+	actstate "github.com/filecoin-project/specs/systems/filecoin_vm/actor"
+	msg "github.com/filecoin-project/specs/systems/filecoin_vm/message"
+	util "github.com/filecoin-project/specs/util"
+)
+
+// The pubsub topic on which messages are announced.
+const MessageTopicName = "/fil/messages"
+
+// The interval at which local messages which are still pending are republished.
+const LocalMessageRepublishInterval = 5 * time.Minute
+
+// MessageTopic is the node's handle on the message pubsub topic.
+type MessageTopic interface {
+	// Announces a serialized SignedMessage to the node's peers.
+	Publish(data util.Bytes) error
+
+	// Sets the validator run on each message received from a peer. Only accepted messages
+	// are relayed to other peers.
+	RegisterValidator(v MessageValidatorFunc)
+}
+
+func MessageSyncer_Make(pool MessageStore, localMessages LocalMessageStore, topic MessageTopic) MessageSyncer {
+	return &MessageSyncer_I{
+		Pool_:          pool,
+		LocalMessages_: localMessages,
+		Topic_:         topic,
+	}
+}
+
+func (ms *MessageSyncer_I) SubmitMessage(m msg.SignedMessage) error {
+	if err := ms.Pool().Add(m); err != nil {
+		return err
+	}
+	if err := ms.LocalMessages().Put(m); err != nil {
+		return err
+	}
+	return ms._publish(m)
+}
+
+func (ms *MessageSyncer_I) LoadLocalMessages() error {
+	local, err := ms.LocalMessages().Load()
+	if err != nil {
+		return err
+	}
+	for _, m := range local {
+		if err := ms.Pool().Add(m); _rejectedPermanently(err) {
+			if err := ms.LocalMessages().Delete(m.Message().From(), m.Message().CallSeqNum()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// A local message is republished if it is pending in the pool. One which is missing from the pool
+// (e.g., because it was evicted, or its inclusion in the chain was reverted) is added again and
+// republished if it is accepted. If it is rejected because it has been included in the chain (or can
+// no longer be), it is dropped; if it is rejected only for lack of space in the pool, it is kept and
+// retried at the next republication.
+func (ms *MessageSyncer_I) RepublishLocalMessages() error {
+	local, err := ms.LocalMessages().Load()
+	if err != nil {
+		return err
+	}
+	for _, m := range local {
+		from, callSeqNum := m.Message().From(), m.Message().CallSeqNum()
+		pending, found := ms.Pool().Get(from, callSeqNum)
+		drop := false
+		if found && !_messagesEqual(pending.Message(), m.Message()) {
+			// Replaced by a message submitted elsewhere with the same key.
+			found, drop = false, true
+		} else if !found {
+			err := ms.Pool().Add(m)
+			found, drop = err == nil, _rejectedPermanently(err)
+		}
+
+		if drop {
+			if err := ms.LocalMessages().Delete(from, callSeqNum); err != nil {
+				return err
+			}
+		}
+		if !found {
+			continue
+		}
+		if err := ms._publish(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns whether a message rejected from the pool with err can never be added to it: it has been
+// included in the chain, or is invalid in the head state. Other rejections (such as ErrPoolFull and
+// ErrSenderPoolFull) depend on the other pending messages, so may not recur.
+func _rejectedPermanently(err error) bool {
+	switch err {
+	case ErrCallSeqNumTooLow, ErrInsufficientBalance, ErrMessageInvalid, ErrSenderNotFound:
+		return true
+	default:
+		return false
+	}
+}
+
+func (ms *MessageSyncer_I) _publish(m msg.SignedMessage) error {
+	return ms.Topic().Publish(util.Bytes(msg.Serialize_SignedMessage(m)))
+}
+
+// Republishes the syncer's local messages every interval, until stopped.
+func StartLocalMessageRepublisher(syncer MessageSyncer, interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				// Failures are retried at the next tick.
+				_ = syncer.RepublishLocalMessages()
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// LocalMessageStore persists the messages submitted by this node in the repository's datastore,
+// keyed by sender address and CallSeqNum.
+type LocalMessageStore struct {
+	_datastore repo.Datastore
+}
+
+const _localMessagePrefix = repo.DatastoreKey("/mpool/local/")
+
+func LocalMessageStore_Make(datastore repo.Datastore) LocalMessageStore {
+	return LocalMessageStore{_datastore: datastore}
+}
+
+// Stores a message, replacing any stored message with the same sender and CallSeqNum.
+func (s LocalMessageStore) Put(m msg.SignedMessage) error {
+	key := _localMessageKey(m.Message().From(), m.Message().CallSeqNum())
+	return s._datastore.Put(key, util.Bytes(msg.Serialize_SignedMessage(m)))
+}
+
+func (s LocalMessageStore) Delete(from addr.Address, callSeqNum actstate.CallSeqNum) error {
+	return s._datastore.Delete(_localMessageKey(from, callSeqNum))
+}
+
+// Returns the stored messages, ordered by sender and CallSeqNum.
+func (s LocalMessageStore) Load() ([]msg.SignedMessage, error) {
+	var ret []msg.SignedMessage
+	for _, key := range s._datastore.KeysWithPrefix(_localMessagePrefix) {
+		serialized, found := s._datastore.Get(key)
+		if !found {
+			continue
+		}
+		m, err := msg.Deserialize_SignedMessage(util.Serialization(serialized))
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, m)
+	}
+	return ret, nil
+}
+
+// CallSeqNums are zero-padded so that keys order messages from a sender by CallSeqNum.
+func _localMessageKey(from addr.Address, callSeqNum actstate.CallSeqNum) repo.DatastoreKey {
+	return _localMessagePrefix + repo.DatastoreKey(fmt.Sprintf("%v/%020d", from, callSeqNum))
+}
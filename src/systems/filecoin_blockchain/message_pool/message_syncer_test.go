@@ -0,0 +1,258 @@
+package message_pool
+
+import (
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	addr "github.com/filecoin-project/go-address"
+	abi "github.com/filecoin-project/specs-actors/actors/abi"
+	filcrypto "github.com/filecoin-project/specs/algorithms/crypto"
+	repo "github.com/filecoin-project/specs/systems/filecoin_nodes/repository"
+	actstate "github.com/filecoin-project/specs/systems/filecoin_vm/actor"
+	msg "github.com/filecoin-project/specs/systems/filecoin_vm/message"
+	util "github.com/filecoin-project/specs/util"
+)
+
+type _memDatastore struct {
+	repo.Datastore_I
+	values map[repo.DatastoreKey]util.Bytes
+}
+
+func _memDatastore_Make() *_memDatastore {
+	return &_memDatastore{values: make(map[repo.DatastoreKey]util.Bytes)}
+}
+
+func (d *_memDatastore) Get(k repo.DatastoreKey) (util.Bytes, bool) {
+	v, found := d.values[k]
+	return v, found
+}
+
+func (d *_memDatastore) Put(k repo.DatastoreKey, v util.Bytes) error {
+	d.values[k] = v
+	return nil
+}
+
+func (d *_memDatastore) Delete(k repo.DatastoreKey) error {
+	delete(d.values, k)
+	return nil
+}
+
+func (d *_memDatastore) KeysWithPrefix(prefix repo.DatastoreKey) []repo.DatastoreKey {
+	var ret []repo.DatastoreKey
+	for k := range d.values {
+		if strings.HasPrefix(string(k), string(prefix)) {
+			ret = append(ret, k)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i] < ret[j] })
+	return ret
+}
+
+// A pool holding the given pending messages, whose Add fails with the error given for the message's
+// CallSeqNum (if any) and otherwise adds it.
+type _testPool struct {
+	MessageStore
+	pending map[_messageKey]msg.SignedMessage
+	addErrs map[actstate.CallSeqNum]error
+}
+
+func _testPool_Make(pending ...msg.SignedMessage) *_testPool {
+	ret := &_testPool{
+		pending: make(map[_messageKey]msg.SignedMessage),
+		addErrs: make(map[actstate.CallSeqNum]error),
+	}
+	for _, m := range pending {
+		ret.pending[_messageKey{from: m.Message().From(), callSeqNum: m.Message().CallSeqNum()}] = m
+	}
+	return ret
+}
+
+func (p *_testPool) Add(m msg.SignedMessage) error {
+	if err := p.addErrs[m.Message().CallSeqNum()]; err != nil {
+		return err
+	}
+	p.pending[_messageKey{from: m.Message().From(), callSeqNum: m.Message().CallSeqNum()}] = m
+	return nil
+}
+
+func (p *_testPool) Get(from addr.Address, callSeqNum actstate.CallSeqNum) (msg.SignedMessage, bool) {
+	m, found := p.pending[_messageKey{from: from, callSeqNum: callSeqNum}]
+	return m, found
+}
+
+// Records the messages published on it.
+type _recordingTopic struct {
+	published []util.Bytes
+}
+
+func (t *_recordingTopic) Publish(data util.Bytes) error {
+	t.published = append(t.published, data)
+	return nil
+}
+
+func (t *_recordingTopic) RegisterValidator(v MessageValidatorFunc) {}
+
+func _testSender(t *testing.T, id uint64) addr.Address {
+	a, err := addr.NewIDAddress(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func _localMessage(from addr.Address, callSeqNum actstate.CallSeqNum, gasPrice abi.TokenAmount) msg.SignedMessage {
+	return msg.SignedMessage_Make(
+		&msg.UnsignedMessage_I{
+			To_:         from,
+			From_:       from,
+			CallSeqNum_: callSeqNum,
+			Value_:      abi.TokenAmount(0),
+			GasPrice_:   gasPrice,
+			GasLimit_:   msg.GasAmount_FromInt(1000),
+		},
+		&filcrypto.Signature_I{Type_: filcrypto.SigType_ECDSASigType},
+	)
+}
+
+// Asserts that the messages stored in s have the given senders and CallSeqNums, in order.
+func _assertLocalMessages(t *testing.T, s LocalMessageStore, expected ...msg.SignedMessage) {
+	t.Helper()
+	local, err := s.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(local) != len(expected) {
+		t.Fatalf("%d local messages, want %d", len(local), len(expected))
+	}
+	for i, m := range local {
+		if !_messagesEqual(m.Message(), expected[i].Message()) {
+			t.Errorf("local message %d is from %v at %d, want from %v at %d", i,
+				m.Message().From(), m.Message().CallSeqNum(), expected[i].Message().From(), expected[i].Message().CallSeqNum())
+		}
+	}
+}
+
+func TestLocalMessageStore(t *testing.T) {
+	s := LocalMessageStore_Make(_memDatastore_Make())
+	a, b := _testSender(t, 100), _testSender(t, 101)
+	a2, a10, b0 := _localMessage(a, 2, 1), _localMessage(a, 10, 1), _localMessage(b, 0, 1)
+
+	for _, m := range []msg.SignedMessage{b0, a10, a2} {
+		if err := s.Put(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Messages are loaded by sender, then CallSeqNum (not lexicographically by CallSeqNum).
+	_assertLocalMessages(t, s, a2, a10, b0)
+
+	// A message with the same key replaces the stored one.
+	a2Replacement := _localMessage(a, 2, 5)
+	if err := s.Put(a2Replacement); err != nil {
+		t.Fatal(err)
+	}
+	_assertLocalMessages(t, s, a2Replacement, a10, b0)
+
+	if err := s.Delete(a, 10); err != nil {
+		t.Fatal(err)
+	}
+	_assertLocalMessages(t, s, a2Replacement, b0)
+}
+
+func TestLoadLocalMessages(t *testing.T) {
+	a := _testSender(t, 100)
+	accepted, included, poolFull := _localMessage(a, 0, 1), _localMessage(a, 1, 1), _localMessage(a, 2, 1)
+	local := LocalMessageStore_Make(_memDatastore_Make())
+	for _, m := range []msg.SignedMessage{accepted, included, poolFull} {
+		if err := local.Put(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pool := _testPool_Make()
+	pool.addErrs[1] = ErrCallSeqNumTooLow
+	pool.addErrs[2] = ErrPoolFull
+	syncer := MessageSyncer_Make(pool, local, &_recordingTopic{})
+	if err := syncer.LoadLocalMessages(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, found := pool.Get(a, 0); !found {
+		t.Error("valid local message not added to the pool")
+	}
+	// The message included in the chain is dropped; that rejected for lack of space is kept.
+	_assertLocalMessages(t, local, accepted, poolFull)
+}
+
+func TestRepublishLocalMessages(t *testing.T) {
+	a := _testSender(t, 100)
+	pending := _localMessage(a, 0, 1)
+	replaced := _localMessage(a, 1, 1)
+	readded := _localMessage(a, 2, 1)
+	poolFull := _localMessage(a, 3, 1)
+	invalid := _localMessage(a, 4, 1)
+	local := LocalMessageStore_Make(_memDatastore_Make())
+	for _, m := range []msg.SignedMessage{pending, replaced, readded, poolFull, invalid} {
+		if err := local.Put(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pool := _testPool_Make(pending, _localMessage(a, 1, 2))
+	pool.addErrs[3] = ErrPoolFull
+	pool.addErrs[4] = ErrInsufficientBalance
+	topic := &_recordingTopic{}
+	syncer := MessageSyncer_Make(pool, local, topic)
+	if err := syncer.RepublishLocalMessages(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the messages pending in the pool are published.
+	if len(topic.published) != 2 {
+		t.Errorf("%d messages published, want 2", len(topic.published))
+	}
+	if _, found := pool.Get(a, 2); !found {
+		t.Error("local message missing from the pool not added again")
+	}
+	// Replaced and invalid messages are dropped; that rejected for lack of space is kept for the next
+	// republication.
+	_assertLocalMessages(t, local, pending, readded, poolFull)
+
+	delete(pool.addErrs, 3)
+	topic.published = nil
+	if err := syncer.RepublishLocalMessages(); err != nil {
+		t.Fatal(err)
+	}
+	if len(topic.published) != 3 {
+		t.Errorf("%d messages published once the pool has space, want 3", len(topic.published))
+	}
+}
+
+// A syncer which reports each republication.
+type _tickingSyncer struct {
+	MessageSyncer
+	republished chan struct{}
+}
+
+func (s *_tickingSyncer) RepublishLocalMessages() error {
+	select {
+	case s.republished <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func TestLocalMessageRepublisher(t *testing.T) {
+	syncer := &_tickingSyncer{republished: make(chan struct{})}
+	stop := StartLocalMessageRepublisher(syncer, time.Millisecond)
+	defer stop()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-syncer.republished:
+		case <-time.After(time.Second):
+			t.Fatalf("%d republications, want 2", i)
+		}
+	}
+}
@@ -0,0 +1,284 @@
+package storage_power_consensus
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+
+	addr "github.com/filecoin-project/go-address"
+	abi "github.com/filecoin-project/specs-actors/actors/abi"
+	builtin "github.com/filecoin-project/specs-actors/actors/builtin"
+	spowact "github.com/filecoin-project/specs-actors/actors/builtin/storage_power"
+	serde "github.com/filecoin-project/specs-actors/actors/serde"
+	filcrypto "github.com/filecoin-project/specs/algorithms/crypto"
+	message_pool "github.com/filecoin-project/specs/systems/filecoin_blockchain/message_pool"
+	block "github.com/filecoin-project/specs/systems/filecoin_blockchain/struct/block"
+	node_base "github.com/filecoin-project/specs/systems/filecoin_nodes/node_base"
+	msg "github.com/filecoin-project/specs/systems/filecoin_vm/message"
+	util "github.com/filecoin-project/specs/util"
+	cid "github.com/ipfs/go-cid"
+)
+
+var (
+	ErrReporterNotFound = errors.New("Consensus fault reporter not found in head state")
+)
+
+// ConsensusFaultReport is the evidence of a consensus fault: blocks which prove a fault of type FaultType,
+// in the order taken by IsValidConsensusFault.
+type ConsensusFaultReport struct {
+	FaultType spowact.ConsensusFaultType
+	Blocks    []block.Block
+}
+
+func (r ConsensusFaultReport) Miner() addr.Address {
+	return r.Blocks[0].Header().Miner()
+}
+
+type ConsensusFaultReporter interface {
+	Report(r ConsensusFaultReport) error
+}
+
+// MessagePoolFaultReporter reports consensus faults to the StoragePowerActor's ReportConsensusFault method,
+// in messages from the account From (which receives the slasher's reward), submitted through the message pool.
+type MessagePoolFaultReporter struct {
+	Pool     message_pool.MessagePoolSubsystem
+	From     addr.Address
+	Key      filcrypto.SigKeyPair
+	GasPrice abi.TokenAmount
+	GasLimit msg.GasAmount
+}
+
+func (r MessagePoolFaultReporter) Report(report ConsensusFaultReport) error {
+	// The message takes the first CallSeqNum not used by the reporter's messages pending in the pool.
+	callSeqNum, ok := r.Pool.Store().NextCallSeqNum(r.From)
+	if !ok {
+		return ErrReporterNotFound
+	}
+
+	var witnesses []util.Serialization
+	for _, w := range report.Blocks[2:] {
+		witnesses = append(witnesses, block.Serialize_BlockHeader(w.Header()))
+	}
+	params := serde.MustSerializeParams(
+		block.Serialize_BlockHeader(report.Blocks[0].Header()),
+		block.Serialize_BlockHeader(report.Blocks[1].Header()),
+		witnesses,
+		report.FaultType,
+	)
+
+	unsignedReportMessage := &msg.UnsignedMessage_I{
+		From_:       r.From,
+		To_:         builtin.StoragePowerActorAddr,
+		Method_:     builtin.Method_StoragePowerActor_ReportConsensusFault,
+		Params_:     params,
+		CallSeqNum_: callSeqNum,
+		Value_:      abi.TokenAmount(0),
+		GasPrice_:   r.GasPrice,
+		GasLimit_:   r.GasLimit,
+	}
+	signedMessage, err := msg.Sign(unsignedReportMessage, r.Key)
+	if err != nil {
+		return err
+	}
+	return r.Pool.Syncer().SubmitMessage(signedMessage)
+}
+
+// ConsensusFaultWatcher indexes the blocks a node receives, to detect the consensus faults which each
+// new block proves together with those received before it. Blocks are expected to have been validated,
+// so that their signatures (checked again by the StoragePowerActor) are those of their miners.
+//
+// A miner's pledge collateral is slashed in full by the first report of any of its faults, so at most
+// one fault is reported for each miner.
+type ConsensusFaultWatcher struct {
+	_lock     sync.Mutex
+	_ec       ExpectedConsensus
+	_reporter ConsensusFaultReporter
+
+	_blocks       map[cid.Cid]block.Block
+	_byMinerEpoch map[_minerEpoch][]block.Block
+	_byParents    map[string][]block.Block
+	_children     map[cid.Cid][]block.Block
+	_reported     map[addr.Address]bool
+}
+
+type _minerEpoch struct {
+	miner addr.Address
+	epoch abi.ChainEpoch
+}
+
+func ConsensusFaultWatcher_Make(ec ExpectedConsensus, reporter ConsensusFaultReporter) *ConsensusFaultWatcher {
+	return &ConsensusFaultWatcher{
+		_ec:           ec,
+		_reporter:     reporter,
+		_blocks:       make(map[cid.Cid]block.Block),
+		_byMinerEpoch: make(map[_minerEpoch][]block.Block),
+		_byParents:    make(map[string][]block.Block),
+		_children:     make(map[cid.Cid][]block.Block),
+		_reported:     make(map[addr.Address]bool),
+	}
+}
+
+// Indexes b and, if it proves a fault of its miner together with the blocks already indexed, reports it.
+// A report which fails to be submitted is attempted again if a later block of the miner proves a fault.
+func (w *ConsensusFaultWatcher) OnBlock(b block.Block) error {
+	w._lock.Lock()
+	defer w._lock.Unlock()
+
+	h := b.Header()
+	c := block.BlockHeaderCID(h)
+	if _, found := w._blocks[c]; found {
+		return nil
+	}
+	if w._reported[h.Miner()] {
+		w._index(b)
+		return nil
+	}
+	report, found := w._detect(b)
+	w._index(b)
+	if !found {
+		return nil
+	}
+	if err := w._reporter.Report(report); err != nil {
+		return err
+	}
+	w._reported[h.Miner()] = true
+	return nil
+}
+
+// Drops the blocks at epochs before the given epoch. Faults are only detected between blocks which are
+// still indexed.
+func (w *ConsensusFaultWatcher) Prune(before abi.ChainEpoch) {
+	w._lock.Lock()
+	defer w._lock.Unlock()
+
+	for c, b := range w._blocks {
+		if b.Header().Epoch() < before {
+			delete(w._blocks, c)
+		}
+	}
+	for k := range w._byMinerEpoch {
+		if k.epoch < before {
+			delete(w._byMinerEpoch, k)
+		}
+	}
+	for k, bs := range w._byParents {
+		if bs = _blocksSince(bs, before); len(bs) == 0 {
+			delete(w._byParents, k)
+		} else {
+			w._byParents[k] = bs
+		}
+	}
+	for c, bs := range w._children {
+		if bs = _blocksSince(bs, before); len(bs) == 0 {
+			delete(w._children, c)
+		} else {
+			w._children[c] = bs
+		}
+	}
+}
+
+// Every block of a fault is mined by the same miner, so the faults b may prove are those with the
+// indexed blocks of its miner: at the same epoch, with the same parents, or (for parent grinding)
+// related to it through a witness.
+func (w *ConsensusFaultWatcher) _detect(b block.Block) (ConsensusFaultReport, bool) {
+	h := b.Header()
+	var candidates []ConsensusFaultReport
+
+	// Double-fork mining.
+	for _, other := range w._byMinerEpoch[_minerEpoch{h.Miner(), h.Epoch()}] {
+		candidates = append(candidates, ConsensusFaultReport{spowact.DoubleForkMiningFault, []block.Block{other, b}})
+	}
+
+	// Time-offset mining, with the blocks in order of epoch.
+	for _, other := range w._byParents[_parentsKey(h)] {
+		if other.Header().Epoch() < h.Epoch() {
+			candidates = append(candidates, ConsensusFaultReport{spowact.TimeOffsetMiningFault, []block.Block{other, b}})
+		} else {
+			candidates = append(candidates, ConsensusFaultReport{spowact.TimeOffsetMiningFault, []block.Block{b, other}})
+		}
+	}
+
+	// Parent grinding, with b omitting its miner's block: a sibling of the witness, one of b's parents.
+	for _, parent := range h.Parents() {
+		witness, found := w._blocks[block.BlockHeaderCID(parent)]
+		if !found {
+			continue
+		}
+		for _, omitted := range w._byParents[_parentsKey(witness.Header())] {
+			candidates = append(candidates, ConsensusFaultReport{spowact.ParentGrindingFault, []block.Block{omitted, b, witness}})
+		}
+	}
+
+	// Parent grinding, with b the omitted block: a child of the witness, one of b's siblings, omits it.
+	for _, witness := range w._byParents[_parentsKey(h)] {
+		for _, child := range w._children[block.BlockHeaderCID(witness.Header())] {
+			candidates = append(candidates, ConsensusFaultReport{spowact.ParentGrindingFault, []block.Block{b, child, witness}})
+		}
+	}
+
+	for _, r := range candidates {
+		if w._ec.IsValidConsensusFault(r.FaultType, r.Blocks) {
+			return r, true
+		}
+	}
+	return ConsensusFaultReport{}, false
+}
+
+func (w *ConsensusFaultWatcher) _index(b block.Block) {
+	h := b.Header()
+	w._blocks[block.BlockHeaderCID(h)] = b
+	k := _minerEpoch{h.Miner(), h.Epoch()}
+	w._byMinerEpoch[k] = append(w._byMinerEpoch[k], b)
+	pk := _parentsKey(h)
+	w._byParents[pk] = append(w._byParents[pk], b)
+	for _, parent := range h.Parents() {
+		pc := block.BlockHeaderCID(parent)
+		w._children[pc] = append(w._children[pc], b)
+	}
+}
+
+// Runs w on the blocks received from blocks until it is closed or the watcher is stopped, dropping
+// blocks more than the Finality in params (at the latest epoch) older than the latest.
+func StartConsensusFaultWatcher(w *ConsensusFaultWatcher, params node_base.NetworkParamsSource, blocks <-chan block.Block) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		latest := abi.ChainEpoch(0)
+		for {
+			select {
+			case b, ok := <-blocks:
+				if !ok {
+					return
+				}
+				_ = w.OnBlock(b)
+				if b.Header().Epoch() > latest {
+					latest = b.Header().Epoch()
+					w.Prune(latest - node_base.NetworkParamsAtEpoch(params, latest).Finality)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// Identifies a block's parent set regardless of the order of its parents.
+func _parentsKey(h block.BlockHeader) string {
+	var keys []string
+	for _, parent := range h.Parents() {
+		keys = append(keys, block.BlockHeaderCID(parent).KeyString())
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, "")
+}
+
+func _blocksSince(bs []block.Block, epoch abi.ChainEpoch) []block.Block {
+	var ret []block.Block
+	for _, b := range bs {
+		if b.Header().Epoch() >= epoch {
+			ret = append(ret, b)
+		}
+	}
+	return ret
+}
@@ -0,0 +1,128 @@
+package storage_power_consensus
+
+import (
+	"errors"
+	"testing"
+
+	spowact "github.com/filecoin-project/specs-actors/actors/builtin/storage_power"
+	block "github.com/filecoin-project/specs/systems/filecoin_blockchain/struct/block"
+)
+
+var ErrTestReportFailed = errors.New("Test report failed")
+
+// Records the faults reported, failing while err is set.
+type _recordingReporter struct {
+	reports []ConsensusFaultReport
+	err     error
+}
+
+func (r *_recordingReporter) Report(report ConsensusFaultReport) error {
+	if r.err != nil {
+		return r.err
+	}
+	r.reports = append(r.reports, report)
+	return nil
+}
+
+func _watcher() (*ConsensusFaultWatcher, *_recordingReporter) {
+	r := &_recordingReporter{}
+	return ConsensusFaultWatcher_Make(ExpectedConsensus_Make(_testExpectedLeaders, 0), r), r
+}
+
+func _assertReports(t *testing.T, r *_recordingReporter, faultType spowact.ConsensusFaultType, blocks ...block.Block) {
+	t.Helper()
+	if len(r.reports) != 1 {
+		t.Fatalf("%d faults reported, want 1", len(r.reports))
+	}
+	report := r.reports[0]
+	if report.FaultType != faultType || len(report.Blocks) != len(blocks) {
+		t.Fatalf("fault %v with %d blocks reported, want %v with %d", report.FaultType, len(report.Blocks), faultType, len(blocks))
+	}
+	for i, b := range blocks {
+		if block.BlockHeaderCID(report.Blocks[i].Header()) != block.BlockHeaderCID(b.Header()) {
+			t.Errorf("reported block %d at epoch %d, want epoch %d", i, report.Blocks[i].Header().Epoch(), b.Header().Epoch())
+		}
+	}
+}
+
+func _receive(t *testing.T, w *ConsensusFaultWatcher, blocks ...block.Block) {
+	t.Helper()
+	for _, b := range blocks {
+		if err := w.OnBlock(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestWatcherDetectsFaults(t *testing.T) {
+	f := _faultFixture_Make(t)
+	cases := []struct {
+		name      string
+		received  []block.Block
+		faultType spowact.ConsensusFaultType
+		blocks    []block.Block
+	}{
+		{"double fork", []block.Block{f.p1, f.p2, f.h1, f.doubleFork}, spowact.DoubleForkMiningFault, []block.Block{f.h1, f.doubleFork}},
+		// The blocks are reported in order of epoch, whichever is received first.
+		{"time offset", []block.Block{f.p1, f.timeOffset, f.h1}, spowact.TimeOffsetMiningFault, []block.Block{f.h1, f.timeOffset}},
+		// The grinding block is received last.
+		{"parent grinding", []block.Block{f.p1, f.h1, f.sibling, f.grinding}, spowact.ParentGrindingFault, []block.Block{f.h1, f.grinding, f.sibling}},
+		// The omitted block is received last.
+		{"parent grinding, omitted block last", []block.Block{f.p1, f.sibling, f.grinding, f.h1}, spowact.ParentGrindingFault, []block.Block{f.h1, f.grinding, f.sibling}},
+	}
+	for _, c := range cases {
+		w, r := _watcher()
+		_receive(t, w, c.received...)
+		if len(r.reports) == 0 {
+			t.Errorf("%s: no fault reported", c.name)
+			continue
+		}
+		_assertReports(t, r, c.faultType, c.blocks...)
+	}
+}
+
+func TestWatcherIgnoresHonestBlocks(t *testing.T) {
+	f := _faultFixture_Make(t)
+	w, r := _watcher()
+	// Each miner mines at most one block per epoch, on the blocks of the epoch before.
+	_receive(t, w, f.p1, f.p2, f.h1, f.sibling, _faultBlock(f.h1.Header().Miner(), 3, f.h1, f.sibling))
+	// A block received again is ignored.
+	_receive(t, w, f.h1)
+	if len(r.reports) != 0 {
+		t.Errorf("%d faults reported among honest blocks", len(r.reports))
+	}
+}
+
+func TestWatcherReportsMinerOnce(t *testing.T) {
+	f := _faultFixture_Make(t)
+	w, r := _watcher()
+	_receive(t, w, f.p1, f.p2, f.h1, f.doubleFork, f.timeOffset)
+	_assertReports(t, r, spowact.DoubleForkMiningFault, f.h1, f.doubleFork)
+}
+
+func TestWatcherRetriesFailedReport(t *testing.T) {
+	f := _faultFixture_Make(t)
+	w, r := _watcher()
+	_receive(t, w, f.p1, f.p2, f.h1)
+
+	r.err = ErrTestReportFailed
+	if err := w.OnBlock(f.doubleFork); err != ErrTestReportFailed {
+		t.Fatalf("error %v, want %v", err, ErrTestReportFailed)
+	}
+
+	// A later block proving a fault of the miner is reported.
+	r.err = nil
+	_receive(t, w, f.timeOffset)
+	_assertReports(t, r, spowact.TimeOffsetMiningFault, f.h1, f.timeOffset)
+}
+
+func TestWatcherPrune(t *testing.T) {
+	f := _faultFixture_Make(t)
+	w, r := _watcher()
+	_receive(t, w, f.p1, f.p2, f.h1)
+	w.Prune(3)
+	_receive(t, w, f.doubleFork, f.timeOffset)
+	if len(r.reports) != 0 {
+		t.Errorf("%d faults reported with pruned blocks", len(r.reports))
+	}
+}
@@ -1,6 +1,7 @@
 package storage_power_consensus
 
 import (
+	"errors"
 	"math/big"
 
 	abi "github.com/filecoin-project/specs-actors/actors/abi"
@@ -10,43 +11,104 @@ import (
 	util "github.com/filecoin-project/specs/util"
 )
 
-func (self *ExpectedConsensus_I) ComputeChainWeight(tipset chain.Tipset) block.ChainWeight {
-	util.IMPL_FINISH()
-	return block.ChainWeight(0)
-	// see expected_consensus.md for detail
-
-	// numTickets := 0
-	// for _, bl := range tipset.Blocks {
-	//		numTickets += bl.ElectionPoSt.Candidates
-	// }
-	// wPowerFactor := self.log2b(spa.GetTotalPower())
-	// wBlocksFactor_num := (wPowerFactor * numTickets * self.wParams.wRatio_num)
-	// wBlocksFactor_den := self.expectedLeadersPerEpoch * self.wParams.wRatio_den
-	// return tipset.ParentTipset.ChainWeight
-	// 	+wPowerFactor * self.wParams.wPrecision
-	// 	+(wBlocksFactor_num * self.wParams.wPrecision / wBlocksFactor_den)
+var (
+	ErrChainWeightOverflow = errors.New("Chain weight exceeds the range of ChainWeight")
+)
+
+// Parameters of the weight function; the values are placeholders, suitable for testing.
+const (
+	WeightRatioNum  = 1
+	WeightRatioDen  = 2
+	WeightPrecision = 1 << 8
+)
+
+func ExpectedConsensus_Make(expectedLeadersPerEpoch util.UVarint, expectedRewardPerEpoch util.UVarint) ExpectedConsensus {
+	return &ExpectedConsensus_I{
+		expectedLeadersPerEpoch_: expectedLeadersPerEpoch,
+		expectedRewardPerEpoch_:  expectedRewardPerEpoch,
+		wParams_: &weightFunctionParameters_I{
+			wRatio_num_: WeightRatioNum,
+			wRatio_den_: WeightRatioDen,
+			wPrecision_: WeightPrecision,
+		},
+	}
 }
 
-func (self *ExpectedConsensus_I) IsValidConsensusFault(faults spowact.ConsensusFaultType, blocks []block.Block) bool {
-	util.IMPL_FINISH()
-	return false
+// Returns the weight of the chain ending in tipset: its blocks' ParentWeight plus the tipset's delta weight
+//
+//	wPowerFactor * wPrecision + (wPowerFactor * numTickets * wRatio_num * wPrecision) / (e * wRatio_den)
+//
+// where wPowerFactor = log2b(totalPower), totalPower is the total power in the power table of the tipset's
+// parent state, and e is the expected number of leaders per epoch. See expected_consensus.md for detail.
+//
+// The products are exact (big integer) and the division is the last operation, rounding down, so that
+// every implementation computes the same weight. Returns ErrChainWeightOverflow if the weight exceeds the
+// range of ChainWeight.
+func (self *ExpectedConsensus_I) ComputeChainWeight(tipset chain.Tipset, totalPower util.BigInt) (block.ChainWeight, error) {
+	util.Assert(len(tipset.Blocks()) > 0)
+
+	numTickets := 0
+	for _, b := range tipset.Blocks() {
+		numTickets += len(b.ElectionPoStOutput().Candidates())
+	}
 
-	// validation checks before calling this method
-	// - there should be exactly two block headers in proof
-	// - block1 and block2 are two different blocks
-	// - both blocks are mined by the same miner
-	// - block1 is of the same or lower block height as block2
+	wPowerFactor := big.NewInt(int64(_log2b(&totalPower)))
+	wPrecision := new(big.Int).SetUint64(self.wParams().wPrecision())
 
-	// 1. double-fork mining fault
-	// return block1.Epoch == block2.Epoch
+	wBlocksFactor_num := new(big.Int).Mul(wPowerFactor, big.NewInt(int64(numTickets)))
+	wBlocksFactor_num.Mul(wBlocksFactor_num, new(big.Int).SetUint64(self.wParams().wRatio_num()))
+	wBlocksFactor_num.Mul(wBlocksFactor_num, wPrecision)
+	wBlocksFactor_den := new(big.Int).Mul(
+		new(big.Int).SetUint64(self.expectedLeadersPerEpoch()),
+		new(big.Int).SetUint64(self.wParams().wRatio_den()))
+	util.Assert(wBlocksFactor_den.Sign() > 0)
 
-	// 2. time-offset mining fault
-	// return block1.Epoch != block2.Epoch
-	// && block1.Parents == block2.Parents
+	weight := new(big.Int).SetUint64(uint64(tipset.Blocks()[0].ParentWeight()))
+	weight.Add(weight, new(big.Int).Mul(wPowerFactor, wPrecision))
+	weight.Add(weight, new(big.Int).Quo(wBlocksFactor_num, wBlocksFactor_den))
+	if !weight.IsUint64() {
+		return 0, ErrChainWeightOverflow
+	}
+	return block.ChainWeight(weight.Uint64()), nil
+}
+
+// Returns floor(log2(x)), which is the binary length of x minus one, or 0 for x = 0 (an empty power table).
+// x is a big integer, so that the total power is not bounded by the width of a machine integer.
+func _log2b(x *big.Int) int {
+	if x.Sign() <= 0 {
+		return 0
+	}
+	return x.BitLen() - 1
+}
+
+// Returns whether blocks prove a consensus fault of the given type (see expected_consensus.md).
+// The first two blocks must be distinct blocks from the same miner, with the first at an epoch no later
+// than the second. A parent-grinding fault is proven with a third block, the witness: a sibling of the
+// first block which the second includes as a parent in place of the first. Other faults take no witness.
+//
+// The fault is that found by block.ConsensusFaultOf, as for the runtime's VerifyConsensusFault.
+// Block signatures are not checked here; the StoragePowerActor verifies them against the miner's worker
+// key when the fault is reported.
+func (self *ExpectedConsensus_I) IsValidConsensusFault(faults spowact.ConsensusFaultType, blocks []block.Block) bool {
+	if len(blocks) < 2 {
+		return false
+	}
+	var witnesses []block.BlockHeader
+	for _, w := range blocks[2:] {
+		witnesses = append(witnesses, w.Header())
+	}
+	kind := block.ConsensusFaultOf(blocks[0].Header(), blocks[1].Header(), witnesses)
 
-	// 3. parent grinding fault
-	// return block2.Epoch - block1.Epoch == 1
-	// && !block2.Parents.include(block1)
+	switch faults {
+	case spowact.DoubleForkMiningFault:
+		return kind == block.ConsensusFault_DoubleForkMining
+	case spowact.TimeOffsetMiningFault:
+		return kind == block.ConsensusFault_TimeOffsetMining
+	case spowact.ParentGrindingFault:
+		return kind == block.ConsensusFault_ParentGrinding
+	default:
+		return false
+	}
 }
 
 func (self *ExpectedConsensus_I) IsWinningChallengeTicket(challengeTicket util.Bytes, sectorPower abi.StoragePower, networkPower abi.StoragePower, numSectorsSampled util.UVarint, numSectorsMiner util.UVarint) bool {
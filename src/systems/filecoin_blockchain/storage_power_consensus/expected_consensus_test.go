@@ -0,0 +1,183 @@
+package storage_power_consensus
+
+import (
+	"math"
+	"math/big"
+	"testing"
+
+	addr "github.com/filecoin-project/go-address"
+	abi "github.com/filecoin-project/specs-actors/actors/abi"
+	spowact "github.com/filecoin-project/specs-actors/actors/builtin/storage_power"
+	block "github.com/filecoin-project/specs/systems/filecoin_blockchain/struct/block"
+	chain "github.com/filecoin-project/specs/systems/filecoin_blockchain/struct/chain"
+)
+
+// The expected number of leaders per epoch of the examples in expected_consensus.md.
+const _testExpectedLeaders = 5
+
+// Returns a tipset of one block, on parents of the given weight, with numTickets winning tickets.
+func _weightTipset(parentWeight block.ChainWeight, numTickets int) chain.Tipset {
+	h := &block.BlockHeader_I{
+		ParentWeight_: parentWeight,
+		ElectionPoStOutput_: &block.ElectionPoStVerifyInfo_I{
+			Candidates_: make([]block.PoStCandidate, numTickets),
+		},
+	}
+	return &chain.Tipset_I{Blocks_: []block.BlockHeader{h}}
+}
+
+// The examples of the delta weight table in expected_consensus.md.
+func TestComputeChainWeightDelta(t *testing.T) {
+	ec := ExpectedConsensus_Make(_testExpectedLeaders, 0)
+	pow2 := func(n uint) *big.Int { return new(big.Int).Lsh(big.NewInt(1), n) }
+	cases := []struct {
+		totalPower *big.Int
+		numTickets int
+		delta      block.ChainWeight
+	}{
+		{big.NewInt(0), 1, 0},
+		{big.NewInt(1), 5, 0},
+		{big.NewInt(1000), 1, 2534},
+		{big.NewInt(1023), 5, 3456},
+		{big.NewInt(1024), 5, 3840},
+		{pow2(40), 3, 13312},
+		// A total power beyond the range of 64-bit integers.
+		{pow2(70), 3, 23296},
+	}
+	for _, c := range cases {
+		for _, parentWeight := range []block.ChainWeight{0, 1000} {
+			w, err := ec.ComputeChainWeight(_weightTipset(parentWeight, c.numTickets), *c.totalPower)
+			if err != nil {
+				t.Errorf("power %d, %d tickets: %v", c.totalPower, c.numTickets, err)
+				continue
+			}
+			if w != parentWeight+c.delta {
+				t.Errorf("power %d, %d tickets, parent weight %d: weight %d, want %d",
+					c.totalPower, c.numTickets, parentWeight, w, parentWeight+c.delta)
+			}
+		}
+	}
+}
+
+func TestComputeChainWeightOverflow(t *testing.T) {
+	ec := ExpectedConsensus_Make(_testExpectedLeaders, 0)
+	const delta = 13312 // For a total power of 2^40 and 3 tickets.
+
+	totalPower := new(big.Int).Lsh(big.NewInt(1), 40)
+	w, err := ec.ComputeChainWeight(_weightTipset(math.MaxUint64-delta, 3), *totalPower)
+	if err != nil {
+		t.Fatalf("weight of exactly the maximum: %v", err)
+	}
+	if w != math.MaxUint64 {
+		t.Errorf("weight %d, want %d", w, uint64(math.MaxUint64))
+	}
+
+	if _, err := ec.ComputeChainWeight(_weightTipset(math.MaxUint64-delta+1, 3), *totalPower); err != ErrChainWeightOverflow {
+		t.Errorf("weight past the maximum: error %v, want %v", err, ErrChainWeightOverflow)
+	}
+}
+
+func TestLog2b(t *testing.T) {
+	pow2 := func(n uint) *big.Int { return new(big.Int).Lsh(big.NewInt(1), n) }
+	cases := []struct {
+		x    *big.Int
+		want int
+	}{
+		{big.NewInt(0), 0},
+		{big.NewInt(1), 0},
+		{big.NewInt(1000), 9},
+		{big.NewInt(1023), 9},
+		{big.NewInt(1024), 10},
+		{pow2(40), 40},
+		// Total powers beyond the range of 64-bit integers.
+		{new(big.Int).Sub(pow2(100), big.NewInt(1)), 99},
+		{pow2(100), 100},
+	}
+	for _, c := range cases {
+		if got := _log2b(c.x); got != c.want {
+			t.Errorf("_log2b(%v) = %d, want %d", c.x, got, c.want)
+		}
+	}
+}
+
+func _faultMiner(t *testing.T, id uint64) addr.Address {
+	a, err := addr.NewIDAddress(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func _faultBlock(miner addr.Address, epoch abi.ChainEpoch, parents ...block.Block) block.Block {
+	var headers []block.BlockHeader
+	for _, p := range parents {
+		headers = append(headers, p.Header())
+	}
+	return &block.Block_I{Header_: &block.BlockHeader_I{Parents_: headers, Epoch_: epoch, Miner_: miner}}
+}
+
+// Blocks of miner a at epochs 2 and 3, among those of miner b, from which the faults of a are built.
+type _faultFixture struct {
+	p1, p2 block.Block
+	// a's block at epoch 2, and b's sibling of it.
+	h1, sibling block.Block
+	// a's blocks: another at epoch 2; one at epoch 3 on the parents of h1; one at epoch 3 on the sibling
+	// of h1, omitting it.
+	doubleFork, timeOffset, grinding block.Block
+}
+
+func _faultFixture_Make(t *testing.T) _faultFixture {
+	a, b := _faultMiner(t, 1000), _faultMiner(t, 1001)
+	f := _faultFixture{
+		p1: _faultBlock(b, 1),
+		p2: _faultBlock(_faultMiner(t, 1002), 1),
+	}
+	f.h1 = _faultBlock(a, 2, f.p1)
+	f.sibling = _faultBlock(b, 2, f.p1)
+	f.doubleFork = _faultBlock(a, 2, f.p2)
+	f.timeOffset = _faultBlock(a, 3, f.p1)
+	f.grinding = _faultBlock(a, 3, f.sibling)
+	return f
+}
+
+func TestIsValidConsensusFault(t *testing.T) {
+	ec := ExpectedConsensus_Make(_testExpectedLeaders, 0)
+	f := _faultFixture_Make(t)
+	a, b := f.h1.Header().Miner(), f.sibling.Header().Miner()
+	// a's block at epoch 3 including both h1 and its sibling.
+	including := _faultBlock(a, 3, f.h1, f.sibling)
+	// b's block at epoch 2, and a's block at epoch 3 on it, which is not a sibling of h1.
+	other := _faultBlock(b, 2, f.p2)
+	onOther := _faultBlock(a, 3, other)
+
+	cases := []struct {
+		name   string
+		faults spowact.ConsensusFaultType
+		blocks []block.Block
+		valid  bool
+	}{
+		{"double fork", spowact.DoubleForkMiningFault, []block.Block{f.h1, f.doubleFork}, true},
+		{"double fork by two miners", spowact.DoubleForkMiningFault, []block.Block{f.h1, f.sibling}, false},
+		{"double fork with witness", spowact.DoubleForkMiningFault, []block.Block{f.h1, f.doubleFork, f.sibling}, false},
+		{"double fork of one block", spowact.DoubleForkMiningFault, []block.Block{f.h1, f.h1}, false},
+		{"double fork at two epochs", spowact.DoubleForkMiningFault, []block.Block{f.h1, f.timeOffset}, false},
+		{"one block", spowact.DoubleForkMiningFault, []block.Block{f.h1}, false},
+
+		{"time offset", spowact.TimeOffsetMiningFault, []block.Block{f.h1, f.timeOffset}, true},
+		{"time offset, later block first", spowact.TimeOffsetMiningFault, []block.Block{f.timeOffset, f.h1}, false},
+		{"time offset with witness", spowact.TimeOffsetMiningFault, []block.Block{f.h1, f.timeOffset, f.sibling}, false},
+		{"time offset, other parents", spowact.TimeOffsetMiningFault, []block.Block{f.h1, f.grinding}, false},
+		{"time offset at one epoch", spowact.TimeOffsetMiningFault, []block.Block{f.h1, f.doubleFork}, false},
+
+		{"parent grinding", spowact.ParentGrindingFault, []block.Block{f.h1, f.grinding, f.sibling}, true},
+		{"parent grinding without witness", spowact.ParentGrindingFault, []block.Block{f.h1, f.grinding}, false},
+		{"parent grinding, including the block", spowact.ParentGrindingFault, []block.Block{f.h1, including, f.sibling}, false},
+		{"parent grinding, witness not a sibling", spowact.ParentGrindingFault, []block.Block{f.h1, onOther, other}, false},
+		{"parent grinding, two witnesses", spowact.ParentGrindingFault, []block.Block{f.h1, f.grinding, f.sibling, other}, false},
+	}
+	for _, c := range cases {
+		if valid := ec.IsValidConsensusFault(c.faults, c.blocks); valid != c.valid {
+			t.Errorf("%s: valid %v, want %v", c.name, valid, c.valid)
+		}
+	}
+}
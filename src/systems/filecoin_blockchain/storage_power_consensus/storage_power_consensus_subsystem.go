@@ -32,18 +32,39 @@ func (spc *StoragePowerConsensusSubsystem_I) validateTicket(ticket block.Ticket,
 	return ticket.Verify(randomness1, pk, minerActorAddr)
 }
 
-func (spc *StoragePowerConsensusSubsystem_I) ComputeChainWeight(tipset chain.Tipset) block.ChainWeight {
-	return spc.ec().ComputeChainWeight(tipset)
+// Miners mine atop the heaviest tipset (see Chain Selection in Expected Consensus).
+func (spc *StoragePowerConsensusSubsystem_I) ChooseTipsetToMine(tipsets []chain.Tipset) []chain.Tipset {
+	best := spc.blockchain().ChooseTipset(tipsets)
+	if best == nil {
+		return nil
+	}
+	return []chain.Tipset{best}
 }
 
-func (spc *StoragePowerConsensusSubsystem_I) IsWinningPartialTicket(stateTree stateTree.StateTree, inds inds.Indices, partialTicket abi.PartialTicket, sectorUtilization abi.StoragePower, numSectors util.UVarint) bool {
+func (spc *StoragePowerConsensusSubsystem_I) ComputeChainWeight(tipset chain.Tipset) (block.ChainWeight, error) {
+	return spc.ec().ComputeChainWeight(tipset, spc._totalPowerAtTipset(tipset))
+}
+
+// The power table of a tipset is that of its parent state, on which all of its blocks were mined.
+// The total is a big integer, so that it cannot overflow however many miners' power it sums.
+func (spc *StoragePowerConsensusSubsystem_I) _totalPowerAtTipset(tipset chain.Tipset) util.BigInt {
+	spa := spc._getStoragePowerActorState(tipset.Blocks()[0].ParentState())
+	var totalPower util.BigInt
+	for _, pow := range spa.PowerTable {
+		totalPower.Add(&totalPower, new(util.BigInt).SetUint64(uint64(pow)))
+	}
+	return totalPower
+}
+
+func (spc *StoragePowerConsensusSubsystem_I) IsWinningPartialTicket(stateTree stateTree.StateTree, epoch abi.ChainEpoch, inds inds.Indices, partialTicket abi.PartialTicket, sectorUtilization abi.StoragePower, numSectors util.UVarint) bool {
 
 	// finalize the partial ticket
 	challengeTicket := acrypto.SHA256(abi.Bytes(partialTicket))
 
 	networkPower := inds.TotalNetworkEffectivePower()
 
-	sectorsSampled := uint64(math.Ceil(float64(node_base.EPOST_SAMPLE_RATE_NUM/node_base.EPOST_SAMPLE_RATE_DENOM) * float64(numSectors)))
+	params := node_base.NetworkParamsAtEpoch(spc.node().NetworkParams(), epoch)
+	sectorsSampled := uint64(math.Ceil(float64(params.EPoStSampleRateNum) / float64(params.EPoStSampleRateDenom) * float64(numSectors)))
 
 	return spc.ec().IsWinningChallengeTicket(challengeTicket, sectorUtilization, networkPower, sectorsSampled, numSectors)
 }
@@ -66,5 +87,5 @@ func (spc *StoragePowerConsensusSubsystem_I) _getStoragePowerActorState(stateTre
 }
 
 func (spc *StoragePowerConsensusSubsystem_I) GetFinalizedEpoch(currentEpoch abi.ChainEpoch) abi.ChainEpoch {
-	return currentEpoch - node_base.FINALITY
+	return currentEpoch - node_base.NetworkParamsAtEpoch(spc.node().NetworkParams(), currentEpoch).Finality
 }
@@ -1,7 +1,168 @@
 package block
 
+import (
+	"errors"
+
+	ipld "github.com/filecoin-project/specs/libraries/ipld"
+	util "github.com/filecoin-project/specs/util"
+	cid "github.com/ipfs/go-cid"
+	mh "github.com/multiformats/go-multihash"
+)
+
 // The maximum sum of the size of the serialized messages included in a block.
 const BlockMaxSize = 512 * 1024
 
 // The maximum sum of the gas limits of the messages included in a block.
 const BlockGasLimit = 0 // Placeholder
+
+// Returns the CID of a block header. For a header reference (such as an element of another header's
+// Parents), this is the CID it holds, so the header need not be loaded.
+func BlockHeaderCID(h BlockHeader) cid.Cid {
+	if ref, ok := h.(*BlockHeader_R); ok && ref.cid != nil {
+		return _castCID(ref.cid)
+	}
+	return _dagCBORCID(Serialize_BlockHeader(h))
+}
+
+// Returns the CID of the TxMeta to which a block header links.
+func TxMetaCID(h BlockHeader) cid.Cid {
+	if ref, ok := h.Messages().(*TxMeta_R); ok && ref.cid != nil {
+		return _castCID(ref.cid)
+	}
+	return _dagCBORCID(Serialize_TxMeta(h.Messages()))
+}
+
+// Chain data is stored as DAG-CBOR, addressed by its SHA2-256 hash.
+func _dagCBORCID(s util.Serialization) cid.Cid {
+	c, err := cid.NewPrefixV1(cid.DagCBOR, mh.SHA2_256).Sum(s)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+func _castCID(b util.Bytes) cid.Cid {
+	c, err := cid.Cast(b)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+// Returns the bytes over which a block's miner signs its header (and against which the Signature is
+// verified): the serialized header with its Signature field cleared.
+func BlockHeaderSigningBytes(h BlockHeader) util.Bytes {
+	unsigned := *h.Impl()
+	unsigned.Signature_ = nil
+	return util.Bytes(Serialize_BlockHeader(&unsigned))
+}
+
+var ErrBlockHeaderNotFound = errors.New("Block header not found in store")
+var ErrTxMetaNotFound = errors.New("Block TxMeta not found in store")
+var ErrReceiptNotFound = errors.New("Receipt not found in store")
+
+// Loads the block header with CID c from store. Its parents, parent state and TxMeta are references holding
+// only their CIDs.
+func LoadBlockHeader(store ipld.GraphStore, c cid.Cid) (BlockHeader, error) {
+	serialized, found := store.Get(c)
+	if !found {
+		return nil, ErrBlockHeaderNotFound
+	}
+	return Deserialize_BlockHeader(util.Serialization(serialized))
+}
+
+// Returns the full block of a header, with the messages to which its TxMeta links. If the header holds only
+// the CID of its TxMeta (as a header received or loaded on its own does), the TxMeta is loaded from store.
+func LoadBlock(store ipld.GraphStore, h BlockHeader) (Block, error) {
+	txMeta := h.Messages()
+	if ref, ok := txMeta.(*TxMeta_R); ok && ref.cached_impl == nil {
+		serialized, found := store.Get(TxMetaCID(h))
+		if !found {
+			return nil, ErrTxMetaNotFound
+		}
+		loaded, err := Deserialize_TxMeta(util.Serialization(serialized))
+		if err != nil {
+			return nil, err
+		}
+		txMeta = loaded
+	}
+	return &Block_I{
+		Header_:       h,
+		BLSMessages_:  txMeta.BLSMessages(),
+		SECPMessages_: txMeta.SECPMessages(),
+	}, nil
+}
+
+// Stores a tipset's serialized receipts, and an AMT of links to them in order of execution, in store.
+// Returns the AMT's root: the ParentMessageReceipts of the next tipset's block headers.
+func PutMessageReceiptsAMT(store ipld.GraphStore, receipts []MessageReceipt) cid.Cid {
+	serialized := make([]util.Bytes, len(receipts))
+	for i, r := range receipts {
+		serialized[i] = util.Bytes(r)
+	}
+	return _putLinksAMT(store, serialized)
+}
+
+// Stores a tipset's serialized implicit receipts, and an AMT of links to them in order of application,
+// in store. Returns the AMT's root: the ParentImplicitReceipts of the next tipset's block headers.
+func PutImplicitReceiptsAMT(store ipld.GraphStore, receipts []ImplicitReceipt) cid.Cid {
+	serialized := make([]util.Bytes, len(receipts))
+	for i, r := range receipts {
+		serialized[i] = util.Bytes(r)
+	}
+	return _putLinksAMT(store, serialized)
+}
+
+// Loads the receipts stored by PutMessageReceiptsAMT.
+func LoadMessageReceiptsAMT(store ipld.GraphStore, root cid.Cid) ([]MessageReceipt, error) {
+	serialized, err := _loadLinksAMT(store, root)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]MessageReceipt, len(serialized))
+	for i, s := range serialized {
+		ret[i] = MessageReceipt(s)
+	}
+	return ret, nil
+}
+
+// Loads the implicit receipts stored by PutImplicitReceiptsAMT.
+func LoadImplicitReceiptsAMT(store ipld.GraphStore, root cid.Cid) ([]ImplicitReceipt, error) {
+	serialized, err := _loadLinksAMT(store, root)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]ImplicitReceipt, len(serialized))
+	for i, s := range serialized {
+		ret[i] = ImplicitReceipt(s)
+	}
+	return ret, nil
+}
+
+func _putLinksAMT(store ipld.GraphStore, serialized []util.Bytes) cid.Cid {
+	links := make([]interface{}, len(serialized))
+	for i, s := range serialized {
+		links[i] = store.Put(s)
+	}
+	return ipld.PutAMT(store, links)
+}
+
+func _loadLinksAMT(store ipld.GraphStore, root cid.Cid) ([]util.Bytes, error) {
+	links, err := ipld.LoadAMT(store, root)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]util.Bytes, len(links))
+	for i, l := range links {
+		c, ok := l.(cid.Cid)
+		if !ok {
+			return nil, ipld.ErrAMTMalformed
+		}
+		serialized, found := store.Get(c)
+		if !found {
+			return nil, ErrReceiptNotFound
+		}
+		ret[i] = serialized
+	}
+	return ret, nil
+}
@@ -0,0 +1,359 @@
+package block
+
+import (
+	"errors"
+
+	addr "github.com/filecoin-project/go-address"
+	abi "github.com/filecoin-project/specs-actors/actors/abi"
+	filcrypto "github.com/filecoin-project/specs/algorithms/crypto"
+	clock "github.com/filecoin-project/specs/systems/filecoin_nodes/clock"
+	msg "github.com/filecoin-project/specs/systems/filecoin_vm/message"
+	st "github.com/filecoin-project/specs/systems/filecoin_vm/state_tree"
+	util "github.com/filecoin-project/specs/util"
+	cid "github.com/ipfs/go-cid"
+	cbornode "github.com/ipfs/go-ipld-cbor"
+)
+
+var (
+	ErrMalformedBlockHeader = errors.New("Malformed block header")
+	ErrMalformedTxMeta      = errors.New("Malformed TxMeta")
+)
+
+// A BlockHeader is serialized as the DAG-CBOR tuple of its fields, in order. Parents, ParentState and
+// Messages are serialized as links (to the parents' headers, the root of the state tree and the TxMeta),
+// and so are deserialized as references holding only their CIDs.
+// A Ticket is serialized as the tuple [VRFResult, Output], with VRFResult the tuple [Output, Proof, Digest];
+// a Signature as the tuple [Type, Sig]; and an ElectionPoStVerifyInfo as the tuple
+// [Candidates, Proof, Randomness], which have no fields.
+// Absent fields (nil, or undefined CIDs) are serialized as null, and an undefined Miner as empty bytes.
+//
+// A TxMeta is serialized as the tuple [BLSMessages, SECPMessages], each a list of the serialized messages.
+
+const _blockHeaderFields = 14
+
+func Serialize_BlockHeader(h BlockHeader) util.Serialization {
+	return _dumpObject(_blockHeaderTuple(h))
+}
+
+func Serialize_BlockHeader_Array(hs []BlockHeader) util.Serialization {
+	tuples := make([]interface{}, len(hs))
+	for i, h := range hs {
+		tuples[i] = _blockHeaderTuple(h)
+	}
+	return _dumpObject(tuples)
+}
+
+func Deserialize_BlockHeader(s util.Serialization) (BlockHeader, error) {
+	var tuple []interface{}
+	if err := cbornode.DecodeInto(s, &tuple); err != nil {
+		return nil, ErrMalformedBlockHeader
+	}
+	ret, ok := _blockHeaderFromTuple(tuple)
+	if !ok {
+		return nil, ErrMalformedBlockHeader
+	}
+	return ret, nil
+}
+
+func Deserialize_BlockHeader_Assert(s util.Serialization) BlockHeader {
+	ret, err := Deserialize_BlockHeader(s)
+	util.Assert(err == nil)
+	return ret
+}
+
+func Serialize_TxMeta(m TxMeta) util.Serialization {
+	return _dumpObject(_txMetaTuple(m))
+}
+
+func Serialize_TxMeta_Array(ms []TxMeta) util.Serialization {
+	tuples := make([]interface{}, len(ms))
+	for i, m := range ms {
+		tuples[i] = _txMetaTuple(m)
+	}
+	return _dumpObject(tuples)
+}
+
+func Deserialize_TxMeta(s util.Serialization) (TxMeta, error) {
+	var tuple []interface{}
+	if err := cbornode.DecodeInto(s, &tuple); err != nil || len(tuple) != 2 {
+		return nil, ErrMalformedTxMeta
+	}
+	blsSerialized, blsOk := _bytesList(tuple[0])
+	secpSerialized, secpOk := _bytesList(tuple[1])
+	if !blsOk || !secpOk {
+		return nil, ErrMalformedTxMeta
+	}
+	ret := &TxMeta_I{}
+	for _, s := range blsSerialized {
+		m, err := msg.Deserialize_UnsignedMessage(util.Serialization(s))
+		if err != nil {
+			return nil, err
+		}
+		ret.BLSMessages_ = append(ret.BLSMessages_, m)
+	}
+	for _, s := range secpSerialized {
+		m, err := msg.Deserialize_SignedMessage(util.Serialization(s))
+		if err != nil {
+			return nil, err
+		}
+		ret.SECPMessages_ = append(ret.SECPMessages_, m)
+	}
+	return ret, nil
+}
+
+func Deserialize_TxMeta_Assert(s util.Serialization) TxMeta {
+	ret, err := Deserialize_TxMeta(s)
+	util.Assert(err == nil)
+	return ret
+}
+
+func _blockHeaderTuple(h BlockHeader) []interface{} {
+	parents := make([]interface{}, len(h.Parents()))
+	for i, p := range h.Parents() {
+		parents[i] = BlockHeaderCID(p)
+	}
+	var parentState, messages interface{}
+	if h.ParentState() != nil {
+		parentState = h.ParentState().RootCID()
+	}
+	if h.Messages() != nil {
+		messages = TxMetaCID(h)
+	}
+	var ticket interface{}
+	if t := h.Ticket(); t != nil {
+		var vrfResult interface{}
+		if r := t.VRFResult(); r != nil {
+			vrfResult = []interface{}{_bytes(r.Output()), _bytes(r.Proof()), _bytes(r.Digest())}
+		}
+		ticket = []interface{}{vrfResult, _bytes(t.Output())}
+	}
+	var electionPoSt interface{}
+	if e := h.ElectionPoStOutput(); e != nil {
+		candidates := make([]interface{}, len(e.Candidates()))
+		for i := range candidates {
+			candidates[i] = []interface{}{}
+		}
+		electionPoSt = []interface{}{candidates, _emptyTuple(e.Proof() != nil), _emptyTuple(e.Randomness() != nil)}
+	}
+
+	return []interface{}{
+		parents,
+		uint64(h.ParentWeight()),
+		parentState,
+		_optionalLink(h.ParentMessageReceipts()),
+		_optionalLink(h.ParentImplicitReceipts()),
+		int64(h.Epoch()),
+		int64(h.Timestamp()),
+		ticket,
+		_bytes(h.Miner().Bytes()),
+		electionPoSt,
+		h.ForkSignal(),
+		messages,
+		_signatureTuple(h.BLSAggregate()),
+		_signatureTuple(h.Signature()),
+	}
+}
+
+func _blockHeaderFromTuple(tuple []interface{}) (BlockHeader, bool) {
+	if len(tuple) != _blockHeaderFields {
+		return nil, false
+	}
+	ret := &BlockHeader_I{}
+
+	parents, ok := tuple[0].([]interface{})
+	if !ok {
+		return nil, false
+	}
+	for _, p := range parents {
+		c, ok := p.(cid.Cid)
+		if !ok {
+			return nil, false
+		}
+		ret.Parents_ = append(ret.Parents_, &BlockHeader_R{cid: c.Bytes()})
+	}
+
+	// DAG-CBOR integers decode as int.
+	var ints [4]int
+	for i, j := range []int{1, 5, 6, 10} {
+		if ints[i], ok = tuple[j].(int); !ok {
+			return nil, false
+		}
+	}
+	ret.ParentWeight_ = ChainWeight(uint64(ints[0]))
+	ret.Epoch_ = abi.ChainEpoch(ints[1])
+	ret.Timestamp_ = clock.UnixTime(ints[2])
+	ret.ForkSignal_ = uint64(ints[3])
+
+	var links [4]cid.Cid
+	for i, j := range []int{2, 3, 4, 11} {
+		if links[i], ok = _linkFromTuple(tuple[j]); !ok {
+			return nil, false
+		}
+	}
+	if links[0].Defined() {
+		ret.ParentState_ = st.StateTree_Ref(links[0])
+	}
+	ret.ParentMessageReceipts_ = links[1]
+	ret.ParentImplicitReceipts_ = links[2]
+	if links[3].Defined() {
+		ret.Messages_ = &TxMeta_R{cid: links[3].Bytes()}
+	}
+
+	minerBytes, ok := tuple[8].([]byte)
+	if !ok {
+		return nil, false
+	}
+	if len(minerBytes) > 0 {
+		miner, err := addr.NewFromBytes(minerBytes)
+		if err != nil {
+			return nil, false
+		}
+		ret.Miner_ = miner
+	}
+
+	if ret.Ticket_, ok = _ticketFromTuple(tuple[7]); !ok {
+		return nil, false
+	}
+	if ret.ElectionPoStOutput_, ok = _electionPoStFromTuple(tuple[9]); !ok {
+		return nil, false
+	}
+	if ret.BLSAggregate_, ok = _signatureFromTuple(tuple[12]); !ok {
+		return nil, false
+	}
+	if ret.Signature_, ok = _signatureFromTuple(tuple[13]); !ok {
+		return nil, false
+	}
+	return ret, true
+}
+
+func _ticketFromTuple(x interface{}) (Ticket, bool) {
+	if x == nil {
+		return nil, true
+	}
+	tuple, ok := x.([]interface{})
+	if !ok || len(tuple) != 2 {
+		return nil, false
+	}
+	output, ok := tuple[1].([]byte)
+	if !ok {
+		return nil, false
+	}
+	ret := &Ticket_I{Output_: output}
+	if tuple[0] != nil {
+		vrfResult, ok := _bytesList(tuple[0])
+		if !ok || len(vrfResult) != 3 {
+			return nil, false
+		}
+		ret.VRFResult_ = &filcrypto.VRFResult_I{Output_: vrfResult[0], Proof_: vrfResult[1], Digest_: vrfResult[2]}
+	}
+	return ret, true
+}
+
+func _electionPoStFromTuple(x interface{}) (ElectionPoStVerifyInfo, bool) {
+	if x == nil {
+		return nil, true
+	}
+	tuple, ok := x.([]interface{})
+	if !ok || len(tuple) != 3 {
+		return nil, false
+	}
+	candidates, ok := tuple[0].([]interface{})
+	if !ok {
+		return nil, false
+	}
+	ret := &ElectionPoStVerifyInfo_I{}
+	for range candidates {
+		ret.Candidates_ = append(ret.Candidates_, &PoStCandidate_I{})
+	}
+	if tuple[1] != nil {
+		ret.Proof_ = &PoStProof_I{}
+	}
+	if tuple[2] != nil {
+		ret.Randomness_ = &PoStRandomness_I{}
+	}
+	return ret, true
+}
+
+func _signatureTuple(sig filcrypto.Signature) interface{} {
+	if sig == nil {
+		return nil
+	}
+	return []interface{}{uint64(sig.Type()), _bytes(sig.Sig())}
+}
+
+func _signatureFromTuple(x interface{}) (filcrypto.Signature, bool) {
+	if x == nil {
+		return nil, true
+	}
+	tuple, ok := x.([]interface{})
+	if !ok || len(tuple) != 2 {
+		return nil, false
+	}
+	sigType, typeOk := tuple[0].(int)
+	sig, sigOk := tuple[1].([]byte)
+	if !typeOk || !sigOk || sigType < 0 {
+		return nil, false
+	}
+	return &filcrypto.Signature_I{Type_: filcrypto.SigType(sigType), Sig_: filcrypto.SignatureBytes(sig)}, true
+}
+
+func _txMetaTuple(m TxMeta) []interface{} {
+	bls := make([]interface{}, len(m.BLSMessages()))
+	for i, u := range m.BLSMessages() {
+		bls[i] = []byte(msg.Serialize_UnsignedMessage(u))
+	}
+	secp := make([]interface{}, len(m.SECPMessages()))
+	for i, s := range m.SECPMessages() {
+		secp[i] = []byte(msg.Serialize_SignedMessage(s))
+	}
+	return []interface{}{bls, secp}
+}
+
+func _bytesList(x interface{}) ([]util.Bytes, bool) {
+	list, ok := x.([]interface{})
+	if !ok {
+		return nil, false
+	}
+	ret := make([]util.Bytes, len(list))
+	for i, e := range list {
+		if ret[i], ok = e.([]byte); !ok {
+			return nil, false
+		}
+	}
+	return ret, true
+}
+
+// Returns b as a byte string which is serialized as such, rather than as null, if empty.
+func _bytes(b []byte) []byte {
+	return append([]byte{}, b...)
+}
+
+// Returns an empty tuple, standing for a struct with no fields, if present, and otherwise null.
+func _emptyTuple(present bool) interface{} {
+	if !present {
+		return nil
+	}
+	return []interface{}{}
+}
+
+// An undefined CID cannot be serialized as a link, so is serialized as null.
+func _optionalLink(c cid.Cid) interface{} {
+	if !c.Defined() {
+		return nil
+	}
+	return c
+}
+
+func _linkFromTuple(x interface{}) (cid.Cid, bool) {
+	if x == nil {
+		return cid.Undef, true
+	}
+	c, ok := x.(cid.Cid)
+	return c, ok
+}
+
+func _dumpObject(x interface{}) util.Serialization {
+	ret, err := cbornode.DumpObject(x)
+	util.Assert(err == nil)
+	return util.Serialization(ret)
+}
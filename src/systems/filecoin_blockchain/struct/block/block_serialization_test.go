@@ -0,0 +1,111 @@
+package block
+
+import (
+	"bytes"
+	"testing"
+
+	addr "github.com/filecoin-project/go-address"
+	filcrypto "github.com/filecoin-project/specs/algorithms/crypto"
+	msg "github.com/filecoin-project/specs/systems/filecoin_vm/message"
+	st "github.com/filecoin-project/specs/systems/filecoin_vm/state_tree"
+	util "github.com/filecoin-project/specs/util"
+)
+
+func _testHeader(t *testing.T) *BlockHeader_I {
+	miner, err := addr.NewIDAddress(1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parent := &BlockHeader_I{Miner_: miner, Epoch_: 1, Ticket_: &Ticket_I{Output_: util.Bytes{1}}}
+	return &BlockHeader_I{
+		Parents_:      []BlockHeader{parent},
+		ParentWeight_: 10,
+		ParentState_:  &st.StateTree_I{},
+		Epoch_:        2,
+		Timestamp_:    60,
+		Ticket_: &Ticket_I{
+			VRFResult_: &filcrypto.VRFResult_I{Output_: util.Bytes{2}, Proof_: util.Bytes{3}, Digest_: util.Bytes{4}},
+			Output_:    util.Bytes{5},
+		},
+		Miner_:              miner,
+		ElectionPoStOutput_: &ElectionPoStVerifyInfo_I{Candidates_: []PoStCandidate{&PoStCandidate_I{}}, Proof_: &PoStProof_I{}},
+		Messages_:           &TxMeta_I{},
+		Signature_:          &filcrypto.Signature_I{Type_: filcrypto.SigType_BLSSigType, Sig_: filcrypto.SignatureBytes{6}},
+	}
+}
+
+func TestBlockHeaderSerializationRoundTrip(t *testing.T) {
+	headers := map[string]BlockHeader{
+		"empty": &BlockHeader_I{},
+		"full":  _testHeader(t),
+	}
+	for name, h := range headers {
+		s := Serialize_BlockHeader(h)
+		d, err := Deserialize_BlockHeader(s)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !bytes.Equal(Serialize_BlockHeader(d), s) {
+			t.Errorf("%s: reserialized header differs", name)
+		}
+		if !BlockHeaderCID(d).Equals(BlockHeaderCID(h)) {
+			t.Errorf("%s: CID %v, want %v", name, BlockHeaderCID(d), BlockHeaderCID(h))
+		}
+	}
+
+	h := _testHeader(t)
+	d := Deserialize_BlockHeader_Assert(Serialize_BlockHeader(h))
+	if !BlockHeaderCID(d.Parents()[0]).Equals(BlockHeaderCID(h.Parents()[0])) {
+		t.Errorf("parent CID %v, want %v", BlockHeaderCID(d.Parents()[0]), BlockHeaderCID(h.Parents()[0]))
+	}
+	if !d.ParentState().RootCID().Equals(h.ParentState().RootCID()) {
+		t.Errorf("parent state %v, want %v", d.ParentState().RootCID(), h.ParentState().RootCID())
+	}
+	if !TxMetaCID(d).Equals(TxMetaCID(h)) {
+		t.Errorf("TxMeta CID %v, want %v", TxMetaCID(d), TxMetaCID(h))
+	}
+}
+
+func TestBlockHeaderSigningBytes(t *testing.T) {
+	h := _testHeader(t)
+	unsigned := *h
+	unsigned.Signature_ = &filcrypto.Signature_I{Type_: filcrypto.SigType_BLSSigType, Sig_: filcrypto.SignatureBytes{7}}
+	if !bytes.Equal(BlockHeaderSigningBytes(h), BlockHeaderSigningBytes(&unsigned)) {
+		t.Error("signing bytes depend on the signature")
+	}
+	if h.Signature() == nil {
+		t.Error("signing bytes cleared the header's signature")
+	}
+}
+
+func TestTxMetaSerializationRoundTrip(t *testing.T) {
+	to, err := addr.NewIDAddress(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &msg.UnsignedMessage_I{To_: to, From_: to, CallSeqNum_: 3, GasLimit_: msg.GasAmount_Zero()}
+	sig := &filcrypto.Signature_I{Type_: filcrypto.SigType_ECDSASigType, Sig_: filcrypto.SignatureBytes{1}}
+	txMeta := &TxMeta_I{
+		BLSMessages_:  []msg.UnsignedMessage{m},
+		SECPMessages_: []msg.SignedMessage{msg.SignedMessage_Make(m, sig)},
+	}
+
+	s := Serialize_TxMeta(txMeta)
+	d, err := Deserialize_TxMeta(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(d.BLSMessages()) != 1 || len(d.SECPMessages()) != 1 || d.SECPMessages()[0].Message().CallSeqNum() != 3 {
+		t.Errorf("TxMeta deserialized as %v, want %v", d, txMeta)
+	}
+	if !bytes.Equal(Serialize_TxMeta(d), s) {
+		t.Error("reserialized TxMeta differs")
+	}
+
+	if _, err := Deserialize_TxMeta(util.Serialization{0xff}); err != ErrMalformedTxMeta {
+		t.Errorf("error %v, want %v", err, ErrMalformedTxMeta)
+	}
+	if _, err := Deserialize_BlockHeader(s); err != ErrMalformedBlockHeader {
+		t.Errorf("error %v, want %v", err, ErrMalformedBlockHeader)
+	}
+}
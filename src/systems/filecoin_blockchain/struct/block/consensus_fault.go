@@ -0,0 +1,71 @@
+package block
+
+import (
+	cid "github.com/ipfs/go-cid"
+)
+
+type ConsensusFaultKind int
+
+const (
+	ConsensusFault_None ConsensusFaultKind = iota
+	ConsensusFault_DoubleForkMining
+	ConsensusFault_TimeOffsetMining
+	ConsensusFault_ParentGrinding
+)
+
+// Returns the consensus fault proven by two headers and the witnesses given with them, or
+// ConsensusFault_None if they prove none. h1 and h2 must be distinct headers from the same miner, with
+// h1 at an epoch no later than h2. A parent-grinding fault is proven with a single witness; the other
+// faults with none.
+//
+// Signatures are not checked here, so the headers must be checked to be signed by the miner's worker
+// key for the fault to be attributed to it.
+func ConsensusFaultOf(h1 BlockHeader, h2 BlockHeader, witnesses []BlockHeader) ConsensusFaultKind {
+	if BlockHeaderCID(h1) == BlockHeaderCID(h2) || h1.Miner() != h2.Miner() || h1.Epoch() > h2.Epoch() {
+		return ConsensusFault_None
+	}
+
+	switch len(witnesses) {
+	case 0:
+		// Double-fork mining: two blocks at the same epoch.
+		if h1.Epoch() == h2.Epoch() {
+			return ConsensusFault_DoubleForkMining
+		}
+		// Time-offset mining: two blocks at different epochs with the same parents.
+		if _sameParents(h1, h2) {
+			return ConsensusFault_TimeOffsetMining
+		}
+
+	case 1:
+		// Parent grinding: h2 omits h1 from its parents, but includes the witness, mined off the same
+		// parents at the same epoch as h1.
+		w := witnesses[0]
+		if h1.Epoch() < h2.Epoch() && !_hasParent(h2, BlockHeaderCID(h1)) && _hasParent(h2, BlockHeaderCID(w)) &&
+			_sameParents(h1, w) && h1.Epoch() == w.Epoch() {
+			return ConsensusFault_ParentGrinding
+		}
+	}
+	return ConsensusFault_None
+}
+
+// Returns whether two headers have the same parents, in any order.
+func _sameParents(h1 BlockHeader, h2 BlockHeader) bool {
+	if len(h1.Parents()) != len(h2.Parents()) {
+		return false
+	}
+	for _, parent := range h1.Parents() {
+		if !_hasParent(h2, BlockHeaderCID(parent)) {
+			return false
+		}
+	}
+	return true
+}
+
+func _hasParent(h BlockHeader, c cid.Cid) bool {
+	for _, parent := range h.Parents() {
+		if BlockHeaderCID(parent) == c {
+			return true
+		}
+	}
+	return false
+}
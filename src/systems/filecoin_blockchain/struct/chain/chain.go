@@ -28,7 +28,7 @@ func (chain *Chain_I) RandomnessSeedAtEpoch(epoch abi.ChainEpoch) abi.Randomness
 }
 
 func (chain *Chain_I) GetTicketProductionRandSeed(epoch abi.ChainEpoch) abi.RandomnessSeed {
-	return chain.RandomnessSeedAtEpoch(epoch - node_base.SPC_LOOKBACK_TICKET)
+	return chain.RandomnessSeedAtEpoch(epoch - node_base.NetworkParamsAtEpoch(chain.NetworkParams(), epoch).SPCLookbackTicket)
 }
 
 func (chain *Chain_I) GetSealRandSeed(epoch abi.ChainEpoch) abi.RandomnessSeed {
@@ -0,0 +1,326 @@
+package chain
+
+import (
+	"errors"
+	"io"
+
+	abi "github.com/filecoin-project/specs-actors/actors/abi"
+	ipld "github.com/filecoin-project/specs/libraries/ipld"
+	car "github.com/filecoin-project/specs/libraries/ipld/car"
+	block "github.com/filecoin-project/specs/systems/filecoin_blockchain/struct/block"
+	repo "github.com/filecoin-project/specs/systems/filecoin_nodes/repository"
+	util "github.com/filecoin-project/specs/util"
+	cid "github.com/ipfs/go-cid"
+)
+
+type ChainExportParams struct {
+	// Whether to include the parent state tree of each exported tipset. Without these, an imported chain
+	// can only be used from a state obtained elsewhere (e.g., by executing the chain from genesis).
+	IncludeStateTrees bool
+}
+
+var (
+	ErrExportRange      = errors.New("Invalid range of epochs to export")
+	ErrBlockNotInStore  = errors.New("Block linked from the exported chain is missing from the store")
+	ErrNoChainRoots     = errors.New("CAR file has no chain head")
+	ErrUnexpectedBlock  = errors.New("CAR file block is not linked from any earlier block")
+	ErrHeaderLinkage    = errors.New("CAR file block header is not at an earlier epoch than its child")
+	ErrChainIncomplete  = errors.New("CAR file is missing blocks linked from the chain it holds")
+	ErrHeadNotOneTipset = errors.New("CAR file roots are not the blocks of a single tipset")
+)
+
+// Writes the tipsets of c from epoch to back to epoch from (inclusive) to w, as a CARv1 file whose roots are
+// the blocks of the latest tipset. For each tipset, the file holds its block headers, each block's TxMeta and
+// the messages it links to, and the receipts of the parent tipset's explicit and implicit messages, followed
+// (if requested) by the parent state tree. Each block is written once, after a block linking to it.
+//
+// Blocks are read from the repository and written as the chain is walked, so only the CIDs of the blocks
+// already written are held in memory.
+func ExportChain(w io.Writer, c Chain, repository repo.Repository, from abi.ChainEpoch, to abi.ChainEpoch, params ChainExportParams) error {
+	if from < 0 || to < from || to > c.HeadTipset().Epoch() {
+		return ErrExportRange
+	}
+	ts := c.TipsetAtEpoch(to)
+
+	var roots []cid.Cid
+	for _, h := range ts.BlockCIDs() {
+		roots = append(roots, block.BlockHeaderCID(h))
+	}
+	cw, err := car.Writer_Make(w, roots)
+	if err != nil {
+		return err
+	}
+
+	e := &_chainExporter{
+		_writer:  cw,
+		_written: make(map[cid.Cid]bool),
+	}
+	for {
+		for _, h := range ts.Blocks() {
+			if err := e._putDAG(repository.ChainStore(), block.BlockHeaderCID(h), false); err != nil {
+				return err
+			}
+		}
+		for _, h := range ts.Blocks() {
+			if err := e._putDAG(repository.ChainStore(), block.TxMetaCID(h), true); err != nil {
+				return err
+			}
+			if err := e._putDAG(repository.ChainStore(), h.ParentMessageReceipts(), true); err != nil {
+				return err
+			}
+			if err := e._putDAG(repository.ChainStore(), h.ParentImplicitReceipts(), true); err != nil {
+				return err
+			}
+		}
+		if params.IncludeStateTrees {
+			parentState := ts.Blocks()[0].ParentState().RootCID()
+			if err := e._putDAG(repository.StateStore(), parentState, true); err != nil {
+				return err
+			}
+		}
+
+		if ts.Epoch() <= from || ts.Epoch() == 0 {
+			return nil
+		}
+		ts = ts.Parents()
+	}
+}
+
+type _chainExporter struct {
+	_writer  *car.Writer
+	_written map[cid.Cid]bool
+}
+
+// Writes the block with CID root and, if followLinks, the blocks reachable from it, depth first.
+func (e *_chainExporter) _putDAG(store ipld.GraphStore, root cid.Cid, followLinks bool) error {
+	stack := []cid.Cid{root}
+	for len(stack) > 0 {
+		c := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if e._written[c] {
+			continue
+		}
+		value, found := store.Get(c)
+		if !found {
+			return ErrBlockNotInStore
+		}
+		if err := e._writer.Put(c, value); err != nil {
+			return err
+		}
+		e._written[c] = true
+
+		if !followLinks {
+			continue
+		}
+		links, err := ipld.Links(value)
+		if err != nil {
+			return err
+		}
+		for i := len(links) - 1; i >= 0; i-- {
+			stack = append(stack, links[i])
+		}
+	}
+	return nil
+}
+
+// Reads a chain exported by ExportChain from r into the repository's ChainStore and StateStore, returning the
+// chain's head tipset.
+//
+// Each block must be linked from a block read before it (or be a root), so that it is known on arrival to be
+// a header, other chain data or state. Each header must be at an earlier epoch than the children linking to
+// it, and every block linked from the chain must be present, except for the parents of the earliest tipset
+// and (if the export omitted them) the state trees.
+func ImportChain(r io.Reader, repository repo.Repository) (Tipset, error) {
+	cr, err := car.Reader_Make(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(cr.Header.Roots) == 0 {
+		return nil, ErrNoChainRoots
+	}
+
+	i := &_chainImporter{
+		_headers:    make(map[cid.Cid]abi.ChainEpoch),
+		_chain:      make(map[cid.Cid]bool),
+		_state:      make(map[cid.Cid]bool),
+		_stateRoots: make(map[cid.Cid]bool),
+		_read:       make(map[cid.Cid]abi.ChainEpoch),
+	}
+	// Roots are expected as headers of any epoch.
+	for _, root := range cr.Header.Roots {
+		i._headers[root] = abi.ChainEpoch(-1)
+	}
+
+	var head []block.BlockHeader
+	minEpoch := abi.ChainEpoch(-1)
+	var earliest []block.BlockHeader
+	for {
+		c, value, err := cr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		h, err := i._import(repository, c, value)
+		if err != nil {
+			return nil, err
+		}
+		if h == nil {
+			continue
+		}
+		if i._isRoot(cr.Header.Roots, c) {
+			head = append(head, h)
+		}
+		if minEpoch < 0 || h.Epoch() < minEpoch {
+			minEpoch = h.Epoch()
+			earliest = nil
+		}
+		if h.Epoch() == minEpoch {
+			earliest = append(earliest, h)
+		}
+	}
+
+	if err := i._checkComplete(earliest); err != nil {
+		return nil, err
+	}
+	return _headTipset(head, len(cr.Header.Roots))
+}
+
+type _chainImporter struct {
+	// The headers expected, with the epoch of the earliest child linking to each (or -1 for roots).
+	_headers map[cid.Cid]abi.ChainEpoch
+	// The other chain data and state blocks expected.
+	_chain map[cid.Cid]bool
+	_state map[cid.Cid]bool
+	// The roots of the state trees linked from headers, which may be omitted.
+	_stateRoots map[cid.Cid]bool
+	// The blocks read, which are not expected again, with the epoch of each header (or -1 for other blocks).
+	_read map[cid.Cid]abi.ChainEpoch
+}
+
+// Stores a block according to how it was linked, and expects the blocks it links to. Returns the block's header,
+// if it is one.
+func (i *_chainImporter) _import(repository repo.Repository, c cid.Cid, value util.Bytes) (block.BlockHeader, error) {
+	childEpoch, isHeader := i._headers[c]
+	isChain, isState := i._chain[c], i._state[c]
+	if !isHeader && !isChain && !isState {
+		return nil, ErrUnexpectedBlock
+	}
+	delete(i._headers, c)
+	delete(i._chain, c)
+	delete(i._state, c)
+	i._read[c] = abi.ChainEpoch(-1)
+
+	if isHeader || isChain {
+		if !repository.ChainStore().Put(value).Equals(c) {
+			return nil, car.ErrBlockCIDMismatch
+		}
+	}
+	if isState {
+		if !repository.StateStore().Put(value).Equals(c) {
+			return nil, car.ErrBlockCIDMismatch
+		}
+	}
+
+	if isChain || isState {
+		links, err := ipld.Links(value)
+		if err != nil {
+			return nil, err
+		}
+		for _, l := range links {
+			if isChain {
+				i._expect(i._chain, l)
+			}
+			if isState {
+				i._expect(i._state, l)
+			}
+		}
+	}
+	if !isHeader {
+		return nil, nil
+	}
+
+	h, err := block.Deserialize_BlockHeader(util.Serialization(value))
+	if err != nil {
+		return nil, err
+	}
+	if childEpoch >= 0 && h.Epoch() >= childEpoch {
+		return nil, ErrHeaderLinkage
+	}
+	i._read[c] = h.Epoch()
+	for _, parent := range h.Parents() {
+		pc := block.BlockHeaderCID(parent)
+		if epoch, read := i._read[pc]; read {
+			if epoch >= h.Epoch() {
+				return nil, ErrHeaderLinkage
+			}
+		} else if epoch, expected := i._headers[pc]; !expected || h.Epoch() < epoch {
+			i._headers[pc] = h.Epoch()
+		}
+	}
+	i._expect(i._chain, block.TxMetaCID(h))
+	i._expect(i._chain, h.ParentMessageReceipts())
+	i._expect(i._chain, h.ParentImplicitReceipts())
+	stateRoot := h.ParentState().RootCID()
+	i._expect(i._state, stateRoot)
+	i._stateRoots[stateRoot] = true
+	return h, nil
+}
+
+// Blocks are written once, so those linked again after being read are not expected again.
+func (i *_chainImporter) _expect(expected map[cid.Cid]bool, c cid.Cid) {
+	if _, read := i._read[c]; !read {
+		expected[c] = true
+	}
+}
+
+// Checks that the only blocks expected but not read are the parents of the earliest headers, and the roots of
+// state trees omitted from the export.
+func (i *_chainImporter) _checkComplete(earliest []block.BlockHeader) error {
+	allowedParents := make(map[cid.Cid]bool)
+	for _, h := range earliest {
+		for _, parent := range h.Parents() {
+			allowedParents[block.BlockHeaderCID(parent)] = true
+		}
+	}
+	for c := range i._headers {
+		if !allowedParents[c] {
+			return ErrChainIncomplete
+		}
+	}
+	if len(i._chain) > 0 {
+		return ErrChainIncomplete
+	}
+	for c := range i._state {
+		if !i._stateRoots[c] {
+			return ErrChainIncomplete
+		}
+	}
+	return nil
+}
+
+func (i *_chainImporter) _isRoot(roots []cid.Cid, c cid.Cid) bool {
+	for _, root := range roots {
+		if root == c {
+			return true
+		}
+	}
+	return false
+}
+
+func _headTipset(head []block.BlockHeader, numRoots int) (Tipset, error) {
+	if len(head) != numRoots {
+		return nil, ErrChainIncomplete
+	}
+	for _, h := range head[1:] {
+		if h.Epoch() != head[0].Epoch() {
+			return nil, ErrHeadNotOneTipset
+		}
+	}
+	return &Tipset_I{
+		BlockCIDs_: head,
+		Blocks_:    head,
+		Epoch_:     head[0].Epoch(),
+	}, nil
+}
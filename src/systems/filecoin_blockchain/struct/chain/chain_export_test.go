@@ -0,0 +1,217 @@
+package chain
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	addr "github.com/filecoin-project/go-address"
+	abi "github.com/filecoin-project/specs-actors/actors/abi"
+	ipld "github.com/filecoin-project/specs/libraries/ipld"
+	car "github.com/filecoin-project/specs/libraries/ipld/car"
+	block "github.com/filecoin-project/specs/systems/filecoin_blockchain/struct/block"
+	repo "github.com/filecoin-project/specs/systems/filecoin_nodes/repository"
+	st "github.com/filecoin-project/specs/systems/filecoin_vm/state_tree"
+	util "github.com/filecoin-project/specs/util"
+	cid "github.com/ipfs/go-cid"
+)
+
+func _repository() *repo.Repository_I {
+	return &repo.Repository_I{ChainStore_: ipld.MemGraphStore_Make(), StateStore_: ipld.MemGraphStore_Make()}
+}
+
+func _header(t *testing.T, parents []block.BlockHeader, epoch abi.ChainEpoch, miner uint64) block.BlockHeader {
+	a, err := addr.NewIDAddress(miner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &block.BlockHeader_I{
+		Parents_:                parents,
+		ParentState_:            &st.StateTree_I{},
+		ParentMessageReceipts_:  block.PutMessageReceiptsAMT(ipld.MemGraphStore_Make(), nil),
+		ParentImplicitReceipts_: block.PutImplicitReceiptsAMT(ipld.MemGraphStore_Make(), nil),
+		Epoch_:                  epoch,
+		Miner_:                  a,
+		Messages_:               &block.TxMeta_I{},
+	}
+}
+
+// Puts a header, and the chain data to which it links, in the repository's ChainStore.
+func _putHeader(repository repo.Repository, h block.BlockHeader) {
+	s := repository.ChainStore()
+	s.Put(util.Bytes(block.Serialize_BlockHeader(h)))
+	s.Put(util.Bytes(block.Serialize_TxMeta(h.Messages())))
+	block.PutMessageReceiptsAMT(s, nil)
+	block.PutImplicitReceiptsAMT(s, nil)
+}
+
+// Returns a chain from genesis to epoch 4, with two blocks at epoch 2 and a null round at epoch 3,
+// whose chain data is in repository.
+func _testChain(t *testing.T, repository repo.Repository) Chain {
+	genesis := _header(t, nil, 0, 100)
+	ts := &Tipset_I{BlockCIDs_: []block.BlockHeader{genesis}, Blocks_: []block.BlockHeader{genesis}, Epoch_: 0}
+	for _, epoch := range []abi.ChainEpoch{1, 2, 4} {
+		blocks := []block.BlockHeader{_header(t, ts.Blocks(), epoch, 100)}
+		if epoch == 2 {
+			blocks = append(blocks, _header(t, ts.Blocks(), epoch, 101))
+		}
+		ts = &Tipset_I{BlockCIDs_: blocks, Blocks_: blocks, Epoch_: epoch, Parents_: ts}
+	}
+	for put := Tipset(ts); ; put = put.Parents() {
+		for _, h := range put.Blocks() {
+			_putHeader(repository, h)
+		}
+		if put.Epoch() == 0 {
+			break
+		}
+	}
+	return &Chain_I{HeadTipset_: ts}
+}
+
+func _export(t *testing.T, c Chain, repository repo.Repository, from abi.ChainEpoch, to abi.ChainEpoch) []byte {
+	var buf bytes.Buffer
+	if err := ExportChain(&buf, c, repository, from, to, ChainExportParams{}); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+type _carBlock struct {
+	c     cid.Cid
+	value util.Bytes
+}
+
+func _readCAR(t *testing.T, data []byte) ([]cid.Cid, []_carBlock) {
+	r, err := car.Reader_Make(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ret []_carBlock
+	for {
+		c, value, err := r.Next()
+		if err == io.EOF {
+			return r.Header.Roots, ret
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		ret = append(ret, _carBlock{c, value})
+	}
+}
+
+func _writeCAR(t *testing.T, roots []cid.Cid, blocks []_carBlock) []byte {
+	var buf bytes.Buffer
+	w, err := car.Writer_Make(&buf, roots)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range blocks {
+		if err := w.Put(b.c, b.value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.Bytes()
+}
+
+func _headerBlock(h block.BlockHeader) _carBlock {
+	return _carBlock{block.BlockHeaderCID(h), util.Bytes(block.Serialize_BlockHeader(h))}
+}
+
+func _assertHead(t *testing.T, head Tipset, expected Tipset) {
+	t.Helper()
+	if head.Epoch() != expected.Epoch() || len(head.Blocks()) != len(expected.Blocks()) {
+		t.Fatalf("head at epoch %d with %d blocks, want epoch %d with %d", head.Epoch(), len(head.Blocks()), expected.Epoch(), len(expected.Blocks()))
+	}
+	for i, h := range head.Blocks() {
+		if block.BlockHeaderCID(h) != block.BlockHeaderCID(expected.Blocks()[i]) {
+			t.Errorf("head block %d is %v, want %v", i, block.BlockHeaderCID(h), block.BlockHeaderCID(expected.Blocks()[i]))
+		}
+	}
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	source := _repository()
+	c := _testChain(t, source)
+
+	for _, from := range []abi.ChainEpoch{0, 2} {
+		data := _export(t, c, source, from, 4)
+		target := _repository()
+		head, err := ImportChain(bytes.NewReader(data), target)
+		if err != nil {
+			t.Fatalf("from epoch %d: %v", from, err)
+		}
+		_assertHead(t, head, c.HeadTipset())
+
+		for ts := c.HeadTipset(); ts.Epoch() >= from; ts = ts.Parents() {
+			for _, h := range ts.Blocks() {
+				for _, hc := range []cid.Cid{block.BlockHeaderCID(h), block.TxMetaCID(h)} {
+					if _, found := target.ChainStore().Get(hc); !found {
+						t.Errorf("from epoch %d: chain data of block at epoch %d not imported", from, h.Epoch())
+					}
+				}
+			}
+			if ts.Epoch() == 0 {
+				break
+			}
+		}
+	}
+
+	// Exporting a range ending before the head starts from the tipset at or before its end.
+	data := _export(t, c, source, 0, 3)
+	head, err := ImportChain(bytes.NewReader(data), _repository())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_assertHead(t, head, c.TipsetAtEpoch(2))
+}
+
+func TestExportRange(t *testing.T) {
+	repository := _repository()
+	c := _testChain(t, repository)
+	for _, r := range [][2]abi.ChainEpoch{{-1, 2}, {3, 2}, {0, 5}} {
+		if err := ExportChain(&bytes.Buffer{}, c, repository, r[0], r[1], ChainExportParams{}); err != ErrExportRange {
+			t.Errorf("export from %d to %d: error %v, want %v", r[0], r[1], err, ErrExportRange)
+		}
+	}
+}
+
+func TestImportRejectsMalformedChain(t *testing.T) {
+	source := _repository()
+	c := _testChain(t, source)
+	roots, blocks := _readCAR(t, _export(t, c, source, 0, 4))
+	head := c.HeadTipset().Blocks()[0]
+
+	without := func(omitted cid.Cid) []_carBlock {
+		var ret []_carBlock
+		for _, b := range blocks {
+			if b.c != omitted {
+				ret = append(ret, b)
+			}
+		}
+		return ret
+	}
+
+	// A header at the epoch of its child, linked from it.
+	sameEpoch := _header(t, nil, head.Epoch(), 200)
+	child := _header(t, []block.BlockHeader{sameEpoch}, head.Epoch(), 201)
+	// A block at epoch 2 stands alone among the roots.
+	epoch2 := c.TipsetAtEpoch(2).Blocks()[0]
+
+	unrelated := _header(t, nil, 0, 300)
+	cases := map[string]struct {
+		data []byte
+		err  error
+	}{
+		"no roots":          {_writeCAR(t, nil, blocks), ErrNoChainRoots},
+		"unexpected block":  {_writeCAR(t, roots, append(append([]_carBlock{}, blocks...), _headerBlock(unrelated))), ErrUnexpectedBlock},
+		"missing TxMeta":    {_writeCAR(t, roots, without(block.TxMetaCID(c.TipsetAtEpoch(1).Blocks()[0]))), ErrChainIncomplete},
+		"missing header":    {_writeCAR(t, roots, without(block.BlockHeaderCID(epoch2))), ErrChainIncomplete},
+		"missing root":      {_writeCAR(t, append(append([]cid.Cid{}, roots...), block.BlockHeaderCID(unrelated)), blocks), ErrChainIncomplete},
+		"header linkage":    {_writeCAR(t, []cid.Cid{block.BlockHeaderCID(child)}, []_carBlock{_headerBlock(child), _headerBlock(sameEpoch)}), ErrHeaderLinkage},
+		"roots of 2 epochs": {_writeCAR(t, append(append([]cid.Cid{}, roots...), block.BlockHeaderCID(epoch2)), blocks), ErrHeadNotOneTipset},
+	}
+	for name, tc := range cases {
+		if _, err := ImportChain(bytes.NewReader(tc.data), _repository()); err != tc.err {
+			t.Errorf("%s: error %v, want %v", name, err, tc.err)
+		}
+	}
+}
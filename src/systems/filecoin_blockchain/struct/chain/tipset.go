@@ -0,0 +1,111 @@
+package chain
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+	"strings"
+
+	addr "github.com/filecoin-project/go-address"
+	block "github.com/filecoin-project/specs/systems/filecoin_blockchain/struct/block"
+	util "github.com/filecoin-project/specs/util"
+	cid "github.com/ipfs/go-cid"
+)
+
+var (
+	ErrTipsetEmpty                    = errors.New("Tipset has no blocks")
+	ErrTipsetEpochMismatch            = errors.New("Tipset blocks have different epochs")
+	ErrTipsetParentsMismatch          = errors.New("Tipset blocks have different parents")
+	ErrTipsetParentWeightMismatch     = errors.New("Tipset blocks have different parent weights")
+	ErrTipsetParentStateMismatch      = errors.New("Tipset blocks have different parent states")
+	ErrTipsetReceiptsMismatch         = errors.New("Tipset blocks have different parent message receipts")
+	ErrTipsetImplicitReceiptsMismatch = errors.New("Tipset blocks have different parent implicit receipts")
+	ErrTipsetDuplicateMiner           = errors.New("Tipset has more than one block from a miner")
+)
+
+// Returns the tipset of the given blocks, checking that they form a valid tipset: a non-empty set of
+// blocks from distinct miners, with identical Epoch, Parents, ParentWeight, ParentState,
+// ParentMessageReceipts and ParentImplicitReceipts. The blocks are put in canonical order.
+func Tipset_Make(blocks []block.BlockHeader) (Tipset, error) {
+	if len(blocks) == 0 {
+		return nil, ErrTipsetEmpty
+	}
+
+	first := blocks[0]
+	miners := make(map[addr.Address]bool)
+	for _, b := range blocks {
+		switch {
+		case b.Epoch() != first.Epoch():
+			return nil, ErrTipsetEpochMismatch
+		case !_sameParents(b, first):
+			return nil, ErrTipsetParentsMismatch
+		case b.ParentWeight() != first.ParentWeight():
+			return nil, ErrTipsetParentWeightMismatch
+		case b.ParentState().RootCID() != first.ParentState().RootCID():
+			return nil, ErrTipsetParentStateMismatch
+		case b.ParentMessageReceipts() != first.ParentMessageReceipts():
+			return nil, ErrTipsetReceiptsMismatch
+		case b.ParentImplicitReceipts() != first.ParentImplicitReceipts():
+			return nil, ErrTipsetImplicitReceiptsMismatch
+		case miners[b.Miner()]:
+			return nil, ErrTipsetDuplicateMiner
+		}
+		miners[b.Miner()] = true
+	}
+
+	sorted := append([]block.BlockHeader(nil), blocks...)
+	SortTipsetBlocks(sorted)
+	return &Tipset_I{
+		BlockCIDs_: sorted,
+		Blocks_:    sorted,
+		Epoch_:     first.Epoch(),
+	}, nil
+}
+
+// Sorts blocks in the canonical order of a tipset: by the bytes of their tickets, breaking ties with
+// the bytes of their CIDs.
+func SortTipsetBlocks(blocks []block.BlockHeader) {
+	sort.Slice(blocks, func(i, j int) bool {
+		if c := bytes.Compare(blocks[i].Ticket().Output(), blocks[j].Ticket().Output()); c != 0 {
+			return c < 0
+		}
+		return bytes.Compare(block.BlockHeaderCID(blocks[i]).Bytes(), block.BlockHeaderCID(blocks[j]).Bytes()) < 0
+	})
+}
+
+// Identifies a tipset by its blocks, regardless of their order (e.g., to key maps of tipsets).
+func TipsetKey(headers []block.BlockHeader) string {
+	var keys []string
+	for _, h := range headers {
+		keys = append(keys, block.BlockHeaderCID(h).KeyString())
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, "")
+}
+
+func (ts *Tipset_I) MinTicket() block.Ticket {
+	util.Assert(len(ts.Blocks()) > 0)
+	ret := ts.Blocks()[0].Ticket()
+	for _, b := range ts.Blocks()[1:] {
+		if bytes.Compare(b.Ticket().Output(), ret.Output()) < 0 {
+			ret = b.Ticket()
+		}
+	}
+	return ret
+}
+
+func _sameParents(h1 block.BlockHeader, h2 block.BlockHeader) bool {
+	if len(h1.Parents()) != len(h2.Parents()) {
+		return false
+	}
+	parents := make(map[cid.Cid]bool)
+	for _, parent := range h1.Parents() {
+		parents[block.BlockHeaderCID(parent)] = true
+	}
+	for _, parent := range h2.Parents() {
+		if !parents[block.BlockHeaderCID(parent)] {
+			return false
+		}
+	}
+	return true
+}
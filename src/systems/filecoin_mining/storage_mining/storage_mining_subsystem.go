@@ -131,7 +131,7 @@ func (sms *StorageMiningSubsystem_I) _tryLeaderElection(currState stateTree.Stat
 	util.TODO()
 	provingSet := make([]abi.SectorID, 0)
 
-	candidates := sms.StorageProving().Impl().GenerateElectionPoStCandidates(postRandomness, provingSet)
+	candidates := sms.StorageProving().Impl().GenerateElectionPoStCandidates(sms._blockchain().LatestEpoch(), postRandomness, provingSet)
 
 	if len(candidates) <= 0 {
 		return nil // fail to generate post candidates
@@ -150,7 +150,7 @@ func (sms *StorageMiningSubsystem_I) _tryLeaderElection(currState stateTree.Stat
 			return nil
 		}
 		sectorPower := indices.ConsensusPowerForStorageWeight(sectorWeightDesc)
-		if sms._consensus().IsWinningPartialTicket(currState, candidate.PartialTicket, sectorPower, numMinerSectors) {
+		if sms._consensus().IsWinningPartialTicket(currState, sms._blockchain().LatestEpoch(), candidate.PartialTicket, sectorPower, numMinerSectors) {
 			winningCandidates = append(winningCandidates, candidate)
 		}
 	}
@@ -309,7 +309,7 @@ func (sms *StorageMiningSubsystem_I) _verifyElection(header block.BlockHeader, o
 			return false
 		}
 		sectorPower := indices.ConsensusPowerForStorageWeight(sectorWeightDesc)
-		if !sms._consensus().IsWinningPartialTicket(header.ParentState(), info.PartialTicket, sectorPower, numMinerSectors) {
+		if !sms._consensus().IsWinningPartialTicket(header.ParentState(), header.Epoch(), info.PartialTicket, sectorPower, numMinerSectors) {
 			return false
 		}
 	}
@@ -331,7 +331,7 @@ func (sms *StorageMiningSubsystem_I) _trySurprisePoSt(currState stateTree.StateT
 	util.TODO()
 	provingSet := make([]abi.SectorID, 0)
 
-	candidates := sms.StorageProving().Impl().GenerateSurprisePoStCandidates(abi.PoStRandomness(postRandomness), provingSet)
+	candidates := sms.StorageProving().Impl().GenerateSurprisePoStCandidates(challEpoch, abi.PoStRandomness(postRandomness), provingSet)
 
 	if len(candidates) <= 0 {
 		// Error. Will fail this surprise post and must then redeclare faults
@@ -28,8 +28,9 @@ func (sps *StorageProvingSubsystem_I) ComputeUnsealedSectorCID(sectorSize abi.Se
 }
 
 // TODO also return error
-func (sps *StorageProvingSubsystem_I) GenerateElectionPoStCandidates(challengeSeed abi.PoStRandomness, sectorIDs []abi.SectorID) []abi.PoStCandidate {
-	numChallengeTickets := util.UInt(len(sectorIDs) * node_base.EPOST_SAMPLE_RATE_NUM / node_base.EPOST_SAMPLE_RATE_DENOM)
+func (sps *StorageProvingSubsystem_I) GenerateElectionPoStCandidates(epoch abi.ChainEpoch, challengeSeed abi.PoStRandomness, sectorIDs []abi.SectorID) []abi.PoStCandidate {
+	params := node_base.NetworkParamsAtEpoch(sps.NetworkParams(), epoch)
+	numChallengeTickets := util.UInt(len(sectorIDs) * params.EPoStSampleRateNum / params.EPoStSampleRateDenom)
 
 	var poster = sps.PoStGenerator()
 
@@ -42,8 +43,9 @@ func (sps *StorageProvingSubsystem_I) CreateElectionPoStProof(challengeSeed abi.
 }
 
 // TODO also return error
-func (sps *StorageProvingSubsystem_I) GenerateSurprisePoStCandidates(challengeSeed abi.PoStRandomness, sectorIDs []abi.SectorID) []abi.PoStCandidate {
-	numChallengeTickets := util.UInt(len(sectorIDs) * node_base.SPOST_SAMPLE_RATE_NUM / node_base.SPOST_SAMPLE_RATE_DENOM)
+func (sps *StorageProvingSubsystem_I) GenerateSurprisePoStCandidates(epoch abi.ChainEpoch, challengeSeed abi.PoStRandomness, sectorIDs []abi.SectorID) []abi.PoStCandidate {
+	params := node_base.NetworkParamsAtEpoch(sps.NetworkParams(), epoch)
+	numChallengeTickets := util.UInt(len(sectorIDs) * params.SPoStSampleRateNum / params.SPoStSampleRateDenom)
 
 	var poster = sps.PoStGenerator()
 
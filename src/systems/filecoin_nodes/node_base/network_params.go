@@ -2,6 +2,7 @@ package node_base
 
 import (
 	addr "github.com/filecoin-project/go-address"
+	abi "github.com/filecoin-project/specs-actors/actors/abi"
 )
 
 // Parameters for on-chain calculations are in actors/builtin/network_params.go
@@ -26,3 +27,51 @@ const SPOST_SAMPLE_RATE_DENOM = 50 // placeholder
 
 const FINALITY = 500          // placeholder
 const SPC_LOOKBACK_TICKET = 1 // we chain blocks together one after the other
+
+/////////////////////////////////////////////////////////////
+// Network upgrades
+/////////////////////////////////////////////////////////////
+
+// NetworkVersion identifies a version of the protocol. It is zero from genesis,
+// and increases with each network upgrade (see vm/interpreter upgrades).
+type NetworkVersion int64
+
+const GenesisNetworkVersion = NetworkVersion(0)
+
+// The parameters above which may change at a network upgrade.
+// Subsystems should use the parameters in effect at the epoch in question.
+type NetworkParams struct {
+	SurpriseChallengeCount int
+	EPoStSampleRateNum     int
+	EPoStSampleRateDenom   int
+	SPoStSampleRateNum     int
+	SPoStSampleRateDenom   int
+	Finality               abi.ChainEpoch
+	SPCLookbackTicket      abi.ChainEpoch
+}
+
+// The network parameters in effect from genesis.
+var GenesisNetworkParams = &NetworkParams{
+	SurpriseChallengeCount: SURPRISE_CHALLENGE_COUNT,
+	EPoStSampleRateNum:     EPOST_SAMPLE_RATE_NUM,
+	EPoStSampleRateDenom:   EPOST_SAMPLE_RATE_DENOM,
+	SPoStSampleRateNum:     SPOST_SAMPLE_RATE_NUM,
+	SPoStSampleRateDenom:   SPOST_SAMPLE_RATE_DENOM,
+	Finality:               FINALITY,
+	SPCLookbackTicket:      SPC_LOOKBACK_TICKET,
+}
+
+// NetworkParamsSource provides the network parameters in effect at an epoch, under a node's upgrade
+// schedule. The VMInterpreter is one.
+type NetworkParamsSource interface {
+	NetworkParamsAtEpoch(epoch abi.ChainEpoch) *NetworkParams
+}
+
+// Returns the parameters from src in effect at an epoch, or the genesis parameters if src is nil. Callers
+// without a source pass nil itself, not a nil pointer of a source type.
+func NetworkParamsAtEpoch(src NetworkParamsSource, epoch abi.ChainEpoch) *NetworkParams {
+	if src == nil {
+		return GenesisNetworkParams
+	}
+	return src.NetworkParamsAtEpoch(epoch)
+}
@@ -0,0 +1,215 @@
+package interpreter
+
+import (
+	"sort"
+	"sync"
+
+	abi "github.com/filecoin-project/specs-actors/actors/abi"
+	ipld "github.com/filecoin-project/specs/libraries/ipld"
+	block "github.com/filecoin-project/specs/systems/filecoin_blockchain/struct/block"
+	chain "github.com/filecoin-project/specs/systems/filecoin_blockchain/struct/chain"
+	msg "github.com/filecoin-project/specs/systems/filecoin_vm/message"
+	vmri "github.com/filecoin-project/specs/systems/filecoin_vm/runtime/impl"
+	st "github.com/filecoin-project/specs/systems/filecoin_vm/state_tree"
+)
+
+// Receives actor events matching a subscription's filter.
+type EventSubscriber interface {
+	// Called for each matching event of a tipset added to the chain, in order of execution, with the
+	// tipset and the message (explicit or implicit) whose execution emitted it.
+	OnEvent(tipset chain.Tipset, message msg.UnsignedMessage, event vmri.ActorEvent)
+
+	// Called for each matching event of a tipset removed from the chain, in reverse order of execution.
+	// Each such event was previously passed to OnEvent.
+	OnRevert(tipset chain.Tipset, message msg.UnsignedMessage, event vmri.ActorEvent)
+}
+
+// Receives the receipts of each tipset the interpreter executes, including tipsets which are never
+// applied to the chain. The EventDispatcher is one.
+type TipSetEventSink interface {
+	TipSetExecuted(tipset chain.Tipset, msgs TipSetMessages, receipts []vmri.MessageReceipt, implicitReceipts []ImplicitReceipt)
+}
+
+type _eventSubscription struct {
+	filter     vmri.EventFilter
+	subscriber EventSubscriber
+}
+
+// The receipt of a message, explicit or implicit, executed in a tipset.
+type _tipSetReceipt struct {
+	message msg.UnsignedMessage
+	receipt vmri.MessageReceipt
+}
+
+type _executedTipSet struct {
+	tipset   chain.Tipset
+	receipts []_tipSetReceipt // In order of execution.
+}
+
+// EventDispatcher delivers the events committed by tipsets to subscribers, filtered by emitter and
+// topic, as the tipsets are added to and removed from the chain.
+// As the interpreter's EventSink, it records the receipts of each tipset executed; as a HeadChangeListener
+// of the node's fork choice, it dispatches the events of the tipsets reverted and applied by each head
+// change. Tipsets executed by another interpreter (e.g., before the node started) are not dispatched.
+type EventDispatcher struct {
+	_store         ipld.GraphStore
+	_lock          sync.Mutex
+	_nextID        int
+	_subscriptions map[int]_eventSubscription
+	_executed      map[string]_executedTipSet // By chain.TipsetKey.
+}
+
+func EventDispatcher_Make(store ipld.GraphStore) *EventDispatcher {
+	return &EventDispatcher{
+		_store:         store,
+		_subscriptions: make(map[int]_eventSubscription),
+		_executed:      make(map[string]_executedTipSet),
+	}
+}
+
+// Subscribes to events matching filter. The returned function cancels the subscription.
+// Subscribers are called in order of subscription.
+func (d *EventDispatcher) Subscribe(filter vmri.EventFilter, subscriber EventSubscriber) (unsubscribe func()) {
+	d._lock.Lock()
+	defer d._lock.Unlock()
+
+	id := d._nextID
+	d._nextID++
+	d._subscriptions[id] = _eventSubscription{filter, subscriber}
+
+	return func() {
+		d._lock.Lock()
+		defer d._lock.Unlock()
+		delete(d._subscriptions, id)
+	}
+}
+
+// Records the receipts of a tipset, as returned by ApplyTipSetMessages, to dispatch its events when
+// it is applied to or reverted from the chain. msgs are the tipset's messages, from which the
+// executed messages are matched with receipts.
+func (d *EventDispatcher) TipSetExecuted(tipset chain.Tipset, msgs TipSetMessages, receipts []vmri.MessageReceipt, implicitReceipts []ImplicitReceipt) {
+	blockMsgs := _executedBlockMessages(msgs)
+	// An Election PoSt and a block reward per block, then the cron tick.
+	Assert(len(implicitReceipts) == 2*len(blockMsgs)+1)
+
+	// Interleave the receipts in order of execution.
+	var ordered []_tipSetReceipt
+	addImplicit := func(r ImplicitReceipt) {
+		ordered = append(ordered, _tipSetReceipt{r.Message, r.Receipt})
+	}
+	next := 0
+	for i, executed := range blockMsgs {
+		addImplicit(implicitReceipts[2*i])
+		for _, m := range executed {
+			Assert(next < len(receipts))
+			ordered = append(ordered, _tipSetReceipt{m, receipts[next]})
+			next++
+		}
+		addImplicit(implicitReceipts[2*i+1])
+	}
+	Assert(next == len(receipts))
+	addImplicit(implicitReceipts[len(implicitReceipts)-1])
+
+	d._lock.Lock()
+	defer d._lock.Unlock()
+	d._executed[chain.TipsetKey(tipset.Blocks())] = _executedTipSet{tipset, ordered}
+}
+
+// Dispatches the events of the tipsets removed from the chain (latest first) to OnRevert, then those
+// of the tipsets added to it (earliest first) to OnEvent. The blocks are as passed by the fork choice:
+// those of each tipset together, in the tipset's order.
+func (d *EventDispatcher) HeadChange(reverted []block.Block, applied []block.Block, headState st.StateTree) {
+	d._lock.Lock()
+	subscriptions := d._sortedSubscriptions()
+	revertedTipSets := d._executedTipSets(reverted)
+	appliedTipSets := d._executedTipSets(applied)
+	d._lock.Unlock()
+
+	if len(subscriptions) == 0 {
+		return
+	}
+
+	for _, ts := range revertedTipSets {
+		for i := len(ts.receipts) - 1; i >= 0; i-- {
+			r := ts.receipts[i]
+			events := d._loadEvents(r.receipt)
+			for j := len(events) - 1; j >= 0; j-- {
+				for _, s := range subscriptions {
+					if s.filter.Matches(events[j]) {
+						s.subscriber.OnRevert(ts.tipset, r.message, events[j])
+					}
+				}
+			}
+		}
+	}
+	for _, ts := range appliedTipSets {
+		for _, r := range ts.receipts {
+			for _, event := range d._loadEvents(r.receipt) {
+				for _, s := range subscriptions {
+					if s.filter.Matches(event) {
+						s.subscriber.OnEvent(ts.tipset, r.message, event)
+					}
+				}
+			}
+		}
+	}
+}
+
+// Drops the receipts recorded for tipsets at epochs before the given epoch (e.g., those before
+// finality, past which the chain is not reorganized).
+func (d *EventDispatcher) Prune(before abi.ChainEpoch) {
+	d._lock.Lock()
+	defer d._lock.Unlock()
+	for id, ts := range d._executed {
+		if ts.tipset.Epoch() < before {
+			delete(d._executed, id)
+		}
+	}
+}
+
+// Must be called with the lock held.
+func (d *EventDispatcher) _sortedSubscriptions() []_eventSubscription {
+	var ids []int
+	for id := range d._subscriptions {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	ret := make([]_eventSubscription, 0, len(ids))
+	for _, id := range ids {
+		ret = append(ret, d._subscriptions[id])
+	}
+	return ret
+}
+
+// Returns the recorded tipsets of blocks, in order, skipping those not executed.
+// Must be called with the lock held.
+func (d *EventDispatcher) _executedTipSets(blocks []block.Block) []_executedTipSet {
+	var ret []_executedTipSet
+	for _, headers := range _blockTipSets(blocks) {
+		ts, found := d._executed[chain.TipsetKey(headers)]
+		if found {
+			ret = append(ret, ts)
+		}
+	}
+	return ret
+}
+
+func (d *EventDispatcher) _loadEvents(receipt vmri.MessageReceipt) []vmri.ActorEvent {
+	events, err := vmri.LoadEventsAMT(d._store, receipt.EventsRoot)
+	Assert(err == nil) // The events were stored during execution.
+	return events
+}
+
+// Groups the blocks of consecutive tipsets, as passed to HeadChange, by tipset. The tipsets of a
+// head change are at distinct epochs.
+func _blockTipSets(blocks []block.Block) [][]block.BlockHeader {
+	var ret [][]block.BlockHeader
+	for i, b := range blocks {
+		if i == 0 || b.Header().Epoch() != blocks[i-1].Header().Epoch() {
+			ret = append(ret, nil)
+		}
+		ret[len(ret)-1] = append(ret[len(ret)-1], b.Header())
+	}
+	return ret
+}
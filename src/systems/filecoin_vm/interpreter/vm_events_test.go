@@ -0,0 +1,154 @@
+package interpreter
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	addr "github.com/filecoin-project/go-address"
+	abi "github.com/filecoin-project/specs-actors/actors/abi"
+	builtin "github.com/filecoin-project/specs-actors/actors/builtin"
+	ipld "github.com/filecoin-project/specs/libraries/ipld"
+	block "github.com/filecoin-project/specs/systems/filecoin_blockchain/struct/block"
+	chain "github.com/filecoin-project/specs/systems/filecoin_blockchain/struct/chain"
+	actstate "github.com/filecoin-project/specs/systems/filecoin_vm/actor"
+	msg "github.com/filecoin-project/specs/systems/filecoin_vm/message"
+	vmri "github.com/filecoin-project/specs/systems/filecoin_vm/runtime/impl"
+)
+
+// Logs the topics of the events it receives, prefixed with its name.
+type _recordingSubscriber struct {
+	name string
+	log  *[]string
+}
+
+func (s _recordingSubscriber) OnEvent(tipset chain.Tipset, message msg.UnsignedMessage, event vmri.ActorEvent) {
+	*s.log = append(*s.log, s.name+" event "+string(event.Topic))
+}
+
+func (s _recordingSubscriber) OnRevert(tipset chain.Tipset, message msg.UnsignedMessage, event vmri.ActorEvent) {
+	*s.log = append(*s.log, s.name+" revert "+string(event.Topic))
+}
+
+// Returns a receipt committing an event of each topic, emitted by the storage market actor.
+func _eventsReceipt(store *ipld.MemGraphStore, topics ...string) vmri.MessageReceipt {
+	var events []vmri.ActorEvent
+	for _, topic := range topics {
+		events = append(events, vmri.ActorEvent{Emitter: builtin.StorageMarketActorAddr, Topic: vmri.EventTopic(topic)})
+	}
+	return vmri.MessageReceipt{EventsRoot: vmri.PutEventsAMT(store, events)}
+}
+
+// Records with d the execution of a one-block tipset at epoch with msgCount messages, and returns its blocks.
+// Each message emits an event with topic "<epoch>.<index>", the block reward one with topic "<epoch>.reward"
+// and the cron tick one with topic "<epoch>.cron". The Election PoSt emits none.
+func _executeTipSet(d *EventDispatcher, store *ipld.MemGraphStore, epoch abi.ChainEpoch, msgCount int) []block.Block {
+	var msgs []msg.UnsignedMessage
+	var receipts []vmri.MessageReceipt
+	for i := 0; i < msgCount; i++ {
+		msgs = append(msgs, &msg.UnsignedMessage_I{CallSeqNum_: actstate.CallSeqNum(i)})
+		receipts = append(receipts, _eventsReceipt(store, fmt.Sprintf("%d.%d", epoch, i)))
+	}
+	implicitReceipts := []ImplicitReceipt{
+		{Kind: ImplicitMessageKind_ElectionPoSt, Message: &msg.UnsignedMessage_I{}, Receipt: _eventsReceipt(store)},
+		{Kind: ImplicitMessageKind_BlockReward, Message: &msg.UnsignedMessage_I{}, Receipt: _eventsReceipt(store, fmt.Sprintf("%d.reward", epoch))},
+		{Kind: ImplicitMessageKind_CronTick, Message: &msg.UnsignedMessage_I{}, Receipt: _eventsReceipt(store, fmt.Sprintf("%d.cron", epoch))},
+	}
+
+	h := &block.BlockHeader_I{Epoch_: epoch}
+	ts := &chain.Tipset_I{Blocks_: []block.BlockHeader{h}, Epoch_: epoch}
+	tsMsgs := &TipSetMessages_I{Blocks_: []BlockMessages{&BlockMessages_I{BLSMessages_: msgs}}, Epoch_: UInt64(epoch)}
+	d.TipSetExecuted(ts, tsMsgs, receipts, implicitReceipts)
+	return []block.Block{&block.Block_I{Header_: h, BLSMessages_: msgs}}
+}
+
+func TestDispatchOnHeadChange(t *testing.T) {
+	store := ipld.MemGraphStore_Make()
+	d := EventDispatcher_Make(store)
+	var log []string
+	d.Subscribe(vmri.EventFilter{}, _recordingSubscriber{"s", &log})
+
+	ts1 := _executeTipSet(d, store, 1, 2)
+	ts2 := _executeTipSet(d, store, 2, 1)
+	fork := _executeTipSet(d, store, 3, 1)
+	_executeTipSet(d, store, 4, 1) // Executed, but never applied.
+	if len(log) != 0 {
+		t.Fatalf("events dispatched on execution: %v", log)
+	}
+
+	d.HeadChange(nil, append(append([]block.Block{}, ts1...), ts2...), nil)
+	want := []string{
+		"s event 1.0", "s event 1.1", "s event 1.reward", "s event 1.cron",
+		"s event 2.0", "s event 2.reward", "s event 2.cron",
+	}
+	if !reflect.DeepEqual(log, want) {
+		t.Fatalf("events %v, want %v", log, want)
+	}
+
+	// A reorganization reverts the tipset at epoch 2, latest event first.
+	log = nil
+	d.HeadChange(ts2, fork, nil)
+	want = []string{
+		"s revert 2.cron", "s revert 2.reward", "s revert 2.0",
+		"s event 3.0", "s event 3.reward", "s event 3.cron",
+	}
+	if !reflect.DeepEqual(log, want) {
+		t.Fatalf("events %v, want %v", log, want)
+	}
+}
+
+func TestDispatchSkipsUnexecutedTipSets(t *testing.T) {
+	store := ipld.MemGraphStore_Make()
+	d := EventDispatcher_Make(store)
+	var log []string
+	d.Subscribe(vmri.EventFilter{}, _recordingSubscriber{"s", &log})
+
+	unexecuted := []block.Block{&block.Block_I{Header_: &block.BlockHeader_I{Epoch_: 1}}}
+	ts2 := _executeTipSet(d, store, 2, 1)
+	d.HeadChange(nil, append(unexecuted, ts2...), nil)
+	want := []string{"s event 2.0", "s event 2.reward", "s event 2.cron"}
+	if !reflect.DeepEqual(log, want) {
+		t.Errorf("events %v, want %v", log, want)
+	}
+
+	// Pruned tipsets are no longer dispatched.
+	log = nil
+	d.Prune(3)
+	d.HeadChange(ts2, nil, nil)
+	if len(log) != 0 {
+		t.Errorf("events of a pruned tipset dispatched: %v", log)
+	}
+}
+
+func TestDispatchSubscriptions(t *testing.T) {
+	store := ipld.MemGraphStore_Make()
+	d := EventDispatcher_Make(store)
+	var log []string
+	for i := 0; i < 10; i++ {
+		d.Subscribe(vmri.EventFilter{Topics: []vmri.EventTopic{"1.0"}}, _recordingSubscriber{fmt.Sprint(i), &log})
+	}
+	unsubscribe := d.Subscribe(vmri.EventFilter{}, _recordingSubscriber{"cancelled", &log})
+	unsubscribe()
+	d.Subscribe(vmri.EventFilter{Emitters: []addr.Address{builtin.StoragePowerActorAddr}}, _recordingSubscriber{"power", &log})
+
+	ts := _executeTipSet(d, store, 1, 1)
+	d.HeadChange(nil, ts, nil)
+	var want []string
+	for i := 0; i < 10; i++ {
+		want = append(want, fmt.Sprintf("%d event 1.0", i))
+	}
+	if !reflect.DeepEqual(log, want) {
+		t.Errorf("events %v, want %v", log, want)
+	}
+
+	// Reverted events are also passed to subscribers in order of subscription.
+	log = nil
+	d.HeadChange(ts, nil, nil)
+	want = nil
+	for i := 0; i < 10; i++ {
+		want = append(want, fmt.Sprintf("%d revert 1.0", i))
+	}
+	if !reflect.DeepEqual(log, want) {
+		t.Errorf("events %v, want %v", log, want)
+	}
+}
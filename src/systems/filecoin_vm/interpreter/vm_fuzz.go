@@ -0,0 +1,270 @@
+package interpreter
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+
+	addr "github.com/filecoin-project/go-address"
+	abi "github.com/filecoin-project/specs-actors/actors/abi"
+	builtin "github.com/filecoin-project/specs-actors/actors/builtin"
+	ipld "github.com/filecoin-project/specs/libraries/ipld"
+	chain "github.com/filecoin-project/specs/systems/filecoin_blockchain/struct/chain"
+	actstate "github.com/filecoin-project/specs/systems/filecoin_vm/actor"
+	msg "github.com/filecoin-project/specs/systems/filecoin_vm/message"
+	st "github.com/filecoin-project/specs/systems/filecoin_vm/state_tree"
+	util "github.com/filecoin-project/specs/util"
+	cid "github.com/ipfs/go-cid"
+)
+
+var (
+	ErrFuzzBlockNotFound = errors.New("Fuzz fixture block not found in store")
+)
+
+// Parameters of a fuzzing run.
+type FuzzConfig struct {
+	Seed int64
+	// A funded account (in the base state) from which the harness creates and funds new accounts.
+	Faucet addr.Address
+	// Number of accounts created, each funded with a random amount up to MaxFunding.
+	NumAccounts int
+	MaxFunding  abi.TokenAmount
+	// Number of random messages applied among the faucet and created accounts.
+	NumMessages int
+	MaxGasLimit int
+	MaxGasPrice abi.TokenAmount
+	MinerAddr   addr.Address
+}
+
+// The result of a fuzzing run which found an invariant violation.
+type FuzzFailure struct {
+	// Minimized fixture, whose final message violates the invariant.
+	Fixture   FuzzFixture
+	Violation *InvariantViolation
+}
+
+func (f *FuzzFailure) String() string {
+	return fmt.Sprintf("seed %v: %v after %v messages", f.Fixture.Seed(), f.Violation.Error(), len(f.Fixture.Messages()))
+}
+
+// Applies a random sequence of explicit messages to baseTree, checking CheckMessageInvariants after
+// each message. The sequence first creates and funds random accounts from the faucet, building a
+// random state, then sends random messages among them: value transfers of random (possibly excessive)
+// amounts, to existing and new addresses, with random gas limits and prices, and occasionally
+// out-of-order CallSeqNums.
+//
+// Runs are deterministic given the configuration and base state. Returns nil if no invariant was
+// violated, or a failure whose fixture has been minimized with MinimizeFuzzFixture, along with the
+// number of messages whose application completed (rather than being skipped, as behavior not yet
+// implemented in the spec). A run which applied no message checked no invariant.
+func (vmi *VMInterpreter_I) FuzzMessages(baseTree st.StateTree, chain chain.Chain, config FuzzConfig) (failure *FuzzFailure, numApplied int) {
+	r := rand.New(rand.NewSource(config.Seed))
+	store := vmi.Node().Repository().StateStore()
+	resolver := vmi.AddressResolver()
+
+	tree := baseTree
+	var applied []msg.UnsignedMessage
+	accounts := []addr.Address{config.Faucet}
+
+	apply := func(m msg.UnsignedMessage) *InvariantViolation {
+		applied = append(applied, m)
+		var completed bool
+		var violation *InvariantViolation
+		tree, completed, violation = vmi._applyMessageChecked(tree, chain, m, config.MinerAddr)
+		if completed {
+			numApplied++
+		}
+		return violation
+	}
+
+	nextCallSeqNum := func(a addr.Address) actstate.CallSeqNum {
+		act, found := tree.GetActor(_resolveSender(store, resolver, tree, a))
+		if !found {
+			return 0
+		}
+		return act.CallSeqNum()
+	}
+
+	var violation *InvariantViolation
+	for i := 0; i < config.NumAccounts && violation == nil; i++ {
+		account := _randomAccountAddress(r)
+		accounts = append(accounts, account)
+		violation = apply(&msg.UnsignedMessage_I{
+			From_:       config.Faucet,
+			To_:         account,
+			Method_:     builtin.MethodSend,
+			CallSeqNum_: nextCallSeqNum(config.Faucet),
+			Value_:      _randomTokenAmount(r, config.MaxFunding),
+			GasPrice_:   _randomTokenAmount(r, config.MaxGasPrice),
+			GasLimit_:   msg.GasAmount_FromInt(config.MaxGasLimit),
+		})
+	}
+
+	for i := 0; i < config.NumMessages && violation == nil; i++ {
+		from := accounts[r.Intn(len(accounts))]
+		to := accounts[r.Intn(len(accounts))]
+		if r.Intn(8) == 0 {
+			to = _randomAccountAddress(r)
+		}
+
+		callSeqNum := nextCallSeqNum(from)
+		if r.Intn(16) == 0 {
+			// A stale or future CallSeqNum.
+			if callSeqNum > 0 && r.Intn(2) == 0 {
+				callSeqNum--
+			} else {
+				callSeqNum++
+			}
+		}
+
+		// Spend up to (and occasionally beyond) the sender's balance.
+		maxValue := abi.TokenAmount(0)
+		if act, found := tree.GetActor(_resolveSender(store, resolver, tree, from)); found {
+			maxValue = _overspendAmount(act.Balance())
+		}
+
+		violation = apply(&msg.UnsignedMessage_I{
+			From_:       from,
+			To_:         to,
+			Method_:     builtin.MethodSend,
+			CallSeqNum_: callSeqNum,
+			Value_:      _randomTokenAmount(r, maxValue),
+			GasPrice_:   _randomTokenAmount(r, config.MaxGasPrice),
+			GasLimit_:   msg.GasAmount_FromInt(r.Intn(config.MaxGasLimit + 1)),
+		})
+	}
+
+	if violation == nil {
+		return nil, numApplied
+	}
+
+	fixture := &FuzzFixture_I{
+		BaseStateRoot_: baseTree.RootCID(),
+		Messages_:      applied,
+		MinerAddr_:     config.MinerAddr,
+		Seed_:          config.Seed,
+		Invariant_:     violation.Invariant,
+	}
+	return vmi.MinimizeFuzzFixture(baseTree, chain, fixture), numApplied
+}
+
+// Removes messages from a failing fixture while its final message still violates the same invariant,
+// until no single message can be removed. Returns nil if the fixture does not fail.
+func (vmi *VMInterpreter_I) MinimizeFuzzFixture(baseTree st.StateTree, chain chain.Chain, fixture FuzzFixture) *FuzzFailure {
+	messages := fixture.Messages()
+	violation := vmi._runFuzzMessages(baseTree, chain, messages, fixture.MinerAddr())
+	if violation == nil || violation.Invariant != fixture.Invariant() {
+		return nil
+	}
+
+	for i := 0; i < len(messages); {
+		candidate := append(append([]msg.UnsignedMessage{}, messages[:i]...), messages[i+1:]...)
+		v := vmi._runFuzzMessages(baseTree, chain, candidate, fixture.MinerAddr())
+		if v != nil && v.Invariant == fixture.Invariant() {
+			messages, violation = candidate, v
+		} else {
+			i++
+		}
+	}
+
+	return &FuzzFailure{
+		Fixture: &FuzzFixture_I{
+			BaseStateRoot_: fixture.BaseStateRoot(),
+			Messages_:      messages,
+			MinerAddr_:     fixture.MinerAddr(),
+			Seed_:          fixture.Seed(),
+			Invariant_:     fixture.Invariant(),
+		},
+		Violation: violation,
+	}
+}
+
+// Re-applies a recorded fixture to its base state, which must be available in the node's state
+// store. Returns nil if no invariant is violated.
+func (vmi *VMInterpreter_I) ReproduceFuzzFixture(chain chain.Chain, fixture FuzzFixture) (*InvariantViolation, error) {
+	baseTree, err := _loadStateTree(vmi.Node().Repository().StateStore(), fixture.BaseStateRoot())
+	if err != nil {
+		return nil, err
+	}
+	return vmi._runFuzzMessages(baseTree, chain, fixture.Messages(), fixture.MinerAddr()), nil
+}
+
+func SaveFuzzFixture(store ipld.GraphStore, fixture FuzzFixture) cid.Cid {
+	return store.Put(Bytes(Serialize_FuzzFixture(fixture)))
+}
+
+func LoadFuzzFixture(store ipld.GraphStore, root cid.Cid) (FuzzFixture, error) {
+	serialized, ok := store.Get(root)
+	if !ok {
+		return nil, ErrFuzzBlockNotFound
+	}
+	return Deserialize_FuzzFixture(util.Serialization(serialized))
+}
+
+// Applies messages in order, stopping at the first invariant violation.
+func (vmi *VMInterpreter_I) _runFuzzMessages(baseTree st.StateTree, chain chain.Chain, messages []msg.UnsignedMessage, minerAddr addr.Address) *InvariantViolation {
+	tree := baseTree
+	for _, m := range messages {
+		var violation *InvariantViolation
+		tree, _, violation = vmi._applyMessageChecked(tree, chain, m, minerAddr)
+		if violation != nil {
+			return violation
+		}
+	}
+	return nil
+}
+
+// Applies an explicit message and checks the invariants of its application. A panic in the
+// interpreter is reported as a violation, unless it marks behavior not yet implemented in the spec,
+// in which case the message is skipped (leaving the state unchanged, and completed false).
+func (vmi *VMInterpreter_I) _applyMessageChecked(inTree st.StateTree, chain chain.Chain, message msg.UnsignedMessage, minerAddr addr.Address) (
+	outTree st.StateTree, completed bool, violation *InvariantViolation) {
+
+	defer func() {
+		if r := recover(); r != nil {
+			outTree, completed, violation = inTree, false, _panicViolation(r)
+		}
+	}()
+
+	onChainSize := len(msg.Serialize_UnsignedMessage(message))
+	outTree, receipt, minerPenalty, minerGasReward, _ := vmi.ApplyMessage(inTree, chain, message, onChainSize, minerAddr)
+	violation = CheckMessageInvariants(inTree, outTree, message, receipt, minerPenalty, minerGasReward)
+	return outTree, true, violation
+}
+
+// Returns the violation reported for a panic recovered from the interpreter, or nil if the panic
+// marks behavior not yet implemented in the spec.
+func _panicViolation(r interface{}) *InvariantViolation {
+	if util.IsUnimplementedPanic(r) {
+		return nil
+	}
+	return &InvariantViolation{Invariant_NoInterpreterPanic, fmt.Sprintf("%v", r)}
+}
+
+func _randomAccountAddress(r *rand.Rand) addr.Address {
+	pubkey := make([]byte, 65)
+	r.Read(pubkey)
+	ret, err := addr.NewSecp256k1Address(pubkey)
+	Assert(err == nil)
+	return ret
+}
+
+// Returns a random amount in [0, max].
+func _randomTokenAmount(r *rand.Rand, max abi.TokenAmount) abi.TokenAmount {
+	if max <= 0 {
+		return 0
+	}
+	if int64(max) == math.MaxInt64 {
+		return abi.TokenAmount(r.Int63())
+	}
+	return abi.TokenAmount(r.Int63n(int64(max) + 1))
+}
+
+// Returns an amount one eighth more than balance, or the largest amount if that is out of range.
+func _overspendAmount(balance abi.TokenAmount) abi.TokenAmount {
+	if balance > math.MaxInt64-balance/8 {
+		return math.MaxInt64
+	}
+	return balance + balance/8
+}
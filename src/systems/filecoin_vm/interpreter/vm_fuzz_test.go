@@ -0,0 +1,110 @@
+package interpreter
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	addr "github.com/filecoin-project/go-address"
+	abi "github.com/filecoin-project/specs-actors/actors/abi"
+	ipld "github.com/filecoin-project/specs/libraries/ipld"
+	chain "github.com/filecoin-project/specs/systems/filecoin_blockchain/struct/chain"
+	node_base "github.com/filecoin-project/specs/systems/filecoin_nodes/node_base"
+	repo "github.com/filecoin-project/specs/systems/filecoin_nodes/repository"
+	st "github.com/filecoin-project/specs/systems/filecoin_vm/state_tree"
+	util "github.com/filecoin-project/specs/util"
+)
+
+// Resolves ID addresses to themselves, leaving all other addresses unresolved, so that the accounts the
+// harness creates are new to each message.
+type _idOnlyResolver struct{}
+
+func (_idOnlyResolver) ResolveAddress(tree st.StateTree, a addr.Address) (addr.Address, bool) {
+	return a, a.Protocol() == addr.ID
+}
+
+func (_idOnlyResolver) RobustAddress(tree st.StateTree, id addr.Address) (addr.Address, bool) {
+	return addr.Undef, false
+}
+
+func _fuzzInterpreter() *VMInterpreter_I {
+	return &VMInterpreter_I{
+		Node_:            &node_base.FilecoinNode_I{Repository_: &repo.Repository_I{StateStore_: ipld.MemGraphStore_Make()}},
+		AddressResolver_: _idOnlyResolver{},
+	}
+}
+
+func TestRandomTokenAmount(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for _, max := range []abi.TokenAmount{-1, 0, 1, 10, math.MaxInt64 - 1, math.MaxInt64} {
+		for i := 0; i < 100; i++ {
+			if v := _randomTokenAmount(r, max); v < 0 || (max >= 0 && v > max) || (max < 0 && v != 0) {
+				t.Fatalf("_randomTokenAmount(%d) = %d, out of range", max, v)
+			}
+		}
+	}
+}
+
+func TestOverspendAmount(t *testing.T) {
+	cases := []struct {
+		balance abi.TokenAmount
+		amount  abi.TokenAmount
+	}{
+		{0, 0},
+		{800, 900},
+		{math.MaxInt64 / 9 * 8, math.MaxInt64 / 9 * 9},
+		{math.MaxInt64 - 1, math.MaxInt64},
+		{math.MaxInt64, math.MaxInt64},
+	}
+	for _, c := range cases {
+		if got := _overspendAmount(c.balance); got != c.amount {
+			t.Errorf("_overspendAmount(%d) = %d, want %d", c.balance, got, c.amount)
+		}
+	}
+}
+
+func TestPanicViolation(t *testing.T) {
+	recovered := func(f func()) (r interface{}) {
+		defer func() { r = recover() }()
+		f()
+		return nil
+	}
+
+	for _, f := range []func(){func() { util.TODO() }, func() { util.IMPL_TODO() }, func() { util.IMPL_FINISH() }} {
+		if v := _panicViolation(recovered(f)); v != nil {
+			t.Errorf("unimplemented behavior reported as %v", v.Error())
+		}
+	}
+	if v := _panicViolation(recovered(func() { util.Assert(false) })); v == nil || v.Invariant != Invariant_NoInterpreterPanic {
+		t.Errorf("failed assertion reported as %v, want %v", v, Invariant_NoInterpreterPanic)
+	}
+}
+
+// Runs the harness on the fixture state, with the account at ID 100 as the faucet, for several seeds.
+// The test is skipped if message application is not yet implemented far enough for any message to
+// be applied, as no invariant is then checked.
+func TestFuzzMessages(t *testing.T) {
+	vmi := _fuzzInterpreter()
+	head := &chain.Chain_I{HeadTipset_: &chain.Tipset_I{Epoch_: 1}}
+	totalApplied := 0
+	for seed := int64(0); seed < 8; seed++ {
+		config := FuzzConfig{
+			Seed:        seed,
+			Faucet:      _idAddr(t, 100),
+			NumAccounts: 4,
+			MaxFunding:  100,
+			NumMessages: 32,
+			MaxGasLimit: 1000,
+			MaxGasPrice: 2,
+			MinerAddr:   _idAddr(t, 101),
+		}
+		failure, numApplied := vmi.FuzzMessages(_fixtureStateTree(t), head, config)
+		if failure != nil {
+			t.Errorf("%v", failure.String())
+		}
+		totalApplied += numApplied
+	}
+	if totalApplied == 0 && !t.Failed() {
+		t.Skip("no message was applied: message application is not yet implemented")
+	}
+}
@@ -0,0 +1,332 @@
+package interpreter
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+
+	addr "github.com/filecoin-project/go-address"
+	abi "github.com/filecoin-project/specs-actors/actors/abi"
+	serde "github.com/filecoin-project/specs-actors/actors/serde"
+	ipld "github.com/filecoin-project/specs/libraries/ipld"
+	vmri "github.com/filecoin-project/specs/systems/filecoin_vm/runtime/impl"
+	st "github.com/filecoin-project/specs/systems/filecoin_vm/state_tree"
+	cid "github.com/ipfs/go-cid"
+	cbornode "github.com/ipfs/go-ipld-cbor"
+)
+
+var (
+	ErrActorStateNotFound   = errors.New("Actor state not found in store")
+	ErrInspectBlockNotFound = errors.New("Inspected block not found in store")
+	ErrInspectMalformedLink = errors.New("Inspected link is not a CID")
+	ErrInspectMalformedHAMT = errors.New("Malformed HAMT node")
+	ErrInspectMalformedAMT  = ipld.ErrAMTMalformed
+)
+
+// Provides the substate type of actors by code ID. Implemented by ActorCodeRegistry.
+type ActorStateTypes interface {
+	NewActorState(id abi.ActorCodeID) (interface{}, error)
+}
+
+var _ ActorStateTypes = &ActorCodeRegistry{}
+
+// ActorStateInspector renders the state of an actor as JSON, for debugging and inspection tools.
+//
+// An actor's substate is decoded into the type registered for its code ID. Links in the substate
+// are expanded recursively, up to a given depth, by decoding the linked blocks as generic IPLD data.
+// A link is rendered as {"/": cid}, with its expansion (if any) under "value". A link to the root of a
+// HAMT or an AMT is instead expanded into the collection's logical entries, under "entries": a map from
+// the (hex-encoded) keys of a HAMT, or from the indices of an AMT, to the values stored there. The
+// trie's internal nodes are not rendered and do not count towards the depth.
+type ActorStateInspector struct {
+	_store      ipld.GraphStore
+	_stateTypes ActorStateTypes
+	_resolver   vmri.AddressResolver
+}
+
+func ActorStateInspector_Make(store ipld.GraphStore, stateTypes ActorStateTypes, resolver vmri.AddressResolver) *ActorStateInspector {
+	return &ActorStateInspector{
+		_store:      store,
+		_stateTypes: stateTypes,
+		_resolver:   resolver,
+	}
+}
+
+// Returns an inspector for the node's state store, decoding substates with the interpreter's actor code
+// loader if it provides state types, and the builtin actor code registry otherwise, and resolving
+// addresses with the interpreter's AddressResolver.
+func (vmi *VMInterpreter_I) ActorStateInspector() *ActorStateInspector {
+	stateTypes, ok := vmi.ActorCodeLoader().(ActorStateTypes)
+	if !ok {
+		stateTypes = _builtinActorCodeRegistry
+	}
+	return ActorStateInspector_Make(vmi.Node().Repository().StateStore(), stateTypes, vmi.AddressResolver())
+}
+
+// Renders the state of the actor with address a (resolved through the InitActor if not an ID address)
+// in the state tree with the given root, expanding links up to depth levels deep.
+func (i *ActorStateInspector) InspectActor(stateRoot cid.Cid, a addr.Address, depth int) (Bytes, error) {
+	tree, err := _loadStateTree(i._store, stateRoot)
+	if err != nil {
+		return nil, err
+	}
+	return i._inspectActor(tree, a, depth)
+}
+
+func (i *ActorStateInspector) _inspectActor(tree st.StateTree, a addr.Address, depth int) (Bytes, error) {
+	idAddr, found := i._resolver.ResolveAddress(tree, a)
+	if !found {
+		return nil, ErrActorNotFound
+	}
+	act, found := tree.GetActor(idAddr)
+	if !found {
+		return nil, ErrActorNotFound
+	}
+
+	substate, err := i._stateTypes.NewActorState(act.CodeID())
+	if err != nil {
+		return nil, err
+	}
+	serialized, ok := i._store.Get(cid.Cid(act.State()))
+	if !ok {
+		return nil, ErrActorStateNotFound
+	}
+	if err := serde.Deserialize(serialized, substate); err != nil {
+		return nil, err
+	}
+
+	return json.MarshalIndent(map[string]interface{}{
+		"Address":    idAddr.String(),
+		"Code":       i._render(reflect.ValueOf(act.CodeID()), 0),
+		"Balance":    act.Balance(),
+		"CallSeqNum": act.CallSeqNum(),
+		"State": map[string]interface{}{
+			"/":     cid.Cid(act.State()).String(),
+			"value": i._render(reflect.ValueOf(substate), depth),
+		},
+	}, "", "  ")
+}
+
+var (
+	_cidType     = reflect.TypeOf(cid.Cid{})
+	_addressType = reflect.TypeOf(addr.Address{})
+)
+
+// Converts a decoded value to a JSON-encodable form, expanding links up to depth.
+func (i *ActorStateInspector) _render(v reflect.Value, depth int) interface{} {
+	if !v.IsValid() {
+		return nil
+	}
+
+	t := v.Type()
+	switch {
+	case t.ConvertibleTo(_cidType) && t.Kind() == reflect.Struct:
+		return i._renderLink(v.Convert(_cidType).Interface().(cid.Cid), depth)
+	case t == _addressType:
+		return v.Interface().(addr.Address).String()
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return i._render(v.Elem(), depth)
+
+	case reflect.Struct:
+		ret := make(map[string]interface{})
+		for f := 0; f < t.NumField(); f++ {
+			if t.Field(f).PkgPath == "" { // Exported fields only.
+				ret[t.Field(f).Name] = i._render(v.Field(f), depth)
+			}
+		}
+		return ret
+
+	case reflect.Map:
+		ret := make(map[string]interface{})
+		for _, k := range v.MapKeys() {
+			ret[fmt.Sprint(i._render(k, 0))] = i._render(v.MapIndex(k), depth)
+		}
+		return ret
+
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return fmt.Sprintf("%x", v.Interface())
+		}
+		ret := make([]interface{}, v.Len())
+		for j := range ret {
+			ret[j] = i._render(v.Index(j), depth)
+		}
+		return ret
+
+	default:
+		return v.Interface()
+	}
+}
+
+// Renders a value decoded from generic IPLD data, in which links are represented as cid.Cid.
+func (i *ActorStateInspector) _renderGeneric(x interface{}, depth int) interface{} {
+	switch x := x.(type) {
+	case cid.Cid:
+		return i._renderLink(x, depth)
+	case []byte:
+		return fmt.Sprintf("%x", x)
+	case map[string]interface{}:
+		ret := make(map[string]interface{}, len(x))
+		for k, v := range x {
+			ret[k] = i._renderGeneric(v, depth)
+		}
+		return ret
+	case map[interface{}]interface{}:
+		ret := make(map[string]interface{}, len(x))
+		for k, v := range x {
+			ret[fmt.Sprint(i._renderGeneric(k, 0))] = i._renderGeneric(v, depth)
+		}
+		return ret
+	case []interface{}:
+		ret := make([]interface{}, len(x))
+		for j, v := range x {
+			ret[j] = i._renderGeneric(v, depth)
+		}
+		return ret
+	default:
+		return x
+	}
+}
+
+func (i *ActorStateInspector) _renderLink(c cid.Cid, depth int) interface{} {
+	if !c.Defined() {
+		return nil
+	}
+	ret := map[string]interface{}{"/": c.String()}
+	if depth <= 0 {
+		return ret
+	}
+
+	serialized, ok := i._store.Get(c)
+	if !ok {
+		return ret
+	}
+	var value interface{}
+	if err := cbornode.DecodeInto(serialized, &value); err != nil {
+		ret["error"] = err.Error()
+		return ret
+	}
+
+	var entries map[string]interface{}
+	var err error
+	switch {
+	case ipld.IsAMTRoot(value):
+		entries, err = i._amtEntries(value, depth-1)
+	case _isHAMTNode(value):
+		entries = make(map[string]interface{})
+		err = i._hamtEntries(value.([]interface{}), depth-1, entries)
+	default:
+		ret["value"] = i._renderGeneric(value, depth-1)
+		return ret
+	}
+	if err != nil {
+		ret["error"] = err.Error()
+		return ret
+	}
+	ret["entries"] = entries
+	return ret
+}
+
+// Returns the entries of the AMT with the given (decoded) root, keyed by their (decimal) indices.
+func (i *ActorStateInspector) _amtEntries(root interface{}, depth int) (map[string]interface{}, error) {
+	values, err := ipld.AMTEntries(i._store, root)
+	if err == ipld.ErrAMTNodeNotFound {
+		return nil, ErrInspectBlockNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	ret := make(map[string]interface{}, len(values))
+	for index, v := range values {
+		ret[fmt.Sprint(index)] = i._renderGeneric(v, depth)
+	}
+	return ret, nil
+}
+
+// A HAMT node is encoded as [bitfield, pointers], where each pointer is either {"0": link}, to a
+// child node, or {"1": [[key, value], ...]}, a bucket of entries.
+func _isHAMTNode(x interface{}) bool {
+	node, ok := x.([]interface{})
+	if !ok || len(node) != 2 {
+		return false
+	}
+	if _, ok := node[0].([]byte); !ok {
+		return false
+	}
+	pointers, ok := node[1].([]interface{})
+	if !ok {
+		return false
+	}
+	for _, p := range pointers {
+		pointer, ok := p.(map[string]interface{})
+		if !ok || len(pointer) != 1 {
+			return false
+		}
+		if _, isLink := pointer["0"]; !isLink {
+			if _, isBucket := pointer["1"]; !isBucket {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Adds the entries of the HAMT rooted at node to out, keyed by their hex-encoded keys.
+func (i *ActorStateInspector) _hamtEntries(node []interface{}, depth int, out map[string]interface{}) error {
+	for _, p := range node[1].([]interface{}) {
+		pointer := p.(map[string]interface{})
+		if link, isLink := pointer["0"]; isLink {
+			child, err := i._loadGeneric(link)
+			if err != nil {
+				return err
+			}
+			if !_isHAMTNode(child) {
+				return ErrInspectMalformedHAMT
+			}
+			if err := i._hamtEntries(child.([]interface{}), depth, out); err != nil {
+				return err
+			}
+			continue
+		}
+
+		bucket, ok := pointer["1"].([]interface{})
+		if !ok {
+			return ErrInspectMalformedHAMT
+		}
+		for _, e := range bucket {
+			kv, ok := e.([]interface{})
+			if !ok || len(kv) != 2 {
+				return ErrInspectMalformedHAMT
+			}
+			key, ok := kv[0].([]byte)
+			if !ok {
+				return ErrInspectMalformedHAMT
+			}
+			out[fmt.Sprintf("%x", key)] = i._renderGeneric(kv[1], depth)
+		}
+	}
+	return nil
+}
+
+// Loads and decodes the block with the given link as generic IPLD data.
+func (i *ActorStateInspector) _loadGeneric(link interface{}) (interface{}, error) {
+	c, ok := link.(cid.Cid)
+	if !ok {
+		return nil, ErrInspectMalformedLink
+	}
+	serialized, ok := i._store.Get(c)
+	if !ok {
+		return nil, ErrInspectBlockNotFound
+	}
+	var ret interface{}
+	if err := cbornode.DecodeInto(serialized, &ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
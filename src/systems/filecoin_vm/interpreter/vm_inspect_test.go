@@ -0,0 +1,245 @@
+package interpreter
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+
+	addr "github.com/filecoin-project/go-address"
+	actor "github.com/filecoin-project/specs-actors/actors"
+	abi "github.com/filecoin-project/specs-actors/actors/abi"
+	builtin "github.com/filecoin-project/specs-actors/actors/builtin"
+	ipld "github.com/filecoin-project/specs/libraries/ipld"
+	actstate "github.com/filecoin-project/specs/systems/filecoin_vm/actor"
+	st "github.com/filecoin-project/specs/systems/filecoin_vm/state_tree"
+	cid "github.com/ipfs/go-cid"
+	cbornode "github.com/ipfs/go-ipld-cbor"
+)
+
+// The substate inspected by the tests, registered for the multisig actor code. Its links are optional,
+// as an undefined CID cannot be serialized.
+type _inspectedState struct {
+	Owner addr.Address
+	Count uint64
+	Data  []byte
+	Link  *cid.Cid
+	AMT   *cid.Cid
+	HAMT  *cid.Cid
+}
+
+func init() {
+	cbornode.RegisterCborType(_inspectedState{})
+}
+
+// Resolves ID addresses to themselves, and robust addresses through a map.
+type _mapResolver map[addr.Address]addr.Address
+
+func (r _mapResolver) ResolveAddress(tree st.StateTree, a addr.Address) (addr.Address, bool) {
+	if a.Protocol() == addr.ID {
+		return a, true
+	}
+	id, found := r[a]
+	if !found {
+		return a, false
+	}
+	return id, true
+}
+
+func (r _mapResolver) RobustAddress(tree st.StateTree, id addr.Address) (addr.Address, bool) {
+	return addr.Undef, false
+}
+
+func _link(c cid.Cid) *cid.Cid {
+	return &c
+}
+
+func _putGeneric(store ipld.GraphStore, x interface{}) cid.Cid {
+	serialized, err := cbornode.DumpObject(x)
+	if err != nil {
+		panic(err)
+	}
+	return store.Put(serialized)
+}
+
+// Returns the state tree holding a multisig actor at ID 100, with the given substate, and an inspector
+// which resolves robust to it.
+func _inspectFixture(t *testing.T, store *ipld.MemGraphStore, robust addr.Address, state _inspectedState) (st.StateTree, *ActorStateInspector) {
+	id := _idAddr(t, 100)
+	tree := &st.StateTree_I{ActorStates_: map[addr.Address]actstate.ActorState{
+		id: &actstate.ActorState_I{
+			CodeID_:     builtin.MultisigActorCodeID,
+			State_:      actor.ActorSubstateCID(_putGeneric(store, state)),
+			Balance_:    abi.TokenAmount(5),
+			CallSeqNum_: 3,
+		},
+	}}
+	registry := ActorCodeRegistry_Make()
+	registry.RegisterActorState(builtin.MultisigActorCodeID, _inspectedState{})
+	return tree, ActorStateInspector_Make(store, registry, _mapResolver{robust: id})
+}
+
+// Inspects the actor at a and returns the decoded JSON rendering of its substate.
+func _inspectState(t *testing.T, i *ActorStateInspector, tree st.StateTree, a addr.Address, depth int) map[string]interface{} {
+	t.Helper()
+	rendered, err := i._inspectActor(tree, a, depth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ret map[string]interface{}
+	if err := json.Unmarshal(rendered, &ret); err != nil {
+		t.Fatal(err)
+	}
+	return ret["State"].(map[string]interface{})["value"].(map[string]interface{})
+}
+
+// Returns the JSON rendering of a link, with the given expansion under key (if any).
+func _renderedLink(c cid.Cid, key string, expansion interface{}) map[string]interface{} {
+	ret := map[string]interface{}{"/": c.String()}
+	if key != "" {
+		ret[key] = expansion
+	}
+	return ret
+}
+
+func TestInspectActorRendersRegisteredState(t *testing.T) {
+	store := ipld.MemGraphStore_Make()
+	robust, err := addr.NewActorAddress([]byte("inspected"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	owner := _idAddr(t, 101)
+	linked := _putGeneric(store, map[string]interface{}{"a": "b"})
+	tree, inspector := _inspectFixture(t, store, robust, _inspectedState{Owner: owner, Count: 7, Data: []byte{0xab, 0xcd}, Link: _link(linked)})
+
+	rendered, err := inspector._inspectActor(tree, robust, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal(rendered, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got["Address"] != _idAddr(t, 100).String() || got["Balance"] != 5.0 || got["CallSeqNum"] != 3.0 {
+		t.Errorf("actor rendered as %v", got)
+	}
+
+	want := map[string]interface{}{
+		"Owner": owner.String(),
+		"Count": 7.0,
+		"Data":  "abcd",
+		"Link":  _renderedLink(linked, "value", map[string]interface{}{"a": "b"}),
+		"AMT":   nil,
+		"HAMT":  nil,
+	}
+	if state := _inspectState(t, inspector, tree, robust, 1); !reflect.DeepEqual(state, want) {
+		t.Errorf("state rendered as %v, want %v", state, want)
+	}
+
+	// At depth 0, links are not expanded.
+	want["Link"] = _renderedLink(linked, "", nil)
+	if state := _inspectState(t, inspector, tree, _idAddr(t, 100), 0); !reflect.DeepEqual(state, want) {
+		t.Errorf("state rendered as %v at depth 0, want %v", state, want)
+	}
+
+	unknown, _ := addr.NewActorAddress([]byte("unknown"))
+	for _, a := range []addr.Address{unknown, _idAddr(t, 102)} {
+		if _, err := inspector._inspectActor(tree, a, 1); err != ErrActorNotFound {
+			t.Errorf("inspecting %v: error %v, want %v", a, err, ErrActorNotFound)
+		}
+	}
+}
+
+func TestInspectCollections(t *testing.T) {
+	store := ipld.MemGraphStore_Make()
+	var leaves []cid.Cid
+	for j := 0; j < 10; j++ {
+		leaves = append(leaves, _putGeneric(store, fmt.Sprint("leaf ", j)))
+	}
+	leaf := func(j int) cid.Cid { return leaves[j%len(leaves)] }
+
+	// A HAMT of two nodes holding links to leaves 1 and 2.
+	hamtChild := _putGeneric(store, []interface{}{[]byte{1}, []interface{}{
+		map[string]interface{}{"1": []interface{}{[]interface{}{[]byte{0x02}, leaf(2)}}},
+	}})
+	hamt := _putGeneric(store, []interface{}{[]byte{3}, []interface{}{
+		map[string]interface{}{"1": []interface{}{[]interface{}{[]byte{0x01}, leaf(1)}}},
+		map[string]interface{}{"0": hamtChild},
+	}})
+
+	// AMTs of heights 0, 1 and 2, holding links to leaves.
+	for _, n := range []int{3, 9, 65} {
+		amtValues := make([]interface{}, n)
+		for j := range amtValues {
+			amtValues[j] = leaf(j)
+		}
+		amt := ipld.PutAMT(store, amtValues)
+		tree, inspector := _inspectFixture(t, store, addr.Undef, _inspectedState{AMT: _link(amt), HAMT: _link(hamt)})
+
+		// The entries of each collection, with their values rendered by renderValue.
+		entries := func(renderValue func(j int) interface{}) (amtEntries, hamtEntries map[string]interface{}) {
+			amtEntries = make(map[string]interface{})
+			for j := 0; j < n; j++ {
+				amtEntries[fmt.Sprint(j)] = renderValue(j)
+			}
+			hamtEntries = map[string]interface{}{"01": renderValue(1), "02": renderValue(2)}
+			return
+		}
+		amtAt1, hamtAt1 := entries(func(j int) interface{} { return _renderedLink(leaf(j), "", nil) })
+		amtAt2, hamtAt2 := entries(func(j int) interface{} { return _renderedLink(leaf(j), "value", fmt.Sprint("leaf ", j%len(leaves))) })
+
+		// Inspection depths at which the collections are not expanded, their values are not, and both are.
+		cases := []struct {
+			depth     int
+			amt, hamt interface{}
+		}{
+			{0, _renderedLink(amt, "", nil), _renderedLink(hamt, "", nil)},
+			{1, _renderedLink(amt, "entries", amtAt1), _renderedLink(hamt, "entries", hamtAt1)},
+			{2, _renderedLink(amt, "entries", amtAt2), _renderedLink(hamt, "entries", hamtAt2)},
+		}
+		for _, c := range cases {
+			state := _inspectState(t, inspector, tree, _idAddr(t, 100), c.depth)
+			if !reflect.DeepEqual(state["AMT"], c.amt) {
+				t.Errorf("%d values, depth %d: AMT rendered as %v, want %v", n, c.depth, state["AMT"], c.amt)
+			}
+			if !reflect.DeepEqual(state["HAMT"], c.hamt) {
+				t.Errorf("%d values, depth %d: HAMT rendered as %v, want %v", n, c.depth, state["HAMT"], c.hamt)
+			}
+		}
+	}
+}
+
+func TestInspectMalformedCollections(t *testing.T) {
+	store := ipld.MemGraphStore_Make()
+	missing := ipld.MemGraphStore_Make().Put([]byte("missing"))
+	bucket := func(kvs ...interface{}) map[string]interface{} {
+		return map[string]interface{}{"1": kvs}
+	}
+	hamtNode := func(pointers ...interface{}) interface{} {
+		return []interface{}{[]byte{1}, pointers}
+	}
+
+	cases := []struct {
+		name string
+		root interface{}
+		err  error
+	}{
+		{"HAMT link not a CID", hamtNode(map[string]interface{}{"0": "not a link"}), ErrInspectMalformedLink},
+		{"HAMT child not a node", hamtNode(map[string]interface{}{"0": _putGeneric(store, "not a node")}), ErrInspectMalformedHAMT},
+		{"HAMT child missing", hamtNode(map[string]interface{}{"0": missing}), ErrInspectBlockNotFound},
+		{"HAMT entry not a pair", hamtNode(bucket([]interface{}{[]byte{1}})), ErrInspectMalformedHAMT},
+		{"HAMT key not bytes", hamtNode(bucket([]interface{}{"key", "value"})), ErrInspectMalformedHAMT},
+		{"HAMT bucket not a list", hamtNode(map[string]interface{}{"1": "not a bucket"}), ErrInspectMalformedHAMT},
+		{"AMT values missing", []interface{}{0, 2, []interface{}{[]byte{3}, []interface{}{}, []interface{}{"a"}}}, ErrInspectMalformedAMT},
+		{"AMT child missing", []interface{}{1, 9, []interface{}{[]byte{1}, []interface{}{missing}, []interface{}{}}}, ErrInspectBlockNotFound},
+		{"AMT child not a node", []interface{}{1, 9, []interface{}{[]byte{1}, []interface{}{_putGeneric(store, "not a node")}, []interface{}{}}}, ErrInspectMalformedAMT},
+	}
+	for _, c := range cases {
+		root := _putGeneric(store, c.root)
+		tree, inspector := _inspectFixture(t, store, addr.Undef, _inspectedState{Link: _link(root)})
+		state := _inspectState(t, inspector, tree, _idAddr(t, 100), 1)
+		if want := _renderedLink(root, "error", c.err.Error()); !reflect.DeepEqual(state["Link"], want) {
+			t.Errorf("%s: rendered as %v, want %v", c.name, state["Link"], want)
+		}
+	}
+}
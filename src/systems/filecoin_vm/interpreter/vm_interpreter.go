@@ -1,6 +1,8 @@
 package interpreter
 
 import (
+	"errors"
+
 	addr "github.com/filecoin-project/go-address"
 	abi "github.com/filecoin-project/specs-actors/actors/abi"
 	builtin "github.com/filecoin-project/specs-actors/actors/builtin"
@@ -13,7 +15,6 @@ import (
 	chain "github.com/filecoin-project/specs/systems/filecoin_blockchain/struct/chain"
 	actstate "github.com/filecoin-project/specs/systems/filecoin_vm/actor"
 	msg "github.com/filecoin-project/specs/systems/filecoin_vm/message"
-	gascost "github.com/filecoin-project/specs/systems/filecoin_vm/runtime/gascost"
 	vmri "github.com/filecoin-project/specs/systems/filecoin_vm/runtime/impl"
 	st "github.com/filecoin-project/specs/systems/filecoin_vm/state_tree"
 	util "github.com/filecoin-project/specs/util"
@@ -22,6 +23,8 @@ import (
 
 type Bytes = util.Bytes
 
+var ErrStateTreeNotFound = errors.New("State tree not found in store")
+
 var Assert = util.Assert
 var TODO = util.TODO
 var IMPL_FINISH = util.IMPL_FINISH
@@ -33,17 +36,65 @@ const (
 	SenderResolveSpec_Invalid
 )
 
+// The kinds of implicit message, applied by the interpreter on behalf of the system actor.
+type ImplicitMessageKind int
+
+const (
+	ImplicitMessageKind_ElectionPoSt ImplicitMessageKind = 1 + iota
+	ImplicitMessageKind_BlockReward
+	ImplicitMessageKind_CronTick
+)
+
+func (k ImplicitMessageKind) String() string {
+	switch k {
+	case ImplicitMessageKind_ElectionPoSt:
+		return "ElectionPoSt"
+	case ImplicitMessageKind_BlockReward:
+		return "BlockReward"
+	case ImplicitMessageKind_CronTick:
+		return "CronTick"
+	default:
+		return "Unknown"
+	}
+}
+
+// The receipt of an implicit message. Implicit receipts are not part of the chain's message receipts
+// (which cover only explicit messages), but record the effects of each tipset's system messages, and
+// are committed separately through the next tipset's ParentImplicitReceipts.
+type ImplicitReceipt struct {
+	Kind ImplicitMessageKind
+	// The block miner on whose behalf the message was applied, or the system actor for the cron tick.
+	Miner   addr.Address
+	Message msg.UnsignedMessage
+	Receipt vmri.MessageReceipt
+	Trace   vmri.ExecutionTrace
+}
+
 // Applies all the message in a tipset, along with implicit block- and tipset-specific state
 // transitions.
-func (vmi *VMInterpreter_I) ApplyTipSetMessages(inTree st.StateTree, tipset chain.Tipset, msgs TipSetMessages) (outTree st.StateTree, receipts []vmri.MessageReceipt) {
-	outTree = inTree
+// Before any message, runs the state migrations of network upgrades scheduled since the parent
+// tipset's epoch.
+// Returns an execution trace for each receipt, and a receipt for each implicit message in order of
+// application, neither of which is part of the resulting state.
+// The receipts are then passed to the EventSink, if any, to deliver the events they commit if the
+// tipset is applied to the chain.
+func (vmi *VMInterpreter_I) ApplyTipSetMessages(inTree st.StateTree, tipset chain.Tipset, msgs TipSetMessages) (
+	outTree st.StateTree, receipts []vmri.MessageReceipt, traces []vmri.ExecutionTrace, implicitReceipts []ImplicitReceipt) {
+
 	seenMsgs := make(map[cid.Cid]struct{}) // CIDs of messages already seen once.
-	var receipt vmri.MessageReceipt
 	store := vmi.Node().Repository().StateStore()
 	// get chain from Tipset
 	chainRand := &chain.Chain_I{
-		HeadTipset_: tipset,
+		HeadTipset_:    tipset,
+		NetworkParams_: vmi,
+	}
+
+	upgrades := vmi.UpgradeSchedule().UpgradesInRange(tipset.Parents().Epoch(), tipset.Epoch())
+	outTree, err := RunStateMigrations(store, inTree, upgrades)
+	if err != nil {
+		panic("Interpreter error: state migration failed")
 	}
+	config := vmi.NetworkConfigAtEpoch(tipset.Epoch())
 
 	for _, blk := range msgs.Blocks() {
 		minerAddr := blk.Miner()
@@ -51,66 +102,97 @@ func (vmi *VMInterpreter_I) ApplyTipSetMessages(inTree st.StateTree, tipset chai
 
 		// Process block miner's Election PoSt.
 		epostMessage := _makeElectionPoStMessage(outTree, minerAddr)
-		outTree = _applyMessageBuiltinAssert(store, outTree, chainRand, epostMessage, minerAddr)
+		var epostReceipt ImplicitReceipt
+		outTree, epostReceipt = _applyImplicitMessage(store, config, vmi.AddressResolver(), outTree, chainRand, ImplicitMessageKind_ElectionPoSt, epostMessage, minerAddr)
+		implicitReceipts = append(implicitReceipts, epostReceipt)
 
-		minerPenaltyTotal := abi.TokenAmount(0)
-		var minerPenaltyCurr abi.TokenAmount
-
-		minerGasRewardTotal := abi.TokenAmount(0)
-		var minerGasRewardCurr abi.TokenAmount
-
-		// Process BLS messages from the block.
+		// Collect the block's messages in execution order: BLS before SECP, skipping any message
+		// already encountered in the tipset.
+		var blockMsgs []_blockMessage
 		for _, m := range blk.BLSMessages() {
 			_, found := seenMsgs[_msgCID(m)]
 			if found {
 				continue
 			}
-			onChainMessageLen := len(msg.Serialize_UnsignedMessage(m))
-			outTree, receipt, minerPenaltyCurr, minerGasRewardCurr = vmi.ApplyMessage(outTree, chainRand, m, onChainMessageLen, minerAddr)
-			minerPenaltyTotal += minerPenaltyCurr
-			minerGasRewardTotal += minerGasRewardCurr
-
-			receipts = append(receipts, receipt)
+			blockMsgs = append(blockMsgs, _blockMessage{m, len(msg.Serialize_UnsignedMessage(m))})
 			seenMsgs[_msgCID(m)] = struct{}{}
 		}
-
-		// Process SECP messages from the block.
 		for _, sm := range blk.SECPMessages() {
 			m := sm.Message()
 			_, found := seenMsgs[_msgCID(m)]
 			if found {
 				continue
 			}
-			onChainMessageLen := len(msg.Serialize_SignedMessage(sm))
-			outTree, receipt, minerPenaltyCurr, minerGasRewardCurr = vmi.ApplyMessage(outTree, chainRand, m, onChainMessageLen, minerAddr)
-			minerPenaltyTotal += minerPenaltyCurr
-			minerGasRewardTotal += minerGasRewardCurr
-
-			receipts = append(receipts, receipt)
+			blockMsgs = append(blockMsgs, _blockMessage{m, len(msg.Serialize_SignedMessage(sm))})
 			seenMsgs[_msgCID(m)] = struct{}{}
 		}
 
+		// Process the block's messages.
+		var results []_messageResult
+		if vmi.ParallelMessageExecution() {
+			inTree := outTree
+			outTree, results = vmi._applyMessagesParallel(inTree, chainRand, blockMsgs, minerAddr)
+			if vmi.CheckParallelExecution() {
+				seqTree, seqResults := vmi._applyMessagesSequential(inTree, chainRand, blockMsgs, minerAddr)
+				if !_sameMessageResults(outTree, results, seqTree, seqResults) {
+					panic("Interpreter error: parallel execution differs from sequential execution")
+				}
+			}
+		} else {
+			outTree, results = vmi._applyMessagesSequential(outTree, chainRand, blockMsgs, minerAddr)
+		}
+
+		minerPenaltyTotal := abi.TokenAmount(0)
+		minerGasRewardTotal := abi.TokenAmount(0)
+		for _, r := range results {
+			minerPenaltyTotal += r.minerPenalty
+			minerGasRewardTotal += r.minerGasReward
+			receipts = append(receipts, r.receipt)
+			traces = append(traces, r.trace)
+		}
+
 		// transfer gas reward from BurntFundsActor to RewardActor
-		_withTransferFundsAssert(outTree, builtin.BurntFundsActorAddr, builtin.RewardActorAddr, minerGasRewardTotal)
+		outTree = _withTransferFundsAssert(outTree, builtin.BurntFundsActorAddr, builtin.RewardActorAddr, minerGasRewardTotal)
 
 		// Pay block reward.
 		rewardMessage := _makeBlockRewardMessage(outTree, minerAddr, minerPenaltyTotal, minerGasRewardTotal)
-		outTree = _applyMessageBuiltinAssert(store, outTree, chainRand, rewardMessage, minerAddr)
+		var rewardReceipt ImplicitReceipt
+		outTree, rewardReceipt = _applyImplicitMessage(store, config, vmi.AddressResolver(), outTree, chainRand, ImplicitMessageKind_BlockReward, rewardMessage, minerAddr)
+		implicitReceipts = append(implicitReceipts, rewardReceipt)
 	}
 
 	// Invoke cron tick.
 	// Since this is outside any block, the top level block winner is declared as the system actor.
 	cronMessage := _makeCronTickMessage(outTree)
-	outTree = _applyMessageBuiltinAssert(store, outTree, chainRand, cronMessage, builtin.SystemActorAddr)
+	var cronReceipt ImplicitReceipt
+	outTree, cronReceipt = _applyImplicitMessage(store, config, vmi.AddressResolver(), outTree, chainRand, ImplicitMessageKind_CronTick, cronMessage, builtin.SystemActorAddr)
+	implicitReceipts = append(implicitReceipts, cronReceipt)
 
+	if vmi.EventSink() != nil {
+		vmi.EventSink().TipSetExecuted(tipset, msgs, receipts, implicitReceipts)
+	}
 	return
 }
 
+// Applies a single explicit message. The returned trace is empty if the message was not
+// invoked (i.e., if it failed validation against the sender's state).
 func (vmi *VMInterpreter_I) ApplyMessage(inTree st.StateTree, chain chain.Chain, message msg.UnsignedMessage, onChainMessageSize int, minerAddr addr.Address) (
-	retTree st.StateTree, retReceipt vmri.MessageReceipt, retMinerPenalty abi.TokenAmount, retMinerGasReward abi.TokenAmount) {
+	retTree st.StateTree, retReceipt vmri.MessageReceipt, retMinerPenalty abi.TokenAmount, retMinerGasReward abi.TokenAmount,
+	retTrace vmri.ExecutionTrace) {
+
+	return vmi._applyMessage(inTree, chain, message, onChainMessageSize, minerAddr, nil)
+}
+
+// Applies a single explicit message, recording the actors whose state it accesses in access (if non-nil).
+func (vmi *VMInterpreter_I) _applyMessage(inTree st.StateTree, chain chain.Chain, message msg.UnsignedMessage, onChainMessageSize int, minerAddr addr.Address, access *vmri.StateAccessSet) (
+	retTree st.StateTree, retReceipt vmri.MessageReceipt, retMinerPenalty abi.TokenAmount, retMinerGasReward abi.TokenAmount,
+	retTrace vmri.ExecutionTrace) {
 
 	store := vmi.Node().Repository().StateStore()
-	senderAddr := _resolveSender(store, inTree, message.From())
+	config := vmi.NetworkConfigAtEpoch(chain.HeadTipset().Epoch())
+	access.RecordRead(builtin.InitActorAddr)
+	senderAddr := _resolveSender(store, vmi.AddressResolver(), inTree, message.From())
+	access.RecordRead(senderAddr)
 
 	vmiGasRemaining := message.GasLimit()
 	vmiGasUsed := msg.GasAmount_Zero()
@@ -171,7 +253,7 @@ func (vmi *VMInterpreter_I) ApplyMessage(inTree st.StateTree, chain chain.Chain,
 		return
 	}
 
-	ok := _vmiBurnGas(gascost.OnChainMessage(onChainMessageSize))
+	ok := _vmiBurnGas(config.GasSchedule.OnChainMessage(onChainMessageSize))
 	if !ok {
 		// Invalid message; insufficient gas limit to pay for the on-chain message size.
 		_applyError(inTree, exitcode.OutOfGas, SenderResolveSpec_Invalid)
@@ -193,7 +275,11 @@ func (vmi *VMInterpreter_I) ApplyMessage(inTree st.StateTree, chain chain.Chain,
 
 	// Check sender balance.
 	gasLimitCost := _gasToFIL(message.GasLimit(), message.GasPrice())
+	for _, a := range _indicesActors {
+		access.RecordRead(a)
+	}
 	tidx := indicesFromStateTree(inTree)
+	access.RecordRead(_resolveSender(store, vmi.AddressResolver(), inTree, message.To()))
 	networkTxnFee := tidx.NetworkTransactionFee(
 		inTree.GetActorCodeID_Assert(message.To()), message.Method())
 	totalCost := message.Value() + gasLimitCost + networkTxnFee
@@ -207,18 +293,21 @@ func (vmi *VMInterpreter_I) ApplyMessage(inTree st.StateTree, chain chain.Chain,
 	// the sender paying gas, and the sender's CallSeqNum being incremented;
 	// at least that much state change will be persisted even if the
 	// method invocation subsequently fails.
+	access.RecordWrite(senderAddr)
+	access.RecordWrite(builtin.BurntFundsActorAddr)
 	compTreePreSend := _withTransferFundsAssert(inTree, senderAddr, builtin.BurntFundsActorAddr, gasLimitCost+networkTxnFee)
 	compTreePreSend = compTreePreSend.Impl().WithIncrementedCallSeqNum_Assert(senderAddr)
 
 	invoc := _makeInvocInput(message)
-	sendRet, compTreePostSend := _applyMessageInternal(store, compTreePreSend, chain, message.CallSeqNum(), senderAddr, invoc, vmiGasRemaining, minerAddr)
+	sendRet, compTreePostSend, sendTrace, sendEvents := _applyMessageInternal(store, config, vmi.AddressResolver(), compTreePreSend, chain, message.CallSeqNum(), senderAddr, invoc, vmiGasRemaining, minerAddr, access)
+	retTrace = sendTrace
 
 	ok = _vmiBurnGas(sendRet.GasUsed)
 	if !ok {
 		panic("Interpreter error: runtime execution used more gas than provided")
 	}
 
-	ok = _vmiAllocGas(gascost.OnChainReturnValue(sendRet.ReturnValue))
+	ok = _vmiAllocGas(config.GasSchedule.OnChainReturnValue(sendRet.ReturnValue))
 	if !ok {
 		// Insufficient gas remaining to cover the on-chain return value; proceed as in the case
 		// of method execution failure.
@@ -233,12 +322,60 @@ func (vmi *VMInterpreter_I) ApplyMessage(inTree st.StateTree, chain chain.Chain,
 
 	_applyReturn(
 		compTreeRet, vmr.InvocOutput_Make(sendRet.ReturnValue), sendRet.ExitCode, SenderResolveSpec_OK)
+	// Events are committed only by successful messages, along with their state.
+	if sendRet.ExitCode.AllowsStateUpdate() {
+		retReceipt.EventsRoot = vmri.PutEventsAMT(store, sendEvents)
+	}
 	return
 }
 
-// Resolves an address through the InitActor's map.
+// Invokes an actor method against a state tree without producing a new state, so that node
+// components (e.g., UIs and market clients) may safely query actor views.
+// The invocation is sent by the system actor and transfers no value. Any attempt to mutate state
+// (updating actor substate, creating or deleting actors, or transferring funds) aborts it with
+// the ReadOnlyViolation exit code. The sender's CallSeqNum is not incremented.
+func (vmi *VMInterpreter_I) CallReadOnly(inTree st.StateTree, chain chain.Chain, to addr.Address, method abi.MethodNum, params abi.MethodParams, gasLimit msg.GasAmount) vmri.MessageReceipt {
+	store := vmi.Node().Repository().StateStore()
+	config := vmi.NetworkConfigAtEpoch(chain.HeadTipset().Epoch())
+	sysActor, ok := inTree.GetActor(builtin.SystemActorAddr)
+	Assert(ok)
+
+	rt := vmri.VMContext_Make(
+		store,
+		config.ActorCodeLoader,
+		config.GasSchedule,
+		chain,
+		builtin.SystemActorAddr,
+		builtin.SystemActorAddr,
+		sysActor.CallSeqNum(),
+		actstate.CallSeqNum(0),
+		inTree,
+		builtin.SystemActorAddr,
+		abi.TokenAmount(0),
+		gasLimit,
+	)
+	rt.UseAddressResolver(vmi.AddressResolver())
+
+	return rt.SendReadOnlyFromInterpreter(vmr.InvocInput_Make(to, method, params, abi.TokenAmount(0)))
+}
+
+// Loads a state tree from store by its root.
+func _loadStateTree(store ipld.GraphStore, root cid.Cid) (st.StateTree, error) {
+	serialized, ok := store.Get(root)
+	if !ok {
+		return nil, ErrStateTreeNotFound
+	}
+	return st.Deserialize_StateTree(util.Serialization(serialized))
+}
+
+// Resolves an address through the InitActor's map, using resolver if non-nil.
 // Returns the resolved address (which will be an ID address) if found, else the original address.
-func _resolveSender(store ipld.GraphStore, tree st.StateTree, address addr.Address) addr.Address {
+func _resolveSender(store ipld.GraphStore, resolver vmri.AddressResolver, tree st.StateTree, address addr.Address) addr.Address {
+	if resolver != nil {
+		ret, _ := resolver.ResolveAddress(tree, address)
+		return ret
+	}
+
 	initState, ok := tree.GetActor(builtin.InitActorAddr)
 	util.Assert(ok)
 	serialized, ok := store.Get(cid.Cid(initState.State()))
@@ -247,7 +384,21 @@ func _resolveSender(store ipld.GraphStore, tree st.StateTree, address addr.Addre
 	return initSubState.ResolveAddress(address)
 }
 
-func _applyMessageBuiltinAssert(store ipld.GraphStore, tree st.StateTree, chain chain.Chain, message msg.UnsignedMessage, minerAddr addr.Address) st.StateTree {
+func _applyImplicitMessage(store ipld.GraphStore, config NetworkConfig, resolver vmri.AddressResolver, tree st.StateTree, chain chain.Chain, kind ImplicitMessageKind, message msg.UnsignedMessage, minerAddr addr.Address) (
+	st.StateTree, ImplicitReceipt) {
+
+	retTree, retReceipt, retTrace := _applyMessageBuiltinAssert(store, config, resolver, tree, chain, message, minerAddr)
+	return retTree, ImplicitReceipt{
+		Kind:    kind,
+		Miner:   minerAddr,
+		Message: message,
+		Receipt: retReceipt,
+		Trace:   retTrace,
+	}
+}
+
+func _applyMessageBuiltinAssert(store ipld.GraphStore, config NetworkConfig, resolver vmri.AddressResolver, tree st.StateTree, chain chain.Chain, message msg.UnsignedMessage, minerAddr addr.Address) (
+	st.StateTree, vmri.MessageReceipt, vmri.ExecutionTrace) {
 	senderAddr := message.From()
 	Assert(senderAddr == builtin.SystemActorAddr)
 	Assert(senderAddr.Protocol() == addr.ID)
@@ -258,19 +409,22 @@ func _applyMessageBuiltinAssert(store ipld.GraphStore, tree st.StateTree, chain
 	tree = tree.Impl().WithIncrementedCallSeqNum_Assert(senderAddr)
 
 	invoc := _makeInvocInput(message)
-	retReceipt, retTree := _applyMessageInternal(store, tree, chain, message.CallSeqNum(), senderAddr, invoc, message.GasLimit(), minerAddr)
+	retReceipt, retTree, retTrace, retEvents := _applyMessageInternal(store, config, resolver, tree, chain, message.CallSeqNum(), senderAddr, invoc, message.GasLimit(), minerAddr, nil)
 	if retReceipt.ExitCode != exitcode.OK() {
 		panic("internal message application failed")
 	}
+	retReceipt.EventsRoot = vmri.PutEventsAMT(store, retEvents)
 
-	return retTree
+	return retTree, retReceipt, retTrace
 }
 
-func _applyMessageInternal(store ipld.GraphStore, tree st.StateTree, chain chain.Chain, messageCallSequenceNumber actstate.CallSeqNum, senderAddr addr.Address, invoc vmr.InvocInput,
-	gasRemainingInit msg.GasAmount, topLevelBlockWinner addr.Address) (vmri.MessageReceipt, st.StateTree) {
+func _applyMessageInternal(store ipld.GraphStore, config NetworkConfig, resolver vmri.AddressResolver, tree st.StateTree, chain chain.Chain, messageCallSequenceNumber actstate.CallSeqNum, senderAddr addr.Address, invoc vmr.InvocInput,
+	gasRemainingInit msg.GasAmount, topLevelBlockWinner addr.Address, access *vmri.StateAccessSet) (vmri.MessageReceipt, st.StateTree, vmri.ExecutionTrace, []vmri.ActorEvent) {
 
 	rt := vmri.VMContext_Make(
 		store,
+		config.ActorCodeLoader,
+		config.GasSchedule,
 		chain,
 		senderAddr,
 		topLevelBlockWinner,
@@ -281,8 +435,11 @@ func _applyMessageInternal(store ipld.GraphStore, tree st.StateTree, chain chain
 		abi.TokenAmount(0),
 		gasRemainingInit,
 	)
+	rt.RecordStateAccess(access)
+	rt.UseAddressResolver(resolver)
 
-	return rt.SendToplevelFromInterpreter(invoc)
+	receipt, outTree, trace := rt.SendToplevelFromInterpreter(invoc)
+	return receipt, outTree, trace, rt.Events()
 }
 
 func _withTransferFundsAssert(tree st.StateTree, from addr.Address, to addr.Address, amount abi.TokenAmount) st.StateTree {
@@ -295,6 +452,10 @@ func _withTransferFundsAssert(tree st.StateTree, from addr.Address, to addr.Addr
 	}
 }
 
+// The actors whose state indicesFromStateTree reads.
+var _indicesActors = []addr.Address{builtin.StoragePowerActorAddr, builtin.StorageMarketActorAddr, builtin.RewardActorAddr}
+
+// Reads the state of the _indicesActors only, so that parallel execution can record the state it depends on.
 func indicesFromStateTree(st st.StateTree) indices.Indices {
 	TODO()
 	panic("")
@@ -0,0 +1,91 @@
+package interpreter
+
+import (
+	"fmt"
+
+	abi "github.com/filecoin-project/specs-actors/actors/abi"
+	msg "github.com/filecoin-project/specs/systems/filecoin_vm/message"
+	vmri "github.com/filecoin-project/specs/systems/filecoin_vm/runtime/impl"
+	st "github.com/filecoin-project/specs/systems/filecoin_vm/state_tree"
+)
+
+// A violation of an invariant of the application of explicit messages.
+type InvariantViolation struct {
+	// Name of the violated invariant.
+	Invariant string
+	Detail    string
+}
+
+func (v *InvariantViolation) Error() string {
+	return fmt.Sprintf("invariant %v violated: %v", v.Invariant, v.Detail)
+}
+
+const (
+	Invariant_SupplyConserved          = "SupplyConserved"
+	Invariant_CallSeqNumMonotonic      = "CallSeqNumMonotonic"
+	Invariant_GasRefundNonnegative     = "GasRefundNonnegative"
+	Invariant_MinerPaymentsNonnegative = "MinerPaymentsNonnegative"
+	// The interpreter panicked (e.g. failed an assertion) while applying a message.
+	Invariant_NoInterpreterPanic = "NoInterpreterPanic"
+)
+
+// Checks the global invariants of the application of an explicit message, given the states before
+// and after, and the results returned by ApplyMessage. Returns nil if all invariants hold.
+//
+// - The total balance of all actors is unchanged: gas payments are held by the burnt funds actor
+// until the end of the block.
+// - No actor's CallSeqNum decreases, and none increases by more than one.
+// - The sender is never refunded a negative amount, i.e. gas used by a message whose sender paid
+// for gas does not exceed its gas limit.
+// - Miner penalties and gas rewards are non-negative.
+func CheckMessageInvariants(preTree, postTree st.StateTree, message msg.UnsignedMessage, receipt vmri.MessageReceipt,
+	minerPenalty abi.TokenAmount, minerGasReward abi.TokenAmount) *InvariantViolation {
+
+	preSupply, postSupply := _totalBalance(preTree), _totalBalance(postTree)
+	if preSupply != postSupply {
+		return &InvariantViolation{Invariant_SupplyConserved,
+			fmt.Sprintf("total balance %v before message, %v after", preSupply, postSupply)}
+	}
+
+	for a, pre := range preTree.ActorStates() {
+		post, found := postTree.GetActor(a)
+		if !found {
+			continue
+		}
+		if post.CallSeqNum() < pre.CallSeqNum() || post.CallSeqNum() > pre.CallSeqNum()+1 {
+			return &InvariantViolation{Invariant_CallSeqNumMonotonic,
+				fmt.Sprintf("actor %v CallSeqNum %v before message, %v after", a, pre.CallSeqNum(), post.CallSeqNum())}
+		}
+	}
+
+	// A message's sender pays for gas exactly when its CallSeqNum is incremented.
+	if receipt.GasUsed.LessThan(msg.GasAmount_Zero()) {
+		return &InvariantViolation{Invariant_GasRefundNonnegative, fmt.Sprintf("negative gas used %v", receipt.GasUsed)}
+	}
+	senderPaid := false
+	for a, pre := range preTree.ActorStates() {
+		post, found := postTree.GetActor(a)
+		if found && post.CallSeqNum() == pre.CallSeqNum()+1 {
+			senderPaid = true
+		}
+	}
+	if senderPaid && message.GasLimit().LessThan(receipt.GasUsed) {
+		return &InvariantViolation{Invariant_GasRefundNonnegative,
+			fmt.Sprintf("gas used %v exceeds gas limit %v", receipt.GasUsed, message.GasLimit())}
+	}
+
+	if minerPenalty < 0 || minerGasReward < 0 {
+		return &InvariantViolation{Invariant_MinerPaymentsNonnegative,
+			fmt.Sprintf("miner penalty %v, gas reward %v", minerPenalty, minerGasReward)}
+	}
+
+	return nil
+}
+
+func _totalBalance(tree st.StateTree) abi.TokenAmount {
+	ret := abi.TokenAmount(0)
+	for _, act := range tree.ActorStates() {
+		ret += act.Balance()
+	}
+	return ret
+}
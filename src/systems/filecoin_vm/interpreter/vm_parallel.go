@@ -0,0 +1,248 @@
+package interpreter
+
+import (
+	"bytes"
+	"sync"
+
+	addr "github.com/filecoin-project/go-address"
+	abi "github.com/filecoin-project/specs-actors/actors/abi"
+	builtin "github.com/filecoin-project/specs-actors/actors/builtin"
+	chain "github.com/filecoin-project/specs/systems/filecoin_blockchain/struct/chain"
+	actstate "github.com/filecoin-project/specs/systems/filecoin_vm/actor"
+	msg "github.com/filecoin-project/specs/systems/filecoin_vm/message"
+	vmri "github.com/filecoin-project/specs/systems/filecoin_vm/runtime/impl"
+	st "github.com/filecoin-project/specs/systems/filecoin_vm/state_tree"
+)
+
+// A message from a block, with the length of its on-chain (serialized, possibly signed) form.
+type _blockMessage struct {
+	message     msg.UnsignedMessage
+	onChainSize int
+}
+
+// The results of applying an explicit message, other than the resulting state.
+type _messageResult struct {
+	receipt        vmri.MessageReceipt
+	minerPenalty   abi.TokenAmount
+	minerGasReward abi.TokenAmount
+	trace          vmri.ExecutionTrace
+}
+
+// A message's speculative execution.
+type _speculation struct {
+	result  _messageResult
+	access  *vmri.StateAccessSet
+	inTree  st.StateTree
+	outTree st.StateTree
+}
+
+// Marks actor state written by a message re-executed on the committed state.
+const _writtenByReexecution = -1
+
+// Applies a message to a state tree, recording the actors whose state it accesses in access (if non-nil).
+type _messageApplier func(tree st.StateTree, bm _blockMessage, access *vmri.StateAccessSet) (st.StateTree, _messageResult)
+
+// Returns the applier of a block's explicit messages on chain, mined by minerAddr.
+func (vmi *VMInterpreter_I) _blockMessageApplier(chain chain.Chain, minerAddr addr.Address) _messageApplier {
+	return func(tree st.StateTree, bm _blockMessage, access *vmri.StateAccessSet) (st.StateTree, _messageResult) {
+		var r _messageResult
+		tree, r.receipt, r.minerPenalty, r.minerGasReward, r.trace =
+			vmi._applyMessage(tree, chain, bm.message, bm.onChainSize, minerAddr, access)
+		return tree, r
+	}
+}
+
+// Applies a block's messages with an optimistic parallel executor (see _executeParallel). The resulting
+// state and message results are identical to those of applying the messages in sequence.
+// The state store must support concurrent use.
+func (vmi *VMInterpreter_I) _applyMessagesParallel(inTree st.StateTree, chain chain.Chain, msgs []_blockMessage, minerAddr addr.Address) (
+	st.StateTree, []_messageResult) {
+
+	store := vmi.Node().Repository().StateStore()
+	resolve := func(a addr.Address) addr.Address {
+		return _resolveSender(store, vmi.AddressResolver(), inTree, a)
+	}
+	return _executeParallel(inTree, msgs, resolve, vmi._blockMessageApplier(chain, minerAddr))
+}
+
+// Applies a block's messages in sequence, each on the state resulting from the previous one.
+func (vmi *VMInterpreter_I) _applyMessagesSequential(inTree st.StateTree, chain chain.Chain, msgs []_blockMessage, minerAddr addr.Address) (
+	st.StateTree, []_messageResult) {
+
+	return _executeSequential(inTree, msgs, vmi._blockMessageApplier(chain, minerAddr))
+}
+
+// Applies messages with apply, in parallel, producing the same state and results as _executeSequential.
+//
+// Messages are grouped by sender, identified by its ID address in inTree (as returned by resolve), so that
+// the messages of an actor sent from its ID and its public key addresses are executed in order. Groups are
+// executed concurrently, each on a snapshot of inTree (state trees are persistent, so a snapshot is the
+// tree itself), with each message of a group executed on the state resulting from the previous one,
+// recording the actors whose state it accesses.
+// The speculative executions are then committed in canonical order. An execution remains valid if
+// none of the actors it accessed has been written by a committed message of another group; the states
+// of the actors it wrote are then copied into the committed state. Otherwise, the message and all
+// subsequent messages of its group are re-executed on the committed state.
+//
+// Every message from a valid sender deposits its maximum gas cost with the burnt funds actor and
+// is refunded from it, which would make all messages conflict. The burnt funds actor has no code, only
+// receives funds, and never refunds more than a message's own deposit, so its state is excluded from
+// conflict detection and its balance is instead merged by adding each message's net change.
+func _executeParallel(inTree st.StateTree, msgs []_blockMessage, resolve func(addr.Address) addr.Address, apply _messageApplier) (
+	st.StateTree, []_messageResult) {
+
+	groups, groupOf := _groupBySender(msgs, resolve)
+
+	// Execute speculatively.
+	specs := make([]_speculation, len(msgs))
+	var wg sync.WaitGroup
+	for _, group := range groups {
+		wg.Add(1)
+		go func(group []int) {
+			defer wg.Done()
+			tree := inTree
+			for _, i := range group {
+				s := &specs[i]
+				s.inTree = tree
+				s.access = vmri.StateAccessSet_Make()
+				s.outTree, s.result = apply(tree, msgs[i], s.access)
+				tree = s.outTree
+			}
+		}(group)
+	}
+	wg.Wait()
+
+	// Commit in canonical order.
+	tree := inTree
+	results := make([]_messageResult, len(msgs))
+	writtenBy := make(map[addr.Address]int) // Group of the last committed message to write each actor.
+	invalidated := make(map[int]bool)
+	for i, bm := range msgs {
+		s := specs[i]
+		g := groupOf[i]
+
+		if !invalidated[g] && !_speculationConflicts(s.access, writtenBy, g) {
+			tree = _withSpeculativeWrites(tree, s)
+			_recordWrites(writtenBy, s.access, g)
+			results[i] = s.result
+			continue
+		}
+
+		invalidated[g] = true
+		access := vmri.StateAccessSet_Make()
+		tree, results[i] = apply(tree, bm, access)
+		_recordWrites(writtenBy, access, _writtenByReexecution)
+	}
+
+	return tree, results
+}
+
+// Applies messages with apply in sequence, each on the state resulting from the previous one.
+func _executeSequential(inTree st.StateTree, msgs []_blockMessage, apply _messageApplier) (st.StateTree, []_messageResult) {
+	tree := inTree
+	var results []_messageResult
+	for _, bm := range msgs {
+		var r _messageResult
+		tree, r = apply(tree, bm, nil)
+		results = append(results, r)
+	}
+	return tree, results
+}
+
+// Groups messages by sender, as identified by resolve, preserving their order within each group.
+// Returns the indices of each group's messages, and the group of each message.
+func _groupBySender(msgs []_blockMessage, resolve func(addr.Address) addr.Address) (groups [][]int, groupOf []int) {
+	groupOf = make([]int, len(msgs))
+	groupIndex := make(map[addr.Address]int)
+	for i, bm := range msgs {
+		sender := resolve(bm.message.From())
+		g, found := groupIndex[sender]
+		if !found {
+			g = len(groups)
+			groupIndex[sender] = g
+			groups = append(groups, nil)
+		}
+		groups[g] = append(groups[g], i)
+		groupOf[i] = g
+	}
+	return groups, groupOf
+}
+
+// Returns whether two executions of a block's messages produced the same state, receipts and miner
+// penalties and gas rewards. Traces are not compared, since they are not part of the chain.
+func _sameMessageResults(tree1 st.StateTree, results1 []_messageResult, tree2 st.StateTree, results2 []_messageResult) bool {
+	if tree1.RootCID() != tree2.RootCID() || len(results1) != len(results2) {
+		return false
+	}
+	for i := range results1 {
+		r1, r2 := results1[i], results2[i]
+		if r1.receipt.ExitCode != r2.receipt.ExitCode ||
+			!bytes.Equal(r1.receipt.ReturnValue, r2.receipt.ReturnValue) ||
+			!r1.receipt.GasUsed.Equals(r2.receipt.GasUsed) ||
+			r1.receipt.EventsRoot != r2.receipt.EventsRoot ||
+			r1.minerPenalty != r2.minerPenalty ||
+			r1.minerGasReward != r2.minerGasReward {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns whether a speculative execution from group g accessed an actor written by a committed
+// message of another group.
+func _speculationConflicts(access *vmri.StateAccessSet, writtenBy map[addr.Address]int, g int) bool {
+	for _, a := range access.Accessed() {
+		if a == builtin.BurntFundsActorAddr {
+			continue
+		}
+		if w, found := writtenBy[a]; found && w != g {
+			return true
+		}
+	}
+	return false
+}
+
+func _recordWrites(writtenBy map[addr.Address]int, access *vmri.StateAccessSet, g int) {
+	for a := range access.Writes {
+		if a != builtin.BurntFundsActorAddr {
+			writtenBy[a] = g
+		}
+	}
+}
+
+// Copies the states of the actors written by a speculative execution into tree, and adds the
+// execution's net change in the burnt funds actor's balance.
+func _withSpeculativeWrites(tree st.StateTree, s _speculation) st.StateTree {
+	for a := range s.access.Writes {
+		if a == builtin.BurntFundsActorAddr {
+			continue
+		}
+		actorState, found := s.outTree.GetActor(a)
+		if !found {
+			tree = tree.Impl().WithDeleteActorSystemState(a)
+			continue
+		}
+		tree = tree.Impl().WithActorState(a, actorState)
+	}
+
+	if _, found := s.access.Writes[builtin.BurntFundsActorAddr]; found {
+		before, ok := s.inTree.GetActor(builtin.BurntFundsActorAddr)
+		Assert(ok)
+		after, ok := s.outTree.GetActor(builtin.BurntFundsActorAddr)
+		Assert(ok)
+		tree = _withBalanceChange(tree, builtin.BurntFundsActorAddr, after.Balance()-before.Balance())
+	}
+
+	return tree
+}
+
+func _withBalanceChange(tree st.StateTree, a addr.Address, delta abi.TokenAmount) st.StateTree {
+	act, ok := tree.GetActor(a)
+	Assert(ok)
+	return tree.Impl().WithActorState(a, &actstate.ActorState_I{
+		CodeID_:     act.CodeID(),
+		State_:      act.State(),
+		Balance_:    act.Balance() + delta,
+		CallSeqNum_: act.CallSeqNum(),
+	})
+}
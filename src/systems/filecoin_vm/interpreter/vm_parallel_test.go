@@ -0,0 +1,294 @@
+package interpreter
+
+import (
+	"math/rand"
+	"reflect"
+	"sync/atomic"
+	"testing"
+
+	addr "github.com/filecoin-project/go-address"
+	abi "github.com/filecoin-project/specs-actors/actors/abi"
+	builtin "github.com/filecoin-project/specs-actors/actors/builtin"
+	exitcode "github.com/filecoin-project/specs-actors/actors/runtime/exitcode"
+	actstate "github.com/filecoin-project/specs/systems/filecoin_vm/actor"
+	msg "github.com/filecoin-project/specs/systems/filecoin_vm/message"
+	vmri "github.com/filecoin-project/specs/systems/filecoin_vm/runtime/impl"
+	st "github.com/filecoin-project/specs/systems/filecoin_vm/state_tree"
+)
+
+func TestGroupBySenderResolvesAddresses(t *testing.T) {
+	id100, id101 := _idAddr(t, 100), _idAddr(t, 101)
+	pubkey100, err := addr.NewSecp256k1Address([]byte("public key of actor 100"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolved := map[addr.Address]addr.Address{pubkey100: id100}
+	resolve := func(a addr.Address) addr.Address {
+		if ret, found := resolved[a]; found {
+			return ret
+		}
+		return a
+	}
+
+	var msgs []_blockMessage
+	for _, from := range []addr.Address{pubkey100, id101, id100, pubkey100, id101} {
+		msgs = append(msgs, _blockMessage{message: &msg.UnsignedMessage_I{From_: from}})
+	}
+
+	groups, groupOf := _groupBySender(msgs, resolve)
+	if want := [][]int{{0, 2, 3}, {1, 4}}; !reflect.DeepEqual(groups, want) {
+		t.Errorf("groups = %v, want %v", groups, want)
+	}
+	if want := []int{0, 1, 0, 0, 1}; !reflect.DeepEqual(groupOf, want) {
+		t.Errorf("groupOf = %v, want %v", groupOf, want)
+	}
+}
+
+func _accessSet(reads []addr.Address, writes []addr.Address) *vmri.StateAccessSet {
+	ret := vmri.StateAccessSet_Make()
+	for _, a := range reads {
+		ret.RecordRead(a)
+	}
+	for _, a := range writes {
+		ret.RecordWrite(a)
+	}
+	return ret
+}
+
+func _accountState(balance abi.TokenAmount, callSeqNum actstate.CallSeqNum) actstate.ActorState {
+	return &actstate.ActorState_I{CodeID_: builtin.AccountActorCodeID, Balance_: balance, CallSeqNum_: callSeqNum}
+}
+
+func TestSpeculationConflicts(t *testing.T) {
+	a100, a101 := _idAddr(t, 100), _idAddr(t, 101)
+	writtenBy := map[addr.Address]int{
+		a100:                        0,
+		a101:                        _writtenByReexecution,
+		builtin.BurntFundsActorAddr: 1,
+	}
+	cases := []struct {
+		name     string
+		access   *vmri.StateAccessSet
+		group    int
+		conflict bool
+	}{
+		{"read of actor written by own group", _accessSet([]addr.Address{a100}, nil), 0, false},
+		{"read of actor written by other group", _accessSet([]addr.Address{a100}, nil), 1, true},
+		{"write of actor written by other group", _accessSet(nil, []addr.Address{a100}), 1, true},
+		{"read of actor written by re-execution", _accessSet([]addr.Address{a101}, nil), 0, true},
+		{"read of unwritten actor", _accessSet([]addr.Address{_idAddr(t, 102)}, nil), 1, false},
+		{"access to burnt funds actor", _accessSet(nil, []addr.Address{builtin.BurntFundsActorAddr}), 0, false},
+	}
+	for _, c := range cases {
+		if got := _speculationConflicts(c.access, writtenBy, c.group); got != c.conflict {
+			t.Errorf("%s: conflict = %v, want %v", c.name, got, c.conflict)
+		}
+	}
+}
+
+func TestRecordWrites(t *testing.T) {
+	a100, a101 := _idAddr(t, 100), _idAddr(t, 101)
+	writtenBy := map[addr.Address]int{a100: 0}
+	_recordWrites(writtenBy, _accessSet([]addr.Address{_idAddr(t, 102)}, []addr.Address{a100, a101, builtin.BurntFundsActorAddr}), 2)
+
+	want := map[addr.Address]int{a100: 2, a101: 2}
+	if !reflect.DeepEqual(writtenBy, want) {
+		t.Errorf("writtenBy = %v, want %v (reads and burnt funds not recorded)", writtenBy, want)
+	}
+}
+
+func TestWithSpeculativeWrites(t *testing.T) {
+	a100, a101, a102, a103 := _idAddr(t, 100), _idAddr(t, 101), _idAddr(t, 102), _idAddr(t, 103)
+	inTree := &st.StateTree_I{ActorStates_: map[addr.Address]actstate.ActorState{
+		a100:                        _accountState(1000, 0),
+		a101:                        _accountState(0, 0),
+		a102:                        _accountState(0, 0),
+		a103:                        _accountState(5, 0),
+		builtin.BurntFundsActorAddr: _accountState(100, 0),
+	}}
+
+	// A committed message of another group changed a102 and added to the burnt funds.
+	committed := inTree.WithActorState(a102, _accountState(7, 0))
+	committed = committed.Impl().WithActorState(builtin.BurntFundsActorAddr, _accountState(130, 0))
+
+	// The speculation sent funds from a100 to a101, deleted a103, and burnt 20 of a100's balance,
+	// having deposited 50 and been refunded 30.
+	out := inTree.WithActorState(a100, _accountState(880, 1))
+	out = out.Impl().WithActorState(a101, _accountState(100, 0))
+	out = out.Impl().WithDeleteActorSystemState(a103)
+	out = out.Impl().WithActorState(builtin.BurntFundsActorAddr, _accountState(120, 0))
+	s := _speculation{
+		access:  _accessSet([]addr.Address{a100}, []addr.Address{a100, a101, a103, builtin.BurntFundsActorAddr}),
+		inTree:  inTree,
+		outTree: out,
+	}
+
+	tree := _withSpeculativeWrites(committed, s)
+	for _, a := range []addr.Address{a100, a101} {
+		want, _ := out.GetActor(a)
+		if got, _ := tree.GetActor(a); got != want {
+			t.Errorf("state of %v not copied from the speculation", a)
+		}
+	}
+	if _, found := tree.GetActor(a103); found {
+		t.Error("actor deleted by the speculation remains")
+	}
+	if _balance(t, tree, a102) != 7 {
+		t.Error("committed write of another group overwritten")
+	}
+	// The burnt funds are merged as the committed balance plus the speculation's net change.
+	if got := _balance(t, tree, builtin.BurntFundsActorAddr); got != 150 {
+		t.Errorf("burnt funds balance %d, want 150", got)
+	}
+	if _balance(t, committed, a100) != 1000 {
+		t.Error("committed tree modified")
+	}
+}
+
+func TestWithBalanceChange(t *testing.T) {
+	a100 := _idAddr(t, 100)
+	tree := &st.StateTree_I{ActorStates_: map[addr.Address]actstate.ActorState{
+		a100: _accountState(100, 4),
+	}}
+
+	updated := _withBalanceChange(_withBalanceChange(tree, a100, 30), a100, -50)
+	act, _ := updated.GetActor(a100)
+	if act.Balance() != 80 || act.CallSeqNum() != 4 || act.CodeID() != builtin.AccountActorCodeID {
+		t.Errorf("actor state %+v, want balance 80 with other fields unchanged", act)
+	}
+	if _balance(t, tree, a100) != 100 {
+		t.Error("input tree modified")
+	}
+}
+
+// Returns a message applier with simple transfer semantics, resolving addresses with resolve.
+// A message from a valid sender deposits 10 with the burnt funds actor, transfers its value to the
+// receiver (creating it if it does not exist), and is refunded 4. calls counts the messages applied.
+func _transferApplier(resolve func(addr.Address) addr.Address, calls *int32) _messageApplier {
+	return func(tree st.StateTree, bm _blockMessage, access *vmri.StateAccessSet) (st.StateTree, _messageResult) {
+		atomic.AddInt32(calls, 1)
+		m := bm.message
+		result := func(code exitcode.ExitCode, gasUsed int) _messageResult {
+			return _messageResult{receipt: vmri.MessageReceipt{ExitCode: code, GasUsed: msg.GasAmount_FromInt(gasUsed)}}
+		}
+
+		sender := resolve(m.From())
+		access.RecordRead(sender)
+		from, found := tree.GetActor(sender)
+		if !found {
+			return tree, result(exitcode.ActorNotFound, 0)
+		}
+		if from.CallSeqNum() != m.CallSeqNum() {
+			return tree, result(exitcode.InvalidCallSeqNum, 0)
+		}
+		if from.Balance() < m.Value()+10 {
+			return tree, result(exitcode.InsufficientFunds_System, 0)
+		}
+
+		access.RecordWrite(sender)
+		access.RecordWrite(builtin.BurntFundsActorAddr)
+		tree = tree.Impl().WithActorState(sender, _accountState(from.Balance()-m.Value()-10, from.CallSeqNum()+1))
+		tree = _withBalanceChange(tree, builtin.BurntFundsActorAddr, 10)
+
+		receiver := resolve(m.To())
+		access.RecordRead(receiver)
+		access.RecordWrite(receiver)
+		if to, found := tree.GetActor(receiver); found {
+			tree = tree.Impl().WithActorState(receiver, _accountState(to.Balance()+m.Value(), to.CallSeqNum()))
+		} else {
+			tree = tree.Impl().WithActorState(receiver, _accountState(m.Value(), 0))
+		}
+
+		tree = _withBalanceChange(tree, builtin.BurntFundsActorAddr, -4)
+		tree = _withBalanceChange(tree, sender, 4)
+		return tree, result(exitcode.OK, 6)
+	}
+}
+
+func _assertSameActorStates(t *testing.T, got st.StateTree, want st.StateTree) {
+	t.Helper()
+	gotStates, wantStates := got.(*st.StateTree_I).ActorStates_, want.(*st.StateTree_I).ActorStates_
+	if len(gotStates) != len(wantStates) {
+		t.Errorf("%d actors, want %d", len(gotStates), len(wantStates))
+	}
+	for a, w := range wantStates {
+		g, found := gotStates[a]
+		if !found {
+			t.Errorf("actor %v missing", a)
+			continue
+		}
+		if g.CodeID() != w.CodeID() || g.Balance() != w.Balance() || g.CallSeqNum() != w.CallSeqNum() || g.State() != w.State() {
+			t.Errorf("actor %v state %+v, want %+v", a, g, w)
+		}
+	}
+}
+
+func TestParallelExecutionMatchesSequential(t *testing.T) {
+	var ids []addr.Address
+	for id := uint64(100); id < 106; id++ {
+		ids = append(ids, _idAddr(t, id))
+	}
+	// Actors 100 and 101 also send and receive from their public key addresses; actor 110 is created
+	// by the first message it receives.
+	resolved := make(map[addr.Address]addr.Address)
+	addrs := append([]addr.Address{_idAddr(t, 110)}, ids...)
+	for _, a := range ids[:2] {
+		pubkey, err := addr.NewSecp256k1Address([]byte("public key of " + a.String()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resolved[pubkey] = a
+		addrs = append(addrs, pubkey)
+	}
+	resolve := func(a addr.Address) addr.Address {
+		if ret, found := resolved[a]; found {
+			return ret
+		}
+		return a
+	}
+
+	inTree := &st.StateTree_I{ActorStates_: map[addr.Address]actstate.ActorState{
+		builtin.BurntFundsActorAddr: _accountState(0, 0),
+	}}
+	for _, a := range ids {
+		inTree.ActorStates_[a] = _accountState(1000, 0)
+	}
+
+	var parallelCalls, totalMsgs int32
+	for seed := int64(0); seed < 20; seed++ {
+		r := rand.New(rand.NewSource(seed))
+		seqNums := make(map[addr.Address]actstate.CallSeqNum)
+		var msgs []_blockMessage
+		for i := 0; i < 40; i++ {
+			from, to := addrs[r.Intn(len(addrs))], addrs[r.Intn(len(addrs))]
+			m := &msg.UnsignedMessage_I{
+				From_:       from,
+				To_:         to,
+				CallSeqNum_: seqNums[resolve(from)],
+				Value_:      abi.TokenAmount(r.Int63n(100)),
+			}
+			seqNums[resolve(from)]++
+			msgs = append(msgs, _blockMessage{message: m})
+		}
+		totalMsgs += int32(len(msgs))
+
+		var sequentialCalls int32
+		seqTree, seqResults := _executeSequential(inTree, msgs, _transferApplier(resolve, &sequentialCalls))
+		parTree, parResults := _executeParallel(inTree, msgs, resolve, _transferApplier(resolve, &parallelCalls))
+
+		_assertSameActorStates(t, parTree, seqTree)
+		if len(parResults) != len(seqResults) {
+			t.Fatalf("seed %d: %d results, want %d", seed, len(parResults), len(seqResults))
+		}
+		for i := range seqResults {
+			got, want := parResults[i].receipt, seqResults[i].receipt
+			if got.ExitCode != want.ExitCode || !got.GasUsed.Equals(want.GasUsed) {
+				t.Errorf("seed %d: message %d receipt %+v, want %+v", seed, i, got, want)
+			}
+		}
+	}
+	// Messages to the senders of other groups invalidate speculations, so some are re-executed.
+	if parallelCalls <= totalMsgs {
+		t.Errorf("%d parallel applications of %d messages, want re-executions", parallelCalls, totalMsgs)
+	}
+}
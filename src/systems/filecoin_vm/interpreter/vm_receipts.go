@@ -0,0 +1,119 @@
+package interpreter
+
+import (
+	"errors"
+
+	addr "github.com/filecoin-project/go-address"
+	ipld "github.com/filecoin-project/specs/libraries/ipld"
+	block "github.com/filecoin-project/specs/systems/filecoin_blockchain/struct/block"
+	vmri "github.com/filecoin-project/specs/systems/filecoin_vm/runtime/impl"
+	util "github.com/filecoin-project/specs/util"
+	cid "github.com/ipfs/go-cid"
+	cbornode "github.com/ipfs/go-ipld-cbor"
+)
+
+var (
+	ErrMalformedImplicitReceipt       = errors.New("Malformed implicit receipt")
+	ErrParentMessageReceiptsMismatch  = errors.New("ParentMessageReceipts does not match parent tipset execution")
+	ErrParentImplicitReceiptsMismatch = errors.New("ParentImplicitReceipts does not match parent tipset execution")
+)
+
+// Stores a tipset's receipts, in order of execution, in an array-mapped trie and returns its root: the
+// ParentMessageReceipts of the next tipset's block headers.
+func PutMessageReceiptsAMT(store ipld.GraphStore, receipts []vmri.MessageReceipt) cid.Cid {
+	serialized := make([]block.MessageReceipt, len(receipts))
+	for i, r := range receipts {
+		serialized[i] = block.MessageReceipt(vmri.Serialize_MessageReceipt(r))
+	}
+	return block.PutMessageReceiptsAMT(store, serialized)
+}
+
+// Loads the receipts stored by PutMessageReceiptsAMT, in order of execution.
+func LoadMessageReceiptsAMT(store ipld.GraphStore, root cid.Cid) ([]vmri.MessageReceipt, error) {
+	serialized, err := block.LoadMessageReceiptsAMT(store, root)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]vmri.MessageReceipt, len(serialized))
+	for i, s := range serialized {
+		if ret[i], err = vmri.Deserialize_MessageReceipt(util.Serialization(s)); err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
+
+// Stores a tipset's implicit receipts, in order of application and without their messages (which
+// follow from their kind, miner and the state they were applied to) or traces, in an array-mapped
+// trie and returns its root: the ParentImplicitReceipts of the next tipset's block headers.
+func PutImplicitReceiptsAMT(store ipld.GraphStore, receipts []ImplicitReceipt) cid.Cid {
+	serialized := make([]block.ImplicitReceipt, len(receipts))
+	for i, r := range receipts {
+		serialized[i] = block.ImplicitReceipt(_serializeImplicitReceipt(r))
+	}
+	return block.PutImplicitReceiptsAMT(store, serialized)
+}
+
+// Loads the implicit receipts stored by PutImplicitReceiptsAMT, in order of application. Their messages
+// are nil and their traces empty.
+func LoadImplicitReceiptsAMT(store ipld.GraphStore, root cid.Cid) ([]ImplicitReceipt, error) {
+	serialized, err := block.LoadImplicitReceiptsAMT(store, root)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]ImplicitReceipt, len(serialized))
+	for i, s := range serialized {
+		if ret[i], err = _deserializeImplicitReceipt(util.Serialization(s)); err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
+
+// Checks a block header's ParentMessageReceipts and ParentImplicitReceipts against the receipts and
+// implicit receipts of its parent tipset's execution (as returned by ApplyTipSetMessages), as part of
+// the block's semantic validation. The receipts are stored in store.
+func ValidateParentReceipts(store ipld.GraphStore, h block.BlockHeader, receipts []vmri.MessageReceipt, implicitReceipts []ImplicitReceipt) error {
+	if PutMessageReceiptsAMT(store, receipts) != h.ParentMessageReceipts() {
+		return ErrParentMessageReceiptsMismatch
+	}
+	if PutImplicitReceiptsAMT(store, implicitReceipts) != h.ParentImplicitReceipts() {
+		return ErrParentImplicitReceiptsMismatch
+	}
+	return nil
+}
+
+// Serializes an implicit receipt as the DAG-CBOR tuple [Kind, Miner, Receipt], with Receipt as
+// serialized by Serialize_MessageReceipt.
+func _serializeImplicitReceipt(r ImplicitReceipt) util.Serialization {
+	tuple := []interface{}{
+		uint64(r.Kind),
+		r.Miner.Bytes(),
+		[]byte(vmri.Serialize_MessageReceipt(r.Receipt)),
+	}
+	ret, err := cbornode.DumpObject(tuple)
+	Assert(err == nil)
+	return util.Serialization(ret)
+}
+
+func _deserializeImplicitReceipt(s util.Serialization) (ImplicitReceipt, error) {
+	var tuple []interface{}
+	if err := cbornode.DecodeInto(s, &tuple); err != nil || len(tuple) != 3 {
+		return ImplicitReceipt{}, ErrMalformedImplicitReceipt
+	}
+	kind, kindOk := tuple[0].(int) // DAG-CBOR integers decode as int.
+	minerBytes, minerOk := tuple[1].([]byte)
+	receiptBytes, receiptOk := tuple[2].([]byte)
+	if !kindOk || !minerOk || !receiptOk {
+		return ImplicitReceipt{}, ErrMalformedImplicitReceipt
+	}
+	miner, err := addr.NewFromBytes(minerBytes)
+	if err != nil {
+		return ImplicitReceipt{}, ErrMalformedImplicitReceipt
+	}
+	receipt, err := vmri.Deserialize_MessageReceipt(util.Serialization(receiptBytes))
+	if err != nil {
+		return ImplicitReceipt{}, err
+	}
+	return ImplicitReceipt{Kind: ImplicitMessageKind(kind), Miner: miner, Receipt: receipt}, nil
+}
@@ -0,0 +1,114 @@
+package interpreter
+
+import (
+	"testing"
+
+	addr "github.com/filecoin-project/go-address"
+	builtin "github.com/filecoin-project/specs-actors/actors/builtin"
+	exitcode "github.com/filecoin-project/specs-actors/actors/runtime/exitcode"
+	ipld "github.com/filecoin-project/specs/libraries/ipld"
+	block "github.com/filecoin-project/specs/systems/filecoin_blockchain/struct/block"
+	msg "github.com/filecoin-project/specs/systems/filecoin_vm/message"
+	vmri "github.com/filecoin-project/specs/systems/filecoin_vm/runtime/impl"
+)
+
+// Returns n receipts with distinct exit codes, return values and gas, every other one with events.
+func _testReceipts(store *ipld.MemGraphStore, n int) []vmri.MessageReceipt {
+	var ret []vmri.MessageReceipt
+	for i := 0; i < n; i++ {
+		r := vmri.MessageReceipt{
+			ExitCode:    exitcode.ExitCode(i % 3),
+			ReturnValue: []byte{byte(i)},
+			GasUsed:     msg.GasAmount_FromInt(1000 * i),
+		}
+		if i%2 == 1 {
+			r.EventsRoot = store.Put([]byte{byte(i)}) // Any link.
+		}
+		ret = append(ret, r)
+	}
+	return ret
+}
+
+func _testImplicitReceipts(t *testing.T, store *ipld.MemGraphStore) []ImplicitReceipt {
+	miner, err := addr.NewIDAddress(1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	receipts := _testReceipts(store, 3)
+	return []ImplicitReceipt{
+		{Kind: ImplicitMessageKind_ElectionPoSt, Miner: miner, Receipt: receipts[0]},
+		{Kind: ImplicitMessageKind_BlockReward, Miner: miner, Receipt: receipts[1]},
+		{Kind: ImplicitMessageKind_CronTick, Miner: builtin.SystemActorAddr, Receipt: receipts[2]},
+	}
+}
+
+func TestReceiptsAMTRoundTrip(t *testing.T) {
+	store := ipld.MemGraphStore_Make()
+	for _, n := range []int{0, 1, 9, 100} {
+		receipts := _testReceipts(store, n)
+		loaded, err := LoadMessageReceiptsAMT(store, PutMessageReceiptsAMT(store, receipts))
+		if err != nil {
+			t.Fatalf("%d receipts: %v", n, err)
+		}
+		if len(loaded) != n {
+			t.Fatalf("%d receipts: loaded %d", n, len(loaded))
+		}
+		for i := range receipts {
+			if !vmri.MessageReceipt_Equals(loaded[i], receipts[i]) {
+				t.Errorf("%d receipts: receipt %d loaded as %v, want %v", n, i, loaded[i], receipts[i])
+			}
+		}
+	}
+
+	implicitReceipts := _testImplicitReceipts(t, store)
+	loaded, err := LoadImplicitReceiptsAMT(store, PutImplicitReceiptsAMT(store, implicitReceipts))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded) != len(implicitReceipts) {
+		t.Fatalf("loaded %d implicit receipts, want %d", len(loaded), len(implicitReceipts))
+	}
+	for i, r := range implicitReceipts {
+		l := loaded[i]
+		if l.Kind != r.Kind || l.Miner != r.Miner || !vmri.MessageReceipt_Equals(l.Receipt, r.Receipt) {
+			t.Errorf("implicit receipt %d loaded as %v, want %v", i, l, r)
+		}
+	}
+}
+
+func TestValidateParentReceipts(t *testing.T) {
+	store := ipld.MemGraphStore_Make()
+	receipts := _testReceipts(store, 5)
+	implicitReceipts := _testImplicitReceipts(t, store)
+	h := &block.BlockHeader_I{
+		ParentMessageReceipts_:  PutMessageReceiptsAMT(store, receipts),
+		ParentImplicitReceipts_: PutImplicitReceiptsAMT(store, implicitReceipts),
+	}
+
+	if err := ValidateParentReceipts(store, h, receipts, implicitReceipts); err != nil {
+		t.Errorf("matching receipts: %v", err)
+	}
+
+	changedReceipt := append([]vmri.MessageReceipt{}, receipts...)
+	changedReceipt[2].GasUsed = msg.GasAmount_FromInt(1)
+	changedImplicit := append([]ImplicitReceipt{}, implicitReceipts...)
+	changedImplicit[1].Receipt.ExitCode = exitcode.OutOfGas
+
+	cases := []struct {
+		name             string
+		receipts         []vmri.MessageReceipt
+		implicitReceipts []ImplicitReceipt
+		err              error
+	}{
+		{"changed receipt", changedReceipt, implicitReceipts, ErrParentMessageReceiptsMismatch},
+		{"missing receipt", receipts[:4], implicitReceipts, ErrParentMessageReceiptsMismatch},
+		{"reordered receipts", append(receipts[1:2:2], receipts[0], receipts[2], receipts[3], receipts[4]), implicitReceipts, ErrParentMessageReceiptsMismatch},
+		{"changed implicit receipt", receipts, changedImplicit, ErrParentImplicitReceiptsMismatch},
+		{"missing implicit receipt", receipts, implicitReceipts[:2], ErrParentImplicitReceiptsMismatch},
+	}
+	for _, c := range cases {
+		if err := ValidateParentReceipts(store, h, c.receipts, c.implicitReceipts); err != c.err {
+			t.Errorf("%s: error %v, want %v", c.name, err, c.err)
+		}
+	}
+}
@@ -2,32 +2,67 @@ package interpreter
 
 import (
 	"errors"
+	"reflect"
 
 	abi "github.com/filecoin-project/specs-actors/actors/abi"
 	builtin "github.com/filecoin-project/specs-actors/actors/builtin"
 	accact "github.com/filecoin-project/specs-actors/actors/builtin/account"
 	cronact "github.com/filecoin-project/specs-actors/actors/builtin/cron"
 	initact "github.com/filecoin-project/specs-actors/actors/builtin/init"
+	msigact "github.com/filecoin-project/specs-actors/actors/builtin/multisig"
+	paychact "github.com/filecoin-project/specs-actors/actors/builtin/paych"
+	rewardact "github.com/filecoin-project/specs-actors/actors/builtin/reward"
 	smarkact "github.com/filecoin-project/specs-actors/actors/builtin/storage_market"
+	sminact "github.com/filecoin-project/specs-actors/actors/builtin/storage_miner"
 	spowact "github.com/filecoin-project/specs-actors/actors/builtin/storage_power"
+	sysact "github.com/filecoin-project/specs-actors/actors/builtin/system"
 	vmr "github.com/filecoin-project/specs-actors/actors/runtime"
+	vmri "github.com/filecoin-project/specs/systems/filecoin_vm/runtime/impl"
 )
 
 var (
-	ErrActorNotFound = errors.New("Actor Not Found")
+	ErrActorNotFound          = errors.New("Actor Not Found")
+	ErrActorStateTypeNotFound = errors.New("Actor state type not found")
 )
 
-var staticActorCodeRegistry = &actorCodeRegistry{}
+// ActorCodeRegistry maps actor code IDs to the code executed for actors of that type, and to the
+// type of their substate.
+// It is the default implementation of the runtime's ActorCodeLoader.
+type ActorCodeRegistry struct {
+	code   map[abi.ActorCodeID]vmr.ActorCode
+	states map[abi.ActorCodeID]reflect.Type
+}
+
+var _ vmri.ActorCodeLoader = &ActorCodeRegistry{}
 
-type actorCodeRegistry struct {
-	code map[abi.ActorCodeID]vmr.ActorCode
+func ActorCodeRegistry_Make() *ActorCodeRegistry {
+	return &ActorCodeRegistry{
+		code:   make(map[abi.ActorCodeID]vmr.ActorCode),
+		states: make(map[abi.ActorCodeID]reflect.Type),
+	}
 }
 
-func (r *actorCodeRegistry) _registerActor(id abi.ActorCodeID, actor vmr.ActorCode) {
+// Registers code for a code ID, replacing any code previously registered for it.
+func (r *ActorCodeRegistry) RegisterActor(id abi.ActorCodeID, actor vmr.ActorCode) {
 	r.code[id] = actor
 }
 
-func (r *actorCodeRegistry) _loadActor(id abi.ActorCodeID) (vmr.ActorCode, error) {
+// Registers the substate type for a code ID, given a (zero) value of that type.
+func (r *ActorCodeRegistry) RegisterActorState(id abi.ActorCodeID, state interface{}) {
+	r.states[id] = reflect.TypeOf(state)
+}
+
+// Returns a pointer to a new zero value of the substate type registered for a code ID,
+// into which a substate may be deserialized.
+func (r *ActorCodeRegistry) NewActorState(id abi.ActorCodeID) (interface{}, error) {
+	t, ok := r.states[id]
+	if !ok {
+		return nil, ErrActorStateTypeNotFound
+	}
+	return reflect.New(t).Interface(), nil
+}
+
+func (r *ActorCodeRegistry) LoadActorCode(id abi.ActorCodeID) (vmr.ActorCode, error) {
 	a, ok := r.code[id]
 	if !ok {
 		return nil, ErrActorNotFound
@@ -35,33 +70,35 @@ func (r *actorCodeRegistry) _loadActor(id abi.ActorCodeID) (vmr.ActorCode, error
 	return a, nil
 }
 
-func RegisterActor(id abi.ActorCodeID, actor vmr.ActorCode) {
-	staticActorCodeRegistry._registerActor(id, actor)
-}
-
-func LoadActor(id abi.ActorCodeID) (vmr.ActorCode, error) {
-	return staticActorCodeRegistry._loadActor(id)
-}
-
-// init is called in Go during initialization of a program.
-// this is an idiomatic way to do this. Implementations should approach this
-// however they wish. The point is to initialize a static registry with
-// built in pure types that have the code for each actor. Once we have
-// a way to load code from the StateTree, use that instead.
-func init() {
-	_registerBuiltinActors()
-}
+// The registry used by interpreters which are not configured with an ActorCodeLoader.
+// It is never modified after initialization.
+var _builtinActorCodeRegistry = BuiltinActorCodeRegistry_Make()
 
-func _registerBuiltinActors() {
-	// TODO
+// Returns a new registry populated with the builtin pure types that have the code for each actor.
+// Each call returns a distinct registry, so callers may register mock or upgraded actors in it
+// without affecting other registries. Implementations should approach this however they wish.
+// Once we have a way to load code from the StateTree, use that instead.
+func BuiltinActorCodeRegistry_Make() *ActorCodeRegistry {
+	r := ActorCodeRegistry_Make()
 
 	cron := &cronact.CronActor{}
 
-	RegisterActor(builtin.InitActorCodeID, &initact.InitActor{})
-	RegisterActor(builtin.CronActorCodeID, cron)
-	RegisterActor(builtin.AccountActorCodeID, &accact.AccountActor{})
-	RegisterActor(builtin.StoragePowerActorCodeID, &spowact.StoragePowerActor{})
-	RegisterActor(builtin.StorageMarketActorCodeID, &smarkact.StorageMarketActor{})
+	r.RegisterActor(builtin.InitActorCodeID, &initact.InitActor{})
+	r.RegisterActor(builtin.CronActorCodeID, cron)
+	r.RegisterActor(builtin.AccountActorCodeID, &accact.AccountActor{})
+	r.RegisterActor(builtin.StoragePowerActorCodeID, &spowact.StoragePowerActor{})
+	r.RegisterActor(builtin.StorageMarketActorCodeID, &smarkact.StorageMarketActor{})
+
+	r.RegisterActorState(builtin.InitActorCodeID, initact.InitActorState{})
+	r.RegisterActorState(builtin.AccountActorCodeID, accact.AccountActorState{})
+	r.RegisterActorState(builtin.StoragePowerActorCodeID, spowact.StoragePowerActorState{})
+	r.RegisterActorState(builtin.StorageMarketActorCodeID, smarkact.StorageMarketActorState{})
+	r.RegisterActorState(builtin.StorageMinerActorCodeID, sminact.StorageMinerActorState{})
+	r.RegisterActorState(builtin.SystemActorCodeID, sysact.SystemActorState{})
+	r.RegisterActorState(builtin.CronActorCodeID, cronact.CronActorState{})
+	r.RegisterActorState(builtin.RewardActorCodeID, rewardact.RewardActorState{})
+	r.RegisterActorState(builtin.MultisigActorCodeID, msigact.MultiSigActorState{})
+	r.RegisterActorState(builtin.PaymentChannelActorCodeID, paychact.PaymentChannelActorState{})
 
 	// wire in CRON actions.
 	// TODO: move this to CronActor's constructor method
@@ -74,4 +111,6 @@ func _registerBuiltinActors() {
 		ToAddr:    builtin.StorageMarketActorAddr,
 		MethodNum: builtin.Method_StorageMarketActor_OnEpochTickEnd,
 	})
+
+	return r
 }
@@ -0,0 +1,185 @@
+package interpreter
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	ipld "github.com/filecoin-project/specs/libraries/ipld"
+	car "github.com/filecoin-project/specs/libraries/ipld/car"
+	msg "github.com/filecoin-project/specs/systems/filecoin_vm/message"
+	vmri "github.com/filecoin-project/specs/systems/filecoin_vm/runtime/impl"
+	st "github.com/filecoin-project/specs/systems/filecoin_vm/state_tree"
+	util "github.com/filecoin-project/specs/util"
+	cid "github.com/ipfs/go-cid"
+)
+
+var (
+	ErrReplayBlockNotFound = errors.New("Replay block not found in store")
+	ErrReplayFixtureRoots  = errors.New("Replay fixture CAR file must have exactly one root")
+	ErrReplayReceiptCount  = errors.New("Replay produced a receipt count different from the executed message count")
+)
+
+// The first point at which a replayed tipset execution differs from the recorded one.
+type ReplayDivergence struct {
+	// Position of the message among the tipset's executed messages (i.e., the explicit messages,
+	// excluding duplicates), which is also the position of its receipt.
+	Index   int
+	Message msg.UnsignedMessage
+	// Nil if no receipt was recorded at this position.
+	Expected *vmri.MessageReceipt
+	// Nil if the replay produced no receipt at this position.
+	Actual *vmri.MessageReceipt
+	Trace  vmri.ExecutionTrace
+}
+
+type ReplayReport struct {
+	ExpectedStateRoot cid.Cid
+	ActualStateRoot   cid.Cid
+	// Nil if every receipt matches the recorded one.
+	FirstDivergence *ReplayDivergence
+	// The replay's implicit message receipts, with their traces (which are not recorded on chain).
+	ImplicitReceipts []ImplicitReceipt
+}
+
+func (r *ReplayReport) StateRootMatches() bool {
+	return r.ExpectedStateRoot.Equals(r.ActualStateRoot)
+}
+
+// Returns whether the replay reproduced the recorded execution exactly.
+func (r *ReplayReport) OK() bool {
+	return r.StateRootMatches() && r.FirstDivergence == nil
+}
+
+func (r *ReplayReport) String() string {
+	ret := fmt.Sprintf("state root: expected %v, actual %v\n", r.ExpectedStateRoot, r.ActualStateRoot)
+	d := r.FirstDivergence
+	if d == nil {
+		return ret + "receipts: all match\n"
+	}
+	ret += fmt.Sprintf("receipts: first divergence at message %v\n", d.Index)
+	ret += fmt.Sprintf("  expected: %v\n  actual:   %v\n", _receiptString(d.Expected), _receiptString(d.Actual))
+	ret += "  trace:\n" + d.Trace.String()
+	return ret
+}
+
+func _receiptString(r *vmri.MessageReceipt) string {
+	if r == nil {
+		return "(none)"
+	}
+	return fmt.Sprintf("exit %v, gas used %v, return %x", r.ExitCode, r.GasUsed, r.ReturnValue)
+}
+
+// Loads a replay fixture from an exported CARv1 file, whose single root is the ReplayFixture, and
+// which holds every block it links to. The blocks are read into store.
+func LoadReplayFixtureCAR(r io.Reader, store ipld.GraphStore) (ReplayFixture, error) {
+	roots, err := car.Load(r, store)
+	if err != nil {
+		return nil, err
+	}
+	if len(roots) != 1 {
+		return nil, ErrReplayFixtureRoots
+	}
+	return LoadReplayFixture(store, roots[0])
+}
+
+// Loads a replay fixture from an export whose blocks have been imported into store.
+func LoadReplayFixture(store ipld.GraphStore, root cid.Cid) (ReplayFixture, error) {
+	serialized, ok := store.Get(root)
+	if !ok {
+		return nil, ErrReplayBlockNotFound
+	}
+	return Deserialize_ReplayFixture(util.Serialization(serialized))
+}
+
+// Re-executes a recorded tipset with ApplyTipSetMessages, on the recorded parent state, and checks
+// the resulting state root and receipts against those recorded.
+// The fixture's blocks must be available in the node's state store.
+func (vmi *VMInterpreter_I) ReplayTipSet(fixture ReplayFixture) (*ReplayReport, error) {
+	store := vmi.Node().Repository().StateStore()
+	serialized, ok := store.Get(fixture.ParentStateRoot())
+	if !ok {
+		return nil, ErrReplayBlockNotFound
+	}
+	inTree, err := st.Deserialize_StateTree(util.Serialization(serialized))
+	if err != nil {
+		return nil, err
+	}
+
+	// The replayed tipset is not applied to the node's chain, so its execution is not recorded for event
+	// dispatch.
+	replayer := *vmi
+	replayer.EventSink_ = nil
+	outTree, receipts, traces, implicitReceipts := replayer.ApplyTipSetMessages(inTree, fixture.Tipset(), fixture.Messages())
+	executed := _executedMessages(fixture.Messages())
+	if len(executed) != len(receipts) {
+		return nil, ErrReplayReceiptCount
+	}
+
+	report := &ReplayReport{
+		ExpectedStateRoot: fixture.ExpectedStateRoot(),
+		ActualStateRoot:   outTree.RootCID(),
+		ImplicitReceipts:  implicitReceipts,
+	}
+
+	expected := fixture.ExpectedReceipts()
+	for i := 0; i < util.IntMax(len(receipts), len(expected)); i++ {
+		d := &ReplayDivergence{Index: i}
+		if i < len(expected) {
+			d.Expected = &expected[i]
+		}
+		if i < len(receipts) {
+			d.Message = executed[i]
+			d.Actual = &receipts[i]
+			d.Trace = traces[i]
+		}
+		if d.Expected == nil || d.Actual == nil || !vmri.MessageReceipt_Equals(*d.Expected, *d.Actual) {
+			report.FirstDivergence = d
+			break
+		}
+	}
+
+	return report, nil
+}
+
+// Loads a replay fixture exported as a CAR file into the node's state store, and replays it.
+func (vmi *VMInterpreter_I) ReplayTipSetCAR(r io.Reader) (*ReplayReport, error) {
+	fixture, err := LoadReplayFixtureCAR(r, vmi.Node().Repository().StateStore())
+	if err != nil {
+		return nil, err
+	}
+	return vmi.ReplayTipSet(fixture)
+}
+
+// Returns the messages of a tipset which are executed, and so produce receipts, in execution order.
+// This matches the iteration in ApplyTipSetMessages.
+func _executedMessages(msgs TipSetMessages) []msg.UnsignedMessage {
+	var ret []msg.UnsignedMessage
+	for _, blockMsgs := range _executedBlockMessages(msgs) {
+		ret = append(ret, blockMsgs...)
+	}
+	return ret
+}
+
+// Returns the executed messages of each block of a tipset, in execution order: a message included
+// in more than one block is executed with the first.
+func _executedBlockMessages(msgs TipSetMessages) [][]msg.UnsignedMessage {
+	ret := make([][]msg.UnsignedMessage, len(msgs.Blocks()))
+	seenMsgs := make(map[cid.Cid]struct{})
+	for i, blk := range msgs.Blocks() {
+		add := func(m msg.UnsignedMessage) {
+			if _, found := seenMsgs[_msgCID(m)]; found {
+				return
+			}
+			ret[i] = append(ret[i], m)
+			seenMsgs[_msgCID(m)] = struct{}{}
+		}
+		for _, m := range blk.BLSMessages() {
+			add(m)
+		}
+		for _, sm := range blk.SECPMessages() {
+			add(sm.Message())
+		}
+	}
+	return ret
+}
@@ -0,0 +1,150 @@
+package interpreter
+
+import (
+	"errors"
+
+	abi "github.com/filecoin-project/specs-actors/actors/abi"
+	ipld "github.com/filecoin-project/specs/libraries/ipld"
+	node_base "github.com/filecoin-project/specs/systems/filecoin_nodes/node_base"
+	gascost "github.com/filecoin-project/specs/systems/filecoin_vm/runtime/gascost"
+	vmri "github.com/filecoin-project/specs/systems/filecoin_vm/runtime/impl"
+	st "github.com/filecoin-project/specs/systems/filecoin_vm/state_tree"
+)
+
+var (
+	ErrUpgradeScheduleInvalid = errors.New("Upgrade schedule epochs and versions must be strictly increasing")
+)
+
+// A StateMigration transforms the state tree at a network upgrade, before any message is applied
+// at or after the upgrade epoch.
+// It must be deterministic and depend only on its inputs, so that it can be tested in isolation
+// against a fixture state tree.
+type StateMigration func(store ipld.GraphStore, inTree st.StateTree) (st.StateTree, error)
+
+// A NetworkUpgrade changes the protocol from a given epoch onwards.
+type NetworkUpgrade struct {
+	// The first epoch at which the upgraded protocol is in effect.
+	Epoch   abi.ChainEpoch
+	Version node_base.NetworkVersion
+
+	// Optional; run on the parent state of the first tipset at or after Epoch.
+	Migration StateMigration
+
+	// Replacement configuration, in effect from Epoch.
+	// A nil value leaves the configuration of the previous version in place.
+	ActorCodeLoader vmri.ActorCodeLoader
+	GasSchedule     *gascost.GasSchedule
+	NetworkParams   *node_base.NetworkParams
+}
+
+// An UpgradeSchedule lists network upgrades in order of strictly increasing epoch and version.
+type UpgradeSchedule []NetworkUpgrade
+
+// The protocol configuration in effect at some epoch.
+type NetworkConfig struct {
+	Version         node_base.NetworkVersion
+	ActorCodeLoader vmri.ActorCodeLoader
+	GasSchedule     *gascost.GasSchedule
+	NetworkParams   *node_base.NetworkParams
+}
+
+func (s UpgradeSchedule) Validate() error {
+	for i := 1; i < len(s); i++ {
+		if s[i].Epoch <= s[i-1].Epoch || s[i].Version <= s[i-1].Version {
+			return ErrUpgradeScheduleInvalid
+		}
+	}
+	return nil
+}
+
+// Returns the configuration in effect at an epoch: the genesis configuration, updated by each
+// upgrade scheduled at or before that epoch.
+func (s UpgradeSchedule) ConfigAtEpoch(genesis NetworkConfig, epoch abi.ChainEpoch) NetworkConfig {
+	ret := genesis
+	for _, u := range s {
+		if u.Epoch > epoch {
+			break
+		}
+		ret.Version = u.Version
+		if u.ActorCodeLoader != nil {
+			ret.ActorCodeLoader = u.ActorCodeLoader
+		}
+		if u.GasSchedule != nil {
+			ret.GasSchedule = u.GasSchedule
+		}
+		if u.NetworkParams != nil {
+			ret.NetworkParams = u.NetworkParams
+		}
+	}
+	return ret
+}
+
+// Returns the upgrades scheduled in the epoch range (after, upTo], in order.
+// Since epochs may be null rounds, a tipset must run the migrations for every upgrade since its
+// parent's epoch, not only one scheduled at its own epoch.
+func (s UpgradeSchedule) UpgradesInRange(after abi.ChainEpoch, upTo abi.ChainEpoch) []NetworkUpgrade {
+	var ret []NetworkUpgrade
+	for _, u := range s {
+		if u.Epoch > after && u.Epoch <= upTo {
+			ret = append(ret, u)
+		}
+	}
+	return ret
+}
+
+// Applies the migrations of a sequence of upgrades to a state tree, in order.
+func RunStateMigrations(store ipld.GraphStore, inTree st.StateTree, upgrades []NetworkUpgrade) (st.StateTree, error) {
+	tree := inTree
+	for _, u := range upgrades {
+		if u.Migration == nil {
+			continue
+		}
+		var err error
+		tree, err = u.Migration(store, tree)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return tree, nil
+}
+
+func (vmi *VMInterpreter_I) _genesisNetworkConfig() NetworkConfig {
+	codeLoader := vmi.ActorCodeLoader()
+	if codeLoader == nil {
+		codeLoader = _builtinActorCodeRegistry
+	}
+	return NetworkConfig{
+		Version:         node_base.GenesisNetworkVersion,
+		ActorCodeLoader: codeLoader,
+		GasSchedule:     gascost.GenesisGasSchedule,
+		NetworkParams:   node_base.GenesisNetworkParams,
+	}
+}
+
+// The number of address resolutions cached by an interpreter's CachingAddressResolver.
+const AddressResolverCacheSize = 1 << 16 // placeholder
+
+// Returns an interpreter for a node, applying the network upgrades in schedule, which must be valid,
+// and resolving addresses with a CachingAddressResolver over the node's state store.
+// The node's other subsystems read the network parameters in effect at each epoch from the interpreter,
+// as the node's NetworkParams.
+func VMInterpreter_Make(node node_base.FilecoinNode, schedule UpgradeSchedule) (*VMInterpreter_I, error) {
+	if err := schedule.Validate(); err != nil {
+		return nil, err
+	}
+	return &VMInterpreter_I{
+		Node_:            node,
+		AddressResolver_: vmri.CachingAddressResolver_Make(node.Repository().StateStore(), AddressResolverCacheSize),
+		UpgradeSchedule_: schedule,
+	}, nil
+}
+
+// Returns the protocol configuration in effect at an epoch, under this interpreter's upgrade schedule.
+func (vmi *VMInterpreter_I) NetworkConfigAtEpoch(epoch abi.ChainEpoch) NetworkConfig {
+	return vmi.UpgradeSchedule().ConfigAtEpoch(vmi._genesisNetworkConfig(), epoch)
+}
+
+// The network parameters in effect at an epoch; the interpreter is its node's NetworkParamsSource.
+func (vmi *VMInterpreter_I) NetworkParamsAtEpoch(epoch abi.ChainEpoch) *node_base.NetworkParams {
+	return vmi.NetworkConfigAtEpoch(epoch).NetworkParams
+}
@@ -0,0 +1,158 @@
+package interpreter
+
+import (
+	"errors"
+	"testing"
+
+	addr "github.com/filecoin-project/go-address"
+	abi "github.com/filecoin-project/specs-actors/actors/abi"
+	builtin "github.com/filecoin-project/specs-actors/actors/builtin"
+	ipld "github.com/filecoin-project/specs/libraries/ipld"
+	node_base "github.com/filecoin-project/specs/systems/filecoin_nodes/node_base"
+	actstate "github.com/filecoin-project/specs/systems/filecoin_vm/actor"
+	st "github.com/filecoin-project/specs/systems/filecoin_vm/state_tree"
+)
+
+func _idAddr(t *testing.T, id uint64) addr.Address {
+	a, err := addr.NewIDAddress(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+// A fixture state tree with the init actor and two account actors.
+func _fixtureStateTree(t *testing.T) st.StateTree {
+	return &st.StateTree_I{
+		ActorStates_: map[addr.Address]actstate.ActorState{
+			builtin.InitActorAddr: &actstate.ActorState_I{CodeID_: builtin.InitActorCodeID},
+			_idAddr(t, 100):       &actstate.ActorState_I{CodeID_: builtin.AccountActorCodeID, Balance_: abi.TokenAmount(1000), CallSeqNum_: 3},
+			_idAddr(t, 101):       &actstate.ActorState_I{CodeID_: builtin.AccountActorCodeID, Balance_: abi.TokenAmount(50)},
+		},
+	}
+}
+
+// A migration which moves amount from one actor's balance to another's, leaving the input tree unchanged.
+func _transferMigration(from addr.Address, to addr.Address, amount abi.TokenAmount) StateMigration {
+	return func(store ipld.GraphStore, inTree st.StateTree) (st.StateTree, error) {
+		actors := make(map[addr.Address]actstate.ActorState)
+		for a, act := range inTree.ActorStates() {
+			actors[a] = act
+		}
+		fromActor, toActor := actors[from], actors[to]
+		if fromActor == nil || toActor == nil || fromActor.Balance() < amount {
+			return nil, errors.New("transfer migration failed")
+		}
+		actors[from] = &actstate.ActorState_I{CodeID_: fromActor.CodeID(), State_: fromActor.State(), Balance_: fromActor.Balance() - amount, CallSeqNum_: fromActor.CallSeqNum()}
+		actors[to] = &actstate.ActorState_I{CodeID_: toActor.CodeID(), State_: toActor.State(), Balance_: toActor.Balance() + amount, CallSeqNum_: toActor.CallSeqNum()}
+		return &st.StateTree_I{ActorStates_: actors}, nil
+	}
+}
+
+func _balance(t *testing.T, tree st.StateTree, a addr.Address) abi.TokenAmount {
+	act, found := tree.GetActor(a)
+	if !found {
+		t.Fatalf("actor %v not found", a)
+	}
+	return act.Balance()
+}
+
+func TestUpgradeScheduleValidate(t *testing.T) {
+	cases := []struct {
+		name     string
+		schedule UpgradeSchedule
+		err      error
+	}{
+		{"empty", nil, nil},
+		{"increasing", UpgradeSchedule{{Epoch: 10, Version: 1}, {Epoch: 20, Version: 2}}, nil},
+		{"same epoch", UpgradeSchedule{{Epoch: 10, Version: 1}, {Epoch: 10, Version: 2}}, ErrUpgradeScheduleInvalid},
+		{"decreasing epoch", UpgradeSchedule{{Epoch: 20, Version: 1}, {Epoch: 10, Version: 2}}, ErrUpgradeScheduleInvalid},
+		{"same version", UpgradeSchedule{{Epoch: 10, Version: 1}, {Epoch: 20, Version: 1}}, ErrUpgradeScheduleInvalid},
+	}
+	for _, c := range cases {
+		if err := c.schedule.Validate(); err != c.err {
+			t.Errorf("%s: Validate() = %v, want %v", c.name, err, c.err)
+		}
+		// An invalid schedule is rejected before the node is used.
+		if c.err != nil {
+			if _, err := VMInterpreter_Make(nil, c.schedule); err != c.err {
+				t.Errorf("%s: VMInterpreter_Make() = %v, want %v", c.name, err, c.err)
+			}
+		}
+	}
+}
+
+func TestNetworkParamsAtEpoch(t *testing.T) {
+	upgraded := &node_base.NetworkParams{Finality: 900, SPCLookbackTicket: 2}
+	vmi := &VMInterpreter_I{UpgradeSchedule_: UpgradeSchedule{
+		{Epoch: 10, Version: 1},
+		{Epoch: 20, Version: 2, NetworkParams: upgraded},
+		{Epoch: 30, Version: 3},
+	}}
+
+	cases := []struct {
+		epoch   abi.ChainEpoch
+		version node_base.NetworkVersion
+		params  *node_base.NetworkParams
+	}{
+		{0, node_base.GenesisNetworkVersion, node_base.GenesisNetworkParams},
+		{9, node_base.GenesisNetworkVersion, node_base.GenesisNetworkParams},
+		{10, 1, node_base.GenesisNetworkParams},
+		{19, 1, node_base.GenesisNetworkParams},
+		{20, 2, upgraded},
+		{35, 3, upgraded},
+	}
+	for _, c := range cases {
+		config := vmi.NetworkConfigAtEpoch(c.epoch)
+		if config.Version != c.version {
+			t.Errorf("epoch %d: version %d, want %d", c.epoch, config.Version, c.version)
+		}
+		if got := node_base.NetworkParamsAtEpoch(vmi, c.epoch); got != c.params {
+			t.Errorf("epoch %d: params %+v, want %+v", c.epoch, got, c.params)
+		}
+	}
+	if got := node_base.NetworkParamsAtEpoch(nil, 35); got != node_base.GenesisNetworkParams {
+		t.Errorf("nil source: params %+v, want genesis params", got)
+	}
+}
+
+func TestRunStateMigrations(t *testing.T) {
+	a100, a101 := _idAddr(t, 100), _idAddr(t, 101)
+	schedule := UpgradeSchedule{
+		{Epoch: 10, Version: 1, Migration: _transferMigration(a100, a101, 600)},
+		{Epoch: 11, Version: 2},
+		{Epoch: 12, Version: 3, Migration: _transferMigration(a100, a101, 400)},
+		{Epoch: 20, Version: 4, Migration: _transferMigration(a100, a101, 1)},
+	}
+
+	// A tipset at epoch 13 whose parent is at epoch 9 (after null rounds) runs the migrations of the
+	// upgrades at 10 and 12, in order, and not that at 20.
+	upgrades := schedule.UpgradesInRange(9, 13)
+	if len(upgrades) != 3 {
+		t.Fatalf("UpgradesInRange(9, 13) has %d upgrades, want 3", len(upgrades))
+	}
+	inTree := _fixtureStateTree(t)
+	outTree, err := RunStateMigrations(nil, inTree, upgrades)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := _balance(t, outTree, a100); b != 0 {
+		t.Errorf("migrated balance of %v is %d, want 0", a100, b)
+	}
+	if b := _balance(t, outTree, a101); b != 1050 {
+		t.Errorf("migrated balance of %v is %d, want 1050", a101, b)
+	}
+	if act, _ := outTree.GetActor(a100); act.CallSeqNum() != 3 {
+		t.Errorf("migrated CallSeqNum of %v is %d, want 3", a100, act.CallSeqNum())
+	}
+
+	// The fixture is unchanged.
+	if b := _balance(t, inTree, a100); b != 1000 {
+		t.Errorf("fixture balance of %v is %d, want 1000", a100, b)
+	}
+
+	// A failing migration fails the sequence: the upgrade at 20 finds no balance left.
+	if _, err := RunStateMigrations(nil, outTree, schedule.UpgradesInRange(13, 20)); err == nil {
+		t.Error("RunStateMigrations succeeded with a failing migration")
+	}
+}
@@ -1,6 +1,8 @@
 package message
 
 import (
+	"math/big"
+
 	filcrypto "github.com/filecoin-project/specs/algorithms/crypto"
 	util "github.com/filecoin-project/specs/util"
 )
@@ -45,13 +47,15 @@ func Verify(message SignedMessage, publicKey filcrypto.PublicKey) (UnsignedMessa
 }
 
 func (x *GasAmount_I) Add(y GasAmount) GasAmount {
-	IMPL_FINISH()
-	panic("")
+	ret := &GasAmount_I{}
+	ret.value_.Add(&x.value_, &y.Impl().value_)
+	return ret
 }
 
 func (x *GasAmount_I) Subtract(y GasAmount) GasAmount {
-	IMPL_FINISH()
-	panic("")
+	ret := &GasAmount_I{}
+	ret.value_.Sub(&x.value_, &y.Impl().value_)
+	return ret
 }
 
 func (x *GasAmount_I) SubtractIfNonnegative(y GasAmount) (ret GasAmount, ok bool) {
@@ -65,18 +69,17 @@ func (x *GasAmount_I) SubtractIfNonnegative(y GasAmount) (ret GasAmount, ok bool
 }
 
 func (x *GasAmount_I) LessThan(y GasAmount) bool {
-	IMPL_FINISH()
-	panic("")
+	return x.value_.Cmp(&y.Impl().value_) < 0
 }
 
 func (x *GasAmount_I) Equals(y GasAmount) bool {
-	IMPL_FINISH()
-	panic("")
+	return x.value_.Cmp(&y.Impl().value_) == 0
 }
 
 func (x *GasAmount_I) Scale(count int) GasAmount {
-	IMPL_FINISH()
-	panic("")
+	ret := &GasAmount_I{}
+	ret.value_.Mul(&x.value_, big.NewInt(int64(count)))
+	return ret
 }
 
 func GasAmount_Affine(b GasAmount, x int, m GasAmount) GasAmount {
@@ -88,8 +91,21 @@ func GasAmount_Zero() GasAmount {
 }
 
 func GasAmount_FromInt(x int) GasAmount {
-	IMPL_FINISH()
-	panic("")
+	ret := &GasAmount_I{}
+	ret.value_.SetInt64(int64(x))
+	return ret
+}
+
+// Returns a gas amount as a big integer, for arithmetic (such as multiplication by a price) which may
+// exceed the range of an int.
+func GasAmount_AsBigInt(x GasAmount) *util.BigInt {
+	return new(util.BigInt).Set(&x.Impl().value_)
+}
+
+func GasAmount_FromBigInt(x *util.BigInt) GasAmount {
+	ret := &GasAmount_I{}
+	ret.value_.Set(x)
+	return ret
 }
 
 func GasAmount_SentinelUnlimited() GasAmount {
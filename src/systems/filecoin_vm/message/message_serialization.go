@@ -0,0 +1,206 @@
+package message
+
+import (
+	"errors"
+	"math/big"
+
+	addr "github.com/filecoin-project/go-address"
+	abi "github.com/filecoin-project/specs-actors/actors/abi"
+	filcrypto "github.com/filecoin-project/specs/algorithms/crypto"
+	actor "github.com/filecoin-project/specs/systems/filecoin_vm/actor"
+	util "github.com/filecoin-project/specs/util"
+	cbornode "github.com/ipfs/go-ipld-cbor"
+)
+
+var Assert = util.Assert
+
+var ErrMalformedMessage = errors.New("Malformed message")
+
+// An UnsignedMessage is serialized as the DAG-CBOR tuple
+// [Version, To, From, CallSeqNum, Value, GasPrice, GasLimit, Method, Params], with addresses as their
+// byte encoding, and GasLimit as a sign byte (0 if positive, 1 if negative) followed by the big-endian
+// bytes of its magnitude, or empty if zero (or unset).
+// A SignedMessage is serialized as the tuple [Message, Signature], with Message as above and Signature
+// as the tuple [Type, Sig].
+
+func Serialize_UnsignedMessage(m UnsignedMessage) util.Serialization {
+	return _dumpObject(_unsignedMessageTuple(m))
+}
+
+func Serialize_UnsignedMessage_Array(ms []UnsignedMessage) util.Serialization {
+	tuples := make([]interface{}, len(ms))
+	for i, m := range ms {
+		tuples[i] = _unsignedMessageTuple(m)
+	}
+	return _dumpObject(tuples)
+}
+
+func Deserialize_UnsignedMessage(s util.Serialization) (UnsignedMessage, error) {
+	var tuple interface{}
+	if err := cbornode.DecodeInto(s, &tuple); err != nil {
+		return nil, ErrMalformedMessage
+	}
+	return _unsignedMessageFromTuple(tuple)
+}
+
+func Deserialize_UnsignedMessage_Assert(s util.Serialization) UnsignedMessage {
+	ret, err := Deserialize_UnsignedMessage(s)
+	Assert(err == nil)
+	return ret
+}
+
+func Serialize_SignedMessage(m SignedMessage) util.Serialization {
+	return _dumpObject(_signedMessageTuple(m))
+}
+
+func Serialize_SignedMessage_Array(ms []SignedMessage) util.Serialization {
+	tuples := make([]interface{}, len(ms))
+	for i, m := range ms {
+		tuples[i] = _signedMessageTuple(m)
+	}
+	return _dumpObject(tuples)
+}
+
+func Deserialize_SignedMessage(s util.Serialization) (SignedMessage, error) {
+	var tuple []interface{}
+	if err := cbornode.DecodeInto(s, &tuple); err != nil || len(tuple) != 2 {
+		return nil, ErrMalformedMessage
+	}
+	message, err := _unsignedMessageFromTuple(tuple[0])
+	if err != nil {
+		return nil, err
+	}
+	sigTuple, ok := tuple[1].([]interface{})
+	if !ok || len(sigTuple) != 2 {
+		return nil, ErrMalformedMessage
+	}
+	sigType, typeOk := sigTuple[0].(int) // DAG-CBOR integers decode as int.
+	sig, sigOk := sigTuple[1].([]byte)
+	if !typeOk || !sigOk || sigType < 0 {
+		return nil, ErrMalformedMessage
+	}
+	return SignedMessage_Make(message, &filcrypto.Signature_I{
+		Type_: filcrypto.SigType(sigType),
+		Sig_:  filcrypto.SignatureBytes(sig),
+	}), nil
+}
+
+func Deserialize_SignedMessage_Assert(s util.Serialization) SignedMessage {
+	ret, err := Deserialize_SignedMessage(s)
+	Assert(err == nil)
+	return ret
+}
+
+func _unsignedMessageTuple(m UnsignedMessage) []interface{} {
+	gasLimit := m.GasLimit()
+	if gasLimit == nil {
+		gasLimit = GasAmount_Zero()
+	}
+	return []interface{}{
+		m.Version(),
+		m.To().Bytes(),
+		m.From().Bytes(),
+		int64(m.CallSeqNum()),
+		int64(m.Value()),
+		int64(m.GasPrice()),
+		_bigIntBytes(GasAmount_AsBigInt(gasLimit)),
+		int64(m.Method()),
+		append([]byte{}, m.Params()...), // Not null if empty.
+	}
+}
+
+func _unsignedMessageFromTuple(x interface{}) (UnsignedMessage, error) {
+	tuple, ok := x.([]interface{})
+	if !ok || len(tuple) != 9 {
+		return nil, ErrMalformedMessage
+	}
+	var ints [5]int
+	for i, j := range []int{0, 3, 4, 5, 7} {
+		if ints[i], ok = tuple[j].(int); !ok {
+			return nil, ErrMalformedMessage
+		}
+	}
+	to, err := _address(tuple[1])
+	if err != nil {
+		return nil, err
+	}
+	from, err := _address(tuple[2])
+	if err != nil {
+		return nil, err
+	}
+	gasLimitBytes, gasLimitOk := tuple[6].([]byte)
+	params, paramsOk := tuple[8].([]byte)
+	if !gasLimitOk || !paramsOk {
+		return nil, ErrMalformedMessage
+	}
+	gasLimit, ok := _bigIntFromBytes(gasLimitBytes)
+	if !ok {
+		return nil, ErrMalformedMessage
+	}
+
+	ret := &UnsignedMessage_I{
+		Version_:    int64(ints[0]),
+		To_:         to,
+		From_:       from,
+		CallSeqNum_: actor.CallSeqNum(ints[1]),
+		Value_:      abi.TokenAmount(ints[2]),
+		GasPrice_:   abi.TokenAmount(ints[3]),
+		GasLimit_:   GasAmount_FromBigInt(gasLimit),
+		Method_:     abi.MethodNum(ints[4]),
+	}
+	if len(params) > 0 {
+		ret.Params_ = abi.MethodParams(params)
+	}
+	return ret, nil
+}
+
+func _signedMessageTuple(m SignedMessage) []interface{} {
+	return []interface{}{
+		_unsignedMessageTuple(m.Message()),
+		[]interface{}{uint64(m.Signature().Type()), append([]byte{}, m.Signature().Sig()...)},
+	}
+}
+
+func _address(x interface{}) (addr.Address, error) {
+	b, ok := x.([]byte)
+	if !ok {
+		return addr.Undef, ErrMalformedMessage
+	}
+	ret, err := addr.NewFromBytes(b)
+	if err != nil {
+		return addr.Undef, ErrMalformedMessage
+	}
+	return ret, nil
+}
+
+func _bigIntBytes(x *big.Int) []byte {
+	if x.Sign() == 0 {
+		return []byte{}
+	}
+	sign := byte(0)
+	if x.Sign() < 0 {
+		sign = 1
+	}
+	return append([]byte{sign}, x.Bytes()...)
+}
+
+func _bigIntFromBytes(b []byte) (*big.Int, bool) {
+	ret := new(big.Int)
+	if len(b) == 0 {
+		return ret, true
+	}
+	if b[0] > 1 {
+		return nil, false
+	}
+	ret.SetBytes(b[1:])
+	if b[0] == 1 {
+		ret.Neg(ret)
+	}
+	return ret, true
+}
+
+func _dumpObject(x interface{}) util.Serialization {
+	ret, err := cbornode.DumpObject(x)
+	Assert(err == nil)
+	return util.Serialization(ret)
+}
@@ -0,0 +1,119 @@
+package message
+
+import (
+	"bytes"
+	"testing"
+
+	addr "github.com/filecoin-project/go-address"
+	abi "github.com/filecoin-project/specs-actors/actors/abi"
+	filcrypto "github.com/filecoin-project/specs/algorithms/crypto"
+	util "github.com/filecoin-project/specs/util"
+	cbornode "github.com/ipfs/go-ipld-cbor"
+)
+
+func _testMessages(t *testing.T) []UnsignedMessage {
+	to, err := addr.NewIDAddress(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	from, err := addr.NewActorAddress([]byte("sender"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return []UnsignedMessage{
+		&UnsignedMessage_I{To_: to, From_: from, GasLimit_: GasAmount_Zero()},
+		&UnsignedMessage_I{
+			Version_:    1,
+			To_:         to,
+			From_:       from,
+			CallSeqNum_: 7,
+			Value_:      abi.TokenAmount(1000),
+			GasPrice_:   abi.TokenAmount(3),
+			GasLimit_:   GasAmount_SentinelUnlimited().Scale(1000),
+			Method_:     abi.MethodNum(2),
+			Params_:     abi.MethodParams{1, 2, 3},
+		},
+		// Invalid, but serializable so that it can be rejected by syntax validation.
+		&UnsignedMessage_I{To_: from, From_: to, CallSeqNum_: -1, Value_: abi.TokenAmount(-5), GasLimit_: GasAmount_FromInt(-100)},
+	}
+}
+
+func _messagesEqual(a, b UnsignedMessage) bool {
+	return a.Version() == b.Version() && a.To() == b.To() && a.From() == b.From() && a.CallSeqNum() == b.CallSeqNum() &&
+		a.Value() == b.Value() && a.GasPrice() == b.GasPrice() && a.GasLimit().Equals(b.GasLimit()) &&
+		a.Method() == b.Method() && bytes.Equal(a.Params(), b.Params())
+}
+
+func TestMessageSerializationRoundTrip(t *testing.T) {
+	for i, m := range _testMessages(t) {
+		unsigned, err := Deserialize_UnsignedMessage(Serialize_UnsignedMessage(m))
+		if err != nil {
+			t.Fatalf("message %d: %v", i, err)
+		}
+		if !_messagesEqual(unsigned, m) {
+			t.Errorf("message %d deserialized as %v, want %v", i, unsigned, m)
+		}
+
+		sig := &filcrypto.Signature_I{Type_: filcrypto.SigType_ECDSASigType, Sig_: filcrypto.SignatureBytes{byte(i), 1, 2}}
+		signed, err := Deserialize_SignedMessage(Serialize_SignedMessage(SignedMessage_Make(m, sig)))
+		if err != nil {
+			t.Fatalf("signed message %d: %v", i, err)
+		}
+		if !_messagesEqual(signed.Message(), m) || signed.Signature().Type() != sig.Type() || !bytes.Equal(signed.Signature().Sig(), sig.Sig()) {
+			t.Errorf("signed message %d deserialized as %v, want %v", i, signed, m)
+		}
+	}
+}
+
+func TestSerializeMessageWithoutGasLimit(t *testing.T) {
+	m := _testMessages(t)[0].(*UnsignedMessage_I)
+	unset := *m
+	unset.GasLimit_ = nil
+	if !bytes.Equal(Serialize_UnsignedMessage(&unset), Serialize_UnsignedMessage(m)) {
+		t.Error("message without a gas limit not serialized as with a zero gas limit")
+	}
+}
+
+func TestDeserializeMalformedMessage(t *testing.T) {
+	dump := func(x interface{}) util.Serialization {
+		ret, err := cbornode.DumpObject(x)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return util.Serialization(ret)
+	}
+	valid := _unsignedMessageTuple(_testMessages(t)[1])
+	withField := func(i int, x interface{}) []interface{} {
+		ret := append([]interface{}{}, valid...)
+		ret[i] = x
+		return ret
+	}
+
+	unsigned := map[string]util.Serialization{
+		"not CBOR":          util.Serialization{0xff},
+		"not a tuple":       dump("message"),
+		"short tuple":       dump(valid[:8]),
+		"address not bytes": dump(withField(1, "address")),
+		"invalid address":   dump(withField(2, []byte{0xff})),
+		"value not an int":  dump(withField(4, []byte{1})),
+		"gas limit sign":    dump(withField(6, []byte{2, 1})),
+		"params not bytes":  dump(withField(8, 1)),
+	}
+	for name, s := range unsigned {
+		if _, err := Deserialize_UnsignedMessage(s); err != ErrMalformedMessage {
+			t.Errorf("%s: error %v, want %v", name, err, ErrMalformedMessage)
+		}
+	}
+
+	signed := map[string]util.Serialization{
+		"malformed message":     dump([]interface{}{withField(0, "version"), []interface{}{1, []byte{}}}),
+		"signature not a tuple": dump([]interface{}{valid, []byte{1}}),
+		"signature type":        dump([]interface{}{valid, []interface{}{"secp256k1", []byte{}}}),
+		"signature not bytes":   dump([]interface{}{valid, []interface{}{1, "signature"}}),
+	}
+	for name, s := range signed {
+		if _, err := Deserialize_SignedMessage(s); err != ErrMalformedMessage {
+			t.Errorf("%s: error %v, want %v", name, err, ErrMalformedMessage)
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package runtime
+
+import (
+	vmr "github.com/filecoin-project/specs-actors/actors/runtime"
+)
+
+type ComputeFunctionID = vmr.ComputeFunctionID
+
+// The IDs of the compute functions which actors may invoke through the runtime's Compute method.
+// VerifySignature is declared by the actors' runtime interface; the others are numbered to follow it,
+// and are to be declared alongside it there.
+const (
+	Compute_VerifySignature = vmr.Compute_VerifySignature
+)
+
+const (
+	Compute_VerifyAggregateSignature ComputeFunctionID = Compute_VerifySignature + 1 + iota
+	Compute_HashBlake2b
+	Compute_HashSHA256
+	Compute_VerifySeal
+	Compute_VerifySurprisePoSt
+	Compute_VerifyConsensusFault
+)
@@ -0,0 +1,14 @@
+package runtime
+
+import (
+	exitcode "github.com/filecoin-project/specs-actors/actors/runtime/exitcode"
+)
+
+// The system exit codes raised by the VM beyond those declared by the actors' exitcode package. They are
+// numbered to follow MethodSubcallError, and are to be declared alongside the other system exit codes there.
+const (
+	// Exit code of an invocation aborted for attempting to mutate state during a read-only query. It is
+	// propagated unchanged through the calling invocations, rather than as MethodSubcallError, so that a
+	// read-only violation is distinguished from other misuse of the runtime API.
+	ReadOnlyViolation = exitcode.MethodSubcallError + 1 + iota
+)
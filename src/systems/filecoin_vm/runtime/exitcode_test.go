@@ -0,0 +1,29 @@
+package runtime
+
+import (
+	"testing"
+
+	exitcode "github.com/filecoin-project/specs-actors/actors/runtime/exitcode"
+)
+
+func TestExitCodesDistinct(t *testing.T) {
+	declared := map[string]exitcode.ExitCode{
+		"OK":                       exitcode.OK,
+		"ActorNotFound":            exitcode.ActorNotFound,
+		"ActorCodeNotFound":        exitcode.ActorCodeNotFound,
+		"InsufficientFunds_System": exitcode.InsufficientFunds_System,
+		"InvalidCallSeqNum":        exitcode.InvalidCallSeqNum,
+		"OutOfGas":                 exitcode.OutOfGas,
+		"RuntimeAPIError":          exitcode.RuntimeAPIError,
+		"RuntimeAssertFailure":     exitcode.RuntimeAssertFailure,
+		"MethodSubcallError":       exitcode.MethodSubcallError,
+		"InsufficientFunds_User":   exitcode.InsufficientFunds_User,
+		"InvalidArguments_User":    exitcode.InvalidArguments_User,
+		"InconsistentState_User":   exitcode.InconsistentState_User,
+	}
+	for name, code := range declared {
+		if code == ReadOnlyViolation {
+			t.Errorf("ReadOnlyViolation collides with exitcode.%v (%v)", name, code)
+		}
+	}
+}
@@ -17,7 +17,9 @@ var (
 	GasAmountPlaceholder_UpdateStateTree = GasAmountPlaceholder
 )
 
-var (
+// GasSchedule is the set of gas costs charged for execution.
+// A schedule is in effect from genesis, or from the network upgrade which introduced it.
+type GasSchedule struct {
 	///////////////////////////////////////////////////////////////////////////
 	// System operations
 	///////////////////////////////////////////////////////////////////////////
@@ -28,36 +30,36 @@ var (
 	// Together, these account for the cost of message propagation and validation,
 	// up to but excluding any actual processing by the VM.
 	// This is the cost a block producer burns when including an invalid message.
-	OnChainMessageBase    = GasAmountPlaceholder
-	OnChainMessagePerByte = GasAmountPlaceholder
+	OnChainMessageBase    msg.GasAmount
+	OnChainMessagePerByte msg.GasAmount
 
 	// Gas cost charged to the originator of a non-nil return value produced
 	// by an on-chain message is given by:
 	//   len(return value)*OnChainReturnValuePerByte
-	OnChainReturnValuePerByte = GasAmountPlaceholder
+	OnChainReturnValuePerByte msg.GasAmount
 
 	// Gas cost for any message send execution(including the top-level one
 	// initiated by an on-chain message).
 	// This accounts for the cost of loading sender and receiver actors and
 	// (for top-level messages) incrementing the sender's sequence number.
 	// Load and store of actor sub-state is charged separately.
-	SendBase = GasAmountPlaceholder
+	SendBase msg.GasAmount
 
 	// Gas cost charged, in addition to SendBase, if a message send
 	// is accompanied by any nonzero currency amount.
 	// Accounts for writing receiver's new balance (the sender's state is
 	// already accounted for).
-	SendTransferFunds = GasAmountPlaceholder
+	SendTransferFunds msg.GasAmount
 
 	// Gas cost charged, in addition to SendBase, if a message invokes
 	// a method on the receiver.
 	// Accounts for the cost of loading receiver code and method dispatch.
-	SendInvokeMethod = GasAmountPlaceholder
+	SendInvokeMethod msg.GasAmount
 
 	// Gas cost (Base + len*PerByte) for any Get operation to the IPLD store
 	// in the runtime VM context.
-	IpldGetBase    = GasAmountPlaceholder
-	IpldGetPerByte = GasAmountPlaceholder
+	IpldGetBase    msg.GasAmount
+	IpldGetPerByte msg.GasAmount
 
 	// Gas cost (Base + len*PerByte) for any Put operation to the IPLD store
 	// in the runtime VM context.
@@ -65,19 +67,24 @@ var (
 	// Note: these costs should be significantly higher than the costs for Get
 	// operations, since they reflect not only serialization/deserialization
 	// but also persistent storage of chain data.
-	IpldPutBase    = GasAmountPlaceholder
-	IpldPutPerByte = GasAmountPlaceholder
+	IpldPutBase    msg.GasAmount
+	IpldPutPerByte msg.GasAmount
 
 	// Gas cost for updating an actor's substate (i.e., UpdateRelease).
 	// This is in addition to a per-byte fee for the state as for IPLD Get/Put.
-	UpdateActorSubstate = GasAmountPlaceholder_UpdateStateTree
+	UpdateActorSubstate msg.GasAmount
 
 	// Gas cost for creating a new actor (via InitActor's Exec method).
 	// Actor sub-state is charged separately.
-	ExecNewActor = GasAmountPlaceholder
+	ExecNewActor msg.GasAmount
 
 	// Gas cost for deleting an actor.
-	DeleteActor = GasAmountPlaceholder
+	DeleteActor msg.GasAmount
+
+	// Gas cost (Base + len*PerByte) for emitting an event with data of a given length.
+	// Accounts for storage of the event with the message receipt.
+	EmitEventBase    msg.GasAmount
+	EmitEventPerByte msg.GasAmount
 
 	///////////////////////////////////////////////////////////////////////////
 	// Pure functions (VM ABI)
@@ -85,37 +92,110 @@ var (
 
 	// Gas cost charged per public-key cryptography operation (e.g., signature
 	// verification).
-	PublicKeyCryptoOp = GasAmountPlaceholder
-)
+	PublicKeyCryptoOp msg.GasAmount
+
+	// Gas cost (Base + count*PerMessage) for verifying a BLS aggregate signature
+	// over a number of distinct messages.
+	BLSAggregateVerifyBase       msg.GasAmount
+	BLSAggregateVerifyPerMessage msg.GasAmount
+
+	// Gas cost (Base + len*PerByte) for hashing data.
+	HashBlake2bBase    msg.GasAmount
+	HashBlake2bPerByte msg.GasAmount
+	HashSHA256Base     msg.GasAmount
+	HashSHA256PerByte  msg.GasAmount
+
+	// Gas cost for verifying a seal proof.
+	VerifySeal msg.GasAmount
+
+	// Gas cost (Base + count*PerCandidate) for verifying a PoSt proof with a
+	// number of candidates.
+	VerifyPoStBase         msg.GasAmount
+	VerifyPoStPerCandidate msg.GasAmount
+
+	// Gas cost for verifying a consensus fault, including verification of the
+	// block header signatures.
+	VerifyConsensusFault msg.GasAmount
+}
 
-func OnChainMessage(onChainMessageLen int) msg.GasAmount {
-	return msg.GasAmount_Affine(OnChainMessageBase, onChainMessageLen, OnChainMessagePerByte)
+// The gas schedule in effect from genesis.
+var GenesisGasSchedule = &GasSchedule{
+	OnChainMessageBase:           GasAmountPlaceholder,
+	OnChainMessagePerByte:        GasAmountPlaceholder,
+	OnChainReturnValuePerByte:    GasAmountPlaceholder,
+	SendBase:                     GasAmountPlaceholder,
+	SendTransferFunds:            GasAmountPlaceholder,
+	SendInvokeMethod:             GasAmountPlaceholder,
+	IpldGetBase:                  GasAmountPlaceholder,
+	IpldGetPerByte:               GasAmountPlaceholder,
+	IpldPutBase:                  GasAmountPlaceholder,
+	IpldPutPerByte:               GasAmountPlaceholder,
+	UpdateActorSubstate:          GasAmountPlaceholder_UpdateStateTree,
+	ExecNewActor:                 GasAmountPlaceholder,
+	DeleteActor:                  GasAmountPlaceholder,
+	EmitEventBase:                GasAmountPlaceholder,
+	EmitEventPerByte:             GasAmountPlaceholder,
+	PublicKeyCryptoOp:            GasAmountPlaceholder,
+	BLSAggregateVerifyBase:       GasAmountPlaceholder,
+	BLSAggregateVerifyPerMessage: GasAmountPlaceholder,
+	HashBlake2bBase:              GasAmountPlaceholder,
+	HashBlake2bPerByte:           GasAmountPlaceholder,
+	HashSHA256Base:               GasAmountPlaceholder,
+	HashSHA256PerByte:            GasAmountPlaceholder,
+	VerifySeal:                   GasAmountPlaceholder,
+	VerifyPoStBase:               GasAmountPlaceholder,
+	VerifyPoStPerCandidate:       GasAmountPlaceholder,
+	VerifyConsensusFault:         GasAmountPlaceholder,
 }
 
-func OnChainReturnValue(returnValue Bytes) msg.GasAmount {
+func (s *GasSchedule) OnChainMessage(onChainMessageLen int) msg.GasAmount {
+	return msg.GasAmount_Affine(s.OnChainMessageBase, onChainMessageLen, s.OnChainMessagePerByte)
+}
+
+func (s *GasSchedule) OnChainReturnValue(returnValue Bytes) msg.GasAmount {
 	retLen := 0
 	if returnValue != nil {
 		retLen = len(returnValue)
 	}
 
-	return msg.GasAmount_Affine(msg.GasAmount_Zero(), retLen, OnChainReturnValuePerByte)
+	return msg.GasAmount_Affine(msg.GasAmount_Zero(), retLen, s.OnChainReturnValuePerByte)
+}
+
+func (s *GasSchedule) IpldGet(dataSize int) msg.GasAmount {
+	return msg.GasAmount_Affine(s.IpldGetBase, dataSize, s.IpldGetPerByte)
+}
+
+func (s *GasSchedule) IpldPut(dataSize int) msg.GasAmount {
+	return msg.GasAmount_Affine(s.IpldPutBase, dataSize, s.IpldPutPerByte)
+}
+
+func (s *GasSchedule) EmitEvent(dataSize int) msg.GasAmount {
+	return msg.GasAmount_Affine(s.EmitEventBase, dataSize, s.EmitEventPerByte)
+}
+
+func (s *GasSchedule) BLSAggregateVerify(numMessages int) msg.GasAmount {
+	return msg.GasAmount_Affine(s.BLSAggregateVerifyBase, numMessages, s.BLSAggregateVerifyPerMessage)
+}
+
+func (s *GasSchedule) HashBlake2b(dataSize int) msg.GasAmount {
+	return msg.GasAmount_Affine(s.HashBlake2bBase, dataSize, s.HashBlake2bPerByte)
 }
 
-func IpldGet(dataSize int) msg.GasAmount {
-	return msg.GasAmount_Affine(IpldGetBase, dataSize, IpldGetPerByte)
+func (s *GasSchedule) HashSHA256(dataSize int) msg.GasAmount {
+	return msg.GasAmount_Affine(s.HashSHA256Base, dataSize, s.HashSHA256PerByte)
 }
 
-func IpldPut(dataSize int) msg.GasAmount {
-	return msg.GasAmount_Affine(IpldPutBase, dataSize, IpldPutPerByte)
+func (s *GasSchedule) VerifyPoSt(numCandidates int) msg.GasAmount {
+	return msg.GasAmount_Affine(s.VerifyPoStBase, numCandidates, s.VerifyPoStPerCandidate)
 }
 
-func InvokeMethod(value abi.TokenAmount, method abi.MethodNum) msg.GasAmount {
-	ret := SendBase
+func (s *GasSchedule) InvokeMethod(value abi.TokenAmount, method abi.MethodNum) msg.GasAmount {
+	ret := s.SendBase
 	if value != abi.TokenAmount(0) {
-		ret = ret.Add(SendTransferFunds)
+		ret = ret.Add(s.SendTransferFunds)
 	}
 	if method != actor.MethodSend {
-		ret = ret.Add(SendInvokeMethod)
+		ret = ret.Add(s.SendInvokeMethod)
 	}
 	return ret
 }
@@ -0,0 +1,195 @@
+package impl
+
+import (
+	"container/list"
+	"sync"
+
+	addr "github.com/filecoin-project/go-address"
+	builtin "github.com/filecoin-project/specs-actors/actors/builtin"
+	acctact "github.com/filecoin-project/specs-actors/actors/builtin/account"
+	initact "github.com/filecoin-project/specs-actors/actors/builtin/init"
+	serde "github.com/filecoin-project/specs-actors/actors/serde"
+	ipld "github.com/filecoin-project/specs/libraries/ipld"
+	st "github.com/filecoin-project/specs/systems/filecoin_vm/state_tree"
+	cid "github.com/ipfs/go-cid"
+)
+
+// AddressResolver maps actor addresses to ID addresses through the InitActor state of a state tree.
+type AddressResolver interface {
+	// Returns the ID address to which a is mapped, or a itself (and false) if it is not mapped.
+	// ID addresses resolve to themselves.
+	ResolveAddress(tree st.StateTree, a addr.Address) (addr.Address, bool)
+
+	// Returns a robust (public key or actor) address which resolves to the ID address id, if any.
+	RobustAddress(tree st.StateTree, id addr.Address) (addr.Address, bool)
+}
+
+// CachingAddressResolver is an AddressResolver with least-recently-used caches of resolved addresses,
+// and of robust addresses keyed by ID address. Both caches hold at most capacity entries.
+//
+// The mappings depend only on the InitActor's state, so cached entries remain valid across state roots
+// until that state changes. Each query checks the InitActor state of the given tree, and if it differs
+// from that of the previous query, clears the caches and loads the new state, indexing its address map
+// by ID address.
+type CachingAddressResolver struct {
+	_store ipld.GraphStore
+
+	_lock sync.Mutex
+	// The InitActor state for which the cached entries are valid.
+	_initHead  cid.Cid
+	_initState initact.InitActorState
+	// The robust addresses of the InitActor's address map, keyed by ID address.
+	_robustByID map[addr.Address]addr.Address
+	// ID addresses, keyed by the address resolved.
+	_resolved *_addressCache
+	// Robust addresses, keyed by ID address.
+	_robust *_addressCache
+}
+
+var _ AddressResolver = &CachingAddressResolver{}
+
+func CachingAddressResolver_Make(store ipld.GraphStore, capacity int) *CachingAddressResolver {
+	Assert(capacity > 0)
+	return &CachingAddressResolver{
+		_store:    store,
+		_initHead: cid.Undef,
+		_resolved: _addressCache_Make(capacity),
+		_robust:   _addressCache_Make(capacity),
+	}
+}
+
+func (r *CachingAddressResolver) ResolveAddress(tree st.StateTree, a addr.Address) (addr.Address, bool) {
+	if a.Protocol() == addr.ID {
+		return a, true
+	}
+
+	r._lock.Lock()
+	defer r._lock.Unlock()
+	r._validate(tree)
+
+	if id, found, cached := r._resolved.Get(a); cached {
+		return id, found
+	}
+
+	id := r._initState.ResolveAddress(a)
+	found := id.Protocol() == addr.ID
+	r._resolved.Put(a, id, found)
+	if found {
+		r._robust.Put(id, a, true)
+	}
+	return id, found
+}
+
+func (r *CachingAddressResolver) RobustAddress(tree st.StateTree, id addr.Address) (addr.Address, bool) {
+	Assert(id.Protocol() == addr.ID)
+
+	r._lock.Lock()
+	defer r._lock.Unlock()
+	r._validate(tree)
+
+	if a, found, cached := r._robust.Get(id); cached {
+		return a, found
+	}
+
+	act, found := tree.GetActor(id)
+	if !found {
+		return addr.Undef, false
+	}
+
+	// An account actor records its public key address in its own state; other actors are found in the
+	// InitActor's address map.
+	var a addr.Address
+	if act.CodeID() == builtin.AccountActorCodeID {
+		serialized, ok := r._store.Get(cid.Cid(act.State()))
+		Assert(ok)
+		var accountSubState acctact.AccountActorState
+		serde.MustDeserialize(serialized, &accountSubState)
+		a = accountSubState.Address
+	} else {
+		a, found = r._robustByID[id]
+	}
+	r._robust.Put(id, a, found)
+	return a, found
+}
+
+// If the InitActor state of tree differs from that for which the caches were built, clears them,
+// loads that state and indexes its address map by ID address.
+func (r *CachingAddressResolver) _validate(tree st.StateTree) {
+	initState, ok := tree.GetActor(builtin.InitActorAddr)
+	Assert(ok)
+	initHead := cid.Cid(initState.State())
+	if initHead.Equals(r._initHead) {
+		return
+	}
+
+	serialized, ok := r._store.Get(initHead)
+	Assert(ok)
+	var initSubState initact.InitActorState
+	serde.MustDeserialize(serialized, &initSubState)
+
+	r._initHead = initHead
+	r._initState = initSubState
+	r._robustByID = make(map[addr.Address]addr.Address, len(initSubState.AddressMap))
+	for robust, mapped := range initSubState.AddressMap {
+		id, err := addr.NewIDAddress(uint64(mapped))
+		Assert(err == nil)
+		r._robustByID[id] = robust
+	}
+	r._resolved.Clear()
+	r._robust.Clear()
+}
+
+// _addressCache is a least-recently-used cache of address lookups, including failed ones.
+type _addressCache struct {
+	_capacity int
+	// Entries keyed by the address looked up, ordered from most recently used.
+	_entries map[addr.Address]*list.Element
+	_lru     *list.List
+}
+
+type _cachedAddress struct {
+	key    addr.Address
+	result addr.Address
+	found  bool
+}
+
+func _addressCache_Make(capacity int) *_addressCache {
+	return &_addressCache{
+		_capacity: capacity,
+		_entries:  make(map[addr.Address]*list.Element),
+		_lru:      list.New(),
+	}
+}
+
+// Returns the cached result of looking up key, and whether it was cached.
+func (c *_addressCache) Get(key addr.Address) (result addr.Address, found bool, cached bool) {
+	e, cached := c._entries[key]
+	if !cached {
+		return addr.Undef, false, false
+	}
+	c._lru.MoveToFront(e)
+	entry := e.Value.(*_cachedAddress)
+	return entry.result, entry.found, true
+}
+
+// Caches the result of looking up key, evicting the least recently used entry if over capacity.
+func (c *_addressCache) Put(key addr.Address, result addr.Address, found bool) {
+	if e, cached := c._entries[key]; cached {
+		c._lru.Remove(e)
+	}
+	c._entries[key] = c._lru.PushFront(&_cachedAddress{key: key, result: result, found: found})
+	if c._lru.Len() > c._capacity {
+		oldest := c._lru.Back()
+		c._lru.Remove(oldest)
+		delete(c._entries, oldest.Value.(*_cachedAddress).key)
+	}
+}
+
+func (c *_addressCache) Len() int {
+	return c._lru.Len()
+}
+
+func (c *_addressCache) Clear() {
+	c._entries = make(map[addr.Address]*list.Element)
+	c._lru.Init()
+}
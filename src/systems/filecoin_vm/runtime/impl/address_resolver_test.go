@@ -0,0 +1,199 @@
+package impl
+
+import (
+	"testing"
+
+	addr "github.com/filecoin-project/go-address"
+	actor "github.com/filecoin-project/specs-actors/actors"
+	abi "github.com/filecoin-project/specs-actors/actors/abi"
+	builtin "github.com/filecoin-project/specs-actors/actors/builtin"
+	acctact "github.com/filecoin-project/specs-actors/actors/builtin/account"
+	initact "github.com/filecoin-project/specs-actors/actors/builtin/init"
+	ipld "github.com/filecoin-project/specs/libraries/ipld"
+	actstate "github.com/filecoin-project/specs/systems/filecoin_vm/actor"
+	msg "github.com/filecoin-project/specs/systems/filecoin_vm/message"
+	gascost "github.com/filecoin-project/specs/systems/filecoin_vm/runtime/gascost"
+	st "github.com/filecoin-project/specs/systems/filecoin_vm/state_tree"
+	cid "github.com/ipfs/go-cid"
+	cbornode "github.com/ipfs/go-ipld-cbor"
+)
+
+func init() {
+	cbornode.RegisterCborType(initact.InitActorState{})
+	cbornode.RegisterCborType(acctact.AccountActorState{})
+}
+
+// Stores the serialized state in store, returning its CID.
+func _putState(store ipld.GraphStore, state interface{}) actor.ActorSubstateCID {
+	serialized, err := cbornode.DumpObject(state)
+	if err != nil {
+		panic(err)
+	}
+	return actor.ActorSubstateCID(store.Put(serialized))
+}
+
+func _testActorAddr(seed string) addr.Address {
+	a, err := addr.NewActorAddress([]byte(seed))
+	if err != nil {
+		panic(err)
+	}
+	return a
+}
+
+// Returns a state tree with an InitActor mapping the given robust addresses to ID addresses, and the
+// given other actors.
+func _resolverStateTree(store *ipld.MemGraphStore, mapped map[addr.Address]abi.ActorID, actors map[addr.Address]actstate.ActorState) st.StateTree {
+	states := map[addr.Address]actstate.ActorState{
+		builtin.InitActorAddr: &actstate.ActorState_I{
+			CodeID_: builtin.InitActorCodeID,
+			State_:  _putState(store, &initact.InitActorState{AddressMap: mapped}),
+		},
+	}
+	for a, act := range actors {
+		states[a] = act
+	}
+	return &st.StateTree_I{ActorStates_: states}
+}
+
+func _assertResolved(t *testing.T, r AddressResolver, tree st.StateTree, a addr.Address, expected addr.Address, expectedFound bool) {
+	t.Helper()
+	id, found := r.ResolveAddress(tree, a)
+	if found != expectedFound || id != expected {
+		t.Errorf("resolved %v to (%v, %v), expected (%v, %v)", a, id, found, expected, expectedFound)
+	}
+}
+
+func _assertRobust(t *testing.T, r AddressResolver, tree st.StateTree, id addr.Address, expected addr.Address, expectedFound bool) {
+	t.Helper()
+	a, found := r.RobustAddress(tree, id)
+	if found != expectedFound || a != expected {
+		t.Errorf("robust address of %v is (%v, %v), expected (%v, %v)", id, a, found, expected, expectedFound)
+	}
+}
+
+func TestResolveAddressIsCachedUntilInitActorStateChanges(t *testing.T) {
+	store := ipld.MemGraphStore_Make()
+	robust := _testActorAddr("robust")
+	unmapped := _testActorAddr("unmapped")
+	tree := _resolverStateTree(store, map[addr.Address]abi.ActorID{robust: 101}, nil)
+	r := CachingAddressResolver_Make(store, 8)
+
+	_assertResolved(t, r, tree, robust, _testIDAddr(101), true)
+	_assertResolved(t, r, tree, unmapped, unmapped, false)
+	_assertResolved(t, r, tree, _testIDAddr(5), _testIDAddr(5), true)
+
+	// With the InitActor state unchanged, both results, including the failed resolution, are answered
+	// from the cache without loading the state again.
+	initAct, _ := tree.GetActor(builtin.InitActorAddr)
+	store.Delete(cid.Cid(initAct.State()))
+	_assertResolved(t, r, tree, robust, _testIDAddr(101), true)
+	_assertResolved(t, r, tree, unmapped, unmapped, false)
+
+	// A new InitActor state invalidates every cached entry.
+	remapped := _resolverStateTree(store, map[addr.Address]abi.ActorID{robust: 102, unmapped: 103}, nil)
+	_assertResolved(t, r, remapped, robust, _testIDAddr(102), true)
+	_assertResolved(t, r, remapped, unmapped, _testIDAddr(103), true)
+	_assertRobust(t, r, remapped, _testIDAddr(102), robust, true)
+}
+
+func TestRobustAddress(t *testing.T) {
+	store := ipld.MemGraphStore_Make()
+	key := _testActorAddr("key")
+	multisig := _testActorAddr("multisig")
+	accountID, multisigID, unmappedID := _testIDAddr(101), _testIDAddr(102), _testIDAddr(103)
+	tree := _resolverStateTree(store, map[addr.Address]abi.ActorID{multisig: 102}, map[addr.Address]actstate.ActorState{
+		accountID: &actstate.ActorState_I{
+			CodeID_: builtin.AccountActorCodeID,
+			State_:  _putState(store, &acctact.AccountActorState{Address: key}),
+		},
+		multisigID: &actstate.ActorState_I{CodeID_: builtin.MultisigActorCodeID},
+		unmappedID: &actstate.ActorState_I{CodeID_: builtin.MultisigActorCodeID},
+	})
+	r := CachingAddressResolver_Make(store, 8)
+
+	// An account actor's address is read from its own state, other actors' from the InitActor's.
+	_assertRobust(t, r, tree, accountID, key, true)
+	_assertRobust(t, r, tree, multisigID, multisig, true)
+	_assertRobust(t, r, tree, unmappedID, addr.Undef, false)
+	_assertRobust(t, r, tree, _testIDAddr(104), addr.Undef, false)
+
+	// Resolving a robust address records the reverse mapping.
+	other := _testActorAddr("other")
+	remapped := _resolverStateTree(store, map[addr.Address]abi.ActorID{other: 103}, map[addr.Address]actstate.ActorState{
+		unmappedID: &actstate.ActorState_I{CodeID_: builtin.MultisigActorCodeID},
+	})
+	_assertResolved(t, r, remapped, other, unmappedID, true)
+	initAct, _ := remapped.GetActor(builtin.InitActorAddr)
+	store.Delete(cid.Cid(initAct.State()))
+	_assertRobust(t, r, remapped, unmappedID, other, true)
+}
+
+func TestRobustAddressCacheIsBounded(t *testing.T) {
+	store := ipld.MemGraphStore_Make()
+	mapped := make(map[addr.Address]abi.ActorID)
+	actors := make(map[addr.Address]actstate.ActorState)
+	for id := uint64(101); id <= 110; id++ {
+		mapped[_testActorAddr(string(rune('a'+id)))] = abi.ActorID(id)
+		actors[_testIDAddr(id)] = &actstate.ActorState_I{CodeID_: builtin.MultisigActorCodeID}
+	}
+	tree := _resolverStateTree(store, mapped, actors)
+	r := CachingAddressResolver_Make(store, 4)
+
+	for id := uint64(101); id <= 110; id++ {
+		_assertRobust(t, r, tree, _testIDAddr(id), _testActorAddr(string(rune('a'+id))), true)
+	}
+	if r._robust.Len() != 4 {
+		t.Errorf("reverse cache holds %d entries, expected 4", r._robust.Len())
+	}
+}
+
+func TestRuntimeResolutionGasIsIndependentOfCaching(t *testing.T) {
+	store := ipld.MemGraphStore_Make()
+	robust := _testActorAddr("robust")
+	tree := _resolverStateTree(store, map[addr.Address]abi.ActorID{robust: 101}, nil)
+	initAct, _ := tree.GetActor(builtin.InitActorAddr)
+	serialized, _ := store.Get(cid.Cid(initAct.State()))
+	loadGas := gascost.GenesisGasSchedule.IpldGet(len(serialized))
+
+	r := CachingAddressResolver_Make(store, 8)
+	gasUsed := func(a addr.Address) msg.GasAmount {
+		rt := VMContext_Make(store, nil, gascost.GenesisGasSchedule, nil, _outerAddr, _outerAddr, 0, 0, tree, _outerAddr,
+			abi.TokenAmount(0), msg.GasAmount_FromInt(1e6))
+		rt.UseAddressResolver(r)
+		before := rt._gasRemaining
+		rt._resolveAddress(a)
+		return before.Subtract(rt._gasRemaining)
+	}
+
+	// The first resolution misses the cache and the second hits it; both are charged for loading the
+	// InitActor state.
+	for i := 0; i < 2; i++ {
+		if gas := gasUsed(robust); !gas.Equals(loadGas) {
+			t.Errorf("resolution %d used %v gas, want %v", i, gas, loadGas)
+		}
+	}
+	if gas := gasUsed(_testIDAddr(101)); !gas.Equals(msg.GasAmount_Zero()) {
+		t.Errorf("resolving an ID address used %v gas, want none", gas)
+	}
+}
+
+func TestAddressCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := _addressCache_Make(2)
+	a, b, d := _testIDAddr(1), _testIDAddr(2), _testIDAddr(3)
+	c.Put(a, _testIDAddr(11), true)
+	c.Put(b, addr.Undef, false)
+	if _, _, cached := c.Get(a); !cached {
+		t.Fatalf("%v not cached", a)
+	}
+
+	c.Put(d, _testIDAddr(13), true)
+	if _, _, cached := c.Get(b); cached {
+		t.Errorf("least recently used %v not evicted", b)
+	}
+	if result, found, cached := c.Get(a); !cached || !found || result != _testIDAddr(11) {
+		t.Errorf("%v cached as (%v, %v, %v)", a, result, found, cached)
+	}
+	if c.Len() != 2 {
+		t.Errorf("cache holds %d entries, expected 2", c.Len())
+	}
+}
@@ -5,13 +5,21 @@ import (
 	vmr "github.com/filecoin-project/specs-actors/actors/runtime"
 )
 
-func loadActorCode(codeID abi.ActorCodeID) (vmr.ActorCode, error) {
-
-	panic("TODO")
-	// TODO: resolve circular dependency
+// ActorCodeLoader resolves the code to execute when invoking an actor with a given code ID.
+//
+// The loader is provided to the runtime by the interpreter, which by default uses the registry of
+// builtin actors (see ActorCodeRegistry in vm/interpreter/vm_registry.go). Supplying a different
+// loader allows, e.g., tests or alternative networks to run mock or upgraded actor code without
+// affecting other VM instances.
+//
+// TODO: load code from the StateTree, once actor code may be deployed on chain.
+type ActorCodeLoader interface {
+	LoadActorCode(codeID abi.ActorCodeID) (vmr.ActorCode, error)
+}
 
-	// // load the code from StateTree.
-	// // TODO: this is going to be enabled in the future.
-	// // code, err := loadCodeFromStateTree(input.InTree, codeCID)
-	// return staticActorCodeRegistry.LoadActor(codeCID)
+// ActorMethodInvoker is implemented by actor code which dispatches its own method invocations (e.g.,
+// mock actors supplied by a test's ActorCodeLoader). The runtime invokes the methods of such code
+// through InvokeMethod.
+type ActorMethodInvoker interface {
+	InvokeMethod(rt Runtime, method abi.MethodNum, params abi.MethodParams) InvocOutput
 }
@@ -0,0 +1,121 @@
+package impl
+
+import (
+	"errors"
+
+	addr "github.com/filecoin-project/go-address"
+	ipld "github.com/filecoin-project/specs/libraries/ipld"
+	util "github.com/filecoin-project/specs/util"
+	cid "github.com/ipfs/go-cid"
+)
+
+var (
+	ErrEventsNotFound = errors.New("Events not found in store")
+	ErrMalformedEvent = errors.New("Malformed event")
+)
+
+// Identifies the type of an actor event, and so the schema of its data.
+// Topics are chosen by actor code, e.g. "market.DealActivated".
+type EventTopic string
+
+// ActorEvent is a structured record emitted by an actor during message execution.
+// Events are not part of actor state: they are committed through the receipt of the top-level
+// message, for the benefit of clients observing the chain.
+type ActorEvent struct {
+	// ID address of the emitting actor.
+	Emitter addr.Address
+	Topic   EventTopic
+	// Serialized event data, according to the schema identified by the topic.
+	Data util.Serialization
+}
+
+// Selects events by emitter and topic.
+// An empty list matches any emitter (or topic).
+type EventFilter struct {
+	Emitters []addr.Address
+	Topics   []EventTopic
+}
+
+func (f EventFilter) Matches(e ActorEvent) bool {
+	return f._matchesEmitter(e.Emitter) && f._matchesTopic(e.Topic)
+}
+
+func (f EventFilter) _matchesEmitter(a addr.Address) bool {
+	if len(f.Emitters) == 0 {
+		return true
+	}
+	for _, emitter := range f.Emitters {
+		if emitter == a {
+			return true
+		}
+	}
+	return false
+}
+
+func (f EventFilter) _matchesTopic(t EventTopic) bool {
+	if len(f.Topics) == 0 {
+		return true
+	}
+	for _, topic := range f.Topics {
+		if topic == t {
+			return true
+		}
+	}
+	return false
+}
+
+// Stores events, in order of emission, in an array-mapped trie and returns its root.
+// Each event is stored as the DAG-CBOR tuple [Emitter, Topic, Data].
+// Returns cid.Undef if there are no events.
+func PutEventsAMT(store ipld.GraphStore, events []ActorEvent) cid.Cid {
+	if len(events) == 0 {
+		return cid.Undef
+	}
+	values := make([]interface{}, len(events))
+	for i, e := range events {
+		values[i] = []interface{}{e.Emitter.Bytes(), string(e.Topic), append([]byte{}, e.Data...)}
+	}
+	return ipld.PutAMT(store, values)
+}
+
+// Loads the events stored by PutEventsAMT, in order of emission.
+func LoadEventsAMT(store ipld.GraphStore, root cid.Cid) ([]ActorEvent, error) {
+	if !root.Defined() {
+		return nil, nil
+	}
+	values, err := ipld.LoadAMT(store, root)
+	if err == ipld.ErrAMTNodeNotFound {
+		return nil, ErrEventsNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	ret := make([]ActorEvent, len(values))
+	for i, v := range values {
+		if ret[i], err = _decodeEvent(v); err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
+
+func _decodeEvent(x interface{}) (ActorEvent, error) {
+	tuple, ok := x.([]interface{})
+	if !ok || len(tuple) != 3 {
+		return ActorEvent{}, ErrMalformedEvent
+	}
+	emitterBytes, emitterOk := tuple[0].([]byte)
+	topic, topicOk := tuple[1].(string)
+	data, dataOk := tuple[2].([]byte)
+	if !emitterOk || !topicOk || !dataOk {
+		return ActorEvent{}, ErrMalformedEvent
+	}
+	emitter, err := addr.NewFromBytes(emitterBytes)
+	if err != nil {
+		return ActorEvent{}, ErrMalformedEvent
+	}
+	ret := ActorEvent{Emitter: emitter, Topic: EventTopic(topic)}
+	if len(data) > 0 {
+		ret.Data = data
+	}
+	return ret, nil
+}
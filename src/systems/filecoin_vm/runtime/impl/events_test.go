@@ -0,0 +1,53 @@
+package impl
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	ipld "github.com/filecoin-project/specs/libraries/ipld"
+	util "github.com/filecoin-project/specs/util"
+	cid "github.com/ipfs/go-cid"
+)
+
+func TestEventsAMTRoundTrip(t *testing.T) {
+	store := ipld.MemGraphStore_Make()
+	if root := PutEventsAMT(store, nil); root.Defined() {
+		t.Errorf("root of no events is %v, want cid.Undef", root)
+	}
+	if events, err := LoadEventsAMT(store, cid.Undef); err != nil || len(events) != 0 {
+		t.Errorf("loaded %v, %v from cid.Undef, want no events", events, err)
+	}
+
+	for _, n := range []int{1, 8, 9, 70} {
+		var events []ActorEvent
+		for i := 0; i < n; i++ {
+			e := ActorEvent{Emitter: _testIDAddr(uint64(100 + i%3)), Topic: EventTopic(fmt.Sprint("topic ", i))}
+			if i%2 == 0 {
+				e.Data = util.Serialization(fmt.Sprint("data ", i))
+			}
+			events = append(events, e)
+		}
+		loaded, err := LoadEventsAMT(store, PutEventsAMT(store, events))
+		if err != nil {
+			t.Fatalf("%d events: %v", n, err)
+		}
+		if !reflect.DeepEqual(loaded, events) {
+			t.Errorf("%d events: loaded %v, want %v", n, loaded, events)
+		}
+	}
+}
+
+func TestLoadEventsAMTErrors(t *testing.T) {
+	store := ipld.MemGraphStore_Make()
+	root := PutEventsAMT(store, []ActorEvent{{Emitter: _outerAddr, Topic: "topic"}})
+	store.Delete(root)
+	if _, err := LoadEventsAMT(store, root); err != ErrEventsNotFound {
+		t.Errorf("missing root: error %v, want %v", err, ErrEventsNotFound)
+	}
+
+	malformed := ipld.PutAMT(store, []interface{}{[]interface{}{"not an address", "topic", []byte{}}})
+	if _, err := LoadEventsAMT(store, malformed); err != ErrMalformedEvent {
+		t.Errorf("malformed event: error %v, want %v", err, ErrMalformedEvent)
+	}
+}
@@ -1,15 +1,27 @@
 package impl
 
 import (
+	"bytes"
+	"errors"
+	"math/big"
+
 	vmr "github.com/filecoin-project/specs-actors/actors/runtime"
 	exitcode "github.com/filecoin-project/specs-actors/actors/runtime/exitcode"
 	msg "github.com/filecoin-project/specs/systems/filecoin_vm/message"
+	util "github.com/filecoin-project/specs/util"
+	cid "github.com/ipfs/go-cid"
+	cbornode "github.com/ipfs/go-ipld-cbor"
 )
 
+var ErrMalformedReceipt = errors.New("Malformed receipt")
+
 type MessageReceipt struct {
 	ExitCode    exitcode.ExitCode
 	ReturnValue Bytes
 	GasUsed     msg.GasAmount
+	// Root of the array-mapped trie of events emitted during execution, in order of emission.
+	// Undefined if no events were emitted.
+	EventsRoot cid.Cid
 }
 
 func MessageReceipt_Make(output vmr.InvocOutput, exitCode exitcode.ExitCode, gasUsed msg.GasAmount) MessageReceipt {
@@ -19,3 +31,57 @@ func MessageReceipt_Make(output vmr.InvocOutput, exitCode exitcode.ExitCode, gas
 		GasUsed:     gasUsed,
 	}
 }
+
+func MessageReceipt_Equals(x, y MessageReceipt) bool {
+	return x.ExitCode == y.ExitCode && bytes.Equal(x.ReturnValue, y.ReturnValue) && x.GasUsed.Equals(y.GasUsed) &&
+		x.EventsRoot.Equals(y.EventsRoot)
+}
+
+// Serializes a receipt as the DAG-CBOR tuple [ExitCode, ReturnValue, GasUsed, EventsRoot], with GasUsed
+// as the big-endian bytes of the amount, and EventsRoot null if no events were emitted.
+func Serialize_MessageReceipt(r MessageReceipt) util.Serialization {
+	var eventsRoot interface{}
+	if r.EventsRoot.Defined() {
+		eventsRoot = r.EventsRoot
+	}
+	tuple := []interface{}{
+		int64(r.ExitCode),
+		append([]byte{}, r.ReturnValue...), // Not null if empty.
+		msg.GasAmount_AsBigInt(r.GasUsed).Bytes(),
+		eventsRoot,
+	}
+	ret, err := cbornode.DumpObject(tuple)
+	Assert(err == nil)
+	return util.Serialization(ret)
+}
+
+func Deserialize_MessageReceipt(s util.Serialization) (MessageReceipt, error) {
+	var tuple []interface{}
+	if err := cbornode.DecodeInto(s, &tuple); err != nil || len(tuple) != 4 {
+		return MessageReceipt{}, ErrMalformedReceipt
+	}
+	var ret MessageReceipt
+	code, ok := tuple[0].(int) // DAG-CBOR integers decode as int.
+	if !ok {
+		return MessageReceipt{}, ErrMalformedReceipt
+	}
+	ret.ExitCode = exitcode.ExitCode(code)
+	returnValue, ok := tuple[1].([]byte)
+	if !ok {
+		return MessageReceipt{}, ErrMalformedReceipt
+	}
+	if len(returnValue) > 0 {
+		ret.ReturnValue = returnValue
+	}
+	gasUsed, ok := tuple[2].([]byte)
+	if !ok {
+		return MessageReceipt{}, ErrMalformedReceipt
+	}
+	ret.GasUsed = msg.GasAmount_FromBigInt(new(big.Int).SetBytes(gasUsed))
+	if tuple[3] != nil {
+		if ret.EventsRoot, ok = tuple[3].(cid.Cid); !ok {
+			return MessageReceipt{}, ErrMalformedReceipt
+		}
+	}
+	return ret, nil
+}
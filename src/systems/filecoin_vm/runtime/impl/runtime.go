@@ -14,10 +14,12 @@ import (
 	vmr "github.com/filecoin-project/specs-actors/actors/runtime"
 	exitcode "github.com/filecoin-project/specs-actors/actors/runtime/exitcode"
 	indices "github.com/filecoin-project/specs-actors/actors/runtime/indices"
+	serde "github.com/filecoin-project/specs-actors/actors/serde"
 	ipld "github.com/filecoin-project/specs/libraries/ipld"
 	chain "github.com/filecoin-project/specs/systems/filecoin_blockchain/struct/chain"
 	actstate "github.com/filecoin-project/specs/systems/filecoin_vm/actor"
 	msg "github.com/filecoin-project/specs/systems/filecoin_vm/message"
+	vmrt "github.com/filecoin-project/specs/systems/filecoin_vm/runtime"
 	gascost "github.com/filecoin-project/specs/systems/filecoin_vm/runtime/gascost"
 	st "github.com/filecoin-project/specs/systems/filecoin_vm/state_tree"
 	util "github.com/filecoin-project/specs/util"
@@ -104,6 +106,8 @@ func (h *ActorStateHandle_I) Take() ActorSubstateCID {
 // API calls.
 type VMContext struct {
 	_store              ipld.GraphStore
+	_codeLoader         ActorCodeLoader
+	_gasSchedule        *gascost.GasSchedule
 	_globalStateInit    st.StateTree
 	_globalStatePending st.StateTree
 	_running            bool
@@ -129,10 +133,23 @@ type VMContext struct {
 	_gasRemaining       msg.GasAmount
 	_numValidateCalls   int
 	_output             vmr.InvocOutput
+	// Set for read-only queries (see SendReadOnlyFromInterpreter), and inherited by subcalls.
+	// Any attempt to mutate state aborts the invocation.
+	_readOnly bool
+	// Traces of the messages sent so far by this invocation.
+	_subcallTraces []ExecutionTrace
+	// If non-nil, records the actors whose state is accessed. Shared with subcalls.
+	_stateAccess *StateAccessSet
+	// Events emitted so far by this invocation and its successful subcalls, in order.
+	_events []ActorEvent
+	// If non-nil, resolves receiver addresses in place of loading the InitActor state. Shared with subcalls.
+	_addressResolver AddressResolver
 }
 
 func VMContext_Make(
 	store ipld.GraphStore,
+	codeLoader ActorCodeLoader,
+	gasSchedule *gascost.GasSchedule,
 	chain chain.Chain,
 	toplevelSender addr.Address,
 	toplevelBlockWinner addr.Address,
@@ -145,6 +162,8 @@ func VMContext_Make(
 
 	return &VMContext{
 		_store:                store,
+		_codeLoader:           codeLoader,
+		_gasSchedule:          gasSchedule,
 		_chain:                chain,
 		_globalStateInit:      globalState,
 		_globalStatePending:   globalState,
@@ -204,6 +223,8 @@ func (rt *VMContext) CreateActor(codeID abi.ActorCodeID, address addr.Address) {
 }
 
 func (rt *VMContext) _createActor(codeID abi.ActorCodeID, address addr.Address) {
+	rt._checkNotReadOnly("Actor creation")
+
 	// Create empty actor state.
 	actorState := &actstate.ActorState_I{
 		CodeID_:     codeID,
@@ -216,7 +237,7 @@ func (rt *VMContext) _createActor(codeID abi.ActorCodeID, address addr.Address)
 	actorStateCID := actstate.ActorSystemStateCID(rt.IpldPut(actorState))
 	rt._updateActorSystemStateInternal(address, actorStateCID)
 
-	rt._rtAllocGas(gascost.ExecNewActor)
+	rt._rtAllocGas(rt._gasSchedule.ExecNewActor)
 }
 
 func (rt *VMContext) DeleteActor(address addr.Address) {
@@ -229,12 +250,20 @@ func (rt *VMContext) DeleteActor(address addr.Address) {
 }
 
 func (rt *VMContext) _deleteActor(address addr.Address) {
+	rt._checkNotReadOnly("Actor deletion")
+	rt._stateAccess.RecordWrite(address)
 	rt._globalStatePending = rt._globalStatePending.Impl().WithDeleteActorSystemState(address)
-	rt._rtAllocGas(gascost.DeleteActor)
+	rt._rtAllocGas(rt._gasSchedule.DeleteActor)
+}
+
+func (rt *VMContext) _getActor(a addr.Address) (actstate.ActorState, bool) {
+	rt._stateAccess.RecordRead(a)
+	return rt._globalStatePending.GetActor(a)
 }
 
 func (rt *VMContext) _updateActorSystemStateInternal(actorAddress addr.Address, newStateCID actstate.ActorSystemStateCID) {
-	newGlobalStatePending, err := rt._globalStatePending.Impl().WithActorSystemState(rt._actorAddress, newStateCID)
+	rt._stateAccess.RecordWrite(actorAddress)
+	newGlobalStatePending, err := rt._globalStatePending.Impl().WithActorSystemState(actorAddress, newStateCID)
 	if err != nil {
 		panic("Error in runtime implementation: failed to update actor system state")
 	}
@@ -242,7 +271,8 @@ func (rt *VMContext) _updateActorSystemStateInternal(actorAddress addr.Address,
 }
 
 func (rt *VMContext) _updateActorSubstateInternal(actorAddress addr.Address, newStateCID actor.ActorSubstateCID) {
-	newGlobalStatePending, err := rt._globalStatePending.Impl().WithActorSubstate(rt._actorAddress, newStateCID)
+	rt._stateAccess.RecordWrite(actorAddress)
+	newGlobalStatePending, err := rt._globalStatePending.Impl().WithActorSubstate(actorAddress, newStateCID)
 	if err != nil {
 		panic("Error in runtime implementation: failed to update actor substate")
 	}
@@ -252,6 +282,7 @@ func (rt *VMContext) _updateActorSubstateInternal(actorAddress addr.Address, new
 func (rt *VMContext) _updateReleaseActorSubstate(newStateCID ActorSubstateCID) {
 	rt._checkRunning()
 	rt._checkActorStateAcquired()
+	rt._checkNotReadOnly("Actor substate update")
 	rt._updateActorSubstateInternal(rt._actorAddress, newStateCID)
 	rt._actorSubstateUpdated = true
 	rt._actorStateAcquired = false
@@ -261,7 +292,7 @@ func (rt *VMContext) _releaseActorSubstate(checkStateCID ActorSubstateCID) {
 	rt._checkRunning()
 	rt._checkActorStateAcquired()
 
-	prevState, ok := rt._globalStatePending.GetActor(rt._actorAddress)
+	prevState, ok := rt._getActor(rt._actorAddress)
 	util.Assert(ok)
 	prevStateCID := prevState.State()
 	if !ActorSubstateCID_Equals(prevStateCID, checkStateCID) {
@@ -365,6 +396,12 @@ func (rt *VMContext) _checkNumValidateCalls(x int) {
 	}
 }
 
+func (rt *VMContext) _checkNotReadOnly(operation string) {
+	if rt._readOnly {
+		rt._throwErrorFull(vmrt.ReadOnlyViolation, fmt.Sprintf("%v not permitted in read-only invocation", operation))
+	}
+}
+
 func (rt *VMContext) _checkRunning() {
 	if !rt._running {
 		panic("Internal runtime error: actor API called with no actor code running")
@@ -410,6 +447,11 @@ func (rt *VMContext) _rtAllocGas(x msg.GasAmount) {
 func (rt *VMContext) _transferFunds(from addr.Address, to addr.Address, amount abi.TokenAmount) error {
 	rt._checkRunning()
 	rt._checkActorStateNotAcquired()
+	if amount != abi.TokenAmount(0) {
+		rt._checkNotReadOnly("Funds transfer")
+		rt._stateAccess.RecordWrite(from)
+		rt._stateAccess.RecordWrite(to)
+	}
 
 	newGlobalStatePending, err := rt._globalStatePending.Impl().WithFundsTransfer(from, to, amount)
 	if err != nil {
@@ -421,6 +463,7 @@ func (rt *VMContext) _transferFunds(from addr.Address, to addr.Address, amount a
 }
 
 func (rt *VMContext) GetActorCodeID(actorAddr addr.Address) (ret abi.ActorCodeID, ok bool) {
+	rt._stateAccess.RecordRead(actorAddr)
 	IMPL_FINISH()
 	panic("")
 }
@@ -437,12 +480,39 @@ const (
 // TODO: this should not use the MessageReceipt return type, even though it needs the same triple
 // of values. This method cannot compute the total gas cost and the returned receipt will never
 // go on chain.
-func (rt *VMContext) SendToplevelFromInterpreter(input InvocInput) (MessageReceipt, st.StateTree) {
+func (rt *VMContext) SendToplevelFromInterpreter(input InvocInput) (MessageReceipt, st.StateTree, ExecutionTrace) {
 
 	rt._running = true
 	ret := rt._sendInternal(input, CatchErrors)
 	rt._running = false
-	return ret, rt._globalStatePending
+	Assert(len(rt._subcallTraces) == 1)
+	return ret, rt._globalStatePending, rt._subcallTraces[0]
+}
+
+// Returns the events emitted by the top-level invocation, in order of emission. The interpreter
+// commits them (with PutEventsAMT) only if the message succeeds, as it does the resulting state.
+func (rt *VMContext) Events() []ActorEvent {
+	return rt._events
+}
+
+// Records the actors whose state is accessed by subsequent invocations in the given set.
+func (rt *VMContext) RecordStateAccess(access *StateAccessSet) {
+	rt._stateAccess = access
+}
+
+// Resolves receiver addresses with the given resolver (if non-nil). Gas is charged as if the
+// InitActor state were loaded.
+func (rt *VMContext) UseAddressResolver(resolver AddressResolver) {
+	rt._addressResolver = resolver
+}
+
+// Executes a top-level invocation as a read-only query (e.g., to compute a view of actor state).
+// Any attempt by the invoked actor, or by actors it calls, to mutate state aborts the invocation
+// with vmrt.ReadOnlyViolation. No resulting state is returned.
+func (rt *VMContext) SendReadOnlyFromInterpreter(input InvocInput) MessageReceipt {
+	rt._readOnly = true
+	ret, _, _ := rt.SendToplevelFromInterpreter(input)
+	return ret
 }
 
 func _catchRuntimeErrors(f func() InvocOutput) (output InvocOutput, exitCode exitcode.ExitCode) {
@@ -477,10 +547,14 @@ func _invokeMethodInternal(
 
 	rt._running = true
 	ret, exitCode = _catchRuntimeErrors(func() InvocOutput {
-		IMPL_TODO("dispatch to actor code")
-		var methodOutput vmr.InvocOutput // actorCode.InvokeMethod(rt, method, params)
+		var methodOutput vmr.InvocOutput
+		if invoker, ok := actorCode.(ActorMethodInvoker); ok {
+			methodOutput = invoker.InvokeMethod(rt, method, params)
+		} else {
+			IMPL_TODO("dispatch to actor code")
+		}
 		if rt._actorSubstateUpdated {
-			rt._rtAllocGas(gascost.UpdateActorSubstate)
+			rt._rtAllocGas(rt._gasSchedule.UpdateActorSubstate)
 		}
 		rt._checkActorStateNotAcquired()
 		rt._checkNumValidateCalls(1)
@@ -499,10 +573,15 @@ func (rtOuter *VMContext) _sendInternal(input InvocInput, errSpec ErrorHandlingS
 
 	initGasRemaining := rtOuter._gasRemaining
 
-	rtOuter._rtAllocGas(gascost.InvokeMethod(input.Value, input.Method))
+	rtOuter._rtAllocGas(rtOuter._gasSchedule.InvokeMethod(input.Value, input.Method))
+
+	// If the subcall fails, all of its effects are rolled back to this checkpoint (including the
+	// value transfer and any implicit creation of the receiver), while the caller's earlier effects
+	// are kept.
+	checkpoint := rtOuter._checkpoint()
 
 	receiver, receiverAddr := rtOuter._resolveReceiver(input.To)
-	receiverCode, err := loadActorCode(receiver.CodeID())
+	receiverCode, err := rtOuter._codeLoader.LoadActorCode(receiver.CodeID())
 	if err != nil {
 		rtOuter._throwError(exitcode.ActorCodeNotFound)
 	}
@@ -514,6 +593,8 @@ func (rtOuter *VMContext) _sendInternal(input InvocInput, errSpec ErrorHandlingS
 
 	rtInner := VMContext_Make(
 		rtOuter._store,
+		rtOuter._codeLoader,
+		rtOuter._gasSchedule,
 		rtOuter._chain,
 		rtOuter._toplevelSender,
 		rtOuter._toplevelBlockWinner,
@@ -524,6 +605,9 @@ func (rtOuter *VMContext) _sendInternal(input InvocInput, errSpec ErrorHandlingS
 		input.Value,
 		rtOuter._gasRemaining,
 	)
+	rtInner._readOnly = rtOuter._readOnly
+	rtInner._stateAccess = rtOuter._stateAccess
+	rtInner._addressResolver = rtOuter._addressResolver
 
 	invocOutput, exitCode, internalCallSeqNumFinal := _invokeMethodInternal(
 		rtInner,
@@ -539,20 +623,60 @@ func (rtOuter *VMContext) _sendInternal(input InvocInput, errSpec ErrorHandlingS
 
 	rtOuter._internalCallSeqNum = internalCallSeqNumFinal
 
+	receipt := MessageReceipt_Make(invocOutput, exitCode, gasUsed)
+	rtOuter._subcallTraces = append(rtOuter._subcallTraces, ExecutionTrace{
+		Caller:   rtOuter._actorAddress,
+		Receiver: receiverAddr,
+		Input:    input,
+		Receipt:  receipt,
+		Subcalls: rtInner._subcallTraces,
+	})
+
+	if exitCode.AllowsStateUpdate() {
+		rtOuter._commitSubcall(rtInner)
+	} else {
+		rtOuter._rollback(checkpoint)
+	}
+
 	if exitCode == exitcode.OutOfGas {
 		// OutOfGas error cannot be caught
 		rtOuter._throwError(exitCode)
 	}
 
 	if errSpec == PropagateErrors && exitCode.IsError() {
-		rtOuter._throwError(exitcode.MethodSubcallError)
+		if exitCode != vmrt.ReadOnlyViolation {
+			exitCode = exitcode.MethodSubcallError
+		}
+		rtOuter._throwError(exitCode)
 	}
 
-	if exitCode.AllowsStateUpdate() {
-		rtOuter._globalStatePending = rtInner._globalStatePending
+	return receipt
+}
+
+// A checkpoint of the effects of an invocation which are subject to rollback.
+// Gas consumption is never rolled back.
+type _runtimeCheckpoint struct {
+	globalState st.StateTree
+	numEvents   int
+}
+
+func (rt *VMContext) _checkpoint() _runtimeCheckpoint {
+	return _runtimeCheckpoint{
+		globalState: rt._globalStatePending,
+		numEvents:   len(rt._events),
 	}
+}
+
+// Discards all state changes and events since the checkpoint was taken.
+func (rt *VMContext) _rollback(c _runtimeCheckpoint) {
+	rt._globalStatePending = c.globalState
+	rt._events = rt._events[:c.numEvents]
+}
 
-	return MessageReceipt_Make(invocOutput, exitCode, gasUsed)
+// Adopts the effects of a successful subcall, which began from this invocation's pending state.
+func (rt *VMContext) _commitSubcall(rtInner *VMContext) {
+	rt._globalStatePending = rtInner._globalStatePending
+	rt._events = append(rt._events, rtInner._events...)
 }
 
 // Loads a receiving actor state from the state tree, resolving non-ID addresses through the InitActor state.
@@ -561,9 +685,8 @@ func (rtOuter *VMContext) _sendInternal(input InvocInput, errSpec ErrorHandlingS
 // Aborts otherwise.
 func (rt *VMContext) _resolveReceiver(targetRaw addr.Address) (actstate.ActorState, addr.Address) {
 	// Resolve the target address via the InitActor, and attempt to load state.
-	initSubState := rt._loadInitActorState()
-	targetIdAddr := initSubState.ResolveAddress(targetRaw)
-	act, found := rt._globalStatePending.GetActor(targetIdAddr)
+	targetIdAddr := rt._resolveAddress(targetRaw)
+	act, found := rt._getActor(targetIdAddr)
 	if found {
 		return act, targetIdAddr
 	}
@@ -572,8 +695,10 @@ func (rt *VMContext) _resolveReceiver(targetRaw addr.Address) (actstate.ActorSta
 		// Don't implicitly create an account actor for an address without an associated key.
 		rt._throwError(exitcode.ActorNotFound)
 	}
+	rt._checkNotReadOnly("Implicit account actor creation")
 
 	// Allocate an ID address from the init actor and map the pubkey To address to it.
+	initSubState := rt._loadInitActorState()
 	newIdAddr := initSubState.MapAddressToNewID(targetRaw)
 	rt._saveInitActorState(initSubState)
 
@@ -585,12 +710,32 @@ func (rt *VMContext) _resolveReceiver(targetRaw addr.Address) (actstate.ActorSta
 		Address: targetRaw,
 	}
 	rt._saveAccountActorState(newIdAddr, *substate)
-	act, _ = rt._globalStatePending.GetActor(newIdAddr)
+	act, _ = rt._getActor(newIdAddr)
 	return act, newIdAddr
 }
 
+// Resolves an address through the InitActor state, charging gas for loading that state whether or
+// not the resolver (if any) has cached the resolution. ID addresses resolve to themselves, without
+// loading it.
+func (rt *VMContext) _resolveAddress(a addr.Address) addr.Address {
+	if a.Protocol() == addr.ID {
+		return a
+	}
+	if rt._addressResolver == nil {
+		return rt._loadInitActorState().ResolveAddress(a)
+	}
+
+	initState, ok := rt._getActor(builtin.InitActorAddr)
+	util.Assert(ok)
+	serialized, ok := rt._store.Get(cid.Cid(initState.State()))
+	util.Assert(ok)
+	rt._rtAllocGas(rt._gasSchedule.IpldGet(len(serialized)))
+	ret, _ := rt._addressResolver.ResolveAddress(rt._globalStatePending, a)
+	return ret
+}
+
 func (rt *VMContext) _loadInitActorState() initact.InitActorState {
-	initState, ok := rt._globalStatePending.GetActor(builtin.InitActorAddr)
+	initState, ok := rt._getActor(builtin.InitActorAddr)
 	util.Assert(ok)
 	var initSubState initact.InitActorState
 	ok = rt.IpldGet(cid.Cid(initState.State()), &initSubState)
@@ -601,14 +746,14 @@ func (rt *VMContext) _loadInitActorState() initact.InitActorState {
 func (rt *VMContext) _saveInitActorState(state initact.InitActorState) {
 	// Gas is charged here separately from _actorSubstateUpdated because this is a different actor
 	// than the receiver.
-	rt._rtAllocGas(gascost.UpdateActorSubstate)
+	rt._rtAllocGas(rt._gasSchedule.UpdateActorSubstate)
 	rt._updateActorSubstateInternal(builtin.InitActorAddr, actor.ActorSubstateCID(rt.IpldPut(&state)))
 }
 
 func (rt *VMContext) _saveAccountActorState(address addr.Address, state acctact.AccountActorState) {
 	// Gas is charged here separately from _actorSubstateUpdated because this is a different actor
 	// than the receiver.
-	rt._rtAllocGas(gascost.UpdateActorSubstate)
+	rt._rtAllocGas(rt._gasSchedule.UpdateActorSubstate)
 	rt._updateActorSubstateInternal(address, actor.ActorSubstateCID(rt.IpldPut(state)))
 }
 
@@ -644,7 +789,20 @@ func (rt *VMContext) SendCatchingErrors(input InvocInput) (InvocOutput, exitcode
 	return rt._sendInternalOutputs(input, CatchErrors)
 }
 
+// Emits an event from the current actor. The event is included in the top-level message's receipt
+// only if this invocation, and every invocation up to the top-level one, completes successfully.
+func (rt *VMContext) EmitEvent(topic EventTopic, data util.Serialization) {
+	rt._checkRunning()
+	rt._rtAllocGas(rt._gasSchedule.EmitEvent(len(data)))
+	rt._events = append(rt._events, ActorEvent{
+		Emitter: rt._actorAddress,
+		Topic:   topic,
+		Data:    data,
+	})
+}
+
 func (rt *VMContext) CurrentBalance() abi.TokenAmount {
+	rt._stateAccess.RecordRead(rt._actorAddress)
 	IMPL_FINISH()
 	panic("")
 }
@@ -660,7 +818,7 @@ func (rt *VMContext) GetRandomness(epoch abi.ChainEpoch) abi.RandomnessSeed {
 func (rt *VMContext) NewActorAddress() addr.Address {
 	addrBuf := new(bytes.Buffer)
 
-	senderState, ok := rt._globalStatePending.GetActor(rt._toplevelSender)
+	senderState, ok := rt._getActor(rt._toplevelSender)
 	util.Assert(ok)
 	var aast acctact.AccountActorState
 	ok = rt.IpldGet(cid.Cid(senderState.State()), &aast)
@@ -681,14 +839,14 @@ func (rt *VMContext) IpldPut(x ipld.Object) cid.Cid {
 	IMPL_FINISH() // Serialization
 	serialized := []byte{}
 	cid := rt._store.Put(serialized)
-	rt._rtAllocGas(gascost.IpldPut(len(serialized)))
+	rt._rtAllocGas(rt._gasSchedule.IpldPut(len(serialized)))
 	return cid
 }
 
 func (rt *VMContext) IpldGet(c cid.Cid, o ipld.Object) bool {
 	serialized, ok := rt._store.Get(c)
 	if ok {
-		rt._rtAllocGas(gascost.IpldGet(len(serialized)))
+		rt._rtAllocGas(rt._gasSchedule.IpldGet(len(serialized)))
 	}
 	IMPL_FINISH() // Deserialization into o
 	return ok
@@ -711,7 +869,7 @@ func (rt *VMContext) AcquireState() ActorStateHandle {
 	rt._checkActorStateNotAcquired()
 	rt._actorStateAcquired = true
 
-	state, ok := rt._globalStatePending.GetActor(rt._actorAddress)
+	state, ok := rt._getActor(rt._actorAddress)
 	util.Assert(ok)
 
 	stateRef := state.State().Ref()
@@ -726,7 +884,10 @@ func (rt *VMContext) Compute(f ComputeFunctionID, args []util.Any) Any {
 	if !found {
 		rt.AbortAPI("Function definition in rt.Compute() not found")
 	}
-	gasCost := def.GasCostFn(args)
+	if !def._argsValid(args) {
+		rt.AbortArgMsg("Invalid arguments to rt.Compute()")
+	}
+	gasCost := def.GasCostFn(rt._gasSchedule, args)
 	rt._rtAllocGas(gasCost)
 	return def.Body(args)
 }
@@ -1,62 +1,209 @@
 package impl
 
-import filcrypto "github.com/filecoin-project/specs/algorithms/crypto"
-import gascost "github.com/filecoin-project/specs/systems/filecoin_vm/runtime/gascost"
-import msg "github.com/filecoin-project/specs/systems/filecoin_vm/message"
-import util "github.com/filecoin-project/specs/util"
-import vmr "github.com/filecoin-project/specs-actors/actors/runtime"
+import (
+	"crypto/sha256"
+	"reflect"
+
+	abi "github.com/filecoin-project/specs-actors/actors/abi"
+	vmr "github.com/filecoin-project/specs-actors/actors/runtime"
+	filcrypto "github.com/filecoin-project/specs/algorithms/crypto"
+	filproofs "github.com/filecoin-project/specs/libraries/filcrypto/filproofs"
+	block "github.com/filecoin-project/specs/systems/filecoin_blockchain/struct/block"
+	msg "github.com/filecoin-project/specs/systems/filecoin_vm/message"
+	vmrt "github.com/filecoin-project/specs/systems/filecoin_vm/runtime"
+	gascost "github.com/filecoin-project/specs/systems/filecoin_vm/runtime/gascost"
+	util "github.com/filecoin-project/specs/util"
+	"golang.org/x/crypto/blake2b"
+)
 
 type Any = util.Any
 type Int = util.Int
 type ComputeFunctionID = vmr.ComputeFunctionID
 
+// The kinds of consensus fault which may be proven by block headers (see Expected Consensus).
+type ConsensusFaultKind = block.ConsensusFaultKind
+
+const (
+	ConsensusFault_None             = block.ConsensusFault_None
+	ConsensusFault_DoubleForkMining = block.ConsensusFault_DoubleForkMining
+	ConsensusFault_TimeOffsetMining = block.ConsensusFault_TimeOffsetMining
+	ConsensusFault_ParentGrinding   = block.ConsensusFault_ParentGrinding
+)
+
 type ComputeFunctionBody = func([]Any) Any
-type ComputeFunctionGasCostFn = func([]Any) msg.GasAmount
+type ComputeFunctionGasCostFn = func(*gascost.GasSchedule, []Any) msg.GasAmount
 
+// A compute function's arguments are checked against ArgTypes (and ValidateArgs, if present) before
+// its gas cost function or body is called, so both may assume well-formed arguments.
 type ComputeFunctionDef struct {
-	Body      ComputeFunctionBody
-	GasCostFn ComputeFunctionGasCostFn
+	ArgTypes     []reflect.Type
+	ValidateArgs func([]Any) bool
+	Body         ComputeFunctionBody
+	GasCostFn    ComputeFunctionGasCostFn
+}
+
+func (def ComputeFunctionDef) _argsValid(args []Any) bool {
+	if len(args) != len(def.ArgTypes) {
+		return false
+	}
+	for i, arg := range args {
+		argType := reflect.TypeOf(arg)
+		if argType == nil || !argType.AssignableTo(def.ArgTypes[i]) {
+			return false
+		}
+	}
+	return def.ValidateArgs == nil || def.ValidateArgs(args)
 }
 
 var _computeFunctionDefs = map[ComputeFunctionID]ComputeFunctionDef{}
 
+var (
+	_bytesType            = reflect.TypeOf(util.Bytes(nil))
+	_publicKeyType        = reflect.TypeOf(filcrypto.PublicKey(nil))
+	_signatureType        = reflect.TypeOf((*filcrypto.Signature)(nil)).Elem()
+	_messageType          = reflect.TypeOf(filcrypto.Message(nil))
+	_messagesType         = reflect.TypeOf([]filcrypto.Message(nil))
+	_sealVerifyInfoType   = reflect.TypeOf(abi.SealVerifyInfo{})
+	_registeredProofType  = reflect.TypeOf(abi.RegisteredProof(0))
+	_postVerifyInfoType   = reflect.TypeOf(abi.PoStVerifyInfo{})
+	_blockHeaderType      = reflect.TypeOf((*block.BlockHeader)(nil)).Elem()
+	_blockHeaderSliceType = reflect.TypeOf([]block.BlockHeader(nil))
+)
+
 func init() {
-	// VerifySignature
-	_computeFunctionDefs[vmr.Compute_VerifySignature] = ComputeFunctionDef{
+	// VerifySignature(pk PublicKey, sig Signature, m Message) bool
+	_computeFunctionDefs[vmrt.Compute_VerifySignature] = ComputeFunctionDef{
+		ArgTypes: []reflect.Type{_publicKeyType, _signatureType, _messageType},
 		Body: func(args []Any) Any {
-			if len(args) != 3 {
-				return nil
-			}
-			i := 0
+			valid, err := filcrypto.Verify(args[0].(filcrypto.PublicKey), args[1].(filcrypto.Signature), args[2].(filcrypto.Message))
+			return err == nil && valid
+		},
+		GasCostFn: func(sched *gascost.GasSchedule, args []Any) msg.GasAmount {
+			return sched.PublicKeyCryptoOp
+		},
+	}
 
-			pk, ok := args[i].(filcrypto.PublicKey)
-			i++
-			if !ok {
-				return nil
+	// VerifyAggregateSignature(messages []Message, aggPk PublicKey, aggSig Signature) bool
+	// The messages must be non-empty and distinct, and the signature a BLS signature.
+	_computeFunctionDefs[vmrt.Compute_VerifyAggregateSignature] = ComputeFunctionDef{
+		ArgTypes: []reflect.Type{_messagesType, _publicKeyType, _signatureType},
+		ValidateArgs: func(args []Any) bool {
+			messages := args[0].([]filcrypto.Message)
+			if len(messages) == 0 || args[2].(filcrypto.Signature).Type() != filcrypto.SigType_BLSSigType {
+				return false
 			}
-			sig, ok := args[i].(filcrypto.Signature)
-			i++
-			if !ok {
-				return nil
+			seen := make(map[string]struct{}, len(messages))
+			for _, m := range messages {
+				if _, found := seen[string(m)]; found {
+					return false
+				}
+				seen[string(m)] = struct{}{}
 			}
-			m, ok := args[i].(filcrypto.Message)
-			i++
-			if !ok {
-				return nil
+			return true
+		},
+		Body: func(args []Any) Any {
+			messages := args[0].([]filcrypto.Message)
+			aggSig := args[2].(filcrypto.Signature)
+			inputs := make([]util.Bytes, len(messages))
+			for i, m := range messages {
+				inputs[i] = util.Bytes(m)
 			}
+			bls := &filcrypto.BLS_I{Signature_: aggSig}
+			return bls.VerifyAggregate(inputs, args[1].(filcrypto.PublicKey), util.Bytes(aggSig.Sig()))
+		},
+		GasCostFn: func(sched *gascost.GasSchedule, args []Any) msg.GasAmount {
+			return sched.BLSAggregateVerify(len(args[0].([]filcrypto.Message)))
+		},
+	}
 
-			if i != len(args) {
-				return nil
-			}
+	// HashBlake2b(data Bytes) Bytes
+	// Returns the 256-bit BLAKE2b digest of data.
+	_computeFunctionDefs[vmrt.Compute_HashBlake2b] = ComputeFunctionDef{
+		ArgTypes: []reflect.Type{_bytesType},
+		Body: func(args []Any) Any {
+			digest := blake2b.Sum256(args[0].(util.Bytes))
+			return util.Bytes(digest[:])
+		},
+		GasCostFn: func(sched *gascost.GasSchedule, args []Any) msg.GasAmount {
+			return sched.HashBlake2b(len(args[0].(util.Bytes)))
+		},
+	}
 
-			valid, err := filcrypto.Verify(pk, sig, m)
-			if err != nil {
-				return nil
-			}
-			return valid
+	// HashSHA256(data Bytes) Bytes
+	_computeFunctionDefs[vmrt.Compute_HashSHA256] = ComputeFunctionDef{
+		ArgTypes: []reflect.Type{_bytesType},
+		Body: func(args []Any) Any {
+			digest := sha256.Sum256(args[0].(util.Bytes))
+			return util.Bytes(digest[:])
+		},
+		GasCostFn: func(sched *gascost.GasSchedule, args []Any) msg.GasAmount {
+			return sched.HashSHA256(len(args[0].(util.Bytes)))
+		},
+	}
+
+	// VerifySeal(sv SealVerifyInfo) bool
+	_computeFunctionDefs[vmrt.Compute_VerifySeal] = ComputeFunctionDef{
+		ArgTypes: []reflect.Type{_sealVerifyInfoType},
+		Body: func(args []Any) Any {
+			sv := args[0].(abi.SealVerifyInfo)
+			return filproofs.WinSDRParams(sv.OnChain.RegisteredProof).VerifySeal(sv)
+		},
+		GasCostFn: func(sched *gascost.GasSchedule, args []Any) msg.GasAmount {
+			return sched.VerifySeal
+		},
+	}
+
+	// VerifySurprisePoSt(registeredProof RegisteredProof, sv PoStVerifyInfo) bool
+	// The proof must include at least one candidate. Election PoSts are verified with the block that
+	// carries them, rather than by actors.
+	_computeFunctionDefs[vmrt.Compute_VerifySurprisePoSt] = ComputeFunctionDef{
+		ArgTypes: []reflect.Type{_registeredProofType, _postVerifyInfoType},
+		ValidateArgs: func(args []Any) bool {
+			sv := args[1].(abi.PoStVerifyInfo)
+			return len(sv.Candidates) > 0 && len(sv.Proofs) > 0
+		},
+		Body: func(args []Any) Any {
+			pv := filproofs.MakeSurprisePoStVerifier(args[0].(abi.RegisteredProof))
+			return pv.VerifySurprisePoSt(args[1].(abi.PoStVerifyInfo))
 		},
-		GasCostFn: func(args []Any) msg.GasAmount {
-			return gascost.PublicKeyCryptoOp
+		GasCostFn: func(sched *gascost.GasSchedule, args []Any) msg.GasAmount {
+			return sched.VerifyPoSt(len(args[1].(abi.PoStVerifyInfo).Candidates))
 		},
 	}
+
+	// VerifyConsensusFault(h1, h2 BlockHeader, witnesses []BlockHeader, workerKey PublicKey) ConsensusFaultKind
+	// h1 and h2 must be distinct headers from the same miner, with h1 at an epoch no later than h2.
+	// A parent-grinding fault is proven by a single witness; otherwise witnesses must be empty.
+	// Returns the fault found by block.ConsensusFaultOf (as for ExpectedConsensus.IsValidConsensusFault),
+	// or ConsensusFault_None if either signature is invalid.
+	_computeFunctionDefs[vmrt.Compute_VerifyConsensusFault] = ComputeFunctionDef{
+		ArgTypes: []reflect.Type{_blockHeaderType, _blockHeaderType, _blockHeaderSliceType, _publicKeyType},
+		ValidateArgs: func(args []Any) bool {
+			h1 := args[0].(block.BlockHeader)
+			h2 := args[1].(block.BlockHeader)
+			witnesses := args[2].([]block.BlockHeader)
+			return block.BlockHeaderCID(h1) != block.BlockHeaderCID(h2) && h1.Miner() == h2.Miner() && h1.Epoch() <= h2.Epoch() &&
+				len(witnesses) <= 1
+		},
+		Body: func(args []Any) Any {
+			return _verifyConsensusFault(
+				args[0].(block.BlockHeader), args[1].(block.BlockHeader), args[2].([]block.BlockHeader), args[3].(filcrypto.PublicKey))
+		},
+		GasCostFn: func(sched *gascost.GasSchedule, args []Any) msg.GasAmount {
+			return sched.VerifyConsensusFault
+		},
+	}
+}
+
+func _verifyConsensusFault(h1, h2 block.BlockHeader, witnesses []block.BlockHeader, workerKey filcrypto.PublicKey) ConsensusFaultKind {
+	if !_blockHeaderSignatureValid(h1, workerKey) || !_blockHeaderSignatureValid(h2, workerKey) {
+		return ConsensusFault_None
+	}
+	return block.ConsensusFaultOf(h1, h2, witnesses)
+}
+
+func _blockHeaderSignatureValid(h block.BlockHeader, workerKey filcrypto.PublicKey) bool {
+	unsigned := filcrypto.Message(block.BlockHeaderSigningBytes(h))
+	valid, err := filcrypto.Verify(workerKey, h.Signature(), unsigned)
+	return err == nil && valid
 }
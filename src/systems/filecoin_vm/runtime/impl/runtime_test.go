@@ -0,0 +1,313 @@
+package impl
+
+import (
+	"testing"
+
+	addr "github.com/filecoin-project/go-address"
+	actor "github.com/filecoin-project/specs-actors/actors"
+	abi "github.com/filecoin-project/specs-actors/actors/abi"
+	builtin "github.com/filecoin-project/specs-actors/actors/builtin"
+	vmr "github.com/filecoin-project/specs-actors/actors/runtime"
+	exitcode "github.com/filecoin-project/specs-actors/actors/runtime/exitcode"
+	actstate "github.com/filecoin-project/specs/systems/filecoin_vm/actor"
+	msg "github.com/filecoin-project/specs/systems/filecoin_vm/message"
+	vmrt "github.com/filecoin-project/specs/systems/filecoin_vm/runtime"
+	gascost "github.com/filecoin-project/specs/systems/filecoin_vm/runtime/gascost"
+	st "github.com/filecoin-project/specs/systems/filecoin_vm/state_tree"
+	util "github.com/filecoin-project/specs/util"
+	cbornode "github.com/ipfs/go-ipld-cbor"
+	mh "github.com/multiformats/go-multihash"
+)
+
+// The tests below send nested subcalls through _sendInternal to mock actors, which transfer value, write
+// their state, emit events and abort through the runtime API. Implicit account creation, which needs the
+// InitActor's state, is applied to the pending state directly.
+
+var (
+	_outerAddr   = _testIDAddr(100)
+	_middleAddr  = _testIDAddr(101)
+	_innerAddr   = _testIDAddr(102)
+	_createdAddr = _testIDAddr(103)
+)
+
+const _testMethod = abi.MethodNum(1)
+
+func _testIDAddr(id uint64) addr.Address {
+	a, err := addr.NewIDAddress(id)
+	if err != nil {
+		panic(err)
+	}
+	return a
+}
+
+// The behavior of a mock actor's method, run in the context of its invocation.
+type _testBehavior func(rt *VMContext) InvocOutput
+
+// _testActors is both the ActorCodeLoader and the code of every mock actor: each invocation runs the
+// behavior registered for the receiving actor.
+type _testActors map[addr.Address]_testBehavior
+
+func (a _testActors) LoadActorCode(codeID abi.ActorCodeID) (vmr.ActorCode, error) {
+	return a, nil
+}
+
+func (a _testActors) InvokeMethod(rt Runtime, method abi.MethodNum, params abi.MethodParams) InvocOutput {
+	vmc := rt.(*VMContext)
+	vmc.ValidateImmediateCallerAcceptAny()
+	return a[vmc._actorAddress](vmc)
+}
+
+// Resolves every address to itself, as only ID addresses are used.
+type _idResolver struct{}
+
+func (_idResolver) ResolveAddress(tree st.StateTree, a addr.Address) (addr.Address, bool) {
+	return a, true
+}
+
+func (_idResolver) RobustAddress(tree st.StateTree, id addr.Address) (addr.Address, bool) {
+	return addr.Undef, false
+}
+
+func _testStateTree() st.StateTree {
+	return &st.StateTree_I{
+		ActorStates_: map[addr.Address]actstate.ActorState{
+			_outerAddr:  &actstate.ActorState_I{CodeID_: builtin.AccountActorCodeID, Balance_: abi.TokenAmount(1000)},
+			_middleAddr: &actstate.ActorState_I{CodeID_: builtin.AccountActorCodeID, Balance_: abi.TokenAmount(0)},
+			_innerAddr:  &actstate.ActorState_I{CodeID_: builtin.AccountActorCodeID, Balance_: abi.TokenAmount(0)},
+		},
+	}
+}
+
+// Returns the context of a running invocation of the outer actor, from which subcalls are sent to actors.
+func _testContext(tree st.StateTree, actors _testActors) *VMContext {
+	rt := VMContext_Make(nil, actors, gascost.GenesisGasSchedule, nil, _outerAddr, _outerAddr, 0, 0, tree, _outerAddr,
+		abi.TokenAmount(0), msg.GasAmount_FromInt(1e6))
+	rt.UseAddressResolver(_idResolver{})
+	rt._running = true
+	return rt
+}
+
+func _send(rt *VMContext, to addr.Address, value abi.TokenAmount) MessageReceipt {
+	return rt._sendInternal(vmr.InvocInput_Make(to, _testMethod, nil, value), CatchErrors)
+}
+
+func _abort(rt *VMContext) InvocOutput {
+	rt.AbortStateMsg("Test abort")
+	panic("unreachable")
+}
+
+func _createAccount(rt *VMContext, a addr.Address) {
+	actors := make(map[addr.Address]actstate.ActorState)
+	for k, v := range rt._globalStatePending.ActorStates() {
+		actors[k] = v
+	}
+	actors[a] = &actstate.ActorState_I{CodeID_: builtin.AccountActorCodeID, Balance_: abi.TokenAmount(0)}
+	rt._globalStatePending = &st.StateTree_I{ActorStates_: actors}
+}
+
+func _substateCID(substate string) actor.ActorSubstateCID {
+	n, err := cbornode.WrapObject(substate, mh.SHA2_256, -1)
+	if err != nil {
+		panic(err)
+	}
+	return actor.ActorSubstateCID(n.Cid())
+}
+
+func _writeSubstate(rt *VMContext, substate string) {
+	rt.AcquireState().UpdateRelease(_substateCID(substate))
+}
+
+func _assertExitCode(t *testing.T, receipt MessageReceipt, expected exitcode.ExitCode) {
+	t.Helper()
+	if receipt.ExitCode != expected {
+		t.Errorf("exit code %v, want %v", receipt.ExitCode, expected)
+	}
+}
+
+func _assertBalance(t *testing.T, tree st.StateTree, a addr.Address, expected abi.TokenAmount) {
+	t.Helper()
+	act, found := tree.GetActor(a)
+	if !found {
+		t.Fatalf("actor %v not found", a)
+	}
+	if act.Balance() != expected {
+		t.Errorf("balance of %v is %d, want %d", a, act.Balance(), expected)
+	}
+}
+
+func _assertSubstate(t *testing.T, tree st.StateTree, a addr.Address, expected actor.ActorSubstateCID) {
+	t.Helper()
+	act, found := tree.GetActor(a)
+	if !found {
+		t.Fatalf("actor %v not found", a)
+	}
+	if act.State() != expected {
+		t.Errorf("substate of %v is %v, want %v", a, act.State(), expected)
+	}
+}
+
+func _assertEvents(t *testing.T, events []ActorEvent, expected ...EventTopic) {
+	t.Helper()
+	if len(events) != len(expected) {
+		t.Fatalf("%d events, want %d", len(events), len(expected))
+	}
+	for i, e := range events {
+		if e.Topic != expected[i] {
+			t.Errorf("event %d has topic %q, want %q", i, e.Topic, expected[i])
+		}
+	}
+}
+
+func TestAbortedSubcallIsRolledBack(t *testing.T) {
+	// The subcall receives value, implicitly creates an account, writes its state and emits an event,
+	// then aborts.
+	outer := _testContext(_testStateTree(), _testActors{
+		_middleAddr: func(rt *VMContext) InvocOutput {
+			_createAccount(rt, _createdAddr)
+			_writeSubstate(rt, "middle")
+			rt.EmitEvent("middle", util.Serialization("2"))
+			return _abort(rt)
+		},
+	})
+
+	// The outer call writes its own state and emits an event before sending.
+	_writeSubstate(outer, "outer")
+	outer.EmitEvent("outer", util.Serialization("1"))
+	outerState := outer._globalStatePending
+
+	_assertExitCode(t, _send(outer, _middleAddr, 300), exitcode.InconsistentState_User)
+
+	if outer._globalStatePending != outerState {
+		t.Error("pending state is not that before the aborted subcall")
+	}
+	_assertBalance(t, outer._globalStatePending, _outerAddr, 1000)
+	_assertBalance(t, outer._globalStatePending, _middleAddr, 0)
+	if _, found := outer._globalStatePending.GetActor(_createdAddr); found {
+		t.Error("account implicitly created by the aborted subcall remains")
+	}
+	_assertSubstate(t, outer._globalStatePending, _middleAddr, actor.ActorSubstateCID{})
+	_assertSubstate(t, outer._globalStatePending, _outerAddr, _substateCID("outer"))
+	_assertEvents(t, outer.Events(), "outer")
+
+	// The outer call's effects after the aborted subcall are kept.
+	outer.EmitEvent("outer-after", util.Serialization("3"))
+	_assertEvents(t, outer.Events(), "outer", "outer-after")
+}
+
+func TestPropagatedSubcallErrorIsRolledBack(t *testing.T) {
+	outer := _testContext(_testStateTree(), _testActors{
+		_middleAddr: func(rt *VMContext) InvocOutput {
+			_writeSubstate(rt, "middle")
+			return _abort(rt)
+		},
+	})
+	outerState := outer._globalStatePending
+
+	// The subcall's effects are rolled back before its error is raised in the caller.
+	_, exitCode := _catchRuntimeErrors(func() InvocOutput {
+		return outer.SendPropagatingErrors(vmr.InvocInput_Make(_middleAddr, _testMethod, nil, abi.TokenAmount(300)))
+	})
+	if exitCode != exitcode.MethodSubcallError {
+		t.Errorf("exit code %v, want %v", exitCode, exitcode.MethodSubcallError)
+	}
+	if outer._globalStatePending != outerState {
+		t.Error("pending state is not that before the aborted subcall")
+	}
+	_assertBalance(t, outer._globalStatePending, _outerAddr, 1000)
+}
+
+func TestSubcallOfAbortedSubcallIsRolledBack(t *testing.T) {
+	// The middle call sends value on to a subcall of its own, which succeeds, then aborts.
+	var middleState st.StateTree
+	var middleEvents []ActorEvent
+	outer := _testContext(_testStateTree(), _testActors{
+		_middleAddr: func(rt *VMContext) InvocOutput {
+			rt.Send(_innerAddr, _testMethod, nil, abi.TokenAmount(100))
+			middleState, middleEvents = rt._globalStatePending, rt.Events()
+			return _abort(rt)
+		},
+		_innerAddr: func(rt *VMContext) InvocOutput {
+			_writeSubstate(rt, "inner")
+			rt.EmitEvent("inner", util.Serialization("1"))
+			return rt.SuccessReturn()
+		},
+	})
+	outerState := outer._globalStatePending
+
+	_assertExitCode(t, _send(outer, _middleAddr, 300), exitcode.InconsistentState_User)
+
+	// The nested subcall's effects were committed to its caller.
+	_assertBalance(t, middleState, _middleAddr, 200)
+	_assertBalance(t, middleState, _innerAddr, 100)
+	_assertSubstate(t, middleState, _innerAddr, _substateCID("inner"))
+	_assertEvents(t, middleEvents, "inner")
+
+	// They are rolled back with those of its caller.
+	if outer._globalStatePending != outerState {
+		t.Error("pending state is not that before the aborted subcall")
+	}
+	_assertBalance(t, outer._globalStatePending, _outerAddr, 1000)
+	_assertBalance(t, outer._globalStatePending, _middleAddr, 0)
+	_assertBalance(t, outer._globalStatePending, _innerAddr, 0)
+	_assertSubstate(t, outer._globalStatePending, _innerAddr, actor.ActorSubstateCID{})
+	_assertEvents(t, outer.Events())
+}
+
+func TestAbortedSubcallOfSuccessfulSubcallIsRolledBack(t *testing.T) {
+	// The middle call writes its state and emits an event, then sends value to a subcall which implicitly
+	// creates an account and aborts. The middle call catches the error and succeeds.
+	var innerReceipt MessageReceipt
+	outer := _testContext(_testStateTree(), _testActors{
+		_middleAddr: func(rt *VMContext) InvocOutput {
+			_writeSubstate(rt, "middle")
+			rt.EmitEvent("middle", util.Serialization("1"))
+			innerReceipt = _send(rt, _innerAddr, 100)
+			return rt.SuccessReturn()
+		},
+		_innerAddr: func(rt *VMContext) InvocOutput {
+			_createAccount(rt, _createdAddr)
+			rt.EmitEvent("inner", util.Serialization("2"))
+			return _abort(rt)
+		},
+	})
+
+	_assertExitCode(t, _send(outer, _middleAddr, 300), exitcode.OK())
+	_assertExitCode(t, innerReceipt, exitcode.InconsistentState_User)
+
+	// Only the aborted subcall's effects are rolled back.
+	_assertBalance(t, outer._globalStatePending, _outerAddr, 700)
+	_assertBalance(t, outer._globalStatePending, _middleAddr, 300)
+	_assertBalance(t, outer._globalStatePending, _innerAddr, 0)
+	if _, found := outer._globalStatePending.GetActor(_createdAddr); found {
+		t.Error("account implicitly created by the aborted subcall remains")
+	}
+	_assertSubstate(t, outer._globalStatePending, _middleAddr, _substateCID("middle"))
+	_assertEvents(t, outer.Events(), "middle")
+}
+
+func TestReadOnlyViolation(t *testing.T) {
+	writeState := func(rt *VMContext) InvocOutput {
+		_writeSubstate(rt, "written")
+		return rt.SuccessReturn()
+	}
+	sendToWriter := func(rt *VMContext) InvocOutput {
+		rt.Send(_innerAddr, _testMethod, nil, abi.TokenAmount(0))
+		return rt.SuccessReturn()
+	}
+	cases := []struct {
+		name   string
+		actors _testActors
+	}{
+		{"queried actor writes state", _testActors{_middleAddr: writeState}},
+		// The violation is not masked as a subcall error by the calling actor.
+		{"subcall writes state", _testActors{_middleAddr: sendToWriter, _innerAddr: writeState}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			rt := _testContext(_testStateTree(), c.actors)
+			rt._running = false
+			receipt := rt.SendReadOnlyFromInterpreter(vmr.InvocInput_Make(_middleAddr, _testMethod, nil, abi.TokenAmount(0)))
+			_assertExitCode(t, receipt, vmrt.ReadOnlyViolation)
+		})
+	}
+}
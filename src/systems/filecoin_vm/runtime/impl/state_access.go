@@ -0,0 +1,48 @@
+package impl
+
+import (
+	addr "github.com/filecoin-project/go-address"
+)
+
+// StateAccessSet records the (ID) addresses of actors whose state is read or written during
+// the execution of a message, including by the interpreter on the message's behalf.
+// It is used to detect conflicts between messages executed speculatively in parallel.
+//
+// A nil *StateAccessSet records nothing, so recording may be left disabled at no cost.
+type StateAccessSet struct {
+	Reads  map[addr.Address]struct{}
+	Writes map[addr.Address]struct{}
+}
+
+func StateAccessSet_Make() *StateAccessSet {
+	return &StateAccessSet{
+		Reads:  make(map[addr.Address]struct{}),
+		Writes: make(map[addr.Address]struct{}),
+	}
+}
+
+func (s *StateAccessSet) RecordRead(a addr.Address) {
+	if s != nil {
+		s.Reads[a] = struct{}{}
+	}
+}
+
+func (s *StateAccessSet) RecordWrite(a addr.Address) {
+	if s != nil {
+		s.Writes[a] = struct{}{}
+	}
+}
+
+// Returns all addresses read or written.
+func (s *StateAccessSet) Accessed() []addr.Address {
+	var ret []addr.Address
+	for a := range s.Reads {
+		ret = append(ret, a)
+	}
+	for a := range s.Writes {
+		if _, found := s.Reads[a]; !found {
+			ret = append(ret, a)
+		}
+	}
+	return ret
+}
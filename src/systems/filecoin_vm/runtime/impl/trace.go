@@ -0,0 +1,37 @@
+package impl
+
+import (
+	"fmt"
+	"strings"
+
+	addr "github.com/filecoin-project/go-address"
+)
+
+// ExecutionTrace records an actor method invocation, its result, and the invocations it made in turn.
+// Traces are produced alongside receipts for inspection and verification tooling. Unlike receipts,
+// they are never committed to the chain.
+type ExecutionTrace struct {
+	Caller addr.Address
+	// ID address of the receiving actor, after resolution of the input's To address.
+	Receiver addr.Address
+	Input    InvocInput
+	Receipt  MessageReceipt
+	// Traces of messages sent by the receiver while processing this invocation, in order.
+	Subcalls []ExecutionTrace
+}
+
+// Renders the trace as an indented call tree, one invocation per line.
+func (t ExecutionTrace) String() string {
+	var b strings.Builder
+	t._write(&b, 0)
+	return b.String()
+}
+
+func (t ExecutionTrace) _write(b *strings.Builder, depth int) {
+	fmt.Fprintf(b, "%v%v -> %v method %v value %v: exit %v, gas used %v\n",
+		strings.Repeat("  ", depth), t.Caller, t.Receiver, t.Input.Method, t.Input.Value,
+		t.Receipt.ExitCode, t.Receipt.GasUsed)
+	for _, sub := range t.Subcalls {
+		sub._write(b, depth+1)
+	}
+}
@@ -1,22 +1,24 @@
 package state_tree
 
 import (
+	"errors"
+
 	addr "github.com/filecoin-project/go-address"
 	actor "github.com/filecoin-project/specs-actors/actors"
 	"github.com/filecoin-project/specs-actors/actors/abi"
 	actstate "github.com/filecoin-project/specs/systems/filecoin_vm/actor"
 	"github.com/filecoin-project/specs/util"
-	cid "github.com/ipfs/go-cid"
 )
 
 var Assert = util.Assert
 var IMPL_FINISH = util.IMPL_FINISH
 var IMPL_TODO = util.IMPL_TODO
 
-func (st *StateTree_I) RootCID() cid.Cid {
-	IMPL_FINISH()
-	panic("")
-}
+var (
+	ErrActorNotFound     = errors.New("Actor not found in state tree")
+	ErrInsufficientFunds = errors.New("Insufficient funds for transfer")
+	ErrNegativeTransfer  = errors.New("Negative transfer amount")
+)
 
 func (st *StateTree_I) GetActor(a addr.Address) (actstate.ActorState, bool) {
 	as, found := st.ActorStates()[a]
@@ -30,13 +32,22 @@ func (st *StateTree_I) GetActorCodeID_Assert(a addr.Address) abi.ActorCodeID {
 }
 
 func (st *StateTree_I) WithActorSubstate(a addr.Address, actorState actor.ActorSubstateCID) (StateTree, error) {
-	IMPL_FINISH()
-	panic("")
+	act, found := st.GetActor(a)
+	if !found {
+		return nil, ErrActorNotFound
+	}
+	return st._withActor(a, &actstate.ActorState_I{
+		CodeID_:     act.CodeID(),
+		State_:      actorState,
+		Balance_:    act.Balance(),
+		CallSeqNum_: act.CallSeqNum(),
+	}), nil
 }
 
 func (st *StateTree_I) WithDeleteActorSystemState(a addr.Address) StateTree {
-	IMPL_FINISH()
-	panic("")
+	actors := st._copyActorStates()
+	delete(actors, a)
+	return &StateTree_I{ActorStates_: actors}
 }
 
 func (st *StateTree_I) WithActorSystemState(a addr.Address, actorState actstate.ActorSystemStateCID) (StateTree, error) {
@@ -44,9 +55,30 @@ func (st *StateTree_I) WithActorSystemState(a addr.Address, actorState actstate.
 	panic("")
 }
 
+// Returns a tree in which the state of actor a is act, as held in another tree.
+func (st *StateTree_I) WithActorState(a addr.Address, act actstate.ActorState) StateTree {
+	return st._withActor(a, act)
+}
+
 func (st *StateTree_I) WithFundsTransfer(from addr.Address, to addr.Address, amount abi.TokenAmount) (StateTree, error) {
-	IMPL_FINISH()
-	panic("")
+	if amount < 0 {
+		return nil, ErrNegativeTransfer
+	}
+	fromActor, found := st.GetActor(from)
+	if !found {
+		return nil, ErrActorNotFound
+	}
+	if _, found := st.GetActor(to); !found {
+		return nil, ErrActorNotFound
+	}
+	if fromActor.Balance() < amount {
+		return nil, ErrInsufficientFunds
+	}
+	if from == to {
+		return st, nil
+	}
+	ret := st._withBalanceDelta(from, -amount)
+	return ret._withBalanceDelta(to, amount), nil
 }
 
 func (st *StateTree_I) WithNewAccountActor(a addr.Address) (StateTree, actstate.ActorState, error) {
@@ -55,8 +87,16 @@ func (st *StateTree_I) WithNewAccountActor(a addr.Address) (StateTree, actstate.
 }
 
 func (st *StateTree_I) WithIncrementedCallSeqNum(a addr.Address) (StateTree, error) {
-	IMPL_FINISH()
-	panic("")
+	act, found := st.GetActor(a)
+	if !found {
+		return nil, ErrActorNotFound
+	}
+	return st._withActor(a, &actstate.ActorState_I{
+		CodeID_:     act.CodeID(),
+		State_:      act.State(),
+		Balance_:    act.Balance(),
+		CallSeqNum_: act.CallSeqNum() + 1,
+	}), nil
 }
 
 func (st *StateTree_I) WithIncrementedCallSeqNum_Assert(a addr.Address) StateTree {
@@ -67,6 +107,33 @@ func (st *StateTree_I) WithIncrementedCallSeqNum_Assert(a addr.Address) StateTre
 	return ret
 }
 
+// State trees are persistent: each update returns a new tree sharing unchanged actor states with
+// the original, which is left unchanged (so that it may serve as a checkpoint to roll back to).
+func (st *StateTree_I) _copyActorStates() map[addr.Address]actstate.ActorState {
+	ret := make(map[addr.Address]actstate.ActorState, len(st.ActorStates()))
+	for a, act := range st.ActorStates() {
+		ret[a] = act
+	}
+	return ret
+}
+
+func (st *StateTree_I) _withActor(a addr.Address, act actstate.ActorState) *StateTree_I {
+	actors := st._copyActorStates()
+	actors[a] = act
+	return &StateTree_I{ActorStates_: actors}
+}
+
+func (st *StateTree_I) _withBalanceDelta(a addr.Address, delta abi.TokenAmount) *StateTree_I {
+	act, found := st.GetActor(a)
+	Assert(found)
+	return st._withActor(a, &actstate.ActorState_I{
+		CodeID_:     act.CodeID(),
+		State_:      act.State(),
+		Balance_:    act.Balance() + delta,
+		CallSeqNum_: act.CallSeqNum(),
+	})
+}
+
 /*
 TODO: finish
 
@@ -0,0 +1,165 @@
+package state_tree
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+
+	addr "github.com/filecoin-project/go-address"
+	actor "github.com/filecoin-project/specs-actors/actors"
+	"github.com/filecoin-project/specs-actors/actors/abi"
+	actstate "github.com/filecoin-project/specs/systems/filecoin_vm/actor"
+	"github.com/filecoin-project/specs/util"
+	cid "github.com/ipfs/go-cid"
+	cbornode "github.com/ipfs/go-ipld-cbor"
+	mh "github.com/multiformats/go-multihash"
+)
+
+var ErrMalformedStateTree = errors.New("Malformed state tree")
+
+// A StateTree is serialized as a single DAG-CBOR node, rather than as a HAMT: a list of the tuples
+// [Address, CodeID, State, Balance, CallSeqNum], one per actor in order of address bytes. CodeID is
+// serialized as the bytes of the CID (empty if undefined) rather than as a link, since code is not
+// stored in the tree's store, and State as a link (null if undefined).
+// Its RootCID is the SHA2-256 CID of that node.
+
+func (st *StateTree_I) RootCID() cid.Cid {
+	c, err := cid.NewPrefixV1(cid.DagCBOR, mh.SHA2_256).Sum(Serialize_StateTree(st))
+	Assert(err == nil)
+	return c
+}
+
+// Returns a reference to the state tree with the given root, as a block header's ParentState holds.
+// Its actor states are available only once the tree is loaded.
+func StateTree_Ref(root cid.Cid) StateTree {
+	return &StateTree_R{cid: root.Bytes()}
+}
+
+func (st *StateTree_R) RootCID() cid.Cid {
+	c, err := cid.Cast(st.cid)
+	Assert(err == nil)
+	return c
+}
+
+func (st *StateTree_R) GetActor(a addr.Address) (actstate.ActorState, bool) {
+	return st.Impl().GetActor(a)
+}
+
+func (st *StateTree_R) GetActorCodeID_Assert(a addr.Address) abi.ActorCodeID {
+	return st.Impl().GetActorCodeID_Assert(a)
+}
+
+func Serialize_StateTree(st StateTree) util.Serialization {
+	return _dumpObject(_stateTreeEntries(st))
+}
+
+func Serialize_StateTree_Array(sts []StateTree) util.Serialization {
+	trees := make([]interface{}, len(sts))
+	for i, st := range sts {
+		trees[i] = _stateTreeEntries(st)
+	}
+	return _dumpObject(trees)
+}
+
+func Deserialize_StateTree(s util.Serialization) (StateTree, error) {
+	var entries []interface{}
+	if err := cbornode.DecodeInto(s, &entries); err != nil {
+		return nil, ErrMalformedStateTree
+	}
+	actors := make(map[addr.Address]actstate.ActorState, len(entries))
+	for _, e := range entries {
+		a, act, ok := _actorFromTuple(e)
+		if !ok {
+			return nil, ErrMalformedStateTree
+		}
+		actors[a] = act
+	}
+	return &StateTree_I{ActorStates_: actors}, nil
+}
+
+func Deserialize_StateTree_Assert(s util.Serialization) StateTree {
+	ret, err := Deserialize_StateTree(s)
+	Assert(err == nil)
+	return ret
+}
+
+func _stateTreeEntries(st StateTree) []interface{} {
+	addrs := make([]addr.Address, 0, len(st.ActorStates()))
+	for a := range st.ActorStates() {
+		addrs = append(addrs, a)
+	}
+	sort.Slice(addrs, func(i, j int) bool { return bytes.Compare(addrs[i].Bytes(), addrs[j].Bytes()) < 0 })
+
+	ret := make([]interface{}, len(addrs))
+	for i, a := range addrs {
+		act := st.ActorStates()[a]
+		ret[i] = []interface{}{
+			a.Bytes(),
+			_cidBytes(cid.Cid(act.CodeID())),
+			_optionalLink(cid.Cid(act.State())),
+			int64(act.Balance()),
+			int64(act.CallSeqNum()),
+		}
+	}
+	return ret
+}
+
+func _actorFromTuple(x interface{}) (addr.Address, actstate.ActorState, bool) {
+	tuple, ok := x.([]interface{})
+	if !ok || len(tuple) != 5 {
+		return addr.Undef, nil, false
+	}
+	addrBytes, addrOk := tuple[0].([]byte)
+	codeIDBytes, codeOk := tuple[1].([]byte)
+	state, stateOk := _linkFromTuple(tuple[2])
+	balance, balanceOk := tuple[3].(int) // DAG-CBOR integers decode as int.
+	callSeqNum, callSeqNumOk := tuple[4].(int)
+	if !addrOk || !codeOk || !stateOk || !balanceOk || !callSeqNumOk {
+		return addr.Undef, nil, false
+	}
+	a, err := addr.NewFromBytes(addrBytes)
+	if err != nil {
+		return addr.Undef, nil, false
+	}
+	codeID := cid.Undef
+	if len(codeIDBytes) > 0 {
+		if codeID, err = cid.Cast(codeIDBytes); err != nil {
+			return addr.Undef, nil, false
+		}
+	}
+	return a, &actstate.ActorState_I{
+		CodeID_:     abi.ActorCodeID(codeID),
+		State_:      actor.ActorSubstateCID(state),
+		Balance_:    abi.TokenAmount(balance),
+		CallSeqNum_: actstate.CallSeqNum(callSeqNum),
+	}, true
+}
+
+func _cidBytes(c cid.Cid) []byte {
+	if !c.Defined() {
+		return []byte{}
+	}
+	return c.Bytes()
+}
+
+// An undefined CID cannot be serialized as a link, so is serialized as null.
+func _optionalLink(c cid.Cid) interface{} {
+	if !c.Defined() {
+		return nil
+	}
+	return c
+}
+
+func _linkFromTuple(x interface{}) (cid.Cid, bool) {
+	if x == nil {
+		return cid.Undef, true
+	}
+	c, ok := x.(cid.Cid)
+	return c, ok
+}
+
+func _dumpObject(x interface{}) util.Serialization {
+	ret, err := cbornode.DumpObject(x)
+	Assert(err == nil)
+	return util.Serialization(ret)
+}
@@ -0,0 +1,57 @@
+package state_tree
+
+import (
+	"testing"
+
+	addr "github.com/filecoin-project/go-address"
+	abi "github.com/filecoin-project/specs-actors/actors/abi"
+	builtin "github.com/filecoin-project/specs-actors/actors/builtin"
+	ipld "github.com/filecoin-project/specs/libraries/ipld"
+	actstate "github.com/filecoin-project/specs/systems/filecoin_vm/actor"
+	util "github.com/filecoin-project/specs/util"
+)
+
+func TestStateTreeSerializationRoundTrip(t *testing.T) {
+	id, err := addr.NewIDAddress(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree := &StateTree_I{ActorStates_: map[addr.Address]actstate.ActorState{
+		builtin.InitActorAddr: &actstate.ActorState_I{CodeID_: builtin.InitActorCodeID},
+		id:                    &actstate.ActorState_I{CodeID_: builtin.AccountActorCodeID, Balance_: abi.TokenAmount(1000), CallSeqNum_: 3},
+	}}
+
+	s := Serialize_StateTree(tree)
+	d, err := Deserialize_StateTree(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(d.ActorStates()) != len(tree.ActorStates()) {
+		t.Fatalf("%d actors, want %d", len(d.ActorStates()), len(tree.ActorStates()))
+	}
+	for a, want := range tree.ActorStates() {
+		got, ok := d.GetActor(a)
+		if !ok || got.CodeID() != want.CodeID() || got.Balance() != want.Balance() || got.CallSeqNum() != want.CallSeqNum() {
+			t.Errorf("actor %v deserialized as %v, want %v", a, got, want)
+		}
+	}
+	if !d.RootCID().Equals(tree.RootCID()) {
+		t.Errorf("root %v, want %v", d.RootCID(), tree.RootCID())
+	}
+	if !StateTree_Ref(tree.RootCID()).RootCID().Equals(tree.RootCID()) {
+		t.Error("reference root differs from the tree's")
+	}
+
+	// Code CIDs are not links, so the tree can be walked in a store holding only the tree.
+	links, err := ipld.Links(util.Bytes(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(links) != 0 {
+		t.Errorf("tree links %v, want none", links)
+	}
+
+	if _, err := Deserialize_StateTree(util.Serialization{0xff}); err != ErrMalformedStateTree {
+		t.Errorf("error %v, want %v", err, ErrMalformedStateTree)
+	}
+}
@@ -91,7 +91,10 @@ func GenGoDecls(topLevelEntries []Entry) []GoNode {
 			case Decl_Case_Type:
 				xr := decl.(*TypeDecl)
 				ret := GenGoTypeDeclAcc(xr.name, xr.type_, ctx.Extend(xr.name), false)
-				GenGoTypeSerializers(ctx, xr.name, ret)
+				// Types with the customSerialization attribute define their own serializers.
+				if !DSLTypeHasAttribute(xr.type_, "customSerialization") {
+					GenGoTypeSerializers(ctx, xr.name, ret)
+				}
 			case Decl_Case_Import:
 				xr := decl.(*ImportDecl)
 				GenGoImportDeclAcc(*xr, ctx)
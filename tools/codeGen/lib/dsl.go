@@ -156,6 +156,18 @@ func DSLTypeIsTrivialStruct(type_ Type) bool {
 	return len(xr.entries) == 0
 }
 
+func DSLTypeHasAttribute(type_ Type, attribute string) bool {
+	if type_.Case() != Type_Case_AlgType {
+		return false
+	}
+	for _, a := range type_.(*AlgType).attributeList {
+		if a == attribute {
+			return true
+		}
+	}
+	return false
+}
+
 func DSLTrivialStructField(fieldName *string, info *ParseFmtInfo) *Field {
 	return RefField(Field{
 		fieldName:     fieldName,
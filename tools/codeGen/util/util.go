@@ -94,22 +94,28 @@ func IsSECP(x Bytes) bool {
 	return false
 }
 
+// The values with which TODO() and its versions below panic.
+const (
+	_todoPanic          = "TODO"
+	_unimplementedPanic = "Not yet implemented in the spec"
+)
+
 // Indicating behavior not yet specified, and may require other spec changes.
 func TODO(...interface{}) {
 	// Indirection to prevent the compiler from ignoring unreachable code
-	panic("TODO")
+	panic(_todoPanic)
 }
 
 // Version of TODO() indicating that the operation is clearly implementable,
 // but some details remain to specify.
 func IMPL_TODO(...interface{}) {
-	panic("Not yet implemented in the spec")
+	panic(_unimplementedPanic)
 }
 
 // Version of TODO() indicating that the operation is believed to be unambiguous,
 // but is not yet implemented as code in the spec repository.
 func IMPL_FINISH(...interface{}) {
-	panic("Not yet implemented in the spec")
+	panic(_unimplementedPanic)
 }
 
 // Version of TODO() indicating that the operation is believed to be unambiguous,
@@ -120,7 +126,13 @@ func IMPL_FINISH(...interface{}) {
 // values are required for potential future completions of the parameterization,
 // and the caller must ensure they are available.
 func PARAM_FINISH(...interface{}) {
-	panic("Not yet implemented in the spec")
+	panic(_unimplementedPanic)
+}
+
+// Returns whether r, a value recovered from a panic, is that of TODO() or one of its versions above,
+// i.e., whether the panic marks behavior the spec has yet to implement rather than an error.
+func IsUnimplementedPanic(r interface{}) bool {
+	return r == _todoPanic || r == _unimplementedPanic
 }
 
 type UVarint = uint64